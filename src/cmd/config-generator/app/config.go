@@ -1,44 +1,89 @@
 package app
 
 import (
+	"fmt"
 	"log"
 	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 )
 
 type Config struct {
-	NatsHosts                []string      `env:"NATS_HOSTS, required"`
-	NatsCAPath               string        `env:"NATS_CA_PATH, required, report"`
-	NatsCertPath             string        `env:"NATS_CERT_PATH, required, report"`
-	NatsKeyPath              string        `env:"NATS_KEY_PATH, required, report"`
-	ScrapeConfigFilePath     string        `env:"SCRAPE_CONFIG_FILE_PATH, required, report"`
-	ConfigExpirationInterval time.Duration `env:"CONFIG_EXPIRATION_INTERVAL, report"`
-	ConfigTimeToLive         time.Duration `env:"CONFIG_TTL, report"`
-	WriteFrequency           time.Duration `env:"WRITE_FREQUENCY, report"`
-
-	MetricsPort     int    `env:"METRICS_PORT, report"`
-	MetricsCAPath   string `env:"METRICS_CA_PATH"`
-	MetricsCertPath string `env:"METRICS_CERT_PATH"`
-	MetricsKeyPath  string `env:"METRICS_KEY_PATH"`
-	DebugMetrics    bool   `env:"DEBUG_METRICS, report"`
-	PprofPort       uint16 `env:"PPROF_PORT, report"`
+	NatsHosts                []string      `env:"NATS_HOSTS, required" yaml:"nats_hosts"`
+	NatsCAPath               string        `env:"NATS_CA_PATH, required, report" yaml:"nats_ca_path"`
+	NatsCertPath             string        `env:"NATS_CERT_PATH, required, report" yaml:"nats_cert_path"`
+	NatsKeyPath              string        `env:"NATS_KEY_PATH, required, report" yaml:"nats_key_path"`
+	ScrapeConfigFilePath     string        `env:"SCRAPE_CONFIG_FILE_PATH, required, report" yaml:"scrape_config_file_path"`
+	ConfigExpirationInterval time.Duration `env:"CONFIG_EXPIRATION_INTERVAL, report" yaml:"config_expiration_interval"`
+	ConfigTimeToLive         time.Duration `env:"CONFIG_TTL, report" yaml:"config_ttl"`
+	WriteFrequency           time.Duration `env:"WRITE_FREQUENCY, report" yaml:"write_frequency"`
+
+	MetricsPort     int    `env:"METRICS_PORT, report" yaml:"metrics_port"`
+	MetricsCAPath   string `env:"METRICS_CA_PATH" yaml:"metrics_ca_path"`
+	MetricsCertPath string `env:"METRICS_CERT_PATH" yaml:"metrics_cert_path"`
+	MetricsKeyPath  string `env:"METRICS_KEY_PATH" yaml:"metrics_key_path"`
+	DebugMetrics    bool   `env:"DEBUG_METRICS, report" yaml:"debug_metrics"`
+	PprofPort       uint16 `env:"PPROF_PORT, report" yaml:"pprof_port"`
+
+	Log    logging.Config `yaml:"log"`
+	Health health.Config  `yaml:"health"`
+}
+
+// LoadConfig loads the configuration from the config file at path, if any,
+// and the environment. If loading the config fails the process exits.
+func LoadConfig(log *log.Logger, path string) Config {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+
+	if err := envstruct.WriteReport(&cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg
 }
 
-func LoadConfig(log *log.Logger) Config {
+// ReadConfig loads and validates the configuration from the config file at
+// path, if any, and the environment. Environment variables take precedence
+// over the file.
+func ReadConfig(path string) (Config, error) {
 	cfg := Config{
 		WriteFrequency:           15 * time.Second,
 		ConfigExpirationInterval: 15 * time.Second,
 		ConfigTimeToLive:         45 * time.Second,
+		Log:                      logging.DefaultConfig(),
 	}
 
-	if err := envstruct.Load(&cfg); err != nil {
-		log.Fatal(err)
+	if err := configfile.Load(&cfg, path); err != nil {
+		return Config{}, err
 	}
 
-	if err := envstruct.WriteReport(&cfg); err != nil {
-		log.Fatal(err)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
 	}
 
-	return cfg
+	return cfg, nil
+}
+
+// Validate returns an error describing the first invalid setting.
+func (c Config) Validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"CONFIG_EXPIRATION_INTERVAL (config_expiration_interval)", c.ConfigExpirationInterval},
+		{"CONFIG_TTL (config_ttl)", c.ConfigTimeToLive},
+		{"WRITE_FREQUENCY (write_frequency)", c.WriteFrequency},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be greater than zero, got %s", d.name, d.value)
+		}
+	}
+
+	return c.Log.Validate()
 }
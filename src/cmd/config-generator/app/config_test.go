@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
 	"code.cloudfoundry.org/metrics-discovery/cmd/config-generator/app"
@@ -43,8 +45,27 @@ var _ = Describe("configuration", func() {
 		var output bytes.Buffer
 		envstruct.ReportWriter = &output
 		logger := log.New(GinkgoWriter, "", log.LstdFlags)
-		app.LoadConfig(logger)
+		app.LoadConfig(logger, "")
 		Expect(output.String()).ToNot(ContainSubstring("some-secret"))
 	})
 
+	It("loads the config file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yml")
+		Expect(os.WriteFile(path, []byte("config_ttl: 1m\nwrite_frequency: 5s\n"), 0600)).To(Succeed())
+
+		cfg, err := app.ReadConfig(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.ConfigTimeToLive).To(Equal(time.Minute))
+		Expect(cfg.WriteFrequency).To(Equal(5 * time.Second))
+		Expect(cfg.ConfigExpirationInterval).To(Equal(15 * time.Second))
+		Expect(cfg.NatsHosts).To(Equal([]string{"some-secret"}))
+	})
+
+	It("rejects durations that are not positive", func() {
+		GinkgoT().Setenv("WRITE_FREQUENCY", "0s")
+
+		_, err := app.ReadConfig("")
+		Expect(err).To(MatchError("WRITE_FREQUENCY (write_frequency) must be greater than zero, got 0s"))
+	})
+
 })
@@ -3,20 +3,27 @@ package app
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof" // nolint:gosec
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 	"code.cloudfoundry.org/metrics-discovery/internal/registry"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
 	"github.com/nats-io/nats.go"
 	"gopkg.in/yaml.v3"
 )
 
+// healthIntervals is the number of write intervals without a successful
+// write after which the generator is not ready.
+const healthIntervals = 3
+
 type Subscriber func(queue string, callback nats.MsgHandler) (*nats.Subscription, error)
 
 type ConfigGenerator struct {
@@ -32,7 +39,9 @@ type ConfigGenerator struct {
 	delivered   metrics.Counter
 	metrics     metricsRegistry
 	pprofServer *http.Server
-	logger      *log.Logger
+	logger      *slog.Logger
+	written     *health.Heartbeat
+	received    *health.Heartbeat
 
 	timestampedTargets map[string]timestampedTarget
 }
@@ -54,7 +63,7 @@ func NewConfigGenerator(
 	expirationInterval time.Duration,
 	path string,
 	m metricsRegistry,
-	logger *log.Logger,
+	logger *slog.Logger,
 ) *ConfigGenerator {
 	configGenerator := &ConfigGenerator{
 		timestampedTargets:       make(map[string]timestampedTarget),
@@ -64,6 +73,8 @@ func NewConfigGenerator(
 		configExpirationInterval: expirationInterval,
 
 		logger:     logger,
+		written:    health.NewHeartbeat(),
+		received:   health.NewHeartbeat(),
 		subscriber: subscriber,
 		delivered:  m.NewCounter("delivered", "Total number of messages successfully delivered from NATs."),
 		metrics:    m,
@@ -74,7 +85,7 @@ func NewConfigGenerator(
 	// If this doesn't happen synchronously, it could fail when the subscriber is called
 	_, err := configGenerator.subscriber(registry.ScrapeTargetQueueName, configGenerator.generate)
 	if err != nil {
-		configGenerator.logger.Fatalf("failed to subscribe to %s: %s", registry.ScrapeTargetQueueName, err)
+		logging.Fatal(configGenerator.logger, "failed to subscribe", "queue", registry.ScrapeTargetQueueName, "error", err)
 	}
 
 	return configGenerator
@@ -88,7 +99,7 @@ func (cg *ConfigGenerator) Start(debugMetrics bool, pprofPort uint16) {
 			Handler:           http.DefaultServeMux,
 			ReadHeaderTimeout: 2 * time.Second,
 		}
-		go func() { cg.logger.Println("PPROF SERVER STOPPED " + cg.pprofServer.ListenAndServe().Error()) }()
+		go func() { cg.logger.Info("pprof server closed", "error", cg.pprofServer.ListenAndServe()) }()
 	}
 
 	expirationTicker := time.NewTicker(cg.configExpirationInterval)
@@ -100,12 +111,19 @@ func (cg *ConfigGenerator) Start(debugMetrics bool, pprofPort uint16) {
 		case <-expirationTicker.C:
 			cg.expireScrapeConfigs()
 		case <-cg.stop:
+			writeTicker.Stop()
+			expirationTicker.Stop()
+			cg.writeConfigToFile()
+			if cg.pprofServer != nil {
+				cg.pprofServer.Close()
+			}
 			close(cg.done)
 			return
 		}
 	}
 }
 
+// Stop writes the scrape config file a final time and stops Start.
 func (cg *ConfigGenerator) Stop() {
 	close(cg.stop)
 	<-cg.done
@@ -126,7 +144,7 @@ func (cg *ConfigGenerator) unmarshalScrapeTarget(message *nats.Msg) (*target.Tar
 	var t target.Target
 	err := yaml.Unmarshal(message.Data, &t)
 	if err != nil {
-		cg.logger.Printf("failed to unmarshal message data: %s\n", err)
+		cg.logger.Warn("failed to unmarshal message data", "queue", message.Subject, "error", err)
 		return nil, false
 	}
 
@@ -141,6 +159,8 @@ func (cg *ConfigGenerator) addTarget(scrapeTarget *target.Target) {
 		scrapeTarget: scrapeTarget,
 		ts:           time.Now(),
 	}
+	cg.received.Beat()
+	cg.logger.Debug("received target", "target", scrapeTarget.Source)
 }
 
 func (cg *ConfigGenerator) writeConfigToFile() {
@@ -148,14 +168,40 @@ func (cg *ConfigGenerator) writeConfigToFile() {
 
 	data, err := json.Marshal(targets)
 	if err != nil {
-		cg.logger.Printf("failed to marshal scrape configs: %s\n", err)
+		cg.logger.Error("failed to marshal scrape configs", "error", err)
+		return
+	}
+
+	err = writeFileAtomically(cg.path, data)
+	if err != nil {
+		cg.logger.Error("failed to write scrape config file", "file", cg.path, "error", err)
 		return
 	}
+	cg.written.Beat()
+	cg.logger.Debug("wrote scrape config file", "file", cg.path, "targets", len(targets))
+}
+
+// writeFileAtomically writes the data to a temporary file that is renamed to
+// path, so that readers never see a partially written file.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
 
-	err = os.WriteFile(cg.path, data, 0644)
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		cg.logger.Printf("failed to write scrape config file: %s\n", err)
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
 	}
+	return os.Rename(tmp.Name(), path)
 }
 
 func (cg *ConfigGenerator) copyTargets() []*target.Target {
@@ -170,6 +216,30 @@ func (cg *ConfigGenerator) copyTargets() []*target.Target {
 	return targets
 }
 
+// SetConfigTTL changes how long targets are kept after they were last
+// received.
+func (cg *ConfigGenerator) SetConfigTTL(ttl time.Duration) {
+	cg.Lock()
+	defer cg.Unlock()
+
+	cg.configTTL = ttl
+}
+
+// RegisterHealthChecks adds readiness checks to r that fail when the scrape
+// config file was not written for healthIntervals write intervals, when it
+// is not writable or when no target was received within the config TTL.
+func (cg *ConfigGenerator) RegisterHealthChecks(r *health.Registry) {
+	r.AddReadiness("write", cg.written.Check("write", healthIntervals*cg.writeFrequency))
+	r.AddReadiness("scrape_config_file", health.Writable(cg.path))
+	r.AddReadiness("receive", func() error {
+		cg.Lock()
+		ttl := cg.configTTL
+		cg.Unlock()
+
+		return cg.received.Check("receive", ttl)()
+	})
+}
+
 func (cg *ConfigGenerator) expireScrapeConfigs() {
 	cg.Lock()
 	defer cg.Unlock()
@@ -1,14 +1,18 @@
 package app_test
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"time"
 
 	"code.cloudfoundry.org/go-metric-registry/testhelpers"
 	"code.cloudfoundry.org/metrics-discovery/cmd/config-generator/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
 	. "github.com/benjamintf1/unmarshalledmatchers"
 	"github.com/nats-io/nats.go"
 	. "github.com/onsi/ginkgo/v2"
@@ -19,7 +23,7 @@ var _ = Describe("Config generator", func() {
 	type testContext struct {
 		subscriber *fakeSubscriber
 		configPath string
-		logger     *log.Logger
+		logger     *slog.Logger
 	}
 
 	var setup = func() *testContext {
@@ -29,7 +33,7 @@ var _ = Describe("Config generator", func() {
 		return &testContext{
 			subscriber: newFakeSubscriber(),
 			configPath: tmpDir + "/scrape_targets.json",
-			logger:     log.New(GinkgoWriter, "", 0),
+			logger:     slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
 		}
 	}
 
@@ -118,6 +122,43 @@ var _ = Describe("Config generator", func() {
 		}).ShouldNot(Succeed())
 	})
 
+	It("writes the config when it is stopped", func() {
+		tc := setup()
+
+		generator := app.NewConfigGenerator(
+			tc.subscriber.Subscribe,
+			time.Hour,
+			time.Hour,
+			time.Hour,
+			tc.configPath,
+			testhelpers.NewMetricsRegistry(),
+			tc.logger,
+		)
+		go generator.Start(false, 1234)
+
+		tc.subscriber.callback(&nats.Msg{
+			Data: target("job1"),
+		})
+		generator.Stop()
+
+		fileData, err := os.ReadFile(tc.configPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(fileData)).To(MatchUnorderedJSON(`[
+			{
+				"targets": [
+				  "localhost:8080"
+				],
+				"labels": {
+				  "job": "job1"
+				}
+			}
+		]`))
+
+		entries, err := os.ReadDir(filepath.Dir(tc.configPath))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
 	It("doesn't duplicate jobs", func() {
 		tc := setup()
 
@@ -219,6 +260,122 @@ var _ = Describe("Config generator", func() {
 		]`))
 	})
 
+	It("expires configs after a changed TTL", func() {
+		tc := setup()
+
+		generator := app.NewConfigGenerator(
+			tc.subscriber.Subscribe,
+			100*time.Millisecond,
+			time.Hour,
+			100*time.Millisecond,
+			tc.configPath,
+			testhelpers.NewMetricsRegistry(),
+			tc.logger,
+		)
+		generator.SetConfigTTL(200 * time.Millisecond)
+		go generator.Start(false, 1234)
+
+		tc.subscriber.callback(&nats.Msg{
+			Data: target("ephemeral"),
+		})
+
+		Eventually(func() string {
+			return readTargets(tc)
+		}).Should(ContainSubstring("ephemeral"))
+		Eventually(func() string {
+			return readTargets(tc)
+		}).ShouldNot(ContainSubstring("ephemeral"))
+	})
+
+	Describe("readiness", func() {
+		var readiness = func(generator *app.ConfigGenerator) func() health.Report {
+			checks := health.NewRegistry()
+			generator.RegisterHealthChecks(checks)
+			server := httptest.NewServer(checks.Handler())
+			DeferCleanup(server.Close)
+
+			return func() health.Report {
+				resp, err := http.Get(server.URL + "/readyz")
+				Expect(err).ToNot(HaveOccurred())
+				defer resp.Body.Close()
+
+				var report health.Report
+				Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+				return report
+			}
+		}
+
+		It("is ready while targets are received and written", func() {
+			tc := setup()
+
+			generator := app.NewConfigGenerator(
+				tc.subscriber.Subscribe,
+				100*time.Millisecond,
+				time.Hour,
+				time.Hour,
+				tc.configPath,
+				testhelpers.NewMetricsRegistry(),
+				tc.logger,
+			)
+			go generator.Start(false, 1234)
+			defer generator.Stop()
+
+			tc.subscriber.callback(&nats.Msg{
+				Data: target("some-source"),
+			})
+
+			report := readiness(generator)
+			Consistently(func() string {
+				return report().Status
+			}, 500*time.Millisecond).Should(Equal(health.StatusOK))
+		})
+
+		It("is not ready when no target is received within the config TTL", func() {
+			tc := setup()
+
+			generator := app.NewConfigGenerator(
+				tc.subscriber.Subscribe,
+				100*time.Millisecond,
+				200*time.Millisecond,
+				time.Hour,
+				tc.configPath,
+				testhelpers.NewMetricsRegistry(),
+				tc.logger,
+			)
+			go generator.Start(false, 1234)
+			defer generator.Stop()
+
+			report := readiness(generator)
+			Eventually(func() string {
+				return report().Status
+			}).Should(Equal(health.StatusFailing))
+			Expect(report().Checks["receive"].Error).To(ContainSubstring("last successful receive"))
+			Expect(report().Checks["write"].Status).To(Equal(health.StatusOK))
+		})
+
+		It("is not ready when the scrape config file cannot be written", func() {
+			tc := setup()
+
+			generator := app.NewConfigGenerator(
+				tc.subscriber.Subscribe,
+				100*time.Millisecond,
+				time.Hour,
+				time.Hour,
+				filepath.Join(filepath.Dir(tc.configPath), "missing", "scrape_targets.json"),
+				testhelpers.NewMetricsRegistry(),
+				tc.logger,
+			)
+			go generator.Start(false, 1234)
+			defer generator.Stop()
+
+			report := readiness(generator)
+			Eventually(func() string {
+				return report().Checks["write"].Status
+			}).Should(Equal(health.StatusFailing))
+			Expect(report().Checks["scrape_config_file"].Status).To(Equal(health.StatusFailing))
+		})
+	})
+
 	It("increments a delivered metric", func() {
 		tc := setup()
 
@@ -2,45 +2,67 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
 	"code.cloudfoundry.org/metrics-discovery/cmd/config-generator/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
 	"code.cloudfoundry.org/tlsconfig"
 	"github.com/nats-io/nats.go"
 )
 
+// shutdownTimeout bounds the time to drain NATS on termination.
+const shutdownTimeout = 15 * time.Second
+
 func main() {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	logger.Printf("starting Scrape Config Generator...")
-	defer log.Printf("closing Scrape Config Generator...")
+	flags := configfile.ParseFlags()
+	config := app.LoadConfig(log.New(os.Stderr, "", log.LstdFlags), flags.Path)
+
+	logger, level, err := logging.NewFromConfig(os.Stderr, config.Log)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	if flags.Check {
+		logger.Info("configuration is valid")
+		return
+	}
 
-	config := app.LoadConfig(logger)
+	logger.Info("starting Scrape Config Generator")
+	defer logger.Info("closing Scrape Config Generator")
 
+	http.Handle(logging.LevelPath, logging.LevelHandler(level))
+
+	m := metrics.NewRegistry(slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		metrics.WithTLSServer(config.MetricsPort, config.MetricsCertPath, config.MetricsKeyPath, config.MetricsCAPath),
+	)
+
+	natsClosed := make(chan struct{})
 	opts := nats.Options{
 		Servers:           config.NatsHosts,
 		PingInterval:      20 * time.Second,
 		AllowReconnect:    true,
 		MaxReconnect:      -1,
 		ReconnectWait:     100 * time.Millisecond,
-		ClosedCB:          closedCB(logger),
+		ClosedCB:          closedCB(logger, natsClosed),
 		DisconnectedErrCB: disconnectErrHandler(logger),
 		ReconnectedCB:     reconnectedCB(logger),
-		TLSConfig:         getTLSConfig(config),
+		TLSConfig:         getTLSConfig(config, m, logger),
 	}
 
 	natsConn, err := opts.Connect()
 	if err != nil {
-		logger.Fatalf("Unable to connect to nats servers: %s", err)
+		logging.Fatal(logger, "unable to connect to nats servers", "error", err)
 	}
 
-	m := metrics.NewRegistry(logger,
-		metrics.WithTLSServer(config.MetricsPort, config.MetricsCertPath, config.MetricsKeyPath, config.MetricsCAPath),
-	)
-
 	generator := app.NewConfigGenerator(
 		natsConn.Subscribe,
 		config.WriteFrequency,
@@ -51,54 +73,89 @@ func main() {
 		logger,
 	)
 
-	generator.Start(config.DebugMetrics, config.PprofPort)
-	defer generator.Stop()
+	go generator.Start(config.DebugMetrics, config.PprofPort)
+
+	healthChecks := health.NewRegistry()
+	healthChecks.AddReadiness("nats", health.NATS(natsConn))
+	generator.RegisterHealthChecks(healthChecks)
+	stopHealth := health.Serve(config.Health.Addr, healthChecks, logger)
+	defer stopHealth()
+
+	configfile.NotifyReload(func() {
+		reloaded, err := app.ReadConfig(flags.Path)
+		if err != nil {
+			logger.Error("failed to reload configuration, keeping the current one", "error", err)
+			return
+		}
+		generator.SetConfigTTL(reloaded.ConfigTimeToLive)
+		if l, err := logging.ParseLevel(reloaded.Log.Level); err == nil {
+			level.Set(l)
+		}
+		logger.Info("reloaded configuration", "config_ttl", reloaded.ConfigTimeToLive, "log_level", reloaded.Log.Level)
+	})
+
+	waitForTermination()
+	stop(natsConn, natsClosed, generator, logger)
 }
 
-func getTLSConfig(cfg app.Config) *tls.Config {
-	caCert, err := os.ReadFile(cfg.NatsCAPath)
-	if err != nil {
-		log.Fatal(err)
+// stop drains the NATS subscription so that targets in flight are added
+// before the scrape config file is written a final time. If the drain does
+// not finish within shutdownTimeout, the connection is closed and the file is
+// written with the targets added so far.
+func stop(natsConn *nats.Conn, natsClosed <-chan struct{}, generator *app.ConfigGenerator, logger *slog.Logger) {
+	if err := natsConn.Drain(); err != nil {
+		logger.Error("failed to drain nats connection", "error", err)
+		natsConn.Close()
 	}
 
-	caCertPool := x509.NewCertPool()
-
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		log.Fatalf("Failed to load CA certificate from file %s", cfg.NatsCAPath)
+	select {
+	case <-natsClosed:
+	case <-time.After(shutdownTimeout):
+		logger.Warn("shutdown timeout exceeded")
+		natsConn.Close()
 	}
 
-	cert, err := tls.LoadX509KeyPair(cfg.NatsCertPath, cfg.NatsKeyPath)
+	generator.Stop()
+}
+
+func waitForTermination() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	<-c
+}
+
+func getTLSConfig(cfg app.Config, m *metrics.Registry, logger *slog.Logger) *tls.Config {
+	certs, err := tlsreload.New(cfg.NatsCertPath, cfg.NatsKeyPath, cfg.NatsCAPath,
+		tlsreload.WithMetrics(m, "nats"),
+		tlsreload.WithLogger(logger),
+	)
 	if err != nil {
-		log.Fatalf("Failed to load certificate from cert: %s and key: %s", cfg.NatsCertPath, cfg.NatsKeyPath)
+		logging.Fatal(logger, "failed to load NATS certificates", "error", err)
 	}
 
-	config, err := tlsconfig.Build(
-		tlsconfig.WithInternalServiceDefaults(),
-		tlsconfig.WithIdentity(cert),
-	).Client(
-		tlsconfig.WithAuthority(caCertPool),
-	)
+	config, err := tlsconfig.Build(tlsconfig.WithInternalServiceDefaults()).Client()
 	if err != nil {
-		log.Fatalf("Failed to build TLS config: %s", err)
+		logging.Fatal(logger, "failed to build NATS TLS config", "error", err)
 	}
 
-	return config
+	return certs.ClientConfig(config)
 }
 
-func closedCB(log *log.Logger) func(conn *nats.Conn) {
+func closedCB(logger *slog.Logger, closed chan<- struct{}) func(conn *nats.Conn) {
 	return func(conn *nats.Conn) {
-		log.Println("Nats Connection Closed")
+		logger.Info("nats connection closed")
+		close(closed)
 	}
 }
 
-func reconnectedCB(log *log.Logger) func(conn *nats.Conn) {
+func reconnectedCB(logger *slog.Logger) func(conn *nats.Conn) {
 	return func(conn *nats.Conn) {
-		log.Println("Nats Reconnected")
+		logger.Info("nats reconnected", "server", conn.ConnectedUrlRedacted())
 	}
 }
 
-func disconnectErrHandler(log *log.Logger) func(conn *nats.Conn, err error) {
+func disconnectErrHandler(logger *slog.Logger) func(conn *nats.Conn, err error) {
 	return func(conn *nats.Conn, err error) {
-		log.Printf("Nats Error %s\n", err)
+		logger.Warn("nats disconnected", "error", err)
 	}
 }
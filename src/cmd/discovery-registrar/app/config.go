@@ -1,45 +1,80 @@
 package app
 
 import (
+	"fmt"
 	"log"
 	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 )
 
 type Config struct {
-	PublishInterval time.Duration `env:"PUBLISH_INTERVAL, report"`
-	NatsHosts       []string      `env:"NATS_HOSTS, required"`
-	NatsCAPath      string        `env:"NATS_CA_PATH, required, report"`
-	NatsCertPath    string        `env:"NATS_CERT_PATH, required, report"`
-	NatsKeyPath     string        `env:"NATS_KEY_PATH, required, report"`
+	PublishInterval time.Duration `env:"PUBLISH_INTERVAL, report" yaml:"publish_interval"`
+	NatsHosts       []string      `env:"NATS_HOSTS, required" yaml:"nats_hosts"`
+	NatsCAPath      string        `env:"NATS_CA_PATH, required, report" yaml:"nats_ca_path"`
+	NatsCertPath    string        `env:"NATS_CERT_PATH, required, report" yaml:"nats_cert_path"`
+	NatsKeyPath     string        `env:"NATS_KEY_PATH, required, report" yaml:"nats_key_path"`
 
-	TargetsGlob           string        `env:"TARGETS_GLOB, report"`
-	TargetRefreshInterval time.Duration `env:"TARGET_REFRESH_INTERVAL, report"`
+	TargetsGlob           string        `env:"TARGETS_GLOB, report" yaml:"targets_glob"`
+	TargetRefreshInterval time.Duration `env:"TARGET_REFRESH_INTERVAL, report" yaml:"target_refresh_interval"`
 
-	MetricsCAPath   string `env:"METRICS_CA_PATH, required, report"`
-	MetricsCertPath string `env:"METRICS_CERT_PATH, required, report"`
-	MetricsKeyPath  string `env:"METRICS_KEY_PATH, required, report"`
+	MetricsCAPath   string `env:"METRICS_CA_PATH, required, report" yaml:"metrics_ca_path"`
+	MetricsCertPath string `env:"METRICS_CERT_PATH, required, report" yaml:"metrics_cert_path"`
+	MetricsKeyPath  string `env:"METRICS_KEY_PATH, required, report" yaml:"metrics_key_path"`
 
-	MetricsPort int `env:"METRICS_PORT, report"`
+	MetricsPort int `env:"METRICS_PORT, report" yaml:"metrics_port"`
 
-	DebugMetrics bool   `env:"DEBUG_METRICS, report"`
-	PprofPort    uint16 `env:"PPROF_PORT, report"`
+	DebugMetrics bool   `env:"DEBUG_METRICS, report" yaml:"debug_metrics"`
+	PprofPort    uint16 `env:"PPROF_PORT, report" yaml:"pprof_port"`
+
+	Log    logging.Config `yaml:"log"`
+	Health health.Config  `yaml:"health"`
+}
+
+// LoadConfig loads the configuration from the config file at path, if any,
+// and the environment. If loading the config fails the process exits.
+func LoadConfig(log *log.Logger, path string) Config {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+
+	if err := envstruct.WriteReport(&cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg
 }
 
-func LoadConfig(log *log.Logger) Config {
+// ReadConfig loads and validates the configuration from the config file at
+// path, if any, and the environment. Environment variables take precedence
+// over the file.
+func ReadConfig(path string) (Config, error) {
 	cfg := Config{
 		PublishInterval:       15 * time.Second,
 		TargetRefreshInterval: 15 * time.Second,
+		Log:                   logging.DefaultConfig(),
 	}
 
-	if err := envstruct.Load(&cfg); err != nil {
-		log.Fatal(err)
+	if err := configfile.Load(&cfg, path); err != nil {
+		return Config{}, err
 	}
 
-	if err := envstruct.WriteReport(&cfg); err != nil {
-		log.Fatal(err)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
 	}
 
-	return cfg
+	return cfg, nil
+}
+
+// Validate returns an error describing the first invalid setting.
+func (c Config) Validate() error {
+	if c.TargetRefreshInterval <= 0 {
+		return fmt.Errorf("TARGET_REFRESH_INTERVAL (target_refresh_interval) must be greater than zero, got %s", c.TargetRefreshInterval)
+	}
+
+	return c.Log.Validate()
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
 	"code.cloudfoundry.org/metrics-discovery/cmd/discovery-registrar/app"
@@ -23,21 +25,9 @@ var _ = Describe("configuration", func() {
 	}
 
 	BeforeEach(func() {
-		err := os.Setenv("NATS_HOSTS", "some-secret")
-		Expect(err).ToNot(HaveOccurred())
-
-		for _, v := range requiredVars {
-			err := os.Setenv(v, "some-value")
-			Expect(err).ToNot(HaveOccurred())
-		}
-	})
-	AfterEach(func() {
-		err := os.Unsetenv("NATS_HOSTS")
-		Expect(err).ToNot(HaveOccurred())
-
+		GinkgoT().Setenv("NATS_HOSTS", "some-secret")
 		for _, v := range requiredVars {
-			err := os.Unsetenv(v)
-			Expect(err).ToNot(HaveOccurred())
+			GinkgoT().Setenv(v, "some-value")
 		}
 	})
 
@@ -45,8 +35,28 @@ var _ = Describe("configuration", func() {
 		var output bytes.Buffer
 		envstruct.ReportWriter = &output
 		logger := log.New(GinkgoWriter, "", log.LstdFlags)
-		app.LoadConfig(logger)
+		app.LoadConfig(logger, "")
 		Expect(output.String()).ToNot(ContainSubstring("some-secret"))
 	})
 
+	It("loads the config file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yml")
+		Expect(os.WriteFile(path, []byte("targets_glob: /targets/*.yml\nmetrics_port: 9090\n"), 0600)).To(Succeed())
+
+		cfg, err := app.ReadConfig(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.TargetsGlob).To(Equal("/targets/*.yml"))
+		Expect(cfg.MetricsPort).To(Equal(9090))
+		Expect(cfg.TargetRefreshInterval).To(Equal(15 * time.Second))
+	})
+
+	It("names required settings that are missing", func() {
+		// Setenv restores NATS_HOSTS after the test.
+		GinkgoT().Setenv("NATS_HOSTS", "")
+		Expect(os.Unsetenv("NATS_HOSTS")).To(Succeed())
+
+		_, err := app.ReadConfig("")
+		Expect(err).To(MatchError("missing required settings: NATS_HOSTS (nats_hosts)"))
+	})
+
 })
@@ -2,17 +2,23 @@ package app
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof" // nolint:gosec
 	"time"
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 	"code.cloudfoundry.org/metrics-discovery/internal/registry"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
 	"gopkg.in/yaml.v3"
 )
 
+// healthIntervals is the number of publish intervals without a successful
+// publish after which the registrar is not ready.
+const healthIntervals = 3
+
 type TargetProvider func() []*target.Target
 
 type Publisher interface {
@@ -28,10 +34,11 @@ type DynamicRegistrar struct {
 	stop chan struct{}
 	done chan struct{}
 
-	logger      *log.Logger
+	logger      *slog.Logger
 	sent        metrics.Counter
 	metrics     metricsRegistry
 	pprofServer *http.Server
+	published   *health.Heartbeat
 }
 
 type metricsRegistry interface {
@@ -39,13 +46,15 @@ type metricsRegistry interface {
 	RegisterDebugMetrics()
 }
 
-func NewDynamicRegistrar(tp TargetProvider, p Publisher, publishInterval time.Duration, m metricsRegistry, log *log.Logger) *DynamicRegistrar {
+func NewDynamicRegistrar(tp TargetProvider, p Publisher, publishInterval time.Duration, m metricsRegistry, log *slog.Logger) *DynamicRegistrar {
 	return &DynamicRegistrar{
 		targetProvider:  tp,
 		publisher:       p,
 		publishInterval: publishInterval,
 		sent:            m.NewCounter("sent", "Total number of messages successfully sent to NATs."),
 		metrics:         m,
+		logger:          log,
+		published:       health.NewHeartbeat(),
 		stop:            make(chan struct{}),
 		done:            make(chan struct{}),
 	}
@@ -62,7 +71,7 @@ func (r *DynamicRegistrar) Start(debugMetrics bool, pprofPort uint16) {
 		go func() {
 			err := r.pprofServer.ListenAndServe()
 			if err != http.ErrServerClosed {
-				log.Fatalf("pprof error: %s", err)
+				logging.Fatal(r.logger, "pprof server failed", "error", err)
 			}
 		}()
 	}
@@ -82,23 +91,36 @@ func (r *DynamicRegistrar) Start(debugMetrics bool, pprofPort uint16) {
 }
 
 func (r *DynamicRegistrar) publishTargets() {
+	failed := false
 	targets := r.targetProvider()
 	for _, t := range targets {
 		bytes, err := yaml.Marshal(t)
 		if err != nil {
-			r.logger.Printf("unable to marshal target(%s): %s\n", t.Source, err)
+			r.logger.Error("unable to marshal target", "target", t.Source, "error", err)
 			continue
 		}
 
 		err = r.publisher.Publish(registry.ScrapeTargetQueueName, bytes)
 		if err != nil {
-			r.logger.Printf("unable to publish target(%s): %s\n", t.Source, err)
+			r.logger.Error("unable to publish target", "target", t.Source, "queue", registry.ScrapeTargetQueueName, "error", err)
+			failed = true
 			continue
 		}
 		r.sent.Add(float64(1))
+		r.logger.Debug("published target", "target", t.Source, "queue", registry.ScrapeTargetQueueName)
+	}
+
+	if !failed {
+		r.published.Beat()
 	}
 }
 
+// RegisterHealthChecks adds a readiness check to r that fails when the
+// targets could not be published for healthIntervals publish intervals.
+func (r *DynamicRegistrar) RegisterHealthChecks(hr *health.Registry) {
+	hr.AddReadiness("publish", r.published.Check("publish", healthIntervals*r.publishInterval))
+}
+
 func (r *DynamicRegistrar) Stop() {
 	if r.pprofServer != nil {
 		r.pprofServer.Close()
@@ -1,17 +1,22 @@
 package app_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 
 	"code.cloudfoundry.org/go-metric-registry/testhelpers"
 	"code.cloudfoundry.org/metrics-discovery/cmd/discovery-registrar/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"gopkg.in/yaml.v3"
 
-	"log"
 	"sync"
 	"time"
 )
@@ -22,7 +27,7 @@ var _ = Describe("Dynamic Registrar", func() {
 		targetProvider *fakeTargetProvider
 		pprofPort      uint16
 		metrics        *testhelpers.SpyMetricsRegistry
-		logger         *log.Logger
+		logger         *slog.Logger
 		registrar      *app.DynamicRegistrar
 	}
 
@@ -38,7 +43,7 @@ var _ = Describe("Dynamic Registrar", func() {
 			},
 			pprofPort: 1234,
 			metrics:   testhelpers.NewMetricsRegistry(),
-			logger:    log.New(GinkgoWriter, "", 0),
+			logger:    slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
 		}
 
 		tc.registrar = app.NewDynamicRegistrar(tc.targetProvider.GetTargets, tc.publisher, publishInterval, tc.metrics, tc.logger)
@@ -85,6 +90,72 @@ var _ = Describe("Dynamic Registrar", func() {
 		}).Should(BeNumerically(">=", 1))
 	})
 
+	It("logs targets that cannot be published", func() {
+		publisher := newFakePublisher()
+		publisher.err = errors.New("connection closed")
+		targetProvider := &fakeTargetProvider{
+			targets: []*target.Target{{Source: "some-source", Targets: []string{"10.0.0.1:8080"}}},
+		}
+		logs := gbytes.NewBuffer()
+		logger := slog.New(slog.NewTextHandler(logs, nil))
+
+		registrar := app.NewDynamicRegistrar(targetProvider.GetTargets, publisher, time.Second, testhelpers.NewMetricsRegistry(), logger)
+		go registrar.Start(false, 0)
+		defer registrar.Stop()
+
+		Eventually(logs).Should(gbytes.Say(
+			`level=ERROR msg="unable to publish target" target=some-source queue=metrics.scrape_targets error="connection closed"`,
+		))
+	})
+
+	It("is ready while targets are published", func() {
+		tc := setup(100*time.Millisecond, false)
+		defer teardown(tc)
+
+		checks := health.NewRegistry()
+		tc.registrar.RegisterHealthChecks(checks)
+		server := httptest.NewServer(checks.Handler())
+		defer server.Close()
+
+		Consistently(func() int {
+			resp, err := http.Get(server.URL + "/readyz")
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			return resp.StatusCode
+		}, 500*time.Millisecond).Should(Equal(http.StatusOK))
+	})
+
+	It("is not ready when targets cannot be published", func() {
+		publisher := newFakePublisher()
+		publisher.err = errors.New("connection closed")
+		targetProvider := &fakeTargetProvider{
+			targets: []*target.Target{{Source: "some-source", Targets: []string{"10.0.0.1:8080"}}},
+		}
+		registrar := app.NewDynamicRegistrar(targetProvider.GetTargets, publisher, 100*time.Millisecond, testhelpers.NewMetricsRegistry(), slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+		go registrar.Start(false, 0)
+		defer registrar.Stop()
+
+		checks := health.NewRegistry()
+		registrar.RegisterHealthChecks(checks)
+		server := httptest.NewServer(checks.Handler())
+		defer server.Close()
+
+		var report health.Report
+		Eventually(func() int {
+			resp, err := http.Get(server.URL + "/readyz")
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			return resp.StatusCode
+		}).Should(Equal(http.StatusServiceUnavailable))
+		Expect(report.Checks["publish"].Error).To(ContainSubstring("last successful publish"))
+
+		resp, err := http.Get(server.URL + "/healthz")
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
 	It("does not emit debug metrics by default", func() {
 		tc := setup(300*time.Millisecond, false)
 		defer teardown(tc)
@@ -117,6 +188,7 @@ type fakePublisher struct {
 	messages [][]byte
 	called   int
 	queue    string
+	err      error
 }
 
 func newFakePublisher() *fakePublisher {
@@ -129,6 +201,9 @@ func (fp *fakePublisher) Publish(queue string, msg []byte) error {
 
 	fp.queue = queue
 	fp.called++
+	if fp.err != nil {
+		return fp.err
+	}
 	fp.messages = append(fp.messages, msg)
 
 	return nil
@@ -2,8 +2,9 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,34 +14,67 @@ import (
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
 	"code.cloudfoundry.org/metrics-discovery/cmd/discovery-registrar/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
 	"code.cloudfoundry.org/tlsconfig"
 )
 
 func main() {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	logger.Printf("starting Metric Discovery Registrar...")
-	defer logger.Printf("closing Metric Discovery Registrar...")
+	flags := configfile.ParseFlags()
+	cfg := app.LoadConfig(log.New(os.Stderr, "", log.LstdFlags), flags.Path)
 
-	cfg := app.LoadConfig(logger)
+	logger, level, err := logging.NewFromConfig(os.Stderr, cfg.Log)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	if flags.Check {
+		logger.Info("configuration is valid")
+		return
+	}
 
-	natsConn := connectToNATS(cfg, logger)
+	logger.Info("starting Metric Discovery Registrar")
+	defer logger.Info("closing Metric Discovery Registrar")
 
-	targetProvider := target.NewFileProvider(cfg.TargetsGlob, cfg.TargetRefreshInterval, logger)
-	go targetProvider.Start()
+	http.Handle(logging.LevelPath, logging.LevelHandler(level))
 
-	m := metrics.NewRegistry(logger,
+	m := metrics.NewRegistry(slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		metrics.WithTLSServer(cfg.MetricsPort, cfg.MetricsCertPath, cfg.MetricsKeyPath, cfg.MetricsCAPath),
 	)
 
+	natsConn := connectToNATS(cfg, m, logger)
+
+	targetProvider := target.NewFileProvider(cfg.TargetsGlob, cfg.TargetRefreshInterval, logger)
+	go targetProvider.Start()
+
 	registrar := app.NewDynamicRegistrar(targetProvider.GetTargets, natsConn, cfg.TargetRefreshInterval, m, logger)
 	go registrar.Start(cfg.DebugMetrics, cfg.PprofPort)
 	defer registrar.Stop()
 
+	healthChecks := health.NewRegistry()
+	healthChecks.AddReadiness("nats", health.NATS(natsConn))
+	registrar.RegisterHealthChecks(healthChecks)
+	stopHealth := health.Serve(cfg.Health.Addr, healthChecks, logger)
+	defer stopHealth()
+
+	configfile.NotifyReload(func() {
+		reloaded, err := app.ReadConfig(flags.Path)
+		if err != nil {
+			logger.Error("invalid configuration", "error", err)
+			return
+		}
+		if l, err := logging.ParseLevel(reloaded.Log.Level); err == nil {
+			level.Set(l)
+		}
+		logger.Info("reloaded the log level, restart the registrar to apply other changes")
+	})
+
 	waitForTermination()
 }
 
-func connectToNATS(cfg app.Config, logger *log.Logger) *nats.Conn {
+func connectToNATS(cfg app.Config, m *metrics.Registry, logger *slog.Logger) *nats.Conn {
 	opts := nats.Options{
 		Servers:      cfg.NatsHosts,
 		PingInterval: 20 * time.Second,
@@ -51,44 +85,31 @@ func connectToNATS(cfg app.Config, logger *log.Logger) *nats.Conn {
 		ClosedCB:          closedCB(logger),
 		DisconnectedErrCB: disconnectErrHandler(logger),
 		ReconnectedCB:     reconnectedCB(logger),
-		TLSConfig:         getTLSConfig(cfg),
+		TLSConfig:         getTLSConfig(cfg, m, logger),
 	}
 
 	natsConn, err := opts.Connect()
 	if err != nil {
-		logger.Fatalf("Unable to connect to nats servers: %s", err)
+		logging.Fatal(logger, "unable to connect to nats servers", "error", err)
 	}
 	return natsConn
 }
 
-func getTLSConfig(cfg app.Config) *tls.Config {
-	caCert, err := os.ReadFile(cfg.NatsCAPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	caCertPool := x509.NewCertPool()
-
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		log.Fatalf("Failed to load CA certificate from file %s", cfg.NatsCAPath)
-	}
-
-	cert, err := tls.LoadX509KeyPair(cfg.NatsCertPath, cfg.NatsKeyPath)
+func getTLSConfig(cfg app.Config, m *metrics.Registry, logger *slog.Logger) *tls.Config {
+	certs, err := tlsreload.New(cfg.NatsCertPath, cfg.NatsKeyPath, cfg.NatsCAPath,
+		tlsreload.WithMetrics(m, "nats"),
+		tlsreload.WithLogger(logger),
+	)
 	if err != nil {
-		log.Fatalf("Failed to load certificate from cert: %s and key: %s", cfg.NatsCertPath, cfg.NatsKeyPath)
+		logging.Fatal(logger, "failed to load NATS certificates", "error", err)
 	}
 
-	config, err := tlsconfig.Build(
-		tlsconfig.WithInternalServiceDefaults(),
-		tlsconfig.WithIdentity(cert),
-	).Client(
-		tlsconfig.WithAuthority(caCertPool),
-	)
+	config, err := tlsconfig.Build(tlsconfig.WithInternalServiceDefaults()).Client()
 	if err != nil {
-		log.Fatalf("Failed to build TLS config: %s", err)
+		logging.Fatal(logger, "failed to build NATS TLS config", "error", err)
 	}
 
-	return config
+	return certs.ClientConfig(config)
 }
 
 func waitForTermination() {
@@ -97,20 +118,20 @@ func waitForTermination() {
 	<-c
 }
 
-func closedCB(logger *log.Logger) func(conn *nats.Conn) {
+func closedCB(logger *slog.Logger) func(conn *nats.Conn) {
 	return func(conn *nats.Conn) {
-		logger.Println("Nats Connection Closed")
+		logger.Info("nats connection closed")
 	}
 }
 
-func reconnectedCB(logger *log.Logger) func(conn *nats.Conn) {
+func reconnectedCB(logger *slog.Logger) func(conn *nats.Conn) {
 	return func(conn *nats.Conn) {
-		logger.Println("Nats Reconnected")
+		logger.Info("nats reconnected", "server", conn.ConnectedUrlRedacted())
 	}
 }
 
-func disconnectErrHandler(logger *log.Logger) func(conn *nats.Conn, err error) {
+func disconnectErrHandler(logger *slog.Logger) func(conn *nats.Conn, err error) {
 	return func(conn *nats.Conn, err error) {
-		logger.Printf("Nats Error %s\n", err)
+		logger.Warn("nats disconnected", "error", err)
 	}
 }
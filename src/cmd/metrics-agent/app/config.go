@@ -1,56 +1,295 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
-	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/config"
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/filter"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds the configuration for the metrics agent
 type Config struct {
-	MetricsExporter MetricsExporterConfig
-	MetricsServer   config.MetricsServer
-	GRPC            GRPCConfig
+	MetricsExporter MetricsExporterConfig `yaml:"metrics_exporter"`
+	MetricsServer   MetricsServerConfig   `yaml:"metrics_server"`
+	GRPC            GRPCConfig            `yaml:"grpc"`
+	StatsD          StatsDConfig          `yaml:"statsd"`
+	Dropsonde       DropsondeConfig       `yaml:"dropsonde"`
+	Push            PushConfig            `yaml:"push"`
+	OTLP            OTLPConfig            `yaml:"otlp"`
+	RemoteWrite     RemoteWriteConfig     `yaml:"remote_write"`
+	Log             logging.Config        `yaml:"log"`
+	Health          health.Config         `yaml:"health"`
 
 	// Scraper Certs
-	ScrapeKeyPath    string `env:"SCRAPE_KEY_PATH, required, report"`
-	ScrapeCertPath   string `env:"SCRAPE_CERT_PATH, required, report"`
-	ScrapeCACertPath string `env:"SCRAPE_CA_CERT_PATH, required, report"`
+	ScrapeKeyPath    string `env:"SCRAPE_KEY_PATH, required, report" yaml:"scrape_key_path"`
+	ScrapeCertPath   string `env:"SCRAPE_CERT_PATH, required, report" yaml:"scrape_cert_path"`
+	ScrapeCACertPath string `env:"SCRAPE_CA_CERT_PATH, required, report" yaml:"scrape_ca_cert_path"`
 
-	MetricsTargetFile string            `env:"METRICS_TARGETS_FILE, required, report"`
-	ConfigGlobs       []string          `env:"CONFIG_GLOBS, report"`
-	Tags              map[string]string `env:"AGENT_TAGS"`
-	Addr              string            `env:"ADDR, required, report"`
-	InstanceID        string            `env:"INSTANCE_ID, required, report"`
+	// ScrapeAllowedHosts are the host names, IP addresses and CIDR ranges
+	// other than local interfaces that scrape configs are allowed to use.
+	ScrapeAllowedHosts []string `env:"SCRAPE_ALLOWED_HOSTS, report" yaml:"scrape_allowed_hosts"`
+
+	// ScrapeMaxConcurrency limits the number of proxied scrapes that run at
+	// the same time. Zero means no limit.
+	ScrapeMaxConcurrency int `env:"SCRAPE_MAX_CONCURRENCY, report" yaml:"scrape_max_concurrency"`
+
+	FilterRules FilterRules `env:"FILTER_RULES" yaml:"filter_rules"`
+
+	MetricsTargetFile string            `env:"METRICS_TARGETS_FILE, required, report" yaml:"metrics_targets_file"`
+	ConfigGlobs       []string          `env:"CONFIG_GLOBS, report" yaml:"config_globs"`
+	Tags              map[string]string `env:"AGENT_TAGS" yaml:"tags"`
+	Addr              string            `env:"ADDR, required, report" yaml:"addr"`
+	InstanceID        string            `env:"INSTANCE_ID, required, report" yaml:"instance_id"`
 }
 
 // MetricsExporterConfig stores the configuration for the metrics server using a PORT
 // with mTLS certs.
 type MetricsExporterConfig struct {
-	Port                 uint16            `env:"METRICS_EXPORTER_PORT, required, report"`
-	WhitelistedTimerTags []string          `env:"WHITELISTED_TIMER_TAGS, required, report"`
-	DefaultLabels        map[string]string `env:"AGENT_TAGS"`
+	Port                 uint16            `env:"METRICS_EXPORTER_PORT, required, report" yaml:"port"`
+	WhitelistedTimerTags []string          `env:"WHITELISTED_TIMER_TAGS, required, report" yaml:"whitelisted_timer_tags"`
+	DefaultLabels        map[string]string `env:"AGENT_TAGS" yaml:"-"`
+
+	UTF8Names                   bool `env:"UTF8_NAMES, report" yaml:"utf8_names"`
+	DisableLoggregatorNameLabel bool `env:"DISABLE_LOGGREGATOR_NAME_LABEL, report" yaml:"disable_loggregator_name_label"`
+	NormalizeLabels             bool `env:"NORMALIZE_LABELS, report" yaml:"normalize_labels"`
+
+	AggregationRules AggregationRules `env:"AGGREGATION_RULES" yaml:"aggregation_rules"`
+
+	// AuthorizationRules restrict the ids and source ids clients may read
+	// by their certificate. Without rules every client may read everything.
+	AuthorizationRules AuthorizationRules `env:"EXPORTER_AUTHORIZATION_RULES" yaml:"authorization_rules"`
+
+	ExpirationInterval time.Duration `env:"EXPIRATION_INTERVAL, report" yaml:"expiration_interval"`
+	TimeToLive         time.Duration `env:"TTL, report" yaml:"ttl"`
+}
+
+// AggregationRules holds the JSON encoded rules used to aggregate envelope
+// metrics before they are exposed.
+type AggregationRules []collector.AggregationRule
+
+// UnmarshalEnv implements envstruct.Unmarshaller
+func (r *AggregationRules) UnmarshalEnv(v string) error {
+	var rules []collector.AggregationRule
+	err := json.Unmarshal([]byte(v), &rules)
+	if err != nil {
+		return fmt.Errorf("unable to parse aggregation rules: %s", err)
+	}
+
+	if err := collector.ValidateAggregationRules(rules); err != nil {
+		return err
+	}
+
+	*r = rules
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (r *AggregationRules) UnmarshalYAML(value *yaml.Node) error {
+	var rules []collector.AggregationRule
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+
+	if err := validateRules(value, rules); err != nil {
+		return err
+	}
+	if err := collector.ValidateAggregationRules(rules); err != nil {
+		return err
+	}
+
+	*r = rules
+	return nil
+}
+
+// FilterRules holds the JSON encoded rules used to filter envelopes before
+// they are converted.
+type FilterRules []filter.Rule
+
+// UnmarshalEnv implements envstruct.Unmarshaller
+func (r *FilterRules) UnmarshalEnv(v string) error {
+	var rules []filter.Rule
+	err := json.Unmarshal([]byte(v), &rules)
+	if err != nil {
+		return fmt.Errorf("unable to parse filter rules: %s", err)
+	}
+
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	*r = rules
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (r *FilterRules) UnmarshalYAML(value *yaml.Node) error {
+	var rules []filter.Rule
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+
+	if err := validateRules(value, rules); err != nil {
+		return err
+	}
+
+	*r = rules
+	return nil
+}
+
+// AuthorizationRules holds the JSON encoded rules used to authorize clients
+// by their certificate.
+type AuthorizationRules []authz.Rule
+
+// UnmarshalEnv implements envstruct.Unmarshaller
+func (r *AuthorizationRules) UnmarshalEnv(v string) error {
+	var rules []authz.Rule
+	err := json.Unmarshal([]byte(v), &rules)
+	if err != nil {
+		return fmt.Errorf("unable to parse authorization rules: %s", err)
+	}
+
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	*r = rules
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (r *AuthorizationRules) UnmarshalYAML(value *yaml.Node) error {
+	var rules []authz.Rule
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+
+	if err := validateRules(value, rules); err != nil {
+		return err
+	}
+
+	*r = rules
+	return nil
+}
 
-	ExpirationInterval time.Duration `env:"EXPIRATION_INTERVAL, report"`
-	TimeToLive         time.Duration `env:"TTL, report"`
+type validator interface {
+	Validate() error
+}
+
+// validateRules returns an error naming the line of the first invalid rule
+// in a YAML sequence.
+func validateRules[T validator](value *yaml.Node, rules []T) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("line %d: %s", value.Content[i].Line, err)
+		}
+	}
+	return nil
+}
+
+// MetricsServerConfig stores the configuration for the debug metrics server
+// of the agent.
+type MetricsServerConfig struct {
+	DebugMetrics bool   `env:"DEBUG_METRICS, report" yaml:"debug_metrics"`
+	Port         uint16 `env:"METRICS_PORT, report" yaml:"port"`
+	PprofPort    uint16 `env:"PPROF_PORT, report" yaml:"pprof_port"`
+	CAFile       string `env:"METRICS_CA_FILE_PATH, required, report" yaml:"ca_file"`
+	CertFile     string `env:"METRICS_CERT_FILE_PATH, required, report" yaml:"cert_file"`
+	KeyFile      string `env:"METRICS_KEY_FILE_PATH, required, report" yaml:"key_file"`
 }
 
 // GRPCConfig stores the configuration for the router as a server using a PORT
 // with mTLS certs.
 type GRPCConfig struct {
-	Port     uint16 `env:"AGENT_PORT, report"`
-	CAFile   string `env:"AGENT_CA_FILE_PATH, required, report"`
-	CertFile string `env:"AGENT_CERT_FILE_PATH, required, report"`
-	KeyFile  string `env:"AGENT_KEY_FILE_PATH, required, report"`
+	Port     uint16 `env:"AGENT_PORT, report" yaml:"port"`
+	CAFile   string `env:"AGENT_CA_FILE_PATH, required, report" yaml:"ca_file"`
+	CertFile string `env:"AGENT_CERT_FILE_PATH, required, report" yaml:"cert_file"`
+	KeyFile  string `env:"AGENT_KEY_FILE_PATH, required, report" yaml:"key_file"`
+
+	// AuthorizationRules restrict the source ids clients may emit by their
+	// certificate. Without rules every client may emit every source id.
+	AuthorizationRules AuthorizationRules `env:"INGRESS_AUTHORIZATION_RULES" yaml:"authorization_rules"`
+}
+
+// StatsDConfig stores the configuration for the optional StatsD listeners.
+// A listener is only started when its address is set.
+type StatsDConfig struct {
+	UDPAddr           string `env:"STATSD_UDP_ADDR, report" yaml:"udp_addr"`
+	TCPAddr           string `env:"STATSD_TCP_ADDR, report" yaml:"tcp_addr"`
+	SourceID          string `env:"STATSD_SOURCE_ID, report" yaml:"source_id"`
+	TCPMaxConnections int    `env:"STATSD_TCP_MAX_CONNECTIONS, report" yaml:"tcp_max_connections"`
+	TCPMaxLineLength  int    `env:"STATSD_TCP_MAX_LINE_LENGTH, report" yaml:"tcp_max_line_length"`
+}
+
+// DropsondeConfig stores the configuration for the optional loggregator v1
+// UDP listener. The listener is only started when its address is set.
+type DropsondeConfig struct {
+	UDPAddr string `env:"DROPSONDE_UDP_ADDR, report" yaml:"udp_addr"`
+}
+
+// PushConfig stores the configuration for the optional push endpoint on
+// localhost. The endpoint uses mTLS when a certificate is configured.
+type PushConfig struct {
+	Port        uint16        `env:"PUSH_PORT, report" yaml:"port"`
+	TTL         time.Duration `env:"PUSH_TTL, report" yaml:"ttl"`
+	MaxBodySize int64         `env:"PUSH_MAX_BODY_SIZE, report" yaml:"max_body_size"`
+	CAFile      string        `env:"PUSH_CA_FILE_PATH, report" yaml:"ca_file"`
+	CertFile    string        `env:"PUSH_CERT_FILE_PATH, report" yaml:"cert_file"`
+	KeyFile     string        `env:"PUSH_KEY_FILE_PATH, report" yaml:"key_file"`
+}
+
+// OTLPConfig stores the configuration for the optional OTLP receivers on
+// localhost. A receiver is only started when its port is set.
+type OTLPConfig struct {
+	GRPCPort        uint16 `env:"OTLP_GRPC_PORT, report" yaml:"grpc_port"`
+	HTTPPort        uint16 `env:"OTLP_HTTP_PORT, report" yaml:"http_port"`
+	DefaultSourceID string `env:"OTLP_DEFAULT_SOURCE_ID, report" yaml:"default_source_id"`
+}
+
+// RemoteWriteConfig stores the configuration for the optional Prometheus
+// remote write receiver on localhost. Received series are exposed with the
+// envelope metrics or, when ID is set, on the exporter endpoint with that id.
+type RemoteWriteConfig struct {
+	Port uint16        `env:"REMOTE_WRITE_PORT, report" yaml:"port"`
+	TTL  time.Duration `env:"REMOTE_WRITE_TTL, report" yaml:"ttl"`
+	ID   string        `env:"REMOTE_WRITE_ID, report" yaml:"id"`
 }
 
 // LoadConfig will load the configuration for the forwarder agent from the
-// environment. If loading the config fails for any reason this function will
-// panic.
-func LoadConfig() Config {
+// config file at path, if any, and the environment. If loading the config
+// fails for any reason this function will exit the process.
+func LoadConfig(path string) Config {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+
+	if err := envstruct.WriteReport(&cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg
+}
+
+// ReadConfig loads and validates the configuration from the config file at
+// path, if any, and the environment. Environment variables take precedence
+// over the file.
+func ReadConfig(path string) (Config, error) {
 	cfg := Config{
+		ScrapeMaxConcurrency: 16,
 		GRPC: GRPCConfig{
 			Port: 3458,
 		},
@@ -58,15 +297,73 @@ func LoadConfig() Config {
 			TimeToLive:         10 * time.Minute,
 			ExpirationInterval: time.Minute,
 		},
+		StatsD: StatsDConfig{
+			SourceID:          "statsd",
+			TCPMaxConnections: 100,
+			TCPMaxLineLength:  65535,
+		},
+		Push: PushConfig{
+			TTL:         10 * time.Minute,
+			MaxBodySize: 10 * 1024 * 1024,
+		},
+		OTLP: OTLPConfig{
+			DefaultSourceID: "otlp",
+		},
+		RemoteWrite: RemoteWriteConfig{
+			TTL: 10 * time.Minute,
+		},
+		Log: logging.DefaultConfig(),
 	}
 
-	if err := envstruct.Load(&cfg); err != nil {
-		log.Fatal(err)
+	if err := configfile.Load(&cfg, path); err != nil {
+		return Config{}, err
 	}
 
-	if err := envstruct.WriteReport(&cfg); err != nil {
-		log.Fatal(err)
+	// AGENT_TAGS sets both, the file only sets the tags.
+	cfg.MetricsExporter.DefaultLabels = cfg.Tags
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
 	}
 
-	return cfg
+	return cfg, nil
+}
+
+// Validate returns an error describing the first invalid setting.
+func (c Config) Validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"TTL (metrics_exporter.ttl)", c.MetricsExporter.TimeToLive},
+		{"EXPIRATION_INTERVAL (metrics_exporter.expiration_interval)", c.MetricsExporter.ExpirationInterval},
+		{"PUSH_TTL (push.ttl)", c.Push.TTL},
+		{"REMOTE_WRITE_TTL (remote_write.ttl)", c.RemoteWrite.TTL},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be greater than zero, got %s", d.name, d.value)
+		}
+	}
+
+	if c.ScrapeMaxConcurrency < 0 {
+		return fmt.Errorf("SCRAPE_MAX_CONCURRENCY (scrape_max_concurrency) must not be negative, got %d", c.ScrapeMaxConcurrency)
+	}
+
+	if c.StatsD.TCPMaxConnections <= 0 {
+		return fmt.Errorf("STATSD_TCP_MAX_CONNECTIONS (statsd.tcp_max_connections) must be greater than zero, got %d", c.StatsD.TCPMaxConnections)
+	}
+	if c.StatsD.TCPMaxLineLength <= 0 {
+		return fmt.Errorf("STATSD_TCP_MAX_LINE_LENGTH (statsd.tcp_max_line_length) must be greater than zero, got %d", c.StatsD.TCPMaxLineLength)
+	}
+
+	if c.Push.MaxBodySize <= 0 {
+		return fmt.Errorf("PUSH_MAX_BODY_SIZE (push.max_body_size) must be greater than zero, got %d", c.Push.MaxBodySize)
+	}
+
+	if (c.Push.CertFile == "") != (c.Push.KeyFile == "") {
+		return errors.New("PUSH_CERT_FILE_PATH (push.cert_file) and PUSH_KEY_FILE_PATH (push.key_file) must be set together")
+	}
+
+	return c.Log.Validate()
 }
@@ -0,0 +1,206 @@
+package app_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/cmd/metrics-agent/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	"code.cloudfoundry.org/metrics-discovery/internal/filter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Describe("AggregationRules", func() {
+		It("parses JSON encoded rules", func() {
+			var rules app.AggregationRules
+			err := rules.UnmarshalEnv(`[{"metric": "cpu", "operation": "sum", "by": ["app"], "replace": true}]`)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(rules).To(ConsistOf(collector.AggregationRule{
+				Metric:    "cpu",
+				Operation: "sum",
+				By:        []string{"app"},
+				Replace:   true,
+			}))
+		})
+
+		It("returns an error for invalid JSON", func() {
+			var rules app.AggregationRules
+			Expect(rules.UnmarshalEnv(`{`)).To(MatchError(ContainSubstring("unable to parse aggregation rules")))
+		})
+
+		It("returns an error for invalid rules", func() {
+			var rules app.AggregationRules
+			Expect(rules.UnmarshalEnv(`[{"metric": "cpu", "operation": "avg"}]`)).To(MatchError(ContainSubstring("unknown operation")))
+		})
+
+		It("returns an error for rules producing the same metric", func() {
+			var rules app.AggregationRules
+			Expect(rules.UnmarshalEnv(`[{"metric": "cpu", "operation": "sum"}, {"metric": "cpu", "operation": "sum", "by": ["app"]}]`)).
+				To(MatchError(ContainSubstring("both produce cpu:sum")))
+		})
+	})
+
+	Describe("FilterRules", func() {
+		It("parses JSON encoded rules", func() {
+			var rules app.FilterRules
+			err := rules.UnmarshalEnv(`[{"name": "no-debug", "action": "deny", "metrics": ["debug_*"], "tags": {"noisy": ""}}]`)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(rules).To(ConsistOf(filter.Rule{
+				Name:    "no-debug",
+				Action:  "deny",
+				Metrics: []string{"debug_*"},
+				Tags:    map[string]string{"noisy": ""},
+			}))
+		})
+
+		It("returns an error for invalid rules", func() {
+			var rules app.FilterRules
+			Expect(rules.UnmarshalEnv(`[{"name": "no-debug", "action": "drop"}]`)).To(MatchError(ContainSubstring("unknown action")))
+		})
+	})
+
+	Describe("AuthorizationRules", func() {
+		It("parses JSON encoded rules", func() {
+			var rules app.AuthorizationRules
+			err := rules.UnmarshalEnv(`[{"name": "tenant-a", "subjects": ["prometheus-a"], "ids": ["db-a"], "source_ids": ["app-a"]}]`)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(rules).To(ConsistOf(authz.Rule{
+				Name:      "tenant-a",
+				Subjects:  []string{"prometheus-a"},
+				IDs:       []string{"db-a"},
+				SourceIDs: []string{"app-a"},
+			}))
+		})
+
+		It("returns an error for invalid rules", func() {
+			var rules app.AuthorizationRules
+			Expect(rules.UnmarshalEnv(`[{"name": "tenant-a", "ids": ["db-a"]}]`)).To(MatchError(ContainSubstring("neither subjects nor sans")))
+		})
+	})
+})
+
+var _ = Describe("ReadConfig", func() {
+	const requiredSettings = `
+addr: 10.0.0.1
+instance_id: instance-1
+metrics_targets_file: /targets.yml
+scrape_key_path: /scrape.key
+scrape_cert_path: /scrape.crt
+scrape_ca_cert_path: /scrape-ca.crt
+metrics_exporter:
+  port: 9100
+  whitelisted_timer_tags: [source_id]
+metrics_server:
+  ca_file: /metrics-ca.crt
+  cert_file: /metrics.crt
+  key_file: /metrics.key
+grpc:
+  ca_file: /grpc-ca.crt
+  cert_file: /grpc.crt
+  key_file: /grpc.key
+`
+
+	var writeConfig = func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yml")
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("loads the config file and keeps the defaults", func() {
+		cfg, err := app.ReadConfig(writeConfig(requiredSettings + "tags:\n  deployment: cf\n"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.MetricsExporter.Port).To(Equal(uint16(9100)))
+		Expect(cfg.GRPC.Port).To(Equal(uint16(3458)))
+		Expect(cfg.MetricsExporter.TimeToLive).To(Equal(10 * time.Minute))
+		Expect(cfg.Tags).To(Equal(map[string]string{"deployment": "cf"}))
+		Expect(cfg.MetricsExporter.DefaultLabels).To(Equal(map[string]string{"deployment": "cf"}))
+	})
+
+	It("lets environment variables override the config file", func() {
+		GinkgoT().Setenv("AGENT_TAGS", "deployment:other")
+		GinkgoT().Setenv("TTL", "1m")
+
+		cfg, err := app.ReadConfig(writeConfig(requiredSettings + "tags:\n  deployment: cf\n"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.Tags).To(Equal(map[string]string{"deployment": "other"}))
+		Expect(cfg.MetricsExporter.TimeToLive).To(Equal(time.Minute))
+	})
+
+	It("loads the reloadable settings rendered by the job", func() {
+		cfg, err := app.ReadConfig(writeConfig(requiredSettings + `tags:
+  deployment: cf
+  instance_group: metrics-agent
+  index: "0"
+push:
+  ttl: 5m
+remote_write:
+  ttl: 2m
+log:
+  level: debug
+`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.Tags).To(Equal(map[string]string{"deployment": "cf", "instance_group": "metrics-agent", "index": "0"}))
+		Expect(cfg.Push.TTL).To(Equal(5 * time.Minute))
+		Expect(cfg.RemoteWrite.TTL).To(Equal(2 * time.Minute))
+		Expect(cfg.Log.Level).To(Equal("debug"))
+	})
+
+	It("names missing required settings", func() {
+		_, err := app.ReadConfig(writeConfig("addr: 10.0.0.1\n"))
+		Expect(err).To(MatchError(ContainSubstring("INSTANCE_ID (instance_id)")))
+		Expect(err).To(MatchError(ContainSubstring("METRICS_EXPORTER_PORT (metrics_exporter.port)")))
+	})
+
+	It("validates rules in the config file", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "filter_rules:\n- name: no-debug\n  action: drop\n"))
+		Expect(err).To(MatchError(ContainSubstring(`line 20: filter rule "no-debug" has unknown action "drop"`)))
+	})
+
+	It("rejects TTLs that are not positive", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "push:\n  ttl: 0s\n"))
+		Expect(err).To(MatchError("PUSH_TTL (push.ttl) must be greater than zero, got 0s"))
+	})
+
+	It("rejects StatsD TCP limits that are not positive", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "statsd:\n  tcp_max_connections: 0\n"))
+		Expect(err).To(MatchError("STATSD_TCP_MAX_CONNECTIONS (statsd.tcp_max_connections) must be greater than zero, got 0"))
+	})
+
+	It("rejects a push body size that is not positive", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "push:\n  max_body_size: 0\n"))
+		Expect(err).To(MatchError("PUSH_MAX_BODY_SIZE (push.max_body_size) must be greater than zero, got 0"))
+	})
+
+	It("requires the push certificate and key together", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "push:\n  cert_file: /push.crt\n"))
+		Expect(err).To(MatchError(ContainSubstring("must be set together")))
+	})
+
+	It("loads the log settings", func() {
+		cfg, err := app.ReadConfig(writeConfig(requiredSettings))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Log.Level).To(Equal("info"))
+		Expect(cfg.Log.Format).To(Equal("json"))
+
+		GinkgoT().Setenv("LOG_LEVEL", "debug")
+		cfg, err = app.ReadConfig(writeConfig(requiredSettings + "log:\n  level: warn\n  format: logfmt\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Log.Level).To(Equal("debug"))
+		Expect(cfg.Log.Format).To(Equal("logfmt"))
+	})
+
+	It("rejects unknown log levels", func() {
+		_, err := app.ReadConfig(writeConfig(requiredSettings + "log:\n  level: verbose\n"))
+		Expect(err).To(MatchError(HavePrefix("LOG_LEVEL (log.level): unknown log level")))
+	})
+})
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expositionSizeBuckets range from 1KiB to 64MiB.
+var expositionSizeBuckets = prometheus.ExponentialBuckets(1024, 4, 9)
+
+// exposition records the duration and size of the responses of an exporter
+// endpoint.
+type exposition struct {
+	duration metrics.Histogram
+	size     metrics.Histogram
+}
+
+// newExposition returns the metrics of the exporter endpoint, which is
+// /metrics for the envelopes or /metrics?id=<id> for a scrape config or
+// remote write id.
+func (m *MetricsAgent) newExposition(endpoint string) exposition {
+	labels := metrics.WithMetricLabels(map[string]string{"endpoint": endpoint})
+	return exposition{
+		duration: m.metrics.NewHistogram(
+			"exposition_duration_seconds",
+			"Duration of rendering and writing the response of an exporter endpoint.",
+			prometheus.DefBuckets,
+			labels,
+		),
+		size: m.metrics.NewHistogram(
+			"exposition_size_bytes",
+			"Size of the response body of an exporter endpoint as written, after compression.",
+			expositionSizeBuckets,
+			labels,
+		),
+	}
+}
+
+// removeExposition unregisters the metrics of an exporter endpoint that is
+// no longer served.
+func (m *MetricsAgent) removeExposition(e exposition) {
+	m.metrics.RemoveHistogram(e.duration)
+	m.metrics.RemoveHistogram(e.size)
+}
+
+func (e exposition) instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(cw, r)
+
+		e.duration.Observe(time.Since(start).Seconds())
+		e.size.Observe(float64(cw.written))
+	})
+}
+
+// countingResponseWriter counts the bytes written to the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/diodes"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+)
+
+const (
+	// envelopeBufferSize is the number of envelopes buffered between
+	// ingress and conversion.
+	envelopeBufferSize = 10000
+
+	// envelopeBufferSaturation is the fill level of the envelope buffer at
+	// which the agent is no longer ready.
+	envelopeBufferSaturation = 0.9
+)
+
+// envelopeBuffer counts the envelopes in a diode and reports their number
+// in the depth gauge. The diode drops the oldest envelopes when it is full.
+type envelopeBuffer struct {
+	diode   *diodes.ManyToOneEnvelopeV2
+	depth   metrics.Gauge
+	written atomic.Int64
+	read    atomic.Int64
+	dropped atomic.Int64
+}
+
+func newEnvelopeBuffer(depth metrics.Gauge, alert func(missed int)) *envelopeBuffer {
+	b := &envelopeBuffer{depth: depth}
+	b.diode = diodes.NewManyToOneEnvelopeV2(envelopeBufferSize, gendiodes.AlertFunc(func(missed int) {
+		b.dropped.Add(int64(missed))
+		alert(missed)
+	}))
+	return b
+}
+
+func (b *envelopeBuffer) Set(e *loggregator_v2.Envelope) {
+	b.written.Add(1)
+	b.diode.Set(e)
+	b.depth.Set(float64(b.Len()))
+}
+
+func (b *envelopeBuffer) TryNext() (*loggregator_v2.Envelope, bool) {
+	e, ok := b.diode.TryNext()
+	if ok {
+		b.read.Add(1)
+		b.depth.Set(float64(b.Len()))
+	}
+	return e, ok
+}
+
+// Len returns the number of envelopes waiting to be converted.
+func (b *envelopeBuffer) Len() int {
+	n := b.written.Load() - b.read.Load() - b.dropped.Load()
+	return int(max(0, min(n, envelopeBufferSize)))
+}
+
+// RegisterHealthChecks adds the checks of the agent to r. The ingress server
+// is checked for liveness. Readiness also requires the exporter endpoint to
+// be listening, the envelope buffer not to be saturated, the targets file to
+// be writable and every scrape config file to be loaded.
+func (m *MetricsAgent) RegisterHealthChecks(r *health.Registry) {
+	r.AddLiveness("ingress", func() error {
+		if !m.ingressServing.Load() {
+			return errors.New("ingress server is not serving")
+		}
+		return nil
+	})
+	r.AddReadiness("exporter", func() error {
+		if !m.exporterServing.Load() {
+			return errors.New("exporter endpoint is not serving")
+		}
+		return nil
+	})
+	r.AddReadiness("envelope_buffer", func() error {
+		buffer := m.envelopes.Load()
+		if buffer == nil {
+			return errors.New("envelope buffer is not created")
+		}
+		if n := buffer.Len(); float64(n) >= envelopeBufferSaturation*envelopeBufferSize {
+			return fmt.Errorf("envelope buffer is saturated with %d of %d envelopes", n, envelopeBufferSize)
+		}
+		return nil
+	})
+	r.AddReadiness("targets_file", health.Writable(m.cfg.MetricsTargetFile))
+	if m.scrapeConfigStatus != nil {
+		r.AddReadiness("scrape_configs", m.checkScrapeConfigs)
+	}
+}
+
+// checkScrapeConfigs fails when a scrape config file is invalid or cannot
+// be read without a previously loaded config.
+func (m *MetricsAgent) checkScrapeConfigs() error {
+	var failed []string
+	for _, s := range m.scrapeConfigStatus() {
+		if s.Status == scrapeconfig.StatusInvalid || s.Status == scrapeconfig.StatusUnreadable {
+			failed = append(failed, fmt.Sprintf("%s is %s", s.File, s.Status))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(failed, ", "))
+}
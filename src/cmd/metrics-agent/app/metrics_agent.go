@@ -3,112 +3,369 @@ package app
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	_ "net/http/pprof" // nolint:gosec
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
 	metrics "code.cloudfoundry.org/go-metric-registry"
-	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/diodes"
 	egress_v2 "code.cloudfoundry.org/loggregator-agent-release/src/pkg/egress/v2"
 	v2 "code.cloudfoundry.org/loggregator-agent-release/src/pkg/ingress/v2"
 	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
 	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	"code.cloudfoundry.org/metrics-discovery/internal/dropsonde"
+	"code.cloudfoundry.org/metrics-discovery/internal/filter"
 	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/ingressauth"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"code.cloudfoundry.org/metrics-discovery/internal/otlp"
+	"code.cloudfoundry.org/metrics-discovery/internal/push"
+	"code.cloudfoundry.org/metrics-discovery/internal/remotewrite"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	"code.cloudfoundry.org/metrics-discovery/internal/statsd"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
 	"code.cloudfoundry.org/tlsconfig"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
+const (
+	// shutdownTimeout bounds the time Stop waits for the envelope buffer to
+	// drain and for in-flight scrapes to finish.
+	shutdownTimeout = 15 * time.Second
+
+	// ingressShutdownTimeout bounds the time Stop waits for in-flight gRPC
+	// requests before closing the connections. Sender streams stay open
+	// until closed, so it is short to leave shutdownTimeout for draining
+	// the envelope buffer.
+	ingressShutdownTimeout = time.Second
+
+	// envelopePollInterval is the time between checks of the empty
+	// envelope buffer.
+	envelopePollInterval = 10 * time.Millisecond
+)
+
 type MetricsAgent struct {
-	cfg           Config
-	log           *log.Logger
-	metrics       Metrics
-	metricsServer *http.Server
-	scrapeConfigs map[string]scraper.PromScraperConfig
-	pprofPort     uint16
-	pprofServer   *http.Server
-	debugMetrics  bool
+	cfg               Config
+	log               *slog.Logger
+	metrics           Metrics
+	metricsServer     *http.Server
+	pprofPort         uint16
+	pprofServer       *http.Server
+	statsdServer      *statsd.Server
+	dropsondeServer   *dropsonde.Server
+	pushServer        *http.Server
+	otlpGRPCServer    *grpc.Server
+	otlpHTTPServer    *http.Server
+	remoteWriteServer *http.Server
+	ingressServer     *grpc.Server
+
+	// scrapeConfigs are the scrape configs read last. The proxied scrapes
+	// and the routing of envelopes derived from them are replaced as a
+	// whole so that they are read without locking.
+	scrapeConfigProvider ScrapeConfigProvider
+	scrapeConfigs        map[string]scrapeconfig.Config
+	promScraperConfigs   []scraper.PromScraperConfig
+	scrapes              atomic.Pointer[scrapes]
+	mergeCollectors      map[string]*collector.EnvelopeCollector
+	scrapeLimiter        *gatherer.ScrapeLimiter
+	scrapeCerts          *tlsreload.Reloader
+
+	// reloadMu guards the settings that can change at runtime and the
+	// components that use them.
+	reloadMu         sync.Mutex
+	runtimeCfg       Config
+	tagger           atomic.Pointer[egress_v2.Tagger]
+	collectors       []*collector.EnvelopeCollector
+	pushStore        *push.Store
+	remoteWriteStore *remotewrite.Store
+
+	// drain is closed once ingress stopped so that the envelope
+	// collection exits after writing the buffered envelopes.
+	drain          chan struct{}
+	collectionDone chan struct{}
+	stopOnce       sync.Once
+
+	// lifecycleMu is held by Run while it starts the servers so that Stop
+	// only reads them once they are all started. started and stopped keep
+	// a Run after Stop from starting anything.
+	lifecycleMu sync.Mutex
+	started     bool
+	stopped     bool
+
+	// instrumentation is shared by the collectors because OTLP metrics of a
+	// source id with a merge scrape config are held by the main collector.
+	instrumentation *collector.Instrumentation
+
+	// gatherers are exposed together with the envelope metrics.
+	gatherers []prometheus.Gatherer
+	// idGatherers are exposed under their id together with the scrape
+	// config of the same source id.
+	idGatherers  map[string]prometheus.Gatherer
+	debugMetrics bool
+
+	scrapeConfigStatus ScrapeConfigStatus
+	logLevel           *slog.LevelVar
+
+	// envelopes, ingressServing and exporterServing are reported by the
+	// health checks.
+	envelopes       atomic.Pointer[envelopeBuffer]
+	ingressServing  atomic.Bool
+	exporterServing atomic.Bool
+}
+
+type ScrapeConfigProvider func() ([]scrapeconfig.Config, error)
+
+// ScrapeConfigStatus returns the load status of the scrape config files.
+type ScrapeConfigStatus func() []scrapeconfig.FileStatus
+
+type Option func(*MetricsAgent)
+
+// WithScrapeConfigStatus lists the load status of the scrape config files at
+// /debug/scrape-configs on the pprof server.
+func WithScrapeConfigStatus(status ScrapeConfigStatus) Option {
+	return func(m *MetricsAgent) {
+		m.scrapeConfigStatus = status
+	}
 }
 
-type ScrapeConfigProvider func() ([]scraper.PromScraperConfig, error)
+// WithLogLevel serves the log level at /debug/log-level on the pprof server
+// and sets it when the configuration is reloaded.
+func WithLogLevel(level *slog.LevelVar) Option {
+	return func(m *MetricsAgent) {
+		m.logLevel = level
+	}
+}
 
 type Metrics interface {
 	NewCounter(name, helpText string, options ...metrics.MetricOption) metrics.Counter
+	NewGauge(name, helpText string, options ...metrics.MetricOption) metrics.Gauge
+	NewHistogram(name, helpText string, buckets []float64, options ...metrics.MetricOption) metrics.Histogram
+	RemoveCounter(metrics.Counter)
+	RemoveGauge(metrics.Gauge)
+	RemoveHistogram(metrics.Histogram)
 	RegisterDebugMetrics()
 }
 
-func NewMetricsAgent(cfg Config, scrapeConfigProvider ScrapeConfigProvider, metrics Metrics, log *log.Logger) *MetricsAgent {
-	scrapeConfigs, err := scrapeConfigProvider()
-	if err != nil {
-		log.Printf("error getting scrape config: %s", err)
+func NewMetricsAgent(cfg Config, scrapeConfigProvider ScrapeConfigProvider, metrics Metrics, log *slog.Logger, opts ...Option) *MetricsAgent {
+	ma := &MetricsAgent{
+		cfg:                  cfg,
+		runtimeCfg:           cfg,
+		log:                  log,
+		metrics:              metrics,
+		scrapeConfigProvider: scrapeConfigProvider,
+		mergeCollectors:      map[string]*collector.EnvelopeCollector{},
+		idGatherers:          map[string]prometheus.Gatherer{},
+		drain:                make(chan struct{}),
+		collectionDone:       make(chan struct{}),
+		instrumentation:      collector.NewInstrumentation(metrics),
+		pprofPort:            cfg.MetricsServer.PprofPort,
+		debugMetrics:         cfg.MetricsServer.DebugMetrics,
 	}
 
-	ma := &MetricsAgent{
-		cfg:           cfg,
-		log:           log,
-		metrics:       metrics,
-		scrapeConfigs: make(map[string]scraper.PromScraperConfig, len(scrapeConfigs)),
-		pprofPort:     cfg.MetricsServer.PprofPort,
-		debugMetrics:  cfg.MetricsServer.DebugMetrics,
+	for _, opt := range opts {
+		opt(ma)
 	}
 
-	for _, sc := range scrapeConfigs {
-		ma.scrapeConfigs[sc.SourceID] = sc
+	tagger := egress_v2.NewTagger(cfg.Tags)
+	ma.tagger.Store(&tagger)
+
+	scrapeConfigs, err := scrapeConfigProvider()
+	if err != nil {
+		log.Error("error getting scrape configs", "error", err)
 	}
+	ma.setScrapeConfigs(scrapeConfigs)
+
+	return ma
+}
 
+func (m *MetricsAgent) writeTargetsFile(tags map[string]string) {
 	target.WriteFile(target.WriterConfig{
-		MetricsHost: fmt.Sprintf("%s:%d", cfg.Addr, cfg.MetricsExporter.Port),
+		MetricsHost: fmt.Sprintf("%s:%d", m.cfg.Addr, m.cfg.MetricsExporter.Port),
 
-		DefaultLabels: cfg.Tags,
-		InstanceID:    cfg.InstanceID,
-		File:          cfg.MetricsTargetFile,
-		ScrapeConfigs: scrapeConfigs,
-	}, log)
+		DefaultLabels: tags,
+		InstanceID:    m.cfg.InstanceID,
+		File:          m.cfg.MetricsTargetFile,
+		ScrapeConfigs: m.promScraperConfigs,
+	}, m.log)
+}
 
-	return ma
+// Reload applies the settings of cfg that can change at runtime: the agent
+// tags, the log level and the TTLs of the exporter, the push endpoint and the
+// remote write receiver. Changes to other settings are logged and ignored until the agent
+// is restarted. The scrape config files are read again as well.
+func (m *MetricsAgent) Reload(cfg Config) {
+	m.reloadSettings(cfg)
+	m.RefreshScrapeConfigs()
+}
+
+func (m *MetricsAgent) reloadSettings(cfg Config) {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if !reflect.DeepEqual(staticSettings(m.runtimeCfg), staticSettings(cfg)) {
+		m.log.Warn("only tags, TTLs and the log level are reloaded, restart the agent to apply the other changed settings")
+	}
+
+	m.runtimeCfg.Tags = cfg.Tags
+	m.runtimeCfg.MetricsExporter.DefaultLabels = cfg.MetricsExporter.DefaultLabels
+	m.runtimeCfg.MetricsExporter.TimeToLive = cfg.MetricsExporter.TimeToLive
+	m.runtimeCfg.Push.TTL = cfg.Push.TTL
+	m.runtimeCfg.RemoteWrite.TTL = cfg.RemoteWrite.TTL
+	m.runtimeCfg.Log.Level = cfg.Log.Level
+
+	tagger := egress_v2.NewTagger(cfg.Tags)
+	m.tagger.Store(&tagger)
+	for _, c := range m.collectors {
+		c.SetDefaultTags(cfg.MetricsExporter.DefaultLabels)
+		c.SetSourceIDTTL(cfg.MetricsExporter.TimeToLive)
+	}
+	if m.statsdServer != nil {
+		m.statsdServer.SetGaugeTTL(cfg.MetricsExporter.TimeToLive)
+	}
+	if m.pushStore != nil {
+		m.pushStore.SetTTL(cfg.Push.TTL)
+	}
+	if m.remoteWriteStore != nil {
+		m.remoteWriteStore.SetTTL(cfg.RemoteWrite.TTL)
+	}
+	m.writeTargetsFile(cfg.Tags)
+	if m.logLevel != nil {
+		if level, err := logging.ParseLevel(cfg.Log.Level); err == nil {
+			m.logLevel.Set(level)
+		}
+	}
+
+	m.log.Info("reloaded configuration")
+}
+
+// staticSettings returns cfg without the settings that can be reloaded.
+func staticSettings(cfg Config) Config {
+	cfg.Tags = nil
+	cfg.MetricsExporter.DefaultLabels = nil
+	cfg.MetricsExporter.TimeToLive = 0
+	cfg.Push.TTL = 0
+	cfg.RemoteWrite.TTL = 0
+	cfg.Log.Level = ""
+	return cfg
 }
 
 func (m *MetricsAgent) Run() {
+	lis := m.start()
+	if lis == nil {
+		return
+	}
+	m.serveMetrics(lis)
+}
+
+// start starts the servers and the envelope collection and returns the
+// listener of the metrics server. It returns nil if the agent was already
+// stopped or the metrics port cannot be bound.
+func (m *MetricsAgent) start() net.Listener {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+	if m.stopped {
+		return nil
+	}
+	m.started = true
+
 	if m.debugMetrics {
 		m.metrics.RegisterDebugMetrics()
 		m.pprofServer = &http.Server{
 			Addr:              fmt.Sprintf("127.0.0.1:%d", m.pprofPort),
-			Handler:           http.DefaultServeMux,
+			Handler:           m.debugHandler(),
 			ReadHeaderTimeout: 2 * time.Second,
 		}
-		go func() { m.log.Println("PPROF SERVER STOPPED " + m.pprofServer.ListenAndServe().Error()) }()
+		pprofServer := m.pprofServer
+		go func() { m.log.Info("pprof server closed", "error", pprofServer.ListenAndServe()) }()
 	}
 	envelopeBuffer := m.envelopeDiode()
-	go m.startIngressServer(envelopeBuffer)
+	m.startIngressServer(envelopeBuffer)
+	m.startStatsDServer(envelopeBuffer)
+	m.startDropsondeServer(envelopeBuffer)
+	m.startPushServer()
+	m.startRemoteWriteServer()
+
+	promCollector := m.newEnvelopeCollector()
+	m.newScrapeClients()
+	m.applyScrapeConfigs()
+	m.startOTLPReceivers(promCollector)
+	go m.startEnvelopeCollection(promCollector, envelopeBuffer)
+	go m.refreshScrapeConfigs()
+
+	return m.listenMetricsServer(promCollector)
+}
 
-	promCollector := collector.NewEnvelopeCollector(
+// debugHandler serves pprof, the load status of the scrape configs and the
+// log level.
+func (m *MetricsAgent) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+	if m.logLevel != nil {
+		mux.Handle(logging.LevelPath, logging.LevelHandler(m.logLevel))
+	}
+	if m.scrapeConfigStatus != nil {
+		mux.HandleFunc("/debug/scrape-configs", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(m.scrapeConfigStatus()); err != nil {
+				m.log.Error("failed to write scrape config status", "error", err)
+			}
+		})
+	}
+	return mux
+}
+
+func (m *MetricsAgent) newEnvelopeCollector() *collector.EnvelopeCollector {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	c := collector.NewEnvelopeCollector(
 		m.metrics,
-		collector.WithSourceIDExpiration(m.cfg.MetricsExporter.TimeToLive, m.cfg.MetricsExporter.ExpirationInterval),
-		collector.WithDefaultTags(m.cfg.MetricsExporter.DefaultLabels),
+		collector.WithSourceIDExpiration(m.runtimeCfg.MetricsExporter.TimeToLive, m.cfg.MetricsExporter.ExpirationInterval),
+		collector.WithDefaultTags(m.runtimeCfg.MetricsExporter.DefaultLabels),
+		collector.WithUTF8Names(m.cfg.MetricsExporter.UTF8Names),
+		collector.WithLoggregatorNameLabel(!m.cfg.MetricsExporter.DisableLoggregatorNameLabel),
+		collector.WithLabelNormalization(m.cfg.MetricsExporter.NormalizeLabels),
+		collector.WithAggregationRules(m.cfg.MetricsExporter.AggregationRules),
+		collector.WithInstrumentation(m.instrumentation),
+		collector.WithLogger(m.log),
 	)
-	go m.startEnvelopeCollection(promCollector, envelopeBuffer)
-
-	m.startMetricsServer(promCollector)
+	m.collectors = append(m.collectors, c)
+	return c
 }
 
-func (m *MetricsAgent) envelopeDiode() *diodes.ManyToOneEnvelopeV2 {
+func (m *MetricsAgent) envelopeDiode() *envelopeBuffer {
 	ingressDropped := m.metrics.NewCounter(
 		"dropped",
 		"Total number of dropped envelopes.",
 		metrics.WithMetricLabels(map[string]string{"direction": "ingress"}),
 	)
-	return diodes.NewManyToOneEnvelopeV2(10000, gendiodes.AlertFunc(func(missed int) {
+	depth := m.metrics.NewGauge(
+		"envelope_buffer_depth",
+		"Number of envelopes waiting in the buffer between ingress and conversion.",
+	)
+	buffer := newEnvelopeBuffer(depth, func(missed int) {
 		ingressDropped.Add(float64(missed))
-	}))
+	})
+	m.envelopes.Store(buffer)
+	return buffer
 }
 
-func (m *MetricsAgent) startIngressServer(diode *diodes.ManyToOneEnvelopeV2) {
+func (m *MetricsAgent) startIngressServer(diode *envelopeBuffer) {
 	ingressMetric := m.metrics.NewCounter(
 		"ingress",
 		"Total number of envelopes ingressed by the agent.",
@@ -119,55 +376,251 @@ func (m *MetricsAgent) startIngressServer(diode *diodes.ManyToOneEnvelopeV2) {
 	)
 
 	receiver := v2.NewReceiver(diode, ingressMetric, originMetric)
-	tlsConfig := m.generateServerTLSConfig(m.cfg.GRPC.CertFile, m.cfg.GRPC.KeyFile, m.cfg.GRPC.CAFile)
-	server := v2.NewServer(
-		fmt.Sprintf("127.0.0.1:%d", m.cfg.GRPC.Port),
-		receiver,
+	tlsConfig := m.generateServerTLSConfig("grpc", m.cfg.GRPC.CertFile, m.cfg.GRPC.KeyFile, m.cfg.GRPC.CAFile)
+	opts := []grpc.ServerOption{
 		grpc.Creds(credentials.NewTLS(tlsConfig)),
-		grpc.MaxRecvMsgSize(10*1024*1024),
+		grpc.MaxRecvMsgSize(10 * 1024 * 1024),
+	}
+
+	if len(m.cfg.GRPC.AuthorizationRules) > 0 {
+		policy, err := authz.New(m.cfg.GRPC.AuthorizationRules)
+		if err != nil {
+			logging.Fatal(m.log, "unable to build ingress authorization", "error", err)
+		}
+
+		authorizer := ingressauth.New(policy, m.metrics)
+		opts = append(opts,
+			grpc.ChainStreamInterceptor(authorizer.StreamInterceptor()),
+			grpc.ChainUnaryInterceptor(authorizer.UnaryInterceptor()),
+		)
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", m.cfg.GRPC.Port))
+	if err != nil {
+		logging.Fatal(m.log, "failed to listen", "error", err)
+	}
+	m.log.Info("ingress server listening", "addr", lis.Addr().String())
+
+	ingressServer := grpc.NewServer(opts...)
+	loggregator_v2.RegisterIngressServer(ingressServer, receiver)
+	m.ingressServer = ingressServer
+	m.ingressServing.Store(true)
+	go func() {
+		err := ingressServer.Serve(lis)
+		m.ingressServing.Store(false)
+		m.log.Info("ingress server closed", "error", err)
+	}()
+}
+
+func (m *MetricsAgent) startStatsDServer(diode *envelopeBuffer) {
+	if m.cfg.StatsD.UDPAddr == "" && m.cfg.StatsD.TCPAddr == "" {
+		return
+	}
+
+	m.reloadMu.Lock()
+	m.statsdServer = statsd.NewServer(
+		m.cfg.StatsD.UDPAddr,
+		m.cfg.StatsD.TCPAddr,
+		m.cfg.StatsD.SourceID,
+		diode,
+		m.metrics,
+		m.log,
+		statsd.WithGaugeTTL(m.runtimeCfg.MetricsExporter.TimeToLive),
+		statsd.WithMaxConnections(m.cfg.StatsD.TCPMaxConnections),
+		statsd.WithMaxLineLength(m.cfg.StatsD.TCPMaxLineLength),
 	)
+	m.reloadMu.Unlock()
+	if err := m.statsdServer.Start(); err != nil {
+		logging.Fatal(m.log, "unable to start StatsD server", "error", err)
+	}
+}
 
-	server.Start()
+func (m *MetricsAgent) startDropsondeServer(diode *envelopeBuffer) {
+	if m.cfg.Dropsonde.UDPAddr == "" {
+		return
+	}
+
+	m.dropsondeServer = dropsonde.NewServer(m.cfg.Dropsonde.UDPAddr, diode, m.metrics, m.log)
+	if err := m.dropsondeServer.Start(); err != nil {
+		logging.Fatal(m.log, "unable to start dropsonde server", "error", err)
+	}
 }
 
-func (m *MetricsAgent) generateServerTLSConfig(certFile, keyFile, caFile string) *tls.Config {
-	tlsConfig, err := tlsconfig.Build(
-		tlsconfig.WithInternalServiceDefaults(),
-		tlsconfig.WithIdentityFromFile(certFile, keyFile),
-	).Server(
-		tlsconfig.WithClientAuthenticationFromFile(caFile),
+func (m *MetricsAgent) startPushServer() {
+	if m.cfg.Push.Port == 0 {
+		return
+	}
+
+	m.reloadMu.Lock()
+	store := push.NewStore(m.runtimeCfg.Push.TTL)
+	m.pushStore = store
+	m.reloadMu.Unlock()
+	m.gatherers = append(m.gatherers, store)
+
+	router := http.NewServeMux()
+	router.Handle("/metrics/job/", push.NewHandler(store, m.cfg.Push.MaxBodySize, m.log))
+	m.pushServer = &http.Server{
+		Addr:              fmt.Sprintf("127.0.0.1:%d", m.cfg.Push.Port),
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		Handler:           router,
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+
+	if m.cfg.Push.CertFile == "" {
+		go func() { m.log.Info("push server closed", "error", m.pushServer.ListenAndServe()) }()
+		return
+	}
+
+	m.pushServer.TLSConfig = m.generateServerTLSConfig("push", m.cfg.Push.CertFile, m.cfg.Push.KeyFile, m.cfg.Push.CAFile)
+	go func() { m.log.Info("push server closed", "error", m.pushServer.ListenAndServeTLS("", "")) }()
+}
+
+func (m *MetricsAgent) startRemoteWriteServer() {
+	if m.cfg.RemoteWrite.Port == 0 {
+		return
+	}
+
+	m.reloadMu.Lock()
+	store := remotewrite.NewStore(m.runtimeCfg.RemoteWrite.TTL)
+	m.remoteWriteStore = store
+	m.reloadMu.Unlock()
+
+	if m.cfg.RemoteWrite.ID == "" {
+		m.gatherers = append(m.gatherers, store)
+	} else {
+		m.idGatherers[m.cfg.RemoteWrite.ID] = store
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/api/v1/write", remotewrite.NewHandler(store, m.metrics, m.log))
+	m.remoteWriteServer = &http.Server{
+		Addr:              fmt.Sprintf("127.0.0.1:%d", m.cfg.RemoteWrite.Port),
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		Handler:           router,
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+	go func() { m.log.Info("remote write server closed", "error", m.remoteWriteServer.ListenAndServe()) }()
+}
+
+func (m *MetricsAgent) startOTLPReceivers(c *collector.EnvelopeCollector) {
+	if m.cfg.OTLP.GRPCPort == 0 && m.cfg.OTLP.HTTPPort == 0 {
+		return
+	}
+
+	receiver := otlp.NewReceiver(c, m.cfg.OTLP.DefaultSourceID, m.metrics, m.log)
+
+	if m.cfg.OTLP.GRPCPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", m.cfg.OTLP.GRPCPort))
+		if err != nil {
+			logging.Fatal(m.log, "unable to start OTLP gRPC receiver", "error", err)
+		}
+
+		m.otlpGRPCServer = grpc.NewServer(grpc.MaxRecvMsgSize(10 * 1024 * 1024))
+		colmetricspb.RegisterMetricsServiceServer(m.otlpGRPCServer, receiver)
+		go func() { m.log.Info("OTLP gRPC receiver closed", "error", m.otlpGRPCServer.Serve(lis)) }()
+	}
+
+	if m.cfg.OTLP.HTTPPort != 0 {
+		router := http.NewServeMux()
+		router.Handle("/v1/metrics", receiver)
+		m.otlpHTTPServer = &http.Server{
+			Addr:              fmt.Sprintf("127.0.0.1:%d", m.cfg.OTLP.HTTPPort),
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			Handler:           router,
+			ReadHeaderTimeout: 15 * time.Second,
+		}
+		go func() { m.log.Info("OTLP HTTP receiver closed", "error", m.otlpHTTPServer.ListenAndServe()) }()
+	}
+}
+
+// generateServerTLSConfig returns a TLS config that reloads the certificate
+// and CA files when they change. The expiry times are exposed as metrics
+// labeled with name.
+func (m *MetricsAgent) generateServerTLSConfig(name, certFile, keyFile, caFile string) *tls.Config {
+	certs, err := tlsreload.New(certFile, keyFile, caFile,
+		tlsreload.WithMetrics(m.metrics, name),
+		tlsreload.WithLogger(m.log),
 	)
 	if err != nil {
-		log.Fatalf("unable to generate server TLS Config: %s", err)
+		logging.Fatal(m.log, "unable to load certificates", "server", name, "error", err)
 	}
 
-	return tlsConfig
+	base, err := tlsconfig.Build(tlsconfig.WithInternalServiceDefaults()).Server()
+	if err != nil {
+		logging.Fatal(m.log, "unable to generate server TLS config", "server", name, "error", err)
+	}
+
+	return certs.ServerConfig(base)
 }
 
-func (m *MetricsAgent) startEnvelopeCollection(promCollector *collector.EnvelopeCollector, diode *diodes.ManyToOneEnvelopeV2) {
-	tagger := egress_v2.NewTagger(m.cfg.Tags).TagEnvelope
-	timerTagFilterer := egress_v2.NewTimerTagFilterer(m.cfg.MetricsExporter.WhitelistedTimerTags, tagger).Filter
-	envelopeWriter := egress_v2.NewEnvelopeWriter(
-		promCollector,
-		egress_v2.NewCounterAggregator(
-			timerTagFilterer,
-		),
-	)
+func (m *MetricsAgent) startEnvelopeCollection(
+	promCollector *collector.EnvelopeCollector,
+	diode *envelopeBuffer,
+) {
+	envelopeWriter := m.envelopeWriter(promCollector)
+
+	envelopeFilter, err := filter.New(m.cfg.FilterRules, m.metrics)
+	if err != nil {
+		logging.Fatal(m.log, "unable to build envelope filter", "error", err)
+	}
+
+	poll := time.NewTicker(envelopePollInterval)
+	defer poll.Stop()
 
 	for {
-		next := diode.Next()
-		if m.hasScrapeConfig(next.GetSourceId()) {
+		next, ok := diode.TryNext()
+		if !ok {
+			select {
+			case <-m.drain:
+				close(m.collectionDone)
+				return
+			case <-poll.C:
+			}
 			continue
 		}
+		writer := envelopeWriter
+
+		scrapes := m.scrapes.Load()
+		if sc, ok := scrapes.configs[next.GetSourceId()]; ok {
+			switch sc.GetEnvelopePolicy() {
+			case scrapeconfig.EnvelopePolicyMerge:
+				writer = scrapes.mergeWriters[sc.SourceID]
+			case scrapeconfig.EnvelopePolicyDrop:
+				scrapes.dropped[sc.SourceID].Add(1)
+				continue
+			}
+		}
 
-		err := envelopeWriter.Write(next)
+		if !envelopeFilter.Keep(next) {
+			continue
+		}
+
+		err = writer.Write(next)
 		if err != nil {
-			log.Printf("unable to write envelope: %s", err)
+			m.log.Error("unable to write envelope", "source_id", next.GetSourceId(), "error", err)
 		}
 	}
 }
 
-func (m *MetricsAgent) startMetricsServer(envelopeCollector *collector.EnvelopeCollector) {
+func (m *MetricsAgent) envelopeWriter(c *collector.EnvelopeCollector) egress_v2.EnvelopeWriter {
+	tagger := func(env *loggregator_v2.Envelope) {
+		m.tagger.Load().TagEnvelope(env)
+	}
+	timerTagFilterer := egress_v2.NewTimerTagFilterer(m.cfg.MetricsExporter.WhitelistedTimerTags, tagger).Filter
+	return egress_v2.NewEnvelopeWriter(
+		c,
+		egress_v2.NewCounterAggregator(
+			timerTagFilterer,
+		),
+	)
+}
+
+// listenMetricsServer creates the metrics server and returns its listener,
+// or nil if the port cannot be bound.
+func (m *MetricsAgent) listenMetricsServer(envelopeCollector *collector.EnvelopeCollector) net.Listener {
 	router := http.NewServeMux()
 	router.Handle(
 		"/metrics",
@@ -175,6 +628,7 @@ func (m *MetricsAgent) startMetricsServer(envelopeCollector *collector.EnvelopeC
 	)
 
 	tlsConfig := m.generateServerTLSConfig(
+		"metrics",
 		m.cfg.MetricsServer.CertFile,
 		m.cfg.MetricsServer.KeyFile,
 		m.cfg.MetricsServer.CAFile,
@@ -188,21 +642,69 @@ func (m *MetricsAgent) startMetricsServer(envelopeCollector *collector.EnvelopeC
 		ReadHeaderTimeout: 15 * time.Second,
 	}
 
-	log.Printf("Metrics server closing: %s", m.metricsServer.ListenAndServeTLS("", ""))
+	lis, err := net.Listen("tcp", m.metricsServer.Addr)
+	if err != nil {
+		m.log.Error("metrics server closed", "error", err)
+		return nil
+	}
+	m.exporterServing.Store(true)
+	return lis
+}
+
+func (m *MetricsAgent) serveMetrics(lis net.Listener) {
+	err := m.metricsServer.ServeTLS(lis, "", "")
+	m.exporterServing.Store(false)
+	m.log.Info("metrics server closed", "error", err)
 }
 
 func (m *MetricsAgent) buildMetricHandler(envelopeCollector *collector.EnvelopeCollector) http.Handler {
-	envelopeHandler := m.envelopeHandler(envelopeCollector)
-	proxyHandlers := m.proxyHandlers()
+	envelopeGatherers := m.envelopeGatherers(envelopeCollector)
+	envelopeExposition := m.newExposition("/metrics")
+	envelopeHandler := envelopeExposition.instrument(
+		promhttp.HandlerFor(envelopeGatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}),
+	)
+
+	authorize := len(m.cfg.MetricsExporter.AuthorizationRules) > 0
+	policy, err := authz.New(m.cfg.MetricsExporter.AuthorizationRules)
+	if err != nil {
+		logging.Fatal(m.log, "unable to build exporter authorization", "error", err)
+	}
+	authorizationFailures := m.metrics.NewCounter(
+		"exporter_authorization_failures",
+		"Total number of exporter requests denied because the client certificate is not authorized.",
+	)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
+
+		var grant authz.Grant
+		if authorize {
+			grant = policy.Grant(peerCertificate(r))
+		}
+
 		if id == "" {
-			envelopeHandler.ServeHTTP(w, r)
+			switch {
+			case !authorize:
+				envelopeHandler.ServeHTTP(w, r)
+			case grant.HasSourceIDs():
+				envelopeExposition.instrument(promhttp.HandlerFor(
+					grant.Gatherer(envelopeGatherers),
+					promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError},
+				)).ServeHTTP(w, r)
+			default:
+				authorizationFailures.Add(1)
+				w.WriteHeader(http.StatusForbidden)
+			}
 			return
 		}
 
-		handler, ok := proxyHandlers[id]
+		if authorize && !grant.ID(id) {
+			authorizationFailures.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		handler, ok := m.scrapes.Load().handlers[id]
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -212,51 +714,125 @@ func (m *MetricsAgent) buildMetricHandler(envelopeCollector *collector.EnvelopeC
 	})
 }
 
-func (m *MetricsAgent) envelopeHandler(envelopeCollector *collector.EnvelopeCollector) http.Handler {
+// peerCertificate returns the client certificate of the request, which has
+// been verified during the TLS handshake.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+func (m *MetricsAgent) envelopeGatherers(envelopeCollector *collector.EnvelopeCollector) prometheus.Gatherers {
 	envelopeGatherer := prometheus.NewRegistry()
 	envelopeGatherer.MustRegister(envelopeCollector)
-	envelopeHandler := promhttp.HandlerFor(
-		envelopeGatherer,
-		promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError},
-	)
-	return envelopeHandler
-}
-
-func (m *MetricsAgent) proxyHandlers() map[string]http.Handler {
-	metricHandlers := make(map[string]http.Handler, len(m.scrapeConfigs))
-	for sourceId, sc := range m.scrapeConfigs {
-		proxyGatherer := gatherer.NewProxyGatherer(
-			sc,
-			m.cfg.ScrapeCertPath,
-			m.cfg.ScrapeKeyPath,
-			m.cfg.ScrapeCACertPath,
-			m.metrics,
-			m.log,
-		)
+	return append(prometheus.Gatherers{envelopeGatherer}, m.gatherers...)
+}
+
+// proxyHandler scrapes the target on every request, limited to the scrape
+// timeout of the Prometheus server scraping the agent.
+func proxyHandler(proxyGatherer *gatherer.ProxyGatherer, others prometheus.Gatherers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var g prometheus.Gatherer = proxyGatherer.WithScrapeTimeout(scraperTimeout(r))
+		if len(others) > 0 {
+			g = append(prometheus.Gatherers{g}, others...)
+		}
 
-		metricHandlers[sourceId] = promhttp.HandlerFor(proxyGatherer, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+		promhttp.HandlerFor(g, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+	})
+}
+
+// scraperTimeout returns the timeout Prometheus sends with its scrapes or 0
+// if the header is missing or invalid.
+func scraperTimeout(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
 	}
-	return metricHandlers
+	return time.Duration(seconds * float64(time.Second))
 }
 
+// Stop stops ingress, writes the buffered envelopes and waits for in-flight
+// scrapes of the exporter endpoint within the shutdown timeout.
 func (m *MetricsAgent) Stop() {
-	if m.pprofServer != nil {
-		m.pprofServer.Close()
+	m.stopOnce.Do(m.stop)
+}
+
+func (m *MetricsAgent) stop() {
+	// Waiting for the lock lets a concurrent Run finish starting the
+	// servers, so that they are stopped and the buffer is drained.
+	m.lifecycleMu.Lock()
+	m.stopped = true
+	started := m.started
+	m.lifecycleMu.Unlock()
+	if !started {
+		return
 	}
-	ctx, cancelFunc := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), shutdownTimeout)
 
 	go func() {
 		defer cancelFunc()
 
+		m.stopIngress(ctx)
+
+		close(m.drain)
+		select {
+		case <-m.collectionDone:
+		case <-ctx.Done():
+			m.log.Warn("shutdown timeout exceeded before the envelope buffer was drained")
+		}
+
 		if m.metricsServer != nil {
 			_ = m.metricsServer.Shutdown(ctx)
 		}
+		if m.pprofServer != nil {
+			m.pprofServer.Close()
+		}
 	}()
 
 	<-ctx.Done()
 }
 
-func (m *MetricsAgent) hasScrapeConfig(sourceID string) bool {
-	_, ok := m.scrapeConfigs[sourceID]
-	return ok
+func (m *MetricsAgent) stopIngress(ctx context.Context) {
+	grpcCtx, cancel := context.WithTimeout(ctx, ingressShutdownTimeout)
+	defer cancel()
+	if m.ingressServer != nil {
+		gracefulStop(grpcCtx, m.ingressServer)
+	}
+	if m.statsdServer != nil {
+		m.statsdServer.Stop()
+	}
+	if m.dropsondeServer != nil {
+		m.dropsondeServer.Stop()
+	}
+	if m.otlpGRPCServer != nil {
+		gracefulStop(grpcCtx, m.otlpGRPCServer)
+	}
+	if m.pushServer != nil {
+		_ = m.pushServer.Shutdown(ctx)
+	}
+	if m.otlpHTTPServer != nil {
+		_ = m.otlpHTTPServer.Shutdown(ctx)
+	}
+	if m.remoteWriteServer != nil {
+		_ = m.remoteWriteServer.Shutdown(ctx)
+	}
+}
+
+// gracefulStop stops the server from accepting new connections and waits
+// for the open streams to finish until ctx is done, then closes them.
+func gracefulStop(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+		<-done
+	}
 }
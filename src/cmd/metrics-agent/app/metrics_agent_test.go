@@ -1,10 +1,14 @@
 package app_test
 
 import (
+	"bytes"
 	"context"
 	b64 "encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -15,9 +19,10 @@ import (
 
 	"code.cloudfoundry.org/go-loggregator/v10"
 	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
-	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/config"
 	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
 	"code.cloudfoundry.org/metrics-discovery/cmd/metrics-agent/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
 	"code.cloudfoundry.org/metrics-discovery/internal/target"
 	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
 	"code.cloudfoundry.org/tlsconfig"
@@ -38,9 +43,10 @@ var _ = Describe("MetricsAgent", func() {
 		targetsFile  string
 		metricsSpy   *metrichelpers.SpyMetricsRegistry
 		cfg          app.Config
-		testLogger   *log.Logger
+		testLogger   *slog.Logger
 
 		ingressClient            *loggregator.IngressClient
+		scrapeConfig             scrapeconfig.Config
 		fakeScrapeConfigProvider app.ScrapeConfigProvider
 	)
 
@@ -56,7 +62,7 @@ var _ = Describe("MetricsAgent", func() {
 				TimeToLive:           10 * time.Minute,
 				WhitelistedTimerTags: []string{"whitelist1", "whitelist2"},
 			},
-			MetricsServer: config.MetricsServer{
+			MetricsServer: app.MetricsServerConfig{
 				CAFile:   testCerts.CA(),
 				CertFile: testCerts.Cert("client"),
 				KeyFile:  testCerts.Key("client"),
@@ -80,19 +86,20 @@ var _ = Describe("MetricsAgent", func() {
 
 		stubPromServer := newStubPromServer()
 		stubPromServer.resp = promOutput
-		fakeScrapeConfigProvider = func() ([]scraper.PromScraperConfig, error) {
-			return []scraper.PromScraperConfig{{
-				Port:     stubPromServer.port,
-				SourceID: "source_id_scraped",
-				Scheme:   "http",
-				Path:     "metrics",
-				Labels: map[string]string{
-					"scrape_config_label": "lemons",
-				},
-			}}, nil
+		scrapeConfig = scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
+			Port:     stubPromServer.port,
+			SourceID: "source_id_scraped",
+			Scheme:   "http",
+			Path:     "metrics",
+			Labels: map[string]string{
+				"scrape_config_label": "lemons",
+			},
+		}}
+		fakeScrapeConfigProvider = func() ([]scrapeconfig.Config, error) {
+			return []scrapeconfig.Config{scrapeConfig}, nil
 		}
 
-		testLogger = log.New(GinkgoWriter, "", log.LstdFlags)
+		testLogger = slog.New(slog.NewTextHandler(GinkgoWriter, nil))
 		metricsSpy = metrichelpers.NewMetricsRegistry()
 	})
 
@@ -137,6 +144,73 @@ var _ = Describe("MetricsAgent", func() {
 		))
 	})
 
+	It("applies reloaded tags to the targets file and new envelopes", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		reloaded := cfg
+		reloaded.Tags = map[string]string{"a": "3"}
+		metricsAgent.Reload(reloaded)
+
+		f, err := os.ReadFile(targetsFile)
+		Expect(err).ToNot(HaveOccurred())
+		var targets []target.Target
+		Expect(yaml.Unmarshal(f, &targets)).To(Succeed())
+		Expect(targets[0].Labels).To(Equal(map[string]string{"a": "3", "instance_id": "instance_id"}))
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("total_counter", loggregator.WithTotal(22))
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("total_counter"))
+		metric := getMetric("total_counter", metricsPort, testCerts)
+		Expect(metric.GetLabel()).To(ContainElement(
+			&dto.LabelPair{Name: proto.String("a"), Value: proto.String("3")},
+		))
+		Expect(metric.GetLabel()).ToNot(ContainElement(
+			&dto.LabelPair{Name: proto.String("b"), Value: proto.String("2")},
+		))
+	})
+
+	It("applies refreshed scrape configs to the proxied scrapes, the envelopes and the targets file", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+		Eventually(getMetricFamilies(metricsPort, "source_id_scraped", testCerts), 3).Should(HaveKey("proxyMetric"))
+		removedEndpoint := map[string]string{"endpoint": "/metrics?id=source_id_scraped"}
+		Eventually(func() bool {
+			return metricsSpy.HasMetric("exposition_size_bytes", removedEndpoint)
+		}).Should(BeTrue())
+
+		scrapeConfig.SourceID = "other_source_id"
+		metricsAgent.RefreshScrapeConfigs()
+
+		_, err := getMetricsResponse(metricsPort, "source_id_scraped", testCerts)
+		Expect(err).To(MatchError("unexpected status code 404"))
+		Expect(getMetricFamilies(metricsPort, "other_source_id", testCerts)()).To(HaveKey("proxyMetric"))
+		Expect(metricsSpy.HasMetric("exposition_size_bytes", removedEndpoint)).To(BeFalse())
+		Expect(metricsSpy.HasMetric("exposition_duration_seconds", removedEndpoint)).To(BeFalse())
+
+		f, err := os.ReadFile(targetsFile)
+		Expect(err).ToNot(HaveOccurred())
+		var targets []target.Target
+		Expect(yaml.Unmarshal(f, &targets)).To(Succeed())
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[1].Labels).To(HaveKeyWithValue("source_id", "other_source_id"))
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("prom_scraped",
+				loggregator.WithTotal(22),
+				loggregator.WithCounterSourceInfo("source_id_scraped", "some-instance-id"),
+			)
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("prom_scraped"))
+	})
+
 	It("exposes metrics on a prometheus endpoint", func() {
 		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
 		go metricsAgent.Run()
@@ -153,6 +227,74 @@ var _ = Describe("MetricsAgent", func() {
 		Expect(metric.GetCounter().GetValue()).To(BeNumerically("==", 22))
 	})
 
+	It("reports its pipeline in its own metrics", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("total_counter", loggregator.WithTotal(22), loggregator.WithCounterSourceInfo("some-id", "0"))
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("total_counter"))
+		Eventually(getMetricFamilies(metricsPort, "source_id_scraped", testCerts), 3).Should(HaveKey("proxyMetric"))
+
+		Expect(metricsSpy.GetMetricValue("processed_envelopes", map[string]string{
+			"type":                  "counter",
+			"originating_source_id": "some-id",
+		})).To(BeNumerically(">", 0))
+		Expect(metricsSpy.GetMetricValue("held_series", map[string]string{
+			"originating_source_id": "some-id",
+		})).To(BeNumerically(">", 0))
+		Expect(metricsSpy.HasMetric("envelope_buffer_depth", map[string]string{})).To(BeTrue())
+		Eventually(func() float64 {
+			return metricsSpy.GetMetricValue("exposition_size_bytes", map[string]string{"endpoint": "/metrics"})
+		}).Should(BeNumerically(">", 0))
+		Eventually(func() float64 {
+			return metricsSpy.GetMetricValue("exposition_size_bytes", map[string]string{"endpoint": "/metrics?id=source_id_scraped"})
+		}).Should(BeNumerically(">", 0))
+		Expect(metricsSpy.HasMetric("exposition_duration_seconds", map[string]string{"endpoint": "/metrics"})).To(BeTrue())
+		Expect(metricsSpy.HasMetric("proxy_scrape_duration_seconds", map[string]string{"scrape_source_id": "source_id_scraped"})).To(BeTrue())
+	})
+
+	It("exposes the expiry times of its certificates", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		for _, name := range []string{"grpc", "metrics"} {
+			labels := map[string]string{"name": name}
+			Eventually(func() float64 {
+				return metricsSpy.GetMetricValue("tls_certificate_expiry_timestamp_seconds", labels)
+			}).Should(BeNumerically(">", time.Now().Unix()), name)
+			Expect(metricsSpy.GetMetricValue("tls_ca_expiry_timestamp_seconds", labels)).
+				To(BeNumerically(">", time.Now().Unix()), name)
+		}
+	})
+
+	It("serves utf-8 names only to scrapers that negotiate them", func() {
+		cfg.MetricsExporter.UTF8Names = true
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("gorouter.total_requests", loggregator.WithTotal(22))
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("gorouter_total_requests"))
+
+		resp, err := getMetricsResponseWithHeaders(metricsPort, "", testCerts, http.Header{
+			"Accept": []string{"text/plain;version=1.0.0;escaping=allow-utf-8"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring(`{"gorouter.total_requests"`))
+	})
+
 	It("does not emit debug metrics by default", func() {
 		cfg.MetricsServer.PprofPort = 1236
 		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
@@ -183,6 +325,133 @@ var _ = Describe("MetricsAgent", func() {
 		Expect(resp.StatusCode).To(Equal(200))
 	})
 
+	It("lists the status of the scrape config files on the pprof server", func() {
+		pprofPort, _ := getFreePorts()
+		cfg.MetricsServer.DebugMetrics = true
+		cfg.MetricsServer.PprofPort = pprofPort
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger,
+			app.WithScrapeConfigStatus(func() []scrapeconfig.FileStatus {
+				return []scrapeconfig.FileStatus{{File: "/jobs/db/prom_scraper_config.yml", Status: scrapeconfig.StatusInvalid, Error: "bad port"}}
+			}),
+		)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		var resp *http.Response
+		Eventually(func() error {
+			var err error
+			resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/scrape-configs", pprofPort))
+			return err
+		}).Should(Succeed())
+		defer resp.Body.Close()
+
+		var status []scrapeconfig.FileStatus
+		Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+		Expect(status).To(ConsistOf(scrapeconfig.FileStatus{
+			File:   "/jobs/db/prom_scraper_config.yml",
+			Status: scrapeconfig.StatusInvalid,
+			Error:  "bad port",
+		}))
+	})
+
+	It("serves the log level on the pprof server and sets it on reload", func() {
+		pprofPort, _ := getFreePorts()
+		cfg.MetricsServer.DebugMetrics = true
+		cfg.MetricsServer.PprofPort = pprofPort
+		cfg.Log.Level = "info"
+		level := &slog.LevelVar{}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger, app.WithLogLevel(level))
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		levelURL := fmt.Sprintf("http://127.0.0.1:%d/debug/log-level", pprofPort)
+		var resp *http.Response
+		Eventually(func() error {
+			var err error
+			resp, err = http.Get(levelURL)
+			return err
+		}).Should(Succeed())
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(body).To(MatchJSON(`{"level":"info"}`))
+
+		reloaded := cfg
+		reloaded.Log.Level = "debug"
+		metricsAgent.Reload(reloaded)
+		Expect(level.Level()).To(Equal(slog.LevelDebug))
+	})
+
+	It("is ready once ingress and the exporter endpoint are serving", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		checks := health.NewRegistry()
+		metricsAgent.RegisterHealthChecks(checks)
+		server := httptest.NewServer(checks.Handler())
+		defer server.Close()
+
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		status, report := getHealthReport(server.URL + "/readyz")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(report.Checks).To(Equal(map[string]health.Result{
+			"ingress":         {Status: health.StatusOK},
+			"exporter":        {Status: health.StatusOK},
+			"envelope_buffer": {Status: health.StatusOK},
+			"targets_file":    {Status: health.StatusOK},
+		}))
+	})
+
+	It("is not live when ingress is stopped", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		checks := health.NewRegistry()
+		metricsAgent.RegisterHealthChecks(checks)
+		server := httptest.NewServer(checks.Handler())
+		defer server.Close()
+
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+		metricsAgent.Stop()
+
+		Eventually(func() int {
+			status, _ := getHealthReport(server.URL + "/healthz")
+			return status
+		}).Should(Equal(http.StatusServiceUnavailable))
+		_, report := getHealthReport(server.URL + "/healthz")
+		Expect(report.Checks).To(Equal(map[string]health.Result{
+			"ingress": {Status: health.StatusFailing, Error: "ingress server is not serving"},
+		}))
+	})
+
+	It("is not ready while a scrape config file is invalid", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger,
+			app.WithScrapeConfigStatus(func() []scrapeconfig.FileStatus {
+				return []scrapeconfig.FileStatus{
+					{File: "/jobs/db/prom_scraper_config.yml", Status: scrapeconfig.StatusInvalid, Error: "bad port"},
+					{File: "/jobs/web/prom_scraper_config.yml", Status: scrapeconfig.StatusLoaded},
+				}
+			}),
+		)
+		checks := health.NewRegistry()
+		metricsAgent.RegisterHealthChecks(checks)
+		server := httptest.NewServer(checks.Handler())
+		defer server.Close()
+
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		status, report := getHealthReport(server.URL + "/readyz")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Status).To(Equal(health.StatusFailing))
+		Expect(report.Checks["scrape_configs"]).To(Equal(health.Result{
+			Status: health.StatusFailing,
+			Error:  "/jobs/db/prom_scraper_config.yml is invalid",
+		}))
+
+		status, _ = getHealthReport(server.URL + "/healthz")
+		Expect(status).To(Equal(http.StatusOK))
+	})
+
 	It("filters timer tags not in whitelist", func() {
 		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
 		go metricsAgent.Run()
@@ -236,6 +505,216 @@ var _ = Describe("MetricsAgent", func() {
 
 		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("non_prom_scraped"))
 		Consistently(getMetricFamilies(metricsPort, "", testCerts), 3).Should(Not(HaveKey("prom_scraped")))
+		Expect(metricsSpy.GetMetricValue(
+			"scrape_config_dropped_envelopes",
+			map[string]string{"scrape_source_id": "source_id_scraped"},
+		)).To(BeNumerically(">", 0))
+	})
+
+	It("can keep envelopes from source ids with a scrape config", func() {
+		scrapeConfig.EnvelopePolicy = scrapeconfig.EnvelopePolicyKeep
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("prom_scraped",
+				loggregator.WithTotal(22),
+				loggregator.WithCounterSourceInfo("source_id_scraped", "some-instance-id"),
+			)
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("prom_scraped"))
+		Expect(getMetricFamilies(metricsPort, "source_id_scraped", testCerts)()).ToNot(HaveKey("prom_scraped"))
+	})
+
+	It("can merge envelopes into the proxied metrics of their scrape config", func() {
+		scrapeConfig.EnvelopePolicy = scrapeconfig.EnvelopePolicyMerge
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("prom_scraped",
+				loggregator.WithTotal(22),
+				loggregator.WithCounterSourceInfo("source_id_scraped", "some-instance-id"),
+			)
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "source_id_scraped", testCerts), 3).Should(And(
+			HaveKey("proxyMetric"),
+			HaveKey("prom_scraped"),
+		))
+		Expect(getMetricFamilies(metricsPort, "", testCerts)()).ToNot(HaveKey("prom_scraped"))
+	})
+
+	It("converts StatsD metrics", func() {
+		udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		statsdAddr := udpConn.LocalAddr().String()
+		udpConn.Close()
+
+		cfg.StatsD = app.StatsDConfig{
+			UDPAddr:  statsdAddr,
+			SourceID: "statsd",
+		}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		conn, err := net.Dial("udp", statsdAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		cancel := doUntilCancelled(func() {
+			_, _ = fmt.Fprint(conn, "statsd_gauge:7|g|#app:some-app")
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("statsd_gauge"))
+		metric := getMetric("statsd_gauge", metricsPort, testCerts)
+		Expect(metric.GetGauge().GetValue()).To(Equal(7.0))
+		Expect(metric.GetLabel()).To(ContainElement(And(
+			HaveField("GetName()", "source_id"),
+			HaveField("GetValue()", "statsd"),
+		)))
+	})
+
+	It("converts dropsonde envelopes", func() {
+		udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		dropsondeAddr := udpConn.LocalAddr().String()
+		udpConn.Close()
+
+		cfg.Dropsonde = app.DropsondeConfig{UDPAddr: dropsondeAddr}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		conn, err := net.Dial("udp", dropsondeAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		packet := testhelpers.DropsondeEnvelope{Origin: "legacy"}.ValueMetric("legacy_gauge", 3, "ms").Marshal()
+		cancel := doUntilCancelled(func() {
+			_, _ = conn.Write(packet)
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("legacy_gauge"))
+		metric := getMetric("legacy_gauge", metricsPort, testCerts)
+		Expect(metric.GetGauge().GetValue()).To(Equal(3.0))
+		Expect(metric.GetLabel()).To(ContainElement(And(
+			HaveField("GetName()", "source_id"),
+			HaveField("GetValue()", "legacy"),
+		)))
+	})
+
+	It("exposes pushed metrics", func() {
+		pushPort, _ := getFreePorts()
+		cfg.Push = app.PushConfig{
+			Port:        pushPort,
+			TTL:         time.Minute,
+			MaxBodySize: 1024,
+		}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		url := fmt.Sprintf("http://127.0.0.1:%d/metrics/job/backup/instance/0", pushPort)
+		Eventually(func() error {
+			req, err := http.NewRequest(http.MethodPut, url, strings.NewReader("last_success 10\n"))
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			}
+			return nil
+		}).Should(Succeed())
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("last_success"))
+		metric := getMetric("last_success", metricsPort, testCerts)
+		Expect(metric.GetUntyped().GetValue()).To(Equal(10.0))
+		Expect(metric.GetLabel()).To(ContainElement(And(
+			HaveField("GetName()", "job"),
+			HaveField("GetValue()", "backup"),
+		)))
+	})
+
+	It("exposes OTLP metrics", func() {
+		otlpPort, _ := getFreePorts()
+		cfg.OTLP = app.OTLPConfig{
+			HTTPPort:        otlpPort,
+			DefaultSourceID: "otlp",
+		}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		body := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},` +
+			`"scopeMetrics":[{"metrics":[{"name":"queue_depth","gauge":{"dataPoints":[{"asDouble":3}]}}]}]}]}`
+		url := fmt.Sprintf("http://127.0.0.1:%d/v1/metrics", otlpPort)
+		Eventually(func() error {
+			resp, err := http.Post(url, "application/json", strings.NewReader(body))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			}
+			return nil
+		}).Should(Succeed())
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("queue_depth"))
+		metric := getMetric("queue_depth", metricsPort, testCerts)
+		Expect(metric.GetGauge().GetValue()).To(Equal(3.0))
+		Expect(metric.GetLabel()).To(ContainElement(And(
+			HaveField("GetName()", "source_id"),
+			HaveField("GetValue()", "checkout"),
+		)))
+	})
+
+	It("exposes remote write metrics", func() {
+		remoteWritePort, _ := getFreePorts()
+		cfg.RemoteWrite = app.RemoteWriteConfig{
+			Port: remoteWritePort,
+			TTL:  time.Minute,
+		}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		remoteWrite(remoteWritePort, "queue_depth", 3)
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("queue_depth"))
+		metric := getMetric("queue_depth", metricsPort, testCerts)
+		Expect(metric.GetUntyped().GetValue()).To(Equal(3.0))
+	})
+
+	It("exposes remote write metrics under their own id", func() {
+		remoteWritePort, _ := getFreePorts()
+		cfg.RemoteWrite = app.RemoteWriteConfig{
+			Port: remoteWritePort,
+			TTL:  time.Minute,
+			ID:   "remote_write",
+		}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		remoteWrite(remoteWritePort, "queue_depth", 3)
+
+		Eventually(getMetricFamilies(metricsPort, "remote_write", testCerts), 3).Should(HaveKey("queue_depth"))
+		Expect(getMetricFamilies(metricsPort, "", testCerts)()).ToNot(HaveKey("queue_depth"))
 	})
 
 	It("proxies to prom endpoints", func() {
@@ -278,6 +757,112 @@ var _ = Describe("MetricsAgent", func() {
 		Expect(err).To(MatchError("unexpected status code 404"))
 	})
 
+	It("restricts clients to the ids and source ids of their authorization rules", func() {
+		cfg.MetricsExporter.AuthorizationRules = app.AuthorizationRules{{
+			Name:      "client",
+			Subjects:  []string{"client"},
+			IDs:       []string{"source_id_scraped"},
+			SourceIDs: []string{"allowed"},
+		}}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("denied_counter", loggregator.WithCounterSourceInfo("denied", "0"), loggregator.WithTotal(1))
+			ingressClient.EmitCounter("allowed_counter", loggregator.WithCounterSourceInfo("allowed", "0"), loggregator.WithTotal(1))
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("allowed_counter"))
+		Expect(getMetricFamilies(metricsPort, "", testCerts)()).ToNot(HaveKey("denied_counter"))
+		Eventually(getMetricFamilies(metricsPort, "source_id_scraped", testCerts), 3).Should(HaveKey("proxyMetric"))
+
+		_, err := getMetricsResponse(metricsPort, "foobarbaz", testCerts)
+		Expect(err).To(MatchError("unexpected status code 403"))
+		Expect(metricsSpy.GetMetricValue("exporter_authorization_failures", nil)).To(Equal(1.0))
+	})
+
+	It("denies clients without authorization rule", func() {
+		cfg.MetricsExporter.AuthorizationRules = app.AuthorizationRules{{
+			Name:     "other",
+			Subjects: []string{"other"},
+			IDs:      []string{"*"},
+		}}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+
+		Eventually(func() error {
+			_, err := getMetricsResponse(metricsPort, "", testCerts)
+			return err
+		}, 10).Should(MatchError("unexpected status code 403"))
+		_, err := getMetricsResponse(metricsPort, "source_id_scraped", testCerts)
+		Expect(err).To(MatchError("unexpected status code 403"))
+	})
+
+	It("drops envelopes of source ids the ingress client may not emit", func() {
+		cfg.GRPC.AuthorizationRules = app.AuthorizationRules{{
+			Name:      "metron",
+			Subjects:  []string{"metron"},
+			SourceIDs: []string{"allowed"},
+		}}
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		cancel := doUntilCancelled(func() {
+			ingressClient.EmitCounter("spoofed_counter", loggregator.WithCounterSourceInfo("spoofed", "0"), loggregator.WithTotal(1))
+			ingressClient.EmitCounter("allowed_counter", loggregator.WithCounterSourceInfo("allowed", "0"), loggregator.WithTotal(1))
+		})
+		defer cancel()
+
+		Eventually(getMetricFamilies(metricsPort, "", testCerts), 3).Should(HaveKey("allowed_counter"))
+		Expect(getMetricFamilies(metricsPort, "", testCerts)()).ToNot(HaveKey("spoofed_counter"))
+		Eventually(func() float64 {
+			return metricsSpy.GetMetricValue("ingress_unauthorized_envelopes", map[string]string{"identity": "metron"})
+		}).Should(BeNumerically(">", 0))
+	})
+
+	It("stops ingress and the exporter endpoint when stopped", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		go metricsAgent.Run()
+		waitForMetricsEndpoint(metricsPort, testCerts)
+
+		metricsAgent.Stop()
+
+		_, err := getMetricsResponse(metricsPort, "", testCerts)
+		Expect(err).To(HaveOccurred())
+		_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", grpcPort))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stops the servers when stopped while starting", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		running := make(chan struct{})
+		go func() {
+			defer close(running)
+			metricsAgent.Run()
+		}()
+
+		metricsAgent.Stop()
+
+		Eventually(running, 10).Should(BeClosed())
+		_, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", grpcPort))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not start when stopped before running", func() {
+		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
+		metricsAgent.Stop()
+
+		metricsAgent.Run()
+
+		_, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", grpcPort))
+		Expect(err).To(HaveOccurred())
+		_, err = getMetricsResponse(metricsPort, "", testCerts)
+		Expect(err).To(HaveOccurred())
+	})
+
 	It("aggregates delta counters", func() {
 		metricsAgent = app.NewMetricsAgent(cfg, fakeScrapeConfigProvider, metricsSpy, testLogger)
 		go metricsAgent.Run()
@@ -331,6 +916,10 @@ func waitForMetricsEndpoint(port uint16, testCerts *testhelpers.TestCerts) {
 }
 
 func getMetricsResponse(port uint16, id string, testCerts *testhelpers.TestCerts) (*http.Response, error) {
+	return getMetricsResponseWithHeaders(port, id, testCerts, nil)
+}
+
+func getMetricsResponseWithHeaders(port uint16, id string, testCerts *testhelpers.TestCerts, headers http.Header) (*http.Response, error) {
 	tlsConfig, err := tlsconfig.Build(tlsconfig.WithIdentityFromFile(testCerts.Cert("client"), testCerts.Key("client"))).
 		Client(tlsconfig.WithAuthorityFromFile(testCerts.CA()))
 	if err != nil {
@@ -342,7 +931,15 @@ func getMetricsResponse(port uint16, id string, testCerts *testhelpers.TestCerts
 	}
 
 	url := fmt.Sprintf("https://127.0.0.1:%d/metrics?id=%s", port, id)
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
 	if err == nil && resp.StatusCode != http.StatusOK {
 		return resp, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 	}
@@ -350,6 +947,46 @@ func getMetricsResponse(port uint16, id string, testCerts *testhelpers.TestCerts
 	return resp, err
 }
 
+func getHealthReport(url string) (int, health.Report) {
+	resp, err := http.Get(url)
+	Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+
+	var report health.Report
+	Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+	return resp.StatusCode, report
+}
+
+func remoteWrite(port uint16, name string, value float64) {
+	body := testhelpers.RemoteWriteRequest{
+		Series: []testhelpers.RemoteWriteSeries{
+			{
+				Labels:  map[string]string{"__name__": name},
+				Samples: []testhelpers.RemoteWriteSample{{Value: value, Timestamp: time.Now().UnixMilli()}},
+			},
+		},
+	}.Compressed()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/api/v1/write", port)
+	Eventually(func() error {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	}).Should(Succeed())
+}
+
 func getMetricFamilies(port uint16, id string, testCerts *testhelpers.TestCerts) func() map[string]*dto.MetricFamily {
 	return func() map[string]*dto.MetricFamily {
 		resp, err := getMetricsResponse(port, id, testCerts)
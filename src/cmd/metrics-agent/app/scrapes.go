@@ -0,0 +1,226 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"time"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	egress_v2 "code.cloudfoundry.org/loggregator-agent-release/src/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeConfigRefreshInterval is the time between reads of the scrape
+// config files.
+const scrapeConfigRefreshInterval = time.Minute
+
+// scrapes is the state derived from the scrape configs in use. It is
+// replaced as a whole when the scrape configs change.
+type scrapes struct {
+	configs map[string]scrapeconfig.Config
+
+	// mergeWriters write the envelopes of source ids with the merge
+	// envelope policy and dropped counts the envelopes of source ids with
+	// the drop policy.
+	mergeWriters map[string]egress_v2.EnvelopeWriter
+	dropped      map[string]metrics.Counter
+
+	// handlers serve /metrics?id=<id> for the scrape configs and the id
+	// gatherers and expositions record their responses. proxies are kept so
+	// that unchanged scrape configs keep their connections.
+	handlers    map[string]http.Handler
+	expositions map[string]exposition
+	proxies     map[string]*proxy
+}
+
+type proxy struct {
+	config  scrapeconfig.Config
+	handler http.Handler
+}
+
+// RefreshScrapeConfigs reads the scrape config files again and applies
+// them to the proxied scrapes, the routing of envelopes and the targets
+// file. It is called periodically while the agent runs and when the
+// configuration is reloaded.
+func (m *MetricsAgent) RefreshScrapeConfigs() {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+	if m.stopped {
+		return
+	}
+
+	configs, err := m.scrapeConfigProvider()
+	if err != nil {
+		m.log.Error("error getting scrape configs", "error", err)
+		return
+	}
+
+	m.setScrapeConfigs(configs)
+	if m.started {
+		m.applyScrapeConfigs()
+	}
+}
+
+// setScrapeConfigs stores the scrape configs and writes the targets file if
+// they changed.
+func (m *MetricsAgent) setScrapeConfigs(configs []scrapeconfig.Config) {
+	scrapeConfigs := make(map[string]scrapeconfig.Config, len(configs))
+	promScraperConfigs := make([]scraper.PromScraperConfig, 0, len(configs))
+	for _, sc := range configs {
+		scrapeConfigs[sc.SourceID] = sc
+		promScraperConfigs = append(promScraperConfigs, sc.PromScraperConfig)
+	}
+
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	changed := !reflect.DeepEqual(m.promScraperConfigs, promScraperConfigs)
+	m.scrapeConfigs = scrapeConfigs
+	m.promScraperConfigs = promScraperConfigs
+	if changed {
+		m.writeTargetsFile(m.runtimeCfg.Tags)
+	}
+}
+
+// refreshScrapeConfigs refreshes the scrape configs until the agent is
+// stopped.
+func (m *MetricsAgent) refreshScrapeConfigs() {
+	ticker := time.NewTicker(scrapeConfigRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.drain:
+			return
+		case <-ticker.C:
+			m.RefreshScrapeConfigs()
+		}
+	}
+}
+
+// applyScrapeConfigs replaces the scrapes with the ones of the current
+// scrape configs. It must be called with lifecycleMu held after the agent
+// started.
+func (m *MetricsAgent) applyScrapeConfigs() {
+	previous := m.scrapes.Load()
+	s := &scrapes{
+		configs:      m.scrapeConfigs,
+		mergeWriters: map[string]egress_v2.EnvelopeWriter{},
+		dropped:      map[string]metrics.Counter{},
+		handlers:     make(map[string]http.Handler, len(m.scrapeConfigs)+len(m.idGatherers)),
+		expositions:  make(map[string]exposition, len(m.scrapeConfigs)+len(m.idGatherers)),
+		proxies:      make(map[string]*proxy, len(m.scrapeConfigs)),
+	}
+
+	for sourceID, sc := range m.scrapeConfigs {
+		switch sc.GetEnvelopePolicy() {
+		case scrapeconfig.EnvelopePolicyMerge:
+			s.mergeWriters[sourceID] = m.envelopeWriter(m.mergeCollector(sourceID))
+		case scrapeconfig.EnvelopePolicyDrop:
+			s.dropped[sourceID] = m.metrics.NewCounter(
+				"scrape_config_dropped_envelopes",
+				"Total number of envelopes dropped because their source id has a scrape config.",
+				metrics.WithMetricLabels(map[string]string{"scrape_source_id": sourceID}),
+			)
+		}
+
+		e := m.newExposition("/metrics?id=" + sourceID)
+		p, ok := previous.proxy(sourceID)
+		if !ok || !reflect.DeepEqual(p.config, sc) {
+			p = &proxy{config: sc, handler: m.newProxyHandler(sc, e)}
+		}
+		s.proxies[sourceID] = p
+		s.handlers[sourceID] = p.handler
+		s.expositions[sourceID] = e
+	}
+
+	for id, g := range m.idGatherers {
+		if _, ok := s.handlers[id]; ok {
+			continue
+		}
+		e := m.newExposition("/metrics?id=" + id)
+		s.handlers[id] = e.instrument(
+			promhttp.HandlerFor(g, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}),
+		)
+		s.expositions[id] = e
+	}
+
+	m.scrapes.Store(s)
+
+	if previous == nil {
+		return
+	}
+	for id, e := range previous.expositions {
+		if _, ok := s.expositions[id]; !ok {
+			m.removeExposition(e)
+		}
+	}
+}
+
+func (s *scrapes) proxy(sourceID string) (*proxy, bool) {
+	if s == nil {
+		return nil, false
+	}
+	p, ok := s.proxies[sourceID]
+	return p, ok
+}
+
+// mergeCollector returns the collector of the envelopes of a source id with
+// the merge envelope policy. Collectors are kept when the policy is removed
+// so that their series expire as usual.
+func (m *MetricsAgent) mergeCollector(sourceID string) *collector.EnvelopeCollector {
+	c, ok := m.mergeCollectors[sourceID]
+	if !ok {
+		c = m.newEnvelopeCollector()
+		m.mergeCollectors[sourceID] = c
+	}
+	return c
+}
+
+// newScrapeClients creates the limiter and the certificates shared by the
+// proxied scrapes.
+func (m *MetricsAgent) newScrapeClients() {
+	m.scrapeLimiter = gatherer.NewScrapeLimiter(m.cfg.ScrapeMaxConcurrency, m.metrics)
+
+	certs, err := tlsreload.New(m.cfg.ScrapeCertPath, m.cfg.ScrapeKeyPath, m.cfg.ScrapeCACertPath,
+		tlsreload.WithMetrics(m.metrics, "scrape"),
+		tlsreload.WithLogger(m.log),
+	)
+	if err != nil {
+		logging.Fatal(m.log, "unable to load scrape certificates", "error", err)
+	}
+	m.scrapeCerts = certs
+}
+
+// newProxyHandler returns the handler serving the metrics of the target of
+// a scrape config together with the envelopes merged into it and the id
+// gatherer of its source id.
+func (m *MetricsAgent) newProxyHandler(sc scrapeconfig.Config, e exposition) http.Handler {
+	proxyGatherer := gatherer.NewProxyGatherer(
+		sc,
+		m.cfg.ScrapeCertPath,
+		m.cfg.ScrapeKeyPath,
+		m.cfg.ScrapeCACertPath,
+		m.metrics,
+		m.log,
+		gatherer.WithScrapeLimiter(m.scrapeLimiter),
+		gatherer.WithCertificates(m.scrapeCerts),
+	)
+
+	var others prometheus.Gatherers
+	if sc.GetEnvelopePolicy() == scrapeconfig.EnvelopePolicyMerge {
+		envelopeGatherer := prometheus.NewRegistry()
+		envelopeGatherer.MustRegister(m.mergeCollector(sc.SourceID))
+		others = append(others, envelopeGatherer)
+	}
+	if g, ok := m.idGatherers[sc.SourceID]; ok {
+		others = append(others, g)
+	}
+
+	return e.instrument(proxyHandler(proxyGatherer, others))
+}
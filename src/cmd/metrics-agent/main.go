@@ -2,23 +2,38 @@ package main
 
 import (
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
-	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
 	"code.cloudfoundry.org/metrics-discovery/cmd/metrics-agent/app"
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
 )
 
 func main() {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	logger.Println("starting metrics-agent")
-	defer logger.Println("stopping metrics-agent")
+	flags := configfile.ParseFlags()
+	cfg := app.LoadConfig(flags.Path)
 
-	cfg := app.LoadConfig()
+	logger, level, err := logging.NewFromConfig(os.Stderr, cfg.Log)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	if flags.Check {
+		logger.Info("configuration is valid")
+		return
+	}
+
+	logger.Info("starting metrics-agent")
+	defer logger.Info("stopping metrics-agent")
 
 	m := metrics.NewRegistry(
-		logger,
+		slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		metrics.WithTLSServer(
 			int(cfg.MetricsServer.Port),
 			cfg.MetricsServer.CertFile,
@@ -27,6 +42,39 @@ func main() {
 		),
 	)
 
-	scrapeConfigProvider := scraper.NewConfigProvider(cfg.ConfigGlobs, time.Second, logger)
-	app.NewMetricsAgent(cfg, scrapeConfigProvider.Configs, m, logger).Run()
+	scrapeConfigProvider := scrapeconfig.NewProvider(
+		cfg.ConfigGlobs,
+		time.Second,
+		logger,
+		scrapeconfig.WithAllowedHosts(cfg.ScrapeAllowedHosts),
+		scrapeconfig.WithMetrics(m),
+	)
+	agent := app.NewMetricsAgent(cfg, scrapeConfigProvider.Configs, m, logger,
+		app.WithScrapeConfigStatus(scrapeConfigProvider.Status),
+		app.WithLogLevel(level),
+	)
+	go agent.Run()
+
+	healthChecks := health.NewRegistry()
+	agent.RegisterHealthChecks(healthChecks)
+	stopHealth := health.Serve(cfg.Health.Addr, healthChecks, logger)
+	defer stopHealth()
+
+	configfile.NotifyReload(func() {
+		cfg, err := app.ReadConfig(flags.Path)
+		if err != nil {
+			logger.Error("failed to reload configuration, keeping the current one", "error", err)
+			return
+		}
+		agent.Reload(cfg)
+	})
+
+	waitForTermination()
+	agent.Stop()
+}
+
+func waitForTermination() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	<-c
 }
@@ -0,0 +1,307 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	"code.cloudfoundry.org/metrics-discovery/internal/target"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// reservedLabels are set by the metrics agent on the targets of scrape
+// configs. Scrape config labels must not override them.
+var reservedLabels = map[string]struct{}{
+	"source_id":   {},
+	"instance_id": {},
+}
+
+// Issue is a problem found in a file.
+type Issue struct {
+	File    string
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.File, i.Message)
+}
+
+// ScrapeConfigFile is a scrape config and the file it was read from.
+type ScrapeConfigFile struct {
+	Path   string
+	Config scrapeconfig.Config
+}
+
+// ValidatorOption configures a Validator.
+type ValidatorOption func(*Validator)
+
+// WithoutFileChecks skips checking that the certificates and secrets
+// referenced by scrape configs are readable. This is useful when validating
+// configs outside of the VMs they are deployed to.
+func WithoutFileChecks() ValidatorOption {
+	return func(v *Validator) {
+		v.checkFiles = false
+	}
+}
+
+// Validator lints scrape configs and target files.
+type Validator struct {
+	checkFiles bool
+}
+
+func NewValidator(opts ...ValidatorOption) *Validator {
+	v := &Validator{
+		checkFiles: true,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// ScrapeConfigs validates the scrape config files. It returns the configs
+// without issues and the issues of all files.
+func (v *Validator) ScrapeConfigs(paths []string) ([]ScrapeConfigFile, []Issue) {
+	var (
+		valid     []ScrapeConfigFile
+		issues    []Issue
+		sourceIDs = map[string]string{}
+	)
+
+	for _, path := range paths {
+		cfg, err := scrapeconfig.ParseFile(path, time.Minute)
+		if err != nil {
+			issues = append(issues, Issue{File: path, Message: err.Error()})
+			continue
+		}
+
+		fileIssues := v.scrapeConfig(path, cfg)
+		if other, ok := sourceIDs[cfg.SourceID]; ok && cfg.SourceID != "" {
+			fileIssues = append(fileIssues, Issue{
+				File:    path,
+				Message: fmt.Sprintf("source_id %q is already used by %s", cfg.SourceID, other),
+			})
+		} else {
+			sourceIDs[cfg.SourceID] = path
+		}
+
+		if len(fileIssues) == 0 {
+			valid = append(valid, ScrapeConfigFile{Path: path, Config: cfg})
+		}
+		issues = append(issues, fileIssues...)
+	}
+
+	return valid, issues
+}
+
+func (v *Validator) scrapeConfig(path string, cfg scrapeconfig.Config) []Issue {
+	var messages []string
+	add := func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.SourceID == "" {
+		add("missing source_id")
+	}
+	if err := cfg.ValidatePort(); err != nil {
+		add("%s", err)
+	}
+	if cfg.Scheme != "http" && cfg.Scheme != "https" {
+		add("unknown scheme %q", cfg.Scheme)
+	}
+	if err := cfg.Validate(); err != nil {
+		add("%s", err)
+	}
+
+	for _, name := range sortedKeys(cfg.Labels) {
+		if _, ok := reservedLabels[name]; ok || strings.HasPrefix(name, "__") {
+			add("label %s is reserved", name)
+			continue
+		}
+		if !model.LabelName(name).IsValidLegacy() {
+			add("invalid label name %q", name)
+		}
+	}
+
+	if v.checkFiles {
+		for _, f := range referencedFiles(cfg) {
+			if err := readable(f.path); err != nil {
+				add("%s is not readable: %s", f.property, err)
+			}
+		}
+	}
+
+	issues := make([]Issue, 0, len(messages))
+	for _, m := range messages {
+		issues = append(issues, Issue{File: path, Message: m})
+	}
+	return issues
+}
+
+type referencedFile struct {
+	property string
+	path     string
+}
+
+func referencedFiles(cfg scrapeconfig.Config) []referencedFile {
+	files := []referencedFile{
+		{"ca_path", cfg.CaPath},
+		{"client_cert_path", cfg.ClientCertPath},
+		{"client_key_path", cfg.ClientKeyPath},
+		{"bearer_token_file", cfg.BearerTokenFile},
+	}
+	if cfg.BasicAuth != nil {
+		files = append(files, referencedFile{"basic_auth.password_file", cfg.BasicAuth.PasswordFile})
+	}
+	if cfg.OAuth2 != nil {
+		files = append(files,
+			referencedFile{"oauth2.client_secret_file", cfg.OAuth2.ClientSecretFile},
+			referencedFile{"oauth2.ca_file", cfg.OAuth2.CAFile},
+		)
+	}
+
+	var set []referencedFile
+	for _, f := range files {
+		if f.path != "" {
+			set = append(set, f)
+		}
+	}
+	return set
+}
+
+func readable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Targets validates the target files read by the discovery registrar.
+func (v *Validator) Targets(paths []string) []Issue {
+	var (
+		issues  []Issue
+		sources = map[string]string{}
+	)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			issues = append(issues, Issue{File: path, Message: fmt.Sprintf("cannot read file: %s", err)})
+			continue
+		}
+
+		var targets []target.Target
+		if err := yaml.Unmarshal(data, &targets); err != nil {
+			issues = append(issues, Issue{File: path, Message: fmt.Sprintf("unmarshal: %s", err)})
+			continue
+		}
+
+		for i, t := range targets {
+			for _, m := range targetIssues(t) {
+				issues = append(issues, Issue{File: path, Message: fmt.Sprintf("target %d: %s", i, m)})
+			}
+
+			if t.Source == "" {
+				continue
+			}
+			if other, ok := sources[t.Source]; ok {
+				issues = append(issues, Issue{
+					File:    path,
+					Message: fmt.Sprintf("target %d: source %q is already used by %s", i, t.Source, other),
+				})
+				continue
+			}
+			sources[t.Source] = path
+		}
+	}
+
+	return issues
+}
+
+func targetIssues(t target.Target) []string {
+	var messages []string
+	if t.Source == "" {
+		messages = append(messages, "missing source")
+	}
+	if len(t.Targets) == 0 {
+		messages = append(messages, "missing targets")
+	}
+
+	for _, addr := range t.Targets {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host == "" {
+			messages = append(messages, fmt.Sprintf("invalid address %q", addr))
+			continue
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			messages = append(messages, fmt.Sprintf("invalid port in address %q", addr))
+		}
+	}
+
+	for _, name := range sortedKeys(t.Labels) {
+		if !model.LabelName(name).IsValidLegacy() {
+			messages = append(messages, fmt.Sprintf("invalid label name %q", name))
+		}
+	}
+
+	return messages
+}
+
+// ScrapeResult is the outcome of a test scrape.
+type ScrapeResult struct {
+	Path     string
+	Families int
+	Err      error
+}
+
+// TestScrapes scrapes every config once the way the metrics agent proxies
+// scrapes, using the given client certificate for https targets.
+func TestScrapes(files []ScrapeConfigFile, certFile, keyFile, caFile string, log *slog.Logger) []ScrapeResult {
+	results := make([]ScrapeResult, 0, len(files))
+	for _, f := range files {
+		g := gatherer.NewProxyGatherer(f.Config, certFile, keyFile, caFile, discardMetrics{}, log)
+		families, err := g.Gather()
+		results = append(results, ScrapeResult{Path: f.Path, Families: len(families), Err: err})
+	}
+	return results
+}
+
+// discardMetrics is a registry whose metrics are not exposed.
+type discardMetrics struct{}
+
+func (discardMetrics) NewCounter(string, string, ...metrics.MetricOption) metrics.Counter {
+	return discardCounter{}
+}
+
+func (discardMetrics) NewHistogram(string, string, []float64, ...metrics.MetricOption) metrics.Histogram {
+	return discardHistogram{}
+}
+
+type discardCounter struct{}
+
+func (discardCounter) Add(float64) {}
+
+type discardHistogram struct{}
+
+func (discardHistogram) Observe(float64) {}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+package app_test
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/metrics-discovery/cmd/metrics-discovery/app"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	var writeFile = func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	Describe("ScrapeConfigs", func() {
+		It("returns valid configs without issues", func() {
+			path := writeFile("a.yml", "port: 8080\nsource_id: a\nlabels:\n  team: db\n")
+
+			valid, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(issues).To(BeEmpty())
+			Expect(valid).To(HaveLen(1))
+			Expect(valid[0].Path).To(Equal(path))
+			Expect(valid[0].Config.SourceID).To(Equal("a"))
+		})
+
+		It("reports files that cannot be parsed", func() {
+			path := writeFile("a.yml", "port: [\n")
+
+			valid, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(valid).To(BeEmpty())
+			Expect(issues).To(ConsistOf(app.Issue{File: path, Message: issues[0].Message}))
+			Expect(issues[0].Message).To(ContainSubstring("unmarshal"))
+		})
+
+		It("reports invalid ports, schemes and missing source ids", func() {
+			path := writeFile("a.yml", "port: 70000\nscheme: ftp\n")
+
+			valid, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(valid).To(BeEmpty())
+			Expect(issues).To(ConsistOf(
+				app.Issue{File: path, Message: "missing source_id"},
+				app.Issue{File: path, Message: `invalid port "70000"`},
+				app.Issue{File: path, Message: `unknown scheme "ftp"`},
+			))
+		})
+
+		It("reports invalid properties", func() {
+			path := writeFile("a.yml", "port: 8080\nsource_id: a\nenvelope_policy: forward\n")
+
+			_, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(issues).To(ConsistOf(app.Issue{File: path, Message: `unknown envelope_policy "forward"`}))
+		})
+
+		It("reports duplicate source ids", func() {
+			first := writeFile("a.yml", "port: 8080\nsource_id: db\n")
+			second := writeFile("b.yml", "port: 8081\nsource_id: db\n")
+
+			valid, issues := app.NewValidator().ScrapeConfigs([]string{first, second})
+			Expect(valid).To(HaveLen(1))
+			Expect(issues).To(ConsistOf(app.Issue{
+				File:    second,
+				Message: fmt.Sprintf(`source_id "db" is already used by %s`, first),
+			}))
+		})
+
+		It("reports reserved and invalid labels", func() {
+			path := writeFile("a.yml", "port: 8080\nsource_id: a\nlabels:\n  __param_id: b\n  source_id: c\n  team-name: d\n")
+
+			_, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(issues).To(ConsistOf(
+				app.Issue{File: path, Message: "label __param_id is reserved"},
+				app.Issue{File: path, Message: "label source_id is reserved"},
+				app.Issue{File: path, Message: `invalid label name "team-name"`},
+			))
+		})
+
+		It("reports unreadable certificates and secrets", func() {
+			ca := writeFile("ca.crt", "ca")
+			path := writeFile("a.yml", fmt.Sprintf(
+				"port: 8080\nsource_id: a\nscheme: https\nca_path: %s\nbasic_auth:\n  username: admin\n  password_file: %s\n",
+				ca, filepath.Join(dir, "missing"),
+			))
+
+			_, issues := app.NewValidator().ScrapeConfigs([]string{path})
+			Expect(issues).To(HaveLen(1))
+			Expect(issues[0].Message).To(HavePrefix("basic_auth.password_file is not readable"))
+		})
+
+		It("can skip checking certificates and secrets", func() {
+			path := writeFile("a.yml", "port: 8080\nsource_id: a\nbearer_token_file: /missing\n")
+
+			_, issues := app.NewValidator(app.WithoutFileChecks()).ScrapeConfigs([]string{path})
+			Expect(issues).To(BeEmpty())
+		})
+	})
+
+	Describe("Targets", func() {
+		It("accepts targets written by the metrics agent", func() {
+			path := writeFile("targets.yml", `
+- targets: ["10.0.0.1:9100"]
+  labels:
+    __param_id: db
+    source_id: db
+  source: db__instance
+`)
+
+			Expect(app.NewValidator().Targets([]string{path})).To(BeEmpty())
+		})
+
+		It("reports targets without source, addresses or valid labels", func() {
+			path := writeFile("targets.yml", `
+- targets: []
+- targets: ["10.0.0.1", "10.0.0.1:http"]
+  labels:
+    team-name: db
+  source: db
+`)
+
+			Expect(app.NewValidator().Targets([]string{path})).To(ConsistOf(
+				app.Issue{File: path, Message: "target 0: missing source"},
+				app.Issue{File: path, Message: "target 0: missing targets"},
+				app.Issue{File: path, Message: `target 1: invalid address "10.0.0.1"`},
+				app.Issue{File: path, Message: `target 1: invalid port in address "10.0.0.1:http"`},
+				app.Issue{File: path, Message: `target 1: invalid label name "team-name"`},
+			))
+		})
+
+		It("reports duplicate sources", func() {
+			first := writeFile("a.yml", "- targets: [\"10.0.0.1:9100\"]\n  source: db\n")
+			second := writeFile("b.yml", "- targets: [\"10.0.0.2:9100\"]\n  source: db\n")
+
+			Expect(app.NewValidator().Targets([]string{first, second})).To(ConsistOf(app.Issue{
+				File:    second,
+				Message: fmt.Sprintf(`target 0: source "db" is already used by %s`, first),
+			}))
+		})
+
+		It("reports files that cannot be parsed", func() {
+			path := writeFile("targets.yml", "source: db\n")
+
+			issues := app.NewValidator().Targets([]string{path})
+			Expect(issues).To(HaveLen(1))
+			Expect(issues[0].Message).To(HavePrefix("unmarshal"))
+		})
+	})
+
+	Describe("TestScrapes", func() {
+		It("scrapes the targets of the configs", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("up 1\nrequests_total 3\n"))
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			ok := writeFile("ok.yml", fmt.Sprintf("port: %s\nsource_id: ok\n", u.Port()))
+			failing := writeFile("failing.yml", "port: 1\nsource_id: failing\n")
+			valid, issues := app.NewValidator().ScrapeConfigs([]string{ok, failing})
+			Expect(issues).To(BeEmpty())
+
+			results := app.TestScrapes(valid, "", "", "", slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+			Expect(results).To(HaveLen(2))
+			Expect(results[0]).To(Equal(app.ScrapeResult{Path: ok, Families: 2}))
+			Expect(results[1].Path).To(Equal(failing))
+			Expect(results[1].Err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/metrics-discovery/cmd/metrics-discovery/app"
+)
+
+const usage = `usage: metrics-discovery validate [flags]
+
+Validates prom_scraper_config.yml files and target files of the discovery
+registrar. Globs may be given multiple times.
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "validate" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	os.Exit(validate(os.Args[2:], os.Stdout, os.Stderr))
+}
+
+type globs []string
+
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globs) Set(v string) error {
+	*g = append(*g, v)
+	return nil
+}
+
+// validate runs the validate command and returns the exit code: 0 if all
+// files are valid, 1 if issues were found and 2 for invalid arguments.
+func validate(args []string, stdout, stderr io.Writer) int {
+	var (
+		scrapeConfigGlobs globs
+		targetGlobs       globs
+	)
+
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage+"\nflags:\n")
+		flags.PrintDefaults()
+	}
+	flags.Var(&scrapeConfigGlobs, "scrape-configs", "glob of prom_scraper_config.yml files")
+	flags.Var(&targetGlobs, "targets", "glob of discovery registrar target files")
+	skipFileChecks := flags.Bool("skip-file-checks", false, "do not check that certificates and secrets are readable")
+	scrape := flags.Bool("scrape", false, "scrape every valid scrape config once")
+	certFile := flags.String("scrape-cert", "", "client certificate used for test scrapes")
+	keyFile := flags.String("scrape-key", "", "client key used for test scrapes")
+	caFile := flags.String("scrape-ca", "", "CA used to verify https targets in test scrapes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if len(scrapeConfigGlobs) == 0 && len(targetGlobs) == 0 {
+		fmt.Fprintln(stderr, "at least one of -scrape-configs and -targets is required")
+		return 2
+	}
+
+	scrapeConfigFiles, err := expand(scrapeConfigGlobs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	targetFiles, err := expand(targetGlobs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var opts []app.ValidatorOption
+	if *skipFileChecks {
+		opts = append(opts, app.WithoutFileChecks())
+	}
+	v := app.NewValidator(opts...)
+
+	valid, issues := v.ScrapeConfigs(scrapeConfigFiles)
+	issues = append(issues, v.Targets(targetFiles)...)
+	for _, issue := range issues {
+		fmt.Fprintln(stdout, issue)
+	}
+
+	failed := len(issues) > 0
+	if *scrape {
+		logger := slog.New(slog.NewTextHandler(stderr, nil))
+		for _, r := range app.TestScrapes(valid, *certFile, *keyFile, *caFile, logger) {
+			if r.Err != nil {
+				failed = true
+				fmt.Fprintf(stdout, "%s: scrape failed: %s\n", r.Path, r.Err)
+				continue
+			}
+			fmt.Fprintf(stdout, "%s: scraped %d metric families\n", r.Path, r.Families)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "%d scrape configs and %d target files are valid\n", len(scrapeConfigFiles), len(targetFiles))
+	return 0
+}
+
+func expand(patterns []string) ([]string, error) {
+	var files []string
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %s", p, err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
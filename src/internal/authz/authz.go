@@ -0,0 +1,182 @@
+package authz
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/pattern"
+)
+
+// Rule grants clients access by the identity in their certificate. A client
+// matches the rule if the common name or the full distinguished name of its
+// subject matches one of the subjects, or one of its DNS, IP, URI or email
+// SANs matches one of the SANs. Matching clients may access the ids and
+// source ids of the rule. All patterns are globs unless they are wrapped in
+// slashes, in which case they are regular expressions. Both have to match the
+// whole value, and the wildcards of globs do not match a slash.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Subjects  []string `json:"subjects" yaml:"subjects"`
+	SANs      []string `json:"sans" yaml:"sans"`
+	IDs       []string `json:"ids" yaml:"ids"`
+	SourceIDs []string `json:"source_ids" yaml:"source_ids"`
+}
+
+// Validate returns an error describing the first problem with the rule.
+func (r Rule) Validate() error {
+	_, err := compileRule(r)
+	return err
+}
+
+// Policy maps client certificates to the ids and source ids they may
+// access.
+type Policy struct {
+	rules []*rule
+}
+
+type rule struct {
+	subjects  []pattern.Matcher
+	sans      []pattern.Matcher
+	ids       []pattern.Matcher
+	sourceIDs []pattern.Matcher
+}
+
+// New returns a Policy for the rules.
+func New(rules []Rule) (*Policy, error) {
+	p := &Policy{}
+	for _, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p, nil
+}
+
+// Grant returns the access of the client certificate, combining all rules
+// the certificate matches. A nil certificate has no access.
+func (p *Policy) Grant(cert *x509.Certificate) Grant {
+	var g Grant
+	if cert == nil {
+		return g
+	}
+
+	subjects := []string{cert.Subject.CommonName, cert.Subject.String()}
+	sans := SANs(cert)
+	for _, r := range p.rules {
+		if matchAny(r.subjects, subjects) || matchAny(r.sans, sans) {
+			g.rules = append(g.rules, r)
+		}
+	}
+
+	return g
+}
+
+// Grant is the access of a client certificate.
+type Grant struct {
+	rules []*rule
+}
+
+// ID reports whether the client may access the id.
+func (g Grant) ID(id string) bool {
+	for _, r := range g.rules {
+		if pattern.MatchAny(r.ids, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceID reports whether the client may access the source id.
+func (g Grant) SourceID(sourceID string) bool {
+	for _, r := range g.rules {
+		if pattern.MatchAny(r.sourceIDs, sourceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSourceIDs reports whether the client may access any source id.
+func (g Grant) HasSourceIDs() bool {
+	for _, r := range g.rules {
+		if len(r.sourceIDs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns a name for the client certificate suitable for logs and
+// metrics. It is the subject common name, or the first SAN if the common
+// name is empty.
+func Identity(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if sans := SANs(cert); len(sans) > 0 {
+		return sans[0]
+	}
+	return ""
+}
+
+// SANs returns the DNS, IP, URI and email subject alternative names of the
+// certificate.
+func SANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return append(sans, cert.EmailAddresses...)
+}
+
+func matchAny(matchers []pattern.Matcher, values []string) bool {
+	for _, v := range values {
+		if pattern.MatchAny(matchers, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRule(r Rule) (*rule, error) {
+	if r.Name == "" {
+		return nil, errors.New("authorization rule is missing a name")
+	}
+	if len(r.Subjects) == 0 && len(r.SANs) == 0 {
+		return nil, fmt.Errorf("authorization rule %q has neither subjects nor sans", r.Name)
+	}
+
+	compiled := &rule{}
+	var err error
+	compiled.subjects, err = pattern.CompileAllFull(r.Subjects)
+	if err != nil {
+		return nil, fmt.Errorf("authorization rule %q has invalid subject: %s", r.Name, err)
+	}
+
+	compiled.sans, err = pattern.CompileAllFull(r.SANs)
+	if err != nil {
+		return nil, fmt.Errorf("authorization rule %q has invalid san: %s", r.Name, err)
+	}
+
+	compiled.ids, err = pattern.CompileAllFull(r.IDs)
+	if err != nil {
+		return nil, fmt.Errorf("authorization rule %q has invalid id: %s", r.Name, err)
+	}
+
+	compiled.sourceIDs, err = pattern.CompileAllFull(r.SourceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("authorization rule %q has invalid source id: %s", r.Name, err)
+	}
+
+	return compiled, nil
+}
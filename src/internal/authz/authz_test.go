@@ -0,0 +1,154 @@
+package authz_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var newPolicy = func(rules ...authz.Rule) *authz.Policy {
+		p, err := authz.New(rules)
+		Expect(err).ToNot(HaveOccurred())
+		return p
+	}
+
+	It("grants access by subject common name", func() {
+		p := newPolicy(authz.Rule{
+			Name:      "tenant-a",
+			Subjects:  []string{"prometheus-a"},
+			IDs:       []string{"tenant-a-*"},
+			SourceIDs: []string{"app-a"},
+		})
+
+		g := p.Grant(cert("prometheus-a"))
+		Expect(g.ID("tenant-a-db")).To(BeTrue())
+		Expect(g.ID("tenant-b-db")).To(BeFalse())
+		Expect(g.SourceID("app-a")).To(BeTrue())
+		Expect(g.SourceID("app-b")).To(BeFalse())
+		Expect(g.HasSourceIDs()).To(BeTrue())
+
+		g = p.Grant(cert("prometheus-b"))
+		Expect(g.ID("tenant-a-db")).To(BeFalse())
+		Expect(g.HasSourceIDs()).To(BeFalse())
+	})
+
+	It("grants access by full subject", func() {
+		p := newPolicy(authz.Rule{
+			Name:     "org",
+			Subjects: []string{"/CN=prometheus,O=tenant-a/"},
+			IDs:      []string{"*"},
+		})
+
+		c := cert("prometheus")
+		c.Subject.Organization = []string{"tenant-a"}
+		Expect(p.Grant(c).ID("db")).To(BeTrue())
+		Expect(p.Grant(cert("prometheus")).ID("db")).To(BeFalse())
+	})
+
+	It("grants access by SAN", func() {
+		p := newPolicy(authz.Rule{
+			Name: "sans",
+			SANs: []string{"*.tenant-a.internal", "10.0.0.1", "spiffe://tenant-a/*", "ops@tenant-a"},
+			IDs:  []string{"*"},
+		})
+
+		dns := cert("")
+		dns.DNSNames = []string{"prometheus.tenant-a.internal"}
+		Expect(p.Grant(dns).ID("db")).To(BeTrue())
+
+		ip := cert("")
+		ip.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+		Expect(p.Grant(ip).ID("db")).To(BeTrue())
+
+		uri := cert("")
+		uri.URIs = []*url.URL{{Scheme: "spiffe", Host: "tenant-a", Path: "/prometheus"}}
+		Expect(p.Grant(uri).ID("db")).To(BeTrue())
+
+		email := cert("")
+		email.EmailAddresses = []string{"ops@tenant-a"}
+		Expect(p.Grant(email).ID("db")).To(BeTrue())
+
+		other := cert("")
+		other.DNSNames = []string{"prometheus.tenant-b.internal"}
+		Expect(p.Grant(other).ID("db")).To(BeFalse())
+	})
+
+	It("matches regular expressions against the whole value", func() {
+		p := newPolicy(authz.Rule{
+			Name:      "tenant-a",
+			Subjects:  []string{"/prometheus-(a|b)/"},
+			IDs:       []string{"/tenant-a/"},
+			SourceIDs: []string{"/tenant-a-.*/"},
+		})
+
+		Expect(p.Grant(cert("evil-prometheus-a")).ID("tenant-a")).To(BeFalse())
+
+		g := p.Grant(cert("prometheus-a"))
+		Expect(g.ID("tenant-a")).To(BeTrue())
+		Expect(g.ID("evil-tenant-a-x")).To(BeFalse())
+		Expect(g.SourceID("tenant-a-app")).To(BeTrue())
+		Expect(g.SourceID("evil-tenant-a-app")).To(BeFalse())
+	})
+
+	It("does not match slashes of URI SANs with glob wildcards", func() {
+		p := newPolicy(authz.Rule{
+			Name: "spiffe",
+			SANs: []string{"spiffe://tenant-a/*"},
+			IDs:  []string{"*"},
+		})
+
+		uri := cert("")
+		uri.URIs = []*url.URL{{Scheme: "spiffe", Host: "tenant-a", Path: "/ns/prometheus"}}
+		Expect(p.Grant(uri).ID("db")).To(BeFalse())
+	})
+
+	It("combines the access of all matching rules", func() {
+		p := newPolicy(
+			authz.Rule{Name: "a", Subjects: []string{"prometheus"}, IDs: []string{"a"}},
+			authz.Rule{Name: "b", Subjects: []string{"prom*"}, IDs: []string{"b"}},
+		)
+
+		g := p.Grant(cert("prometheus"))
+		Expect(g.ID("a")).To(BeTrue())
+		Expect(g.ID("b")).To(BeTrue())
+		Expect(g.ID("c")).To(BeFalse())
+	})
+
+	It("grants no access without a certificate", func() {
+		p := newPolicy(authz.Rule{Name: "all", Subjects: []string{"*"}, IDs: []string{"*"}})
+
+		Expect(p.Grant(nil).ID("db")).To(BeFalse())
+	})
+
+	It("validates rules", func() {
+		Expect(authz.Rule{Subjects: []string{"a"}}.Validate()).To(MatchError(ContainSubstring("missing a name")))
+		Expect(authz.Rule{Name: "r"}.Validate()).To(MatchError(ContainSubstring("neither subjects nor sans")))
+		Expect(authz.Rule{Name: "r", Subjects: []string{"["}}.Validate()).To(MatchError(ContainSubstring("invalid subject")))
+		Expect(authz.Rule{Name: "r", SANs: []string{"["}}.Validate()).To(MatchError(ContainSubstring("invalid san")))
+		Expect(authz.Rule{Name: "r", SANs: []string{"a"}, IDs: []string{"/(/"}}.Validate()).To(MatchError(ContainSubstring("invalid id")))
+		Expect(authz.Rule{Name: "r", SANs: []string{"a"}, SourceIDs: []string{"["}}.Validate()).To(MatchError(ContainSubstring("invalid source id")))
+
+		_, err := authz.New([]authz.Rule{{Name: "r"}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("identifies certificates by common name or first SAN", func() {
+		Expect(authz.Identity(cert("prometheus"))).To(Equal("prometheus"))
+
+		c := cert("")
+		c.DNSNames = []string{"prometheus.internal"}
+		Expect(authz.Identity(c)).To(Equal("prometheus.internal"))
+
+		Expect(authz.Identity(nil)).To(BeEmpty())
+	})
+})
+
+func cert(commonName string) *x509.Certificate {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+}
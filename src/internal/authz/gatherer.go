@@ -0,0 +1,48 @@
+package authz
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+const sourceIDLabel = "source_id"
+
+// Gatherer returns a prometheus.Gatherer that only returns the series of
+// the given gatherer whose source_id label the client may access. Series
+// without a source_id label have the empty source id.
+func (g Grant) Gatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*io_prometheus_client.MetricFamily, error) {
+		families, err := gatherer.Gather()
+
+		var allowed []*io_prometheus_client.MetricFamily
+		for _, family := range families {
+			var metrics []*io_prometheus_client.Metric
+			for _, metric := range family.GetMetric() {
+				if g.SourceID(sourceID(metric)) {
+					metrics = append(metrics, metric)
+				}
+			}
+
+			if len(metrics) > 0 {
+				allowed = append(allowed, &io_prometheus_client.MetricFamily{
+					Name:   family.Name,
+					Help:   family.Help,
+					Type:   family.Type,
+					Unit:   family.Unit,
+					Metric: metrics,
+				})
+			}
+		}
+
+		return allowed, err
+	})
+}
+
+func sourceID(metric *io_prometheus_client.Metric) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == sourceIDLabel {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package authz_test
+
+import (
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Gatherer", func() {
+	var registry *prometheus.Registry
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+
+		requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"source_id"})
+		requests.WithLabelValues("app-a").Add(1)
+		requests.WithLabelValues("app-b").Add(2)
+		registry.MustRegister(requests)
+
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "unlabeled"}))
+	})
+
+	var gather = func(sourceIDs ...string) map[string][]string {
+		p, err := authz.New([]authz.Rule{{Name: "r", Subjects: []string{"prometheus"}, SourceIDs: sourceIDs}})
+		Expect(err).ToNot(HaveOccurred())
+
+		families, err := p.Grant(cert("prometheus")).Gatherer(registry).Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		series := map[string][]string{}
+		for _, f := range families {
+			for _, m := range f.GetMetric() {
+				var sourceID string
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "source_id" {
+						sourceID = l.GetValue()
+					}
+				}
+				series[f.GetName()] = append(series[f.GetName()], sourceID)
+			}
+		}
+		return series
+	}
+
+	It("only returns series of allowed source ids", func() {
+		Expect(gather("app-a")).To(Equal(map[string][]string{
+			"requests": {"app-a"},
+		}))
+	})
+
+	It("returns series without source id to wildcard rules", func() {
+		Expect(gather("*")).To(Equal(map[string][]string{
+			"requests":  {"app-a", "app-b"},
+			"unlabeled": {""},
+		}))
+	})
+
+	It("does not return families without allowed series", func() {
+		Expect(gather("app-c")).To(BeEmpty())
+	})
+})
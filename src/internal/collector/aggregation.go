@@ -0,0 +1,339 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	aggregateSum   = "sum"
+	aggregateMax   = "max"
+	aggregateMin   = "min"
+	aggregateCount = "count"
+)
+
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// AggregationRule describes series that are aggregated when they are
+// written. Series named Metric are grouped by the labels in By and combined
+// with Operation. Timers can only be aggregated with sum, which merges their
+// histograms, or count.
+type AggregationRule struct {
+	Metric    string   `json:"metric" yaml:"metric"`
+	Operation string   `json:"operation" yaml:"operation"`
+	By        []string `json:"by" yaml:"by"`
+
+	// Name is the name of the aggregated metric. It defaults to
+	// <metric>:<operation>.
+	Name string `json:"name" yaml:"name"`
+
+	// Replace keeps the series that were aggregated from being stored and
+	// exposed.
+	Replace bool `json:"replace" yaml:"replace"`
+}
+
+// Validate returns an error describing the first problem with the rule.
+func (r AggregationRule) Validate() error {
+	if r.Metric == "" {
+		return errors.New("aggregation rule is missing a metric")
+	}
+
+	switch r.Operation {
+	case aggregateSum, aggregateMax, aggregateMin, aggregateCount:
+	default:
+		return fmt.Errorf("aggregation rule for %s has unknown operation %q", r.Metric, r.Operation)
+	}
+
+	if r.Name != "" && !metricNameRegex.MatchString(r.Name) {
+		return fmt.Errorf("aggregation rule for %s has invalid name %q", r.Metric, r.Name)
+	}
+
+	for _, label := range r.By {
+		if !labelNameRegex.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("aggregation rule for %s has invalid label name %q", r.Metric, label)
+		}
+	}
+
+	if r.Name == r.Metric && !r.Replace {
+		return fmt.Errorf("aggregation rule for %s must replace the metric when using the same name", r.Metric)
+	}
+
+	return nil
+}
+
+// ValidateAggregationRules validates every rule and returns an error if two
+// rules produce a metric with the same name.
+func ValidateAggregationRules(rules []AggregationRule) error {
+	names := map[string]AggregationRule{}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+
+		if other, ok := names[r.name()]; ok {
+			return fmt.Errorf(
+				"aggregation rules for %s with %s and %s with %s both produce %s",
+				other.Metric, other.Operation, r.Metric, r.Operation, r.name(),
+			)
+		}
+		names[r.name()] = r
+	}
+	return nil
+}
+
+func (r AggregationRule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Metric + ":" + r.Operation
+}
+
+// aggregation holds the aggregated series. Series are added when they are
+// written, so the series of replacing rules are never stored and sums of
+// counters keep increasing when one of their series expires. It is guarded
+// by the lock of the collector.
+type aggregation struct {
+	rules  map[string][]AggregationRule
+	groups map[string]*aggregationGroup
+}
+
+// aggregationGroup is an aggregated series. value, count, sum and buckets
+// accumulate the increases of counters and histograms. Other operations are
+// computed from the last values of the members.
+type aggregationGroup struct {
+	rule        AggregationRule
+	valueType   string
+	labelValues []string
+
+	value   float64
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+
+	members    map[string]*aggregationMember
+	lastUpdate time.Time
+}
+
+// aggregationMember is the last value of a series of a group.
+type aggregationMember struct {
+	value      float64
+	count      uint64
+	sum        float64
+	buckets    map[float64]uint64
+	lastUpdate time.Time
+}
+
+func newAggregation(rules []AggregationRule) *aggregation {
+	indexed := map[string][]AggregationRule{}
+	for _, r := range rules {
+		indexed[r.Metric] = append(indexed[r.Metric], r)
+	}
+
+	return &aggregation{
+		rules:  indexed,
+		groups: map[string]*aggregationGroup{},
+	}
+}
+
+// add aggregates the series with the given id into every rule for its name.
+// It returns true if the series should not be stored on its own.
+func (a *aggregation) add(id string, metric convertedMetric) bool {
+	rules, ok := a.rules[metric.name]
+	if !ok {
+		return false
+	}
+
+	m := &dto.Metric{}
+	if err := metric.metric.Write(m); err != nil {
+		return false
+	}
+
+	now := time.Now()
+	replace := false
+	for _, r := range rules {
+		if metric.valueType == histogramType && (r.Operation == aggregateMax || r.Operation == aggregateMin) {
+			continue
+		}
+
+		labelValues := groupLabelValues(r.By, m.GetLabel())
+		key := r.name() + "\xff" + strings.Join(labelValues, "\xff")
+		group, ok := a.groups[key]
+		if !ok {
+			group = &aggregationGroup{
+				rule:        r,
+				valueType:   metric.valueType,
+				labelValues: labelValues,
+				buckets:     map[float64]uint64{},
+				members:     map[string]*aggregationMember{},
+			}
+			a.groups[key] = group
+		}
+		group.add(id, metric, m, now)
+		replace = replace || r.Replace
+	}
+
+	return replace
+}
+
+// expire removes the members and groups that were not updated since
+// tooOld.
+func (a *aggregation) expire(tooOld time.Time) {
+	for key, group := range a.groups {
+		if group.lastUpdate.Before(tooOld) {
+			delete(a.groups, key)
+			continue
+		}
+
+		for id, member := range group.members {
+			if member.lastUpdate.Before(tooOld) {
+				delete(group.members, id)
+			}
+		}
+	}
+}
+
+func (a *aggregation) collect(ch chan<- prometheus.Metric) {
+	for _, group := range a.groups {
+		metric, err := group.metric()
+		if err != nil {
+			continue
+		}
+		ch <- metric
+	}
+}
+
+func (g *aggregationGroup) add(id string, metric convertedMetric, m *dto.Metric, now time.Time) {
+	if metric.valueType != g.valueType {
+		return
+	}
+	g.lastUpdate = now
+
+	member, ok := g.members[id]
+	if !ok {
+		member = &aggregationMember{buckets: map[float64]uint64{}}
+		g.members[id] = member
+	}
+	member.lastUpdate = now
+
+	switch {
+	case metric.observation != nil:
+		g.observe(*metric.observation)
+	case metric.valueType == histogramType:
+		g.addHistogram(member, m.GetHistogram())
+	case metric.valueType == counterType:
+		value := m.GetCounter().GetValue()
+		if value < member.value {
+			member.value = 0
+		}
+		g.value += value - member.value
+		member.value = value
+	default:
+		member.value = m.GetGauge().GetValue()
+	}
+}
+
+// observe adds an observation of a timer to the histogram of the group.
+func (g *aggregationGroup) observe(value float64) {
+	g.count++
+	g.sum += value
+	for _, bound := range buckets {
+		var n uint64
+		if value <= bound {
+			n = 1
+		}
+		g.buckets[bound] += n
+	}
+}
+
+// addHistogram adds the increase of a cumulative histogram since its last
+// value to the histogram of the group.
+func (g *aggregationGroup) addHistogram(member *aggregationMember, h *dto.Histogram) {
+	if h.GetSampleCount() < member.count {
+		member.count = 0
+		member.sum = 0
+		member.buckets = map[float64]uint64{}
+	}
+
+	g.count += h.GetSampleCount() - member.count
+	g.sum += h.GetSampleSum() - member.sum
+	member.count = h.GetSampleCount()
+	member.sum = h.GetSampleSum()
+	for _, b := range h.GetBucket() {
+		bound := b.GetUpperBound()
+		g.buckets[bound] += b.GetCumulativeCount() - member.buckets[bound]
+		member.buckets[bound] = b.GetCumulativeCount()
+	}
+}
+
+func (g *aggregationGroup) metric() (prometheus.Metric, error) {
+	labelNames := sortedBy(g.rule.By)
+	desc := prometheus.NewDesc(g.rule.name(), help, labelNames, nil)
+
+	if g.rule.Operation == aggregateCount {
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, float64(len(g.members)), g.labelValues...)
+	}
+
+	switch g.valueType {
+	case histogramType:
+		return prometheus.NewConstHistogram(desc, g.count, g.sum, g.buckets, g.labelValues...)
+	case counterType:
+		if g.rule.Operation == aggregateSum {
+			return prometheus.NewConstMetric(desc, prometheus.CounterValue, g.value, g.labelValues...)
+		}
+	}
+
+	return prometheus.NewConstMetric(desc, prometheus.GaugeValue, g.memberValue(), g.labelValues...)
+}
+
+// memberValue combines the last values of the members.
+func (g *aggregationGroup) memberValue() float64 {
+	var value float64
+	first := true
+	for _, member := range g.members {
+		switch {
+		case first:
+			value = member.value
+			first = false
+		case g.rule.Operation == aggregateSum:
+			value += member.value
+		case g.rule.Operation == aggregateMax:
+			value = math.Max(value, member.value)
+		case g.rule.Operation == aggregateMin:
+			value = math.Min(value, member.value)
+		}
+	}
+	return value
+}
+
+func groupLabelValues(by []string, labels []*dto.LabelPair) []string {
+	values := make([]string, 0, len(by))
+	for _, name := range sortedBy(by) {
+		value := ""
+		for _, l := range labels {
+			if l.GetName() == name {
+				value = l.GetValue()
+				break
+			}
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func sortedBy(by []string) []string {
+	sorted := make([]string, len(by))
+	copy(sorted, by)
+	sort.Strings(sorted)
+	return sorted
+}
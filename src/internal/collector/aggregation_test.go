@@ -0,0 +1,224 @@
+package collector_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Aggregation", func() {
+	var writeContainerGauges = func(envelopeCollector *collector.EnvelopeCollector) {
+		for i, value := range []float64{10, 20, 30} {
+			env := gaugeWithSourceID("cpu", "app-guid")
+			env.InstanceId = string(rune('0' + i))
+			env.GetGauge().GetMetrics()["cpu"].Value = value
+			env.Tags = map[string]string{"app": "some-app"}
+			Expect(envelopeCollector.Write(env)).To(Succeed())
+		}
+	}
+
+	DescribeTable("aggregates gauges grouped by labels",
+		func(operation string, expected float64) {
+			envelopeCollector := collector.NewEnvelopeCollector(
+				testhelpers.NewMetricsRegistry(),
+				collector.WithAggregationRules([]collector.AggregationRule{{
+					Metric:    "cpu",
+					Operation: operation,
+					By:        []string{"app"},
+				}}),
+			)
+			writeContainerGauges(envelopeCollector)
+
+			Expect(collectMetrics(envelopeCollector)).To(receiveInAnyOrder(
+				haveName("cpu"),
+				haveName("cpu"),
+				haveName("cpu"),
+				And(
+					haveName("cpu:"+operation),
+					haveLabels(labelPair("app", "some-app")),
+					gaugeWithValue(expected),
+				),
+			))
+		},
+		Entry("max", "max", 30.0),
+		Entry("min", "min", 10.0),
+		Entry("count", "count", 3.0),
+	)
+
+	It("sums counters and keeps them counters", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "requests",
+				Operation: "sum",
+				Name:      "requests_total",
+			}}),
+		)
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-1"))).To(Succeed())
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-2"))).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveInAnyOrder(
+			haveName("requests"),
+			haveName("requests"),
+			And(
+				haveName("requests_total"),
+				counterWithValue(158),
+			),
+		))
+	})
+
+	It("keeps summed counters increasing when their series reset or expire", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithSourceIDExpiration(50*time.Millisecond, time.Millisecond),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "requests",
+				Operation: "sum",
+				Name:      "requests_total",
+				Replace:   true,
+			}}),
+		)
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-1"))).To(Succeed())
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-2"))).To(Succeed())
+		reset := counterWithSourceID("requests", "source-2")
+		reset.GetCounter().Total = 10
+		Expect(envelopeCollector.Write(reset)).To(Succeed())
+		cancel := writeUntilCancelled(envelopeCollector, reset)
+		defer cancel()
+
+		Consistently(func() chan prometheus.Metric {
+			return collectMetrics(envelopeCollector)
+		}, 200*time.Millisecond).Should(receiveOnly(And(
+			haveName("requests_total"),
+			counterWithValue(168),
+		)))
+	})
+
+	It("expires aggregated series that are no longer written", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithSourceIDExpiration(50*time.Millisecond, time.Millisecond),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "requests",
+				Operation: "sum",
+				Replace:   true,
+			}}),
+		)
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-1"))).To(Succeed())
+
+		Eventually(func() chan prometheus.Metric {
+			return collectMetrics(envelopeCollector)
+		}).Should(receiveInAnyOrder())
+	})
+
+	It("counts the series that are currently written", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithSourceIDExpiration(50*time.Millisecond, time.Millisecond),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "requests",
+				Operation: "count",
+				Replace:   true,
+			}}),
+		)
+		Expect(envelopeCollector.Write(counterWithSourceID("requests", "source-1"))).To(Succeed())
+		cancel := writeUntilCancelled(envelopeCollector, counterWithSourceID("requests", "source-2"))
+		defer cancel()
+
+		Eventually(func() chan prometheus.Metric {
+			return collectMetrics(envelopeCollector)
+		}).Should(receiveOnly(gaugeWithValue(1)))
+	})
+
+	It("replaces the aggregated series", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "cpu",
+				Operation: "sum",
+				By:        []string{"app"},
+				Replace:   true,
+			}}),
+		)
+		writeContainerGauges(envelopeCollector)
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+			haveName("cpu:sum"),
+			gaugeWithValue(60),
+		)))
+	})
+
+	It("merges timer histograms", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "http_seconds",
+				Operation: "sum",
+				Replace:   true,
+			}}),
+		)
+		for _, sourceID := range []string{"router-1", "router-2", "router-2"} {
+			env := timer("http", 0, int64(time.Second))
+			env.SourceId = sourceID
+			Expect(envelopeCollector.Write(env)).To(Succeed())
+		}
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+			haveName("http_seconds:sum"),
+			histogramWithCount(3),
+			histogramWithSum(3),
+			histogramWithBuckets(0.01, 0.2, 1.0, 15.0, 60.0),
+		)))
+	})
+
+	It("ignores envelopes that do not match a rule", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithAggregationRules([]collector.AggregationRule{{
+				Metric:    "cpu",
+				Operation: "sum",
+				Replace:   true,
+			}}),
+		)
+		Expect(envelopeCollector.Write(&loggregator_v2.Envelope{})).To(Succeed())
+		Expect(envelopeCollector.Write(totalCounter("memory", 1))).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(haveName("memory")))
+	})
+
+	DescribeTable("validating rules",
+		func(rule collector.AggregationRule, errMatcher string) {
+			err := rule.Validate()
+			if errMatcher == "" {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(errMatcher)))
+		},
+		Entry("valid", collector.AggregationRule{Metric: "cpu", Operation: "sum"}, ""),
+		Entry("missing metric", collector.AggregationRule{Operation: "sum"}, "missing a metric"),
+		Entry("unknown operation", collector.AggregationRule{Metric: "cpu", Operation: "avg"}, `unknown operation "avg"`),
+		Entry("same name without replace", collector.AggregationRule{Metric: "cpu", Operation: "sum", Name: "cpu"}, "must replace"),
+		Entry("invalid name", collector.AggregationRule{Metric: "cpu", Operation: "sum", Name: "cpu-sum"}, `invalid name "cpu-sum"`),
+		Entry("invalid label name", collector.AggregationRule{Metric: "cpu", Operation: "sum", By: []string{"app.id"}}, `invalid label name "app.id"`),
+		Entry("reserved label name", collector.AggregationRule{Metric: "cpu", Operation: "sum", By: []string{"__name__"}}, `invalid label name "__name__"`),
+	)
+
+	It("rejects rules producing the same metric", func() {
+		err := collector.ValidateAggregationRules([]collector.AggregationRule{
+			{Metric: "cpu", Operation: "sum", Name: "usage"},
+			{Metric: "memory", Operation: "max", Name: "usage"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("both produce usage")))
+
+		Expect(collector.ValidateAggregationRules([]collector.AggregationRule{
+			{Metric: "cpu", Operation: "sum"},
+			{Metric: "cpu", Operation: "max"},
+		})).To(Succeed())
+	})
+})
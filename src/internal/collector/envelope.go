@@ -3,14 +3,17 @@ package collector
 import (
 	b64 "encoding/base64"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
 	metrics "code.cloudfoundry.org/go-metric-registry"
 	v2 "code.cloudfoundry.org/loggregator-agent-release/src/pkg/egress/v2"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -28,8 +31,20 @@ type sourceIDBucket struct {
 }
 
 type metricWithExpiry struct {
+	convertedMetric
 	lastUpdate time.Time
+}
+
+type convertedMetric struct {
+	name       string
+	valueType  string
+	labelNames []string
 	metric     prometheus.Metric
+
+	// observation is the duration of a timer envelope. Timers are
+	// aggregated by their observations because their histogram is only
+	// kept while the series is stored.
+	observation *float64
 }
 
 func newSourceIDBucket() *sourceIDBucket {
@@ -39,9 +54,12 @@ func newSourceIDBucket() *sourceIDBucket {
 	}
 }
 
-func (b *sourceIDBucket) addMetric(id string, metric prometheus.Metric) {
-	b.metrics[id] = metricWithExpiry{metric: metric, lastUpdate: time.Now()}
+// addMetric stores the metric and reports whether it is a new series.
+func (b *sourceIDBucket) addMetric(id string, metric convertedMetric) bool {
+	_, exists := b.metrics[id]
+	b.metrics[id] = metricWithExpiry{convertedMetric: metric, lastUpdate: time.Now()}
 	b.lastUpdate = time.Now()
+	return !exists
 }
 
 type EnvelopeCollector struct {
@@ -52,13 +70,23 @@ type EnvelopeCollector struct {
 	sourceIDTTL                time.Duration
 	sourceIDExpirationInterval time.Duration
 	defaultTags                map[string]string
+	utf8Names                  bool
+	loggregatorNameLabel       bool
+	normalizeLabels            bool
+	aggregation                *aggregation
+	schemas                    *metricSchemas
 	metrics                    debugMetrics
+	instrumentation            *Instrumentation
+	log                        *slog.Logger
 }
 
 type EnvelopeCollectorOption func(*EnvelopeCollector)
 
 type debugMetrics interface {
 	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+	NewGauge(name, helpText string, opts ...metrics.MetricOption) metrics.Gauge
+	RemoveCounter(metrics.Counter)
+	RemoveGauge(metrics.Gauge)
 }
 
 func NewEnvelopeCollector(m debugMetrics, opts ...EnvelopeCollectorOption) *EnvelopeCollector {
@@ -66,12 +94,19 @@ func NewEnvelopeCollector(m debugMetrics, opts ...EnvelopeCollectorOption) *Enve
 		metricBuckets:              map[string]*sourceIDBucket{},
 		sourceIDTTL:                time.Hour,
 		sourceIDExpirationInterval: time.Minute,
+		loggregatorNameLabel:       true,
+		schemas:                    newMetricSchemas(),
+		aggregation:                newAggregation(nil),
 		metrics:                    m,
+		log:                        logging.Discard(),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.instrumentation == nil {
+		c.instrumentation = NewInstrumentation(m)
+	}
 
 	go c.expireMetrics()
 
@@ -91,26 +126,101 @@ func WithDefaultTags(tags map[string]string) EnvelopeCollectorOption {
 	}
 }
 
+// WithUTF8Names keeps metric and label names as they were emitted instead of
+// replacing every character outside of the legacy Prometheus character set.
+// Scrapers that do not negotiate escaping=allow-utf-8 still receive legacy
+// names because promhttp escapes them during exposition.
+func WithUTF8Names(enabled bool) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.utf8Names = enabled
+	}
+}
+
+// WithLoggregatorNameLabel controls whether the base64 encoded original
+// metric name is added to every metric as the loggregator_name label.
+func WithLoggregatorNameLabel(enabled bool) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.loggregatorNameLabel = enabled
+	}
+}
+
+// WithLabelNormalization fills in labels that are missing from a series with
+// empty values so that every series of a metric has the same label names.
+func WithLabelNormalization(enabled bool) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.normalizeLabels = enabled
+	}
+}
+
+// WithAggregationRules aggregates the series matching the given rules when
+// they are written.
+func WithAggregationRules(rules []AggregationRule) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.aggregation = newAggregation(rules)
+	}
+}
+
+// WithInstrumentation reports the envelopes and series of the collector with
+// i instead of an Instrumentation of its own.
+func WithInstrumentation(i *Instrumentation) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.instrumentation = i
+	}
+}
+
+// WithLogger sets the logger used to report metrics with conflicting types
+// or label names.
+func WithLogger(l *slog.Logger) EnvelopeCollectorOption {
+	return func(c *EnvelopeCollector) {
+		c.log = l
+	}
+}
+
 func (c *EnvelopeCollector) expireMetrics() {
 	expirationTicker := time.NewTicker(c.sourceIDExpirationInterval)
 	for range expirationTicker.C {
-		tooOld := time.Now().Add(-c.sourceIDTTL)
-
 		c.Lock()
+		tooOld := time.Now().Add(-c.sourceIDTTL)
 		for sourceID, bucket := range c.metricBuckets {
 			if bucket.lastUpdate.Before(tooOld) {
 				delete(c.metricBuckets, sourceID)
+				c.instrumentation.seriesExpired(sourceID, len(bucket.metrics))
+				continue
 			}
+
+			expired := 0
 			for id, metric := range bucket.metrics {
 				if metric.lastUpdate.Before(tooOld) {
 					delete(bucket.metrics, id)
+					expired++
 				}
 			}
+			c.instrumentation.seriesExpired(sourceID, expired)
 		}
+		c.instrumentation.expire(tooOld)
+		c.aggregation.expire(tooOld)
+		c.schemas.expire(tooOld)
 		c.Unlock()
 	}
 }
 
+// SetSourceIDTTL changes how long metrics are kept after their last update.
+func (c *EnvelopeCollector) SetSourceIDTTL(ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.sourceIDTTL = ttl
+}
+
+// SetDefaultTags changes the tags added to envelopes that do not have them.
+// Metrics written before keep their labels until they expire.
+func (c *EnvelopeCollector) SetDefaultTags(tags map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.defaultTags = tags
+}
+
 // Describe implements prometheus.Collector
 // Unimplemented because metric descriptors should not be checked against other collectors
 func (c *EnvelopeCollector) Describe(ch chan<- *prometheus.Desc) {}
@@ -120,8 +230,14 @@ func (c *EnvelopeCollector) Collect(ch chan<- prometheus.Metric) {
 	c.RLock()
 	defer c.RUnlock()
 
+	c.aggregation.collect(ch)
+
 	for _, bucket := range c.metricBuckets {
 		for _, metric := range bucket.metrics {
+			if c.normalizeLabels {
+				ch <- c.schemas.normalize(metric.convertedMetric)
+				continue
+			}
 			ch <- metric.metric
 		}
 	}
@@ -129,20 +245,79 @@ func (c *EnvelopeCollector) Collect(ch chan<- prometheus.Metric) {
 
 // Write implements v2.Writer
 func (c *EnvelopeCollector) Write(env *loggregator_v2.Envelope) error {
+	c.instrumentation.processedEnvelope(env)
+
 	metrics, err := c.convertEnvelope(env)
 	if err != nil {
+		c.instrumentation.conversionError(env.GetSourceId(), reasonInvalidMetric)
 		return err
 	}
 
+	for id, metric := range metrics {
+		if !c.checkSchema(env.GetSourceId(), metric) {
+			delete(metrics, id)
+		}
+	}
+
 	c.Lock()
 	defer c.Unlock()
+	added := 0
 	for id, metric := range metrics {
-		c.getOrCreateBucket(env.GetSourceId()).addMetric(id, metric)
+		if c.addMetric(env.GetSourceId(), id, metric) {
+			added++
+		}
 	}
+	c.instrumentation.seriesAdded(env.GetSourceId(), added)
 
 	return nil
 }
 
+// checkSchema reports metrics whose type or label names differ from other
+// series with the same name. Metrics with a conflicting type are rejected
+// because they would fail the whole metric family during exposition.
+func (c *EnvelopeCollector) checkSchema(sourceID string, metric convertedMetric) bool {
+	conflict := c.schemas.check(sourceID, metric)
+	if conflict == nil {
+		return true
+	}
+
+	c.metrics.NewCounter(
+		"metric_conflicts",
+		"Total number of metrics with a type or label names inconsistent with other metrics of the same name",
+		metrics.WithMetricLabels(map[string]string{
+			"originating_source_id": sourceID,
+			"conflict":              conflict.kind,
+		}),
+	).Add(1)
+
+	if conflict.firstOccurrence {
+		c.log.Warn(
+			"metric conflicts with an existing metric of the same name",
+			"metric", metric.name,
+			"source_id", sourceID,
+			"got", conflict.got,
+			"existing_source_id", conflict.existingSourceID,
+			"want", conflict.want,
+		)
+	}
+
+	if conflict.kind == typeConflict {
+		c.instrumentation.conversionError(sourceID, reasonTypeConflict)
+		return false
+	}
+	return true
+}
+
+// addMetric aggregates the metric and stores it unless an aggregation rule
+// replaces it. It reports whether a new series was stored and must be
+// called with the lock held.
+func (c *EnvelopeCollector) addMetric(sourceID, id string, metric convertedMetric) bool {
+	if c.aggregation.add(sourceID+"\xff"+id, metric) {
+		return false
+	}
+	return c.getOrCreateBucket(sourceID).addMetric(id, metric)
+}
+
 func (c *EnvelopeCollector) getOrCreateBucket(sourceID string) *sourceIDBucket {
 	bucket, ok := c.metricBuckets[sourceID]
 	if ok {
@@ -154,52 +329,63 @@ func (c *EnvelopeCollector) getOrCreateBucket(sourceID string) *sourceIDBucket {
 	return bucket
 }
 
-func (c *EnvelopeCollector) convertEnvelope(env *loggregator_v2.Envelope) (map[string]prometheus.Metric, error) {
+func (c *EnvelopeCollector) convertEnvelope(env *loggregator_v2.Envelope) (map[string]convertedMetric, error) {
 	switch env.GetMessage().(type) {
 	case *loggregator_v2.Envelope_Counter:
 		id, metric, err := c.convertCounter(env)
-		return map[string]prometheus.Metric{id: metric}, err
+		if err != nil {
+			return nil, err
+		}
+		return map[string]convertedMetric{id: metric}, nil
 	case *loggregator_v2.Envelope_Gauge:
 		return c.convertGaugeEnvelope(env)
 	case *loggregator_v2.Envelope_Timer:
 		id, metric, err := c.convertTimer(env)
-		return map[string]prometheus.Metric{id: metric}, err
+		if err != nil {
+			return nil, err
+		}
+		return map[string]convertedMetric{id: metric}, nil
 	default:
 		return nil, nil
 	}
 }
 
-func (c *EnvelopeCollector) convertCounter(env *loggregator_v2.Envelope) (metricID string, metric prometheus.Metric, err error) {
+func (c *EnvelopeCollector) convertCounter(env *loggregator_v2.Envelope) (string, convertedMetric, error) {
 	originalName := env.GetCounter().GetName()
-	name, modified := sanitizeName(originalName)
+	name, modified := c.sanitizeName(originalName)
 	if modified {
 		c.incrementCounter("modified_tags", env.GetSourceId())
 	}
 
 	labelNames, labelValues := c.convertTags(env)
-	labelNames, labelValues = addLoggregatorNameTag(labelNames, labelValues, originalName)
+	labelNames, labelValues = c.addLoggregatorNameTag(labelNames, labelValues, originalName)
 
 	desc := prometheus.NewDesc(name, help, labelNames, nil)
-	metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, float64(env.GetCounter().GetTotal()), labelValues...)
+	metric, err := prometheus.NewConstMetric(desc, prometheus.CounterValue, float64(env.GetCounter().GetTotal()), labelValues...)
 	if err != nil {
-		return "", nil, err
+		return "", convertedMetric{}, err
 	}
 
-	return buildMetricID(name, labelNames, labelValues), metric, nil
+	return buildMetricID(name, labelNames, labelValues), convertedMetric{
+		name:       name,
+		valueType:  counterType,
+		labelNames: labelNames,
+		metric:     metric,
+	}, nil
 }
 
-func (c *EnvelopeCollector) convertGaugeEnvelope(env *loggregator_v2.Envelope) (map[string]prometheus.Metric, error) {
+func (c *EnvelopeCollector) convertGaugeEnvelope(env *loggregator_v2.Envelope) (map[string]convertedMetric, error) {
 	labelNames, labelValues := c.convertTags(env)
 
-	promMetrics := map[string]prometheus.Metric{}
+	promMetrics := map[string]convertedMetric{}
 
 	for name, metric := range env.GetGauge().GetMetrics() {
-		sanitizedName, modified := sanitizeName(name)
+		sanitizedName, modified := c.sanitizeName(name)
 		if modified {
 			c.incrementCounter("modified_tags", env.GetSourceId())
 		}
 
-		id, metric, err := convertGaugeValue(name, sanitizedName, metric, labelNames, labelValues)
+		id, metric, err := c.convertGaugeValue(name, sanitizedName, metric, labelNames, labelValues)
 		if err != nil {
 			return nil, fmt.Errorf("invalid metric: %s", err)
 		}
@@ -209,16 +395,21 @@ func (c *EnvelopeCollector) convertGaugeEnvelope(env *loggregator_v2.Envelope) (
 	return promMetrics, nil
 }
 
-func convertGaugeValue(originalName, sanitizedName string, gaugeValue *loggregator_v2.GaugeValue, envelopeLabelNames, envelopeLabelValues []string) (string, prometheus.Metric, error) {
+func (c *EnvelopeCollector) convertGaugeValue(originalName, sanitizedName string, gaugeValue *loggregator_v2.GaugeValue, envelopeLabelNames, envelopeLabelValues []string) (string, convertedMetric, error) {
 	labelNames, labelValues := gaugeLabels(gaugeValue, envelopeLabelNames, envelopeLabelValues)
-	labelNames, labelValues = addLoggregatorNameTag(labelNames, labelValues, originalName)
+	labelNames, labelValues = c.addLoggregatorNameTag(labelNames, labelValues, originalName)
 	desc := prometheus.NewDesc(sanitizedName, help, labelNames, nil)
 	metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, gaugeValue.Value, labelValues...)
 	if err != nil {
-		return "", nil, err
+		return "", convertedMetric{}, err
 	}
 
-	return buildMetricID(sanitizedName, envelopeLabelNames, envelopeLabelValues), metric, nil
+	return buildMetricID(sanitizedName, envelopeLabelNames, envelopeLabelValues), convertedMetric{
+		name:       sanitizedName,
+		valueType:  gaugeType,
+		labelNames: labelNames,
+		metric:     metric,
+	}, nil
 }
 
 func buildMetricID(name string, envelopeLabelNames, envelopeLabelValues []string) string {
@@ -243,23 +434,27 @@ func gaugeLabels(metric *loggregator_v2.GaugeValue, envelopeLabelNames, envelope
 	return append(envelopeLabelNames, "unit"), append(envelopeLabelValues, metric.Unit)
 }
 
-func (c *EnvelopeCollector) convertTimer(env *loggregator_v2.Envelope) (metricID string, metric prometheus.Metric, err error) {
+func (c *EnvelopeCollector) convertTimer(env *loggregator_v2.Envelope) (string, convertedMetric, error) {
 	timer := env.GetTimer()
 	name := timer.GetName() + "_seconds"
-	name, modified := sanitizeName(name)
+	name, modified := c.sanitizeName(name)
 	if modified {
 		c.incrementCounter("modified_tags", env.GetSourceId())
 	}
 
 	labelNames, labelValues := c.convertTags(env)
-	labelNames, labelValues = addLoggregatorNameTag(labelNames, labelValues, timer.GetName())
+	labelNames, labelValues = c.addLoggregatorNameTag(labelNames, labelValues, timer.GetName())
 	id := buildMetricID(name, labelNames, labelValues)
 
-	c.Lock()
-	bucket := c.getOrCreateBucket(env.GetSourceId())
-	metricWithExpiry, ok := bucket.metrics[id]
-	c.Unlock()
+	c.RLock()
+	var metricWithExpiry metricWithExpiry
+	bucket, ok := c.metricBuckets[env.GetSourceId()]
+	if ok {
+		metricWithExpiry, ok = bucket.metrics[id]
+	}
+	c.RUnlock()
 
+	var metric prometheus.Metric
 	if ok {
 		metric = metricWithExpiry.metric
 	} else {
@@ -270,9 +465,16 @@ func (c *EnvelopeCollector) convertTimer(env *loggregator_v2.Envelope) (metricID
 			ConstLabels: labelTags(labelNames, labelValues),
 		})
 	}
-	metric.(prometheus.Histogram).Observe(durationInSeconds(timer))
-
-	return id, metric, nil
+	duration := durationInSeconds(timer)
+	metric.(prometheus.Histogram).Observe(duration)
+
+	return id, convertedMetric{
+		name:        name,
+		valueType:   histogramType,
+		labelNames:  labelNames,
+		metric:      metric,
+		observation: &duration,
+	}, nil
 }
 
 func durationInSeconds(timer *loggregator_v2.Timer) float64 {
@@ -301,7 +503,11 @@ func (c *EnvelopeCollector) allTags(env *loggregator_v2.Envelope) map[string]str
 		allTags[k] = v
 	}
 
-	for k, v := range c.defaultTags {
+	c.RLock()
+	defaultTags := c.defaultTags
+	c.RUnlock()
+
+	for k, v := range defaultTags {
 		_, exists := allTags[k]
 		if exists {
 			continue
@@ -319,7 +525,7 @@ func (c *EnvelopeCollector) toLabels(sourceID string, tags map[string]string) ([
 			continue
 		}
 
-		name, modified := sanitizeTagName(name)
+		name, modified := c.sanitizeTagName(name)
 		if modified {
 			c.incrementCounter("modified_tags", sourceID)
 		}
@@ -338,17 +544,29 @@ func (c *EnvelopeCollector) incrementCounter(metricName, originatingSourceID str
 	).Add(1)
 }
 
-func addLoggregatorNameTag(labelNames, labelValues []string, name string) ([]string, []string) {
+func (c *EnvelopeCollector) addLoggregatorNameTag(labelNames, labelValues []string, name string) ([]string, []string) {
+	if !c.loggregatorNameLabel {
+		return labelNames, labelValues
+	}
+
 	name = b64.StdEncoding.EncodeToString([]byte(name))
 	return append(labelNames, "loggregator_name"), append(labelValues, name)
 }
 
-func sanitizeTagName(name string) (string, bool) {
+func (c *EnvelopeCollector) sanitizeTagName(name string) (string, bool) {
+	if c.utf8Names && utf8.ValidString(name) {
+		return name, false
+	}
+
 	sanitized := invalidTagCharacterRegex.ReplaceAllString(name, "_")
 	return sanitized, sanitized != name
 }
 
-func sanitizeName(name string) (string, bool) {
+func (c *EnvelopeCollector) sanitizeName(name string) (string, bool) {
+	if c.utf8Names && name != "" && utf8.ValidString(name) {
+		return name, false
+	}
+
 	sanitized := invalidNameRegex.ReplaceAllString(name, "_")
 	return sanitized, sanitized != name
 }
@@ -3,6 +3,8 @@ package collector_test
 import (
 	b64 "encoding/base64"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
@@ -10,6 +12,7 @@ import (
 	"code.cloudfoundry.org/metrics-discovery/internal/collector"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/types"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -356,6 +359,194 @@ var _ = Describe("EnvelopeCollector", func() {
 				),
 			)))
 		})
+
+		It("uses changed default tags for new envelopes", func() {
+			envelopeCollector := collector.NewEnvelopeCollector(
+				testhelpers.NewMetricsRegistry(),
+				collector.WithDefaultTags(map[string]string{"a": "1"}),
+			)
+			envelopeCollector.SetDefaultTags(map[string]string{"a": "2"})
+			Expect(envelopeCollector.Write(counterWithTags("some_name", 1, nil))).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(Receive(
+				haveLabels(
+					labelPair("source_id", "some-source-id"),
+					labelPair("instance_id", "some-instance-id"),
+					labelPair("a", "2"),
+					labelPair("loggregator_name", b64.StdEncoding.EncodeToString([]byte("some_name"))),
+				),
+			))
+		})
+	})
+
+	Context("utf-8 names", func() {
+		It("keeps original metric and tag names", func() {
+			spyRegistry := testhelpers.NewMetricsRegistry()
+			envelopeCollector := collector.NewEnvelopeCollector(spyRegistry, collector.WithUTF8Names(true))
+			counter := counterWithTags("gorouter.total_requests", 1, map[string]string{
+				"app.name": "some-app",
+			})
+			Expect(envelopeCollector.Write(counter)).To(Succeed())
+			Expect(envelopeCollector.Write(gauge(map[string]float64{"gorouter.latency": 7}))).To(Succeed())
+			Expect(envelopeCollector.Write(timer("http.request", 0, int64(time.Second)))).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(receiveInAnyOrder(
+				And(
+					haveName("gorouter.total_requests"),
+					haveLabels(
+						labelPair("app.name", "some-app"),
+						labelPair("source_id", "some-source-id"),
+						labelPair("instance_id", "some-instance-id"),
+						labelPair("loggregator_name", b64.StdEncoding.EncodeToString([]byte("gorouter.total_requests"))),
+					),
+				),
+				haveName("gorouter.latency"),
+				haveName("http.request_seconds"),
+			))
+			Expect(spyRegistry.HasMetric("modified_tags", map[string]string{"originating_source_id": "some-source-id"})).To(BeFalse())
+		})
+
+		It("still sanitizes names that are not valid utf-8", func() {
+			envelopeCollector := collector.NewEnvelopeCollector(testhelpers.NewMetricsRegistry(), collector.WithUTF8Names(true))
+			Expect(envelopeCollector.Write(totalCounter("invalid\xffname", 1))).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(Receive(haveName("invalid_name")))
+		})
+	})
+
+	It("can omit the loggregator_name label", func() {
+		envelopeCollector := collector.NewEnvelopeCollector(testhelpers.NewMetricsRegistry(), collector.WithLoggregatorNameLabel(false))
+		Expect(envelopeCollector.Write(counterWithTags("some.counter", 1, map[string]string{"a": "1"}))).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(Receive(And(
+			haveName("some_counter"),
+			haveLabels(
+				labelPair("a", "1"),
+				labelPair("source_id", "some-source-id"),
+				labelPair("instance_id", "some-instance-id"),
+			),
+		)))
+	})
+
+	Context("conflicting metrics", func() {
+		It("rejects metrics whose type conflicts with an existing metric of the same name", func() {
+			spyRegistry := testhelpers.NewMetricsRegistry()
+			envelopeCollector := collector.NewEnvelopeCollector(spyRegistry)
+			Expect(envelopeCollector.Write(counterWithSourceID("some_metric", "counter-source"))).To(Succeed())
+			Expect(envelopeCollector.Write(gaugeWithSourceID("some_metric", "gauge-source"))).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+				haveName("some_metric"),
+				counterWithValue(79),
+			)))
+			Expect(spyRegistry.GetMetricValue("metric_conflicts", map[string]string{
+				"originating_source_id": "gauge-source",
+				"conflict":              "type",
+			})).To(Equal(1.0))
+		})
+
+		It("reports metrics with inconsistent label names", func() {
+			spyRegistry := testhelpers.NewMetricsRegistry()
+			logs := gbytes.NewBuffer()
+			envelopeCollector := collector.NewEnvelopeCollector(spyRegistry, collector.WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+
+			first := counterWithTags("some_counter", 1, map[string]string{"a": "1"})
+			first.SourceId = "first-source"
+			second := counterWithTags("some_counter", 2, map[string]string{"b": "2"})
+			second.SourceId = "second-source"
+			Expect(envelopeCollector.Write(first)).To(Succeed())
+			Expect(envelopeCollector.Write(second)).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(HaveLen(2))
+			Expect(spyRegistry.GetMetricValue("metric_conflicts", map[string]string{
+				"originating_source_id": "second-source",
+				"conflict":              "labels",
+			})).To(Equal(1.0))
+			Expect(logs).To(gbytes.Say("metric=some_counter source_id=second-source .* existing_source_id=first-source"))
+		})
+
+		It("does not report the source id owning a metric after a label conflict", func() {
+			spyRegistry := testhelpers.NewMetricsRegistry()
+			logs := gbytes.NewBuffer()
+			envelopeCollector := collector.NewEnvelopeCollector(spyRegistry, collector.WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+
+			first := counterWithTags("some_counter", 1, map[string]string{"a": "1"})
+			first.SourceId = "first-source"
+			second := counterWithTags("some_counter", 2, map[string]string{"b": "2"})
+			second.SourceId = "second-source"
+			Expect(envelopeCollector.Write(first)).To(Succeed())
+			Expect(envelopeCollector.Write(second)).To(Succeed())
+			Expect(logs).To(gbytes.Say("source_id=second-source"))
+
+			Expect(envelopeCollector.Write(first)).To(Succeed())
+
+			Expect(spyRegistry.HasMetric("metric_conflicts", map[string]string{
+				"originating_source_id": "first-source",
+				"conflict":              "labels",
+			})).To(BeFalse())
+			Expect(spyRegistry.GetMetricValue("metric_conflicts", map[string]string{
+				"originating_source_id": "second-source",
+				"conflict":              "labels",
+			})).To(Equal(1.0))
+			Expect(strings.Count(string(logs.Contents()), "metric conflicts")).To(Equal(1))
+		})
+
+		It("reports inconsistent label names within a source id", func() {
+			spyRegistry := testhelpers.NewMetricsRegistry()
+			logs := gbytes.NewBuffer()
+			envelopeCollector := collector.NewEnvelopeCollector(spyRegistry, collector.WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+
+			Expect(envelopeCollector.Write(counterWithTags("some_counter", 1, map[string]string{"a": "1"}))).To(Succeed())
+			Expect(envelopeCollector.Write(counterWithTags("some_counter", 2, map[string]string{"b": "2"}))).To(Succeed())
+
+			Expect(spyRegistry.GetMetricValue("metric_conflicts", map[string]string{
+				"originating_source_id": "some-source-id",
+				"conflict":              "labels",
+			})).To(Equal(1.0))
+			Expect(logs).To(gbytes.Say("metric=some_counter source_id=some-source-id .* existing_source_id=some-source-id"))
+		})
+
+		It("logs a conflict once while the metric is written", func() {
+			logs := gbytes.NewBuffer()
+			envelopeCollector := collector.NewEnvelopeCollector(
+				testhelpers.NewMetricsRegistry(),
+				collector.WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+				collector.WithSourceIDExpiration(time.Hour, time.Millisecond),
+			)
+
+			Expect(envelopeCollector.Write(counterWithTags("some_counter", 1, map[string]string{"a": "1"}))).To(Succeed())
+			for range 3 {
+				Expect(envelopeCollector.Write(counterWithTags("some_counter", 2, map[string]string{"b": "2"}))).To(Succeed())
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			Expect(strings.Count(string(logs.Contents()), "metric conflicts")).To(Equal(1))
+		})
+
+		It("fills missing labels with empty values when normalizing", func() {
+			envelopeCollector := collector.NewEnvelopeCollector(
+				testhelpers.NewMetricsRegistry(),
+				collector.WithLabelNormalization(true),
+				collector.WithLoggregatorNameLabel(false),
+			)
+			Expect(envelopeCollector.Write(counterWithTags("some_counter", 1, map[string]string{"a": "1"}))).To(Succeed())
+			Expect(envelopeCollector.Write(counterWithTags("some_counter", 2, map[string]string{"b": "2"}))).To(Succeed())
+
+			Expect(collectMetrics(envelopeCollector)).To(receiveInAnyOrder(
+				haveLabels(
+					labelPair("a", "1"),
+					labelPair("b", ""),
+					labelPair("source_id", "some-source-id"),
+					labelPair("instance_id", "some-instance-id"),
+				),
+				haveLabels(
+					labelPair("a", ""),
+					labelPair("b", "2"),
+					labelPair("source_id", "some-source-id"),
+					labelPair("instance_id", "some-instance-id"),
+				),
+			))
+		})
 	})
 
 	It("differentiates between metrics with the same name but different labels", func() {
@@ -448,6 +639,17 @@ var _ = Describe("EnvelopeCollector", func() {
 				haveName("counter_to_keep"),
 			))
 		})
+
+		It("uses a changed TTL", func() {
+			envelopeCollector := collector.NewEnvelopeCollector(testhelpers.NewMetricsRegistry(), collector.WithSourceIDExpiration(time.Hour, time.Millisecond))
+			envelopeCollector.SetSourceIDTTL(50 * time.Millisecond)
+
+			Expect(envelopeCollector.Write(counterWithSourceID("counter_to_expire", "soon-to-not-exist"))).To(Succeed())
+
+			Eventually(func() chan prometheus.Metric {
+				return collectMetrics(envelopeCollector)
+			}, 2).Should(BeEmpty())
+		})
 	})
 })
 
@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+)
+
+// Reasons of the conversion_errors metric.
+const (
+	reasonInvalidMetric = "invalid_metric"
+	reasonTypeConflict  = "type_conflict"
+)
+
+// Instrumentation reports the envelopes and series passing through
+// collectors. Metrics are created once per label set because creating them
+// registers them, which is too expensive for every envelope. The metrics of
+// a source id are removed once no collector holds series for it anymore, or
+// once a source id without series has not been seen within the TTL.
+//
+// Collectors that can receive the same source id must share an
+// Instrumentation so that the held series are counted across them.
+type Instrumentation struct {
+	metrics debugMetrics
+
+	// processed and conversionErrors map a sourceIDKey to a
+	// metrics.Counter and are read for every envelope without locking.
+	processed        sync.Map
+	conversionErrors sync.Map
+
+	// lastSeen maps a source id to the *atomic.Int64 unix nanoseconds of
+	// its last envelope or conversion error.
+	lastSeen sync.Map
+
+	mu      sync.Mutex
+	held    map[string]int
+	gauges  map[string]metrics.Gauge
+	expired map[string]metrics.Counter
+}
+
+// sourceIDKey identifies a per source id metric by the value of its other
+// label.
+type sourceIDKey struct {
+	value    string
+	sourceID string
+}
+
+// NewInstrumentation returns an Instrumentation that registers its metrics
+// with m.
+func NewInstrumentation(m debugMetrics) *Instrumentation {
+	return &Instrumentation{
+		metrics: m,
+		held:    map[string]int{},
+		gauges:  map[string]metrics.Gauge{},
+		expired: map[string]metrics.Counter{},
+	}
+}
+
+// processedEnvelope counts an envelope written to a collector.
+func (i *Instrumentation) processedEnvelope(env *loggregator_v2.Envelope) {
+	i.counter(
+		&i.processed,
+		sourceIDKey{value: envelopeType(env), sourceID: env.GetSourceId()},
+		"processed_envelopes",
+		"Total number of envelopes written to the collector by envelope type and originating source id.",
+		"type",
+	).Add(1)
+}
+
+// conversionError counts a metric that could not be converted or stored.
+func (i *Instrumentation) conversionError(sourceID, reason string) {
+	i.counter(
+		&i.conversionErrors,
+		sourceIDKey{value: reason, sourceID: sourceID},
+		"conversion_errors",
+		"Total number of metrics that could not be converted or were rejected by reason and originating source id.",
+		"reason",
+	).Add(1)
+}
+
+// seriesAdded changes the number of series held for a source id.
+func (i *Instrumentation) seriesAdded(sourceID string, n int) {
+	if n == 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.addHeld(sourceID, n)
+}
+
+// seriesExpired counts series removed after their TTL and updates the
+// number of held series. Once no series are held for the source id, all of
+// its metrics are removed so that they do not accumulate.
+func (i *Instrumentation) seriesExpired(sourceID string, n int) {
+	if n == 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.addHeld(sourceID, -n) > 0 {
+		c, ok := i.expired[sourceID]
+		if !ok {
+			c = i.metrics.NewCounter(
+				"expired_series",
+				"Total number of series removed because they were not updated within the TTL by originating source id.",
+				metrics.WithMetricLabels(map[string]string{"originating_source_id": sourceID}),
+			)
+			i.expired[sourceID] = c
+		}
+		c.Add(float64(n))
+		return
+	}
+
+	i.remove(sourceID)
+}
+
+// expire removes the metrics of source ids that hold no series and have not
+// been seen since tooOld, e.g. source ids that only send logs or invalid
+// metrics.
+func (i *Instrumentation) expire(tooOld time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.lastSeen.Range(func(k, v any) bool {
+		sourceID := k.(string)
+		if i.held[sourceID] == 0 && v.(*atomic.Int64).Load() < tooOld.UnixNano() {
+			i.remove(sourceID)
+		}
+		return true
+	})
+}
+
+// addHeld must be called with mu held.
+func (i *Instrumentation) addHeld(sourceID string, n int) int {
+	held := i.held[sourceID] + n
+	i.held[sourceID] = held
+
+	g, ok := i.gauges[sourceID]
+	if !ok {
+		g = i.metrics.NewGauge(
+			"held_series",
+			"Number of series currently held for the originating source id.",
+			metrics.WithMetricLabels(map[string]string{"originating_source_id": sourceID}),
+		)
+		i.gauges[sourceID] = g
+	}
+	g.Set(float64(held))
+	return held
+}
+
+// remove unregisters the metrics of a source id. It must be called with mu
+// held.
+func (i *Instrumentation) remove(sourceID string) {
+	delete(i.held, sourceID)
+	i.lastSeen.Delete(sourceID)
+	if g, ok := i.gauges[sourceID]; ok {
+		i.metrics.RemoveGauge(g)
+		delete(i.gauges, sourceID)
+	}
+	if c, ok := i.expired[sourceID]; ok {
+		i.metrics.RemoveCounter(c)
+		delete(i.expired, sourceID)
+	}
+
+	for _, counters := range []*sync.Map{&i.processed, &i.conversionErrors} {
+		counters.Range(func(k, c any) bool {
+			if k.(sourceIDKey).sourceID == sourceID {
+				counters.Delete(k)
+				i.metrics.RemoveCounter(c.(metrics.Counter))
+			}
+			return true
+		})
+	}
+}
+
+func (i *Instrumentation) counter(counters *sync.Map, key sourceIDKey, name, help, label string) metrics.Counter {
+	i.seen(key.sourceID)
+
+	if c, ok := counters.Load(key); ok {
+		return c.(metrics.Counter)
+	}
+
+	c := i.metrics.NewCounter(name, help, metrics.WithMetricLabels(map[string]string{
+		label:                   key.value,
+		"originating_source_id": key.sourceID,
+	}))
+	actual, _ := counters.LoadOrStore(key, c)
+	return actual.(metrics.Counter)
+}
+
+func (i *Instrumentation) seen(sourceID string) {
+	now := time.Now().UnixNano()
+	if t, ok := i.lastSeen.Load(sourceID); ok {
+		t.(*atomic.Int64).Store(now)
+		return
+	}
+
+	t := &atomic.Int64{}
+	t.Store(now)
+	if actual, loaded := i.lastSeen.LoadOrStore(sourceID, t); loaded {
+		actual.(*atomic.Int64).Store(now)
+	}
+}
+
+func envelopeType(env *loggregator_v2.Envelope) string {
+	switch env.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return "counter"
+	case *loggregator_v2.Envelope_Gauge:
+		return "gauge"
+	case *loggregator_v2.Envelope_Timer:
+		return "timer"
+	case *loggregator_v2.Envelope_Log:
+		return "log"
+	case *loggregator_v2.Envelope_Event:
+		return "event"
+	default:
+		return "unknown"
+	}
+}
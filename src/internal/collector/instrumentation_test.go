@@ -0,0 +1,234 @@
+package collector_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EnvelopeCollector instrumentation", func() {
+	var (
+		spyRegistry       *testhelpers.SpyMetricsRegistry
+		envelopeCollector *collector.EnvelopeCollector
+	)
+
+	BeforeEach(func() {
+		spyRegistry = testhelpers.NewMetricsRegistry()
+		envelopeCollector = collector.NewEnvelopeCollector(spyRegistry)
+	})
+
+	It("counts processed envelopes by type and source id", func() {
+		Expect(envelopeCollector.Write(counterWithSourceID("some_counter", "source-a"))).To(Succeed())
+		Expect(envelopeCollector.Write(counterWithSourceID("other_counter", "source-a"))).To(Succeed())
+		Expect(envelopeCollector.Write(gaugeWithSourceID("some_gauge", "source-b"))).To(Succeed())
+		Expect(envelopeCollector.Write(&loggregator_v2.Envelope{
+			SourceId: "source-b",
+			Message:  &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte("hello")}},
+		})).To(Succeed())
+
+		Expect(spyRegistry.GetMetricValue("processed_envelopes", map[string]string{
+			"type":                  "counter",
+			"originating_source_id": "source-a",
+		})).To(Equal(2.0))
+		Expect(spyRegistry.GetMetricValue("processed_envelopes", map[string]string{
+			"type":                  "gauge",
+			"originating_source_id": "source-b",
+		})).To(Equal(1.0))
+		Expect(spyRegistry.GetMetricValue("processed_envelopes", map[string]string{
+			"type":                  "log",
+			"originating_source_id": "source-b",
+		})).To(Equal(1.0))
+	})
+
+	It("counts conversion errors by reason", func() {
+		Expect(envelopeCollector.Write(counterWithTags("some_counter", 1, map[string]string{"tag": "\xff"}))).ToNot(Succeed())
+		Expect(envelopeCollector.Write(counterWithSourceID("some_metric", "counter-source"))).To(Succeed())
+		Expect(envelopeCollector.Write(gaugeWithSourceID("some_metric", "gauge-source"))).To(Succeed())
+
+		Expect(spyRegistry.GetMetricValue("conversion_errors", map[string]string{
+			"reason":                "invalid_metric",
+			"originating_source_id": "some-source-id",
+		})).To(Equal(1.0))
+		Expect(spyRegistry.GetMetricValue("conversion_errors", map[string]string{
+			"reason":                "type_conflict",
+			"originating_source_id": "gauge-source",
+		})).To(Equal(1.0))
+	})
+
+	It("reports the series held per source id", func() {
+		Expect(envelopeCollector.Write(counterWithSourceID("some_counter", "source-a"))).To(Succeed())
+		Expect(envelopeCollector.Write(counterWithSourceID("some_counter", "source-a"))).To(Succeed())
+		Expect(envelopeCollector.Write(gaugeWithSourceID("some_gauge", "source-a"))).To(Succeed())
+		Expect(envelopeCollector.WriteGauge(collector.Series{SourceID: "source-b", Name: "pushed_gauge"}, 1)).To(Succeed())
+
+		Expect(spyRegistry.GetMetricValue("held_series", map[string]string{"originating_source_id": "source-a"})).To(Equal(2.0))
+		Expect(spyRegistry.GetMetricValue("held_series", map[string]string{"originating_source_id": "source-b"})).To(Equal(1.0))
+	})
+
+	Context("when series expire", func() {
+		var registry *lockedRegistry
+
+		BeforeEach(func() {
+			registry = &lockedRegistry{spy: spyRegistry}
+			envelopeCollector = collector.NewEnvelopeCollector(registry, collector.WithSourceIDExpiration(time.Hour, time.Millisecond))
+			envelopeCollector.SetSourceIDTTL(50 * time.Millisecond)
+		})
+
+		It("counts the expired series of a source id that still holds series", func() {
+			Expect(envelopeCollector.Write(gaugeWithSourceID("old_gauge", "source-a"))).To(Succeed())
+			cancel := writeUntilCancelled(envelopeCollector, gaugeWithSourceID("new_gauge", "source-a"))
+			defer cancel()
+
+			Eventually(func() float64 {
+				return registry.GetMetricValue("expired_series", map[string]string{"originating_source_id": "source-a"})
+			}, 2).Should(Equal(1.0))
+			Expect(registry.GetMetricValue("held_series", map[string]string{"originating_source_id": "source-a"})).To(Equal(1.0))
+		})
+
+		It("removes the metrics of a source id without series", func() {
+			Expect(envelopeCollector.Write(counterWithSourceID("some_counter", "source-a"))).To(Succeed())
+			Expect(envelopeCollector.Write(gaugeWithSourceID("some_gauge", "source-a"))).To(Succeed())
+			Expect(envelopeCollector.Write(counterWithTags("bad_counter", 1, map[string]string{"tag": "\xff"}))).ToNot(Succeed())
+			Expect(envelopeCollector.Write(counterWithSourceID("some_counter", "some-source-id"))).To(Succeed())
+
+			sourceA := map[string]string{"originating_source_id": "source-a"}
+			Eventually(func() bool {
+				return registry.HasMetric("held_series", sourceA)
+			}, 2).Should(BeFalse())
+			Eventually(func() bool {
+				return registry.HasMetric("held_series", map[string]string{"originating_source_id": "some-source-id"})
+			}, 2).Should(BeFalse())
+			Expect(registry.HasMetric("expired_series", sourceA)).To(BeFalse())
+			Expect(registry.HasMetric("processed_envelopes", map[string]string{
+				"type":                  "counter",
+				"originating_source_id": "source-a",
+			})).To(BeFalse())
+			Expect(registry.HasMetric("conversion_errors", map[string]string{
+				"reason":                "invalid_metric",
+				"originating_source_id": "some-source-id",
+			})).To(BeFalse())
+		})
+
+		It("removes the metrics of a source id that never held series", func() {
+			Expect(envelopeCollector.Write(&loggregator_v2.Envelope{
+				SourceId: "log-source",
+				Message:  &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte("hello")}},
+			})).To(Succeed())
+			Expect(envelopeCollector.Write(counterWithTags("bad_counter", 1, map[string]string{"tag": "\xff"}))).ToNot(Succeed())
+
+			processed := map[string]string{"type": "log", "originating_source_id": "log-source"}
+			conversionErrors := map[string]string{"reason": "invalid_metric", "originating_source_id": "some-source-id"}
+			Expect(registry.HasMetric("processed_envelopes", processed)).To(BeTrue())
+			Expect(registry.HasMetric("conversion_errors", conversionErrors)).To(BeTrue())
+
+			Eventually(func() bool {
+				return registry.HasMetric("processed_envelopes", processed)
+			}, 2).Should(BeFalse())
+			Eventually(func() bool {
+				return registry.HasMetric("conversion_errors", conversionErrors)
+			}, 2).Should(BeFalse())
+		})
+
+		It("keeps the metrics of a source id without series while it is seen", func() {
+			cancel := writeUntilCancelled(envelopeCollector, &loggregator_v2.Envelope{
+				SourceId: "log-source",
+				Message:  &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte("hello")}},
+			})
+			defer cancel()
+
+			processed := map[string]string{"type": "log", "originating_source_id": "log-source"}
+			Eventually(func() bool {
+				return registry.HasMetric("processed_envelopes", processed)
+			}, 2).Should(BeTrue())
+			Consistently(func() bool {
+				return registry.HasMetric("processed_envelopes", processed)
+			}, 200*time.Millisecond).Should(BeTrue())
+		})
+	})
+
+	It("counts the series held by collectors sharing the instrumentation", func() {
+		registry := &lockedRegistry{spy: spyRegistry}
+		instrumentation := collector.NewInstrumentation(registry)
+		expiring := collector.NewEnvelopeCollector(registry,
+			collector.WithInstrumentation(instrumentation),
+			collector.WithSourceIDExpiration(50*time.Millisecond, time.Millisecond),
+		)
+		kept := collector.NewEnvelopeCollector(registry, collector.WithInstrumentation(instrumentation))
+
+		Expect(expiring.Write(gaugeWithSourceID("some_gauge", "source-a"))).To(Succeed())
+		Expect(kept.Write(gaugeWithSourceID("other_gauge", "source-a"))).To(Succeed())
+		Expect(registry.GetMetricValue("held_series", map[string]string{"originating_source_id": "source-a"})).To(Equal(2.0))
+
+		Eventually(func() float64 {
+			return registry.GetMetricValue("expired_series", map[string]string{"originating_source_id": "source-a"})
+		}, 2).Should(Equal(1.0))
+		Expect(registry.GetMetricValue("held_series", map[string]string{"originating_source_id": "source-a"})).To(Equal(1.0))
+	})
+})
+
+// lockedRegistry serializes the access to the spy registry, whose Remove
+// methods do not lock.
+type lockedRegistry struct {
+	mu  sync.Mutex
+	spy *testhelpers.SpyMetricsRegistry
+}
+
+func (r *lockedRegistry) NewCounter(name, help string, opts ...metrics.MetricOption) metrics.Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spy.NewCounter(name, help, opts...)
+}
+
+func (r *lockedRegistry) NewGauge(name, help string, opts ...metrics.MetricOption) metrics.Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spy.NewGauge(name, help, opts...)
+}
+
+func (r *lockedRegistry) RemoveCounter(c metrics.Counter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spy.RemoveCounter(c)
+}
+
+func (r *lockedRegistry) RemoveGauge(g metrics.Gauge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spy.RemoveGauge(g)
+}
+
+func (r *lockedRegistry) GetMetricValue(name string, labels map[string]string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spy.GetMetricValue(name, labels)
+}
+
+func (r *lockedRegistry) HasMetric(name string, labels map[string]string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spy.HasMetric(name, labels)
+}
+
+func writeUntilCancelled(c *collector.EnvelopeCollector, env *loggregator_v2.Envelope) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = c.Write(env)
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+
+	typeConflict  = "type"
+	labelConflict = "labels"
+)
+
+// metricSchemas tracks the type and label names of every metric name so
+// that envelopes from different emitters that disagree can be detected.
+type metricSchemas struct {
+	sync.Mutex
+	schemas map[string]*metricSchema
+}
+
+// metricSchema is the schema of the first metric of a name, which was
+// emitted by sourceID. allLabelNames additionally holds the label names of
+// conflicting metrics so that they can be normalized. reported holds the
+// conflicts that were logged for as long as the schema exists.
+type metricSchema struct {
+	valueType     string
+	sourceID      string
+	labelNames    map[string]struct{}
+	allLabelNames map[string]struct{}
+	reported      map[string]struct{}
+	lastUpdate    time.Time
+}
+
+type schemaConflict struct {
+	kind             string
+	got              string
+	want             string
+	existingSourceID string
+	firstOccurrence  bool
+}
+
+func newMetricSchemas() *metricSchemas {
+	return &metricSchemas{
+		schemas: map[string]*metricSchema{},
+	}
+}
+
+// check records the schema of the given metric and returns a conflict if it
+// disagrees with the schema previously recorded for the same name. Label
+// names of other metrics are added to the names used for normalization.
+func (s *metricSchemas) check(sourceID string, metric convertedMetric) *schemaConflict {
+	s.Lock()
+	defer s.Unlock()
+
+	schema, ok := s.schemas[metric.name]
+	if !ok {
+		s.schemas[metric.name] = &metricSchema{
+			valueType:     metric.valueType,
+			sourceID:      sourceID,
+			labelNames:    toSet(metric.labelNames),
+			allLabelNames: toSet(metric.labelNames),
+			reported:      map[string]struct{}{},
+			lastUpdate:    time.Now(),
+		}
+		return nil
+	}
+
+	if schema.valueType != metric.valueType {
+		return s.conflict(typeConflict, sourceID, metric, schema, metric.valueType, schema.valueType)
+	}
+	schema.lastUpdate = time.Now()
+
+	for _, name := range metric.labelNames {
+		schema.allLabelNames[name] = struct{}{}
+	}
+	if sameLabelNames(schema.labelNames, metric.labelNames) {
+		return nil
+	}
+
+	return s.conflict(
+		labelConflict,
+		sourceID,
+		metric,
+		schema,
+		fmt.Sprintf("labels %v", sortedLabelNames(toSet(metric.labelNames))),
+		fmt.Sprintf("labels %v", sortedLabelNames(schema.labelNames)),
+	)
+}
+
+func (s *metricSchemas) conflict(kind, sourceID string, metric convertedMetric, schema *metricSchema, got, want string) *schemaConflict {
+	key := kind + "\xff" + sourceID
+	_, reported := schema.reported[key]
+	schema.reported[key] = struct{}{}
+
+	return &schemaConflict{
+		kind:             kind,
+		got:              got,
+		want:             want,
+		existingSourceID: schema.sourceID,
+		firstOccurrence:  !reported,
+	}
+}
+
+// normalize returns the metric with every label name recorded for the
+// metric name that it is missing added with an empty value.
+func (s *metricSchemas) normalize(metric convertedMetric) prometheus.Metric {
+	s.Lock()
+	defer s.Unlock()
+
+	schema, ok := s.schemas[metric.name]
+	if !ok {
+		return metric.metric
+	}
+
+	var missing []string
+	for name := range schema.allLabelNames {
+		if !slices.Contains(metric.labelNames, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return metric.metric
+	}
+
+	return &normalizedMetric{Metric: metric.metric, missingLabels: missing}
+}
+
+func (s *metricSchemas) expire(tooOld time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for name, schema := range s.schemas {
+		if schema.lastUpdate.Before(tooOld) {
+			delete(s.schemas, name)
+		}
+	}
+}
+
+type normalizedMetric struct {
+	prometheus.Metric
+	missingLabels []string
+}
+
+// Write implements prometheus.Metric
+func (m *normalizedMetric) Write(out *dto.Metric) error {
+	err := m.Metric.Write(out)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range m.missingLabels {
+		out.Label = append(out.Label, &dto.LabelPair{
+			Name:  proto.String(name),
+			Value: proto.String(""),
+		})
+	}
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+
+	return nil
+}
+
+func sameLabelNames(names map[string]struct{}, other []string) bool {
+	if len(names) != len(other) {
+		return false
+	}
+
+	for _, name := range other {
+		if _, ok := names[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedLabelNames(names map[string]struct{}) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func toSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
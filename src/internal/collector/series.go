@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Series identifies a metric that was converted outside of the collector,
+// such as an OpenTelemetry data point. Tags are converted to labels the same
+// way as envelope tags.
+type Series struct {
+	SourceID   string
+	InstanceID string
+	Name       string
+	Tags       map[string]string
+}
+
+// WriteCounter stores the cumulative value of a counter series.
+func (c *EnvelopeCollector) WriteCounter(s Series, value float64) error {
+	return c.writeSeries(s, counterType, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, value, labelValues...)
+	})
+}
+
+// WriteGauge stores the value of a gauge series.
+func (c *EnvelopeCollector) WriteGauge(s Series, value float64) error {
+	return c.writeSeries(s, gaugeType, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	})
+}
+
+// WriteHistogram stores a cumulative histogram series. Buckets map upper
+// bounds to cumulative counts.
+func (c *EnvelopeCollector) WriteHistogram(s Series, count uint64, sum float64, buckets map[float64]uint64) error {
+	return c.writeSeries(s, histogramType, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstHistogram(desc, count, sum, buckets, labelValues...)
+	})
+}
+
+func (c *EnvelopeCollector) writeSeries(
+	s Series,
+	valueType string,
+	newMetric func(*prometheus.Desc, []string) (prometheus.Metric, error),
+) error {
+	name, modified := c.sanitizeName(s.Name)
+	if modified {
+		c.incrementCounter("modified_tags", s.SourceID)
+	}
+
+	labelNames, labelValues := c.convertTags(&loggregator_v2.Envelope{
+		SourceId:   s.SourceID,
+		InstanceId: s.InstanceID,
+		Tags:       s.Tags,
+	})
+
+	metric, err := newMetric(prometheus.NewDesc(name, help, labelNames, nil), labelValues)
+	if err != nil {
+		c.instrumentation.conversionError(s.SourceID, reasonInvalidMetric)
+		return err
+	}
+
+	converted := convertedMetric{
+		name:       name,
+		valueType:  valueType,
+		labelNames: labelNames,
+		metric:     metric,
+	}
+	if !c.checkSchema(s.SourceID, converted) {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.addMetric(s.SourceID, buildMetricID(name, labelNames, labelValues), converted) {
+		c.instrumentation.seriesAdded(s.SourceID, 1)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package collector_test
+
+import (
+	"code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Series", func() {
+	var (
+		envelopeCollector *collector.EnvelopeCollector
+		series            collector.Series
+	)
+
+	BeforeEach(func() {
+		envelopeCollector = collector.NewEnvelopeCollector(
+			testhelpers.NewMetricsRegistry(),
+			collector.WithDefaultTags(map[string]string{"deployment": "cf"}),
+		)
+		series = collector.Series{
+			SourceID:   "some-service",
+			InstanceID: "some-instance",
+			Name:       "http.requests",
+			Tags:       map[string]string{"method": "GET"},
+		}
+	})
+
+	It("writes counters", func() {
+		Expect(envelopeCollector.WriteCounter(series, 1.5)).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+			haveName("http_requests"),
+			counterWithValue(1.5),
+			haveLabels(
+				labelPair("source_id", "some-service"),
+				labelPair("instance_id", "some-instance"),
+				labelPair("method", "GET"),
+				labelPair("deployment", "cf"),
+			),
+		)))
+	})
+
+	It("writes gauges", func() {
+		Expect(envelopeCollector.WriteGauge(series, 3)).To(Succeed())
+		Expect(envelopeCollector.WriteGauge(series, 4)).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+			haveName("http_requests"),
+			gaugeWithValue(4),
+		)))
+	})
+
+	It("writes histograms", func() {
+		Expect(envelopeCollector.WriteHistogram(series, 3, 4.5, map[float64]uint64{1: 1, 5: 3})).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(And(
+			haveName("http_requests"),
+			histogramWithCount(3),
+			histogramWithSum(4.5),
+			histogramWithBuckets(1, 5),
+		)))
+	})
+
+	It("rejects series conflicting with the type of envelope metrics", func() {
+		Expect(envelopeCollector.Write(counterWithSourceID("http_requests", "some-service"))).To(Succeed())
+		Expect(envelopeCollector.WriteGauge(series, 3)).To(Succeed())
+
+		Expect(collectMetrics(envelopeCollector)).To(receiveOnly(counterWithValue(79)))
+	})
+})
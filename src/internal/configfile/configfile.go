@@ -0,0 +1,183 @@
+package configfile
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/go-envstruct"
+	"gopkg.in/yaml.v3"
+)
+
+// Flags are the command line flags shared by all commands.
+type Flags struct {
+	// Path is the YAML config file. It is empty if no file is used.
+	Path string
+	// Check is set when the command should exit after validating its
+	// configuration.
+	Check bool
+}
+
+// ParseFlags parses the --config and --check-config flags. The config file
+// defaults to the CONFIG_FILE environment variable.
+func ParseFlags() Flags {
+	var f Flags
+	flag.StringVar(&f.Path, "config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flag.BoolVar(&f.Check, "check-config", false, "validate the configuration and exit")
+	flag.Parse()
+	return f
+}
+
+// Load sets the fields of cfg, a pointer to a struct, from the YAML file at
+// path and then from the environment, so that environment variables take
+// precedence over the file. Without a path only the environment is used.
+// Fields with a required env tag must be set by either source.
+func Load(cfg any, path string) error {
+	if path != "" {
+		if err := decodeFile(cfg, path); err != nil {
+			return err
+		}
+	}
+
+	err := envstruct.Load(cfg)
+	if err != nil && !strings.HasPrefix(err.Error(), "missing required environment variables") {
+		return envError(cfg, err)
+	}
+
+	return checkRequired(cfg)
+}
+
+// NotifyReload calls reload every time the process receives SIGHUP.
+func NotifyReload(reload func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			reload()
+		}
+	}()
+}
+
+// decodeFile decodes the file strictly so that misspelled keys are
+// reported instead of being ignored.
+func decodeFile(cfg any, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// field is a struct field with an env tag.
+type field struct {
+	env      string
+	key      string
+	required bool
+	value    reflect.Value
+}
+
+func (f field) String() string {
+	return fmt.Sprintf("%s (%s)", f.env, f.key)
+}
+
+func fields(v reflect.Value, prefix string) []field {
+	var fs []field
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		key := yamlKey(sf)
+		if key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		env := sf.Tag.Get("env")
+		if env == "" {
+			if sf.Type.Kind() == reflect.Struct {
+				fs = append(fs, fields(v.Field(i), key)...)
+			}
+			continue
+		}
+
+		props := strings.Split(env, ",")
+		f := field{env: strings.TrimSpace(props[0]), key: key, value: v.Field(i)}
+		for _, p := range props[1:] {
+			if strings.TrimSpace(p) == "required" {
+				f.required = true
+			}
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func yamlKey(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+func checkRequired(cfg any) error {
+	var missing []string
+	for _, f := range fields(reflect.ValueOf(cfg).Elem(), "") {
+		if f.required && isEmpty(f.value) {
+			missing = append(missing, f.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// envError names the environment variable envstruct failed to parse by
+// loading every set variable on its own.
+func envError(cfg any, err error) error {
+	for _, f := range fields(reflect.ValueOf(cfg).Elem(), "") {
+		if os.Getenv(f.env) == "" {
+			continue
+		}
+
+		probe := reflect.New(reflect.StructOf([]reflect.StructField{{
+			Name: "Value",
+			Type: f.value.Type(),
+			Tag:  reflect.StructTag(fmt.Sprintf(`env:"%s"`, f.env)),
+		}}))
+		if perr := envstruct.Load(probe.Interface()); perr != nil {
+			return fmt.Errorf("invalid value for %s: %s", f, perr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package configfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/configfile"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type testConfig struct {
+	Server serverConfig      `yaml:"server"`
+	Hosts  []string          `env:"TEST_HOSTS, required" yaml:"hosts"`
+	Tags   map[string]string `env:"TEST_TAGS" yaml:"tags"`
+	TTL    time.Duration     `env:"TEST_TTL" yaml:"ttl"`
+}
+
+type serverConfig struct {
+	Port     uint16 `env:"TEST_PORT, required" yaml:"port"`
+	CertFile string `env:"TEST_CERT_FILE" yaml:"cert_file"`
+}
+
+var _ = Describe("Load", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	var writeFile = func(content string) string {
+		path := filepath.Join(dir, "config.yml")
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("loads the file", func() {
+		path := writeFile("server:\n  port: 8080\n  cert_file: /cert\nhosts: [a, b]\ntags:\n  env: prod\nttl: 5m\n")
+
+		cfg := testConfig{TTL: time.Minute}
+		Expect(configfile.Load(&cfg, path)).To(Succeed())
+		Expect(cfg).To(Equal(testConfig{
+			Server: serverConfig{Port: 8080, CertFile: "/cert"},
+			Hosts:  []string{"a", "b"},
+			Tags:   map[string]string{"env": "prod"},
+			TTL:    5 * time.Minute,
+		}))
+	})
+
+	It("keeps defaults that are not in the file", func() {
+		path := writeFile("server:\n  port: 8080\nhosts: [a]\n")
+
+		cfg := testConfig{TTL: time.Minute}
+		Expect(configfile.Load(&cfg, path)).To(Succeed())
+		Expect(cfg.TTL).To(Equal(time.Minute))
+	})
+
+	It("lets environment variables override the file", func() {
+		GinkgoT().Setenv("TEST_PORT", "9090")
+		GinkgoT().Setenv("TEST_TAGS", "env:dev")
+		path := writeFile("server:\n  port: 8080\nhosts: [a]\ntags:\n  env: prod\n")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, path)).To(Succeed())
+		Expect(cfg.Server.Port).To(Equal(uint16(9090)))
+		Expect(cfg.Tags).To(Equal(map[string]string{"env": "dev"}))
+		Expect(cfg.Hosts).To(Equal([]string{"a"}))
+	})
+
+	It("loads only the environment without a file", func() {
+		GinkgoT().Setenv("TEST_PORT", "9090")
+		GinkgoT().Setenv("TEST_HOSTS", "a,b")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, "")).To(Succeed())
+		Expect(cfg.Server.Port).To(Equal(uint16(9090)))
+		Expect(cfg.Hosts).To(Equal([]string{"a", "b"}))
+	})
+
+	It("names required settings that are missing from both sources", func() {
+		path := writeFile("hosts: []\n")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, path)).To(MatchError(
+			"missing required settings: TEST_PORT (server.port), TEST_HOSTS (hosts)",
+		))
+	})
+
+	It("reports unknown keys with their line", func() {
+		path := writeFile("server:\n  port: 8080\n  cert: /cert\n")
+
+		var cfg testConfig
+		err := configfile.Load(&cfg, path)
+		Expect(err).To(MatchError(ContainSubstring(path)))
+		Expect(err).To(MatchError(ContainSubstring("line 3: field cert not found")))
+	})
+
+	It("reports values of the wrong type with their line", func() {
+		path := writeFile("server:\n  port: 8080\nhosts: [a]\nttl: soon\n")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, path)).To(MatchError(ContainSubstring("line 4")))
+	})
+
+	It("names environment variables with invalid values", func() {
+		GinkgoT().Setenv("TEST_TTL", "soon")
+		path := writeFile("server:\n  port: 8080\nhosts: [a]\n")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, path)).To(MatchError(ContainSubstring("invalid value for TEST_TTL (ttl)")))
+	})
+
+	It("returns an error if the file cannot be read", func() {
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, filepath.Join(dir, "missing.yml"))).To(MatchError(ContainSubstring("unable to read config file")))
+	})
+
+	It("accepts an empty file", func() {
+		GinkgoT().Setenv("TEST_PORT", "9090")
+		GinkgoT().Setenv("TEST_HOSTS", "a")
+		path := writeFile("")
+
+		var cfg testConfig
+		Expect(configfile.Load(&cfg, path)).To(Succeed())
+	})
+})
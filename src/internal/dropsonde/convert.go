@@ -0,0 +1,94 @@
+package dropsonde
+
+import (
+	"errors"
+	"strconv"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+)
+
+// ErrUnsupportedEvent is returned for dropsonde events that are not metrics,
+// such as log messages and HTTP start stop events.
+var ErrUnsupportedEvent = errors.New("unsupported dropsonde event type")
+
+// ToV2 decodes a marshalled dropsonde envelope and converts it to a
+// loggregator v2 envelope the way the loggregator agent does. The origin is
+// used as source id unless a source_id tag is present. Container metrics use
+// the application id as source id and the instance index as instance id.
+func ToV2(data []byte) (*loggregator_v2.Envelope, error) {
+	e, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	v2e := &loggregator_v2.Envelope{
+		Timestamp:  e.timestamp,
+		SourceId:   e.origin,
+		InstanceId: e.tags["instance_id"],
+		Tags:       make(map[string]string, len(e.tags)+6),
+	}
+	if sourceID, ok := e.tags["source_id"]; ok {
+		v2e.SourceId = sourceID
+	}
+
+	for k, v := range e.tags {
+		v2e.Tags[k] = v
+	}
+	delete(v2e.Tags, "source_id")
+	delete(v2e.Tags, "instance_id")
+	v2e.Tags["origin"] = e.origin
+	for k, v := range map[string]string{
+		"deployment": e.deployment,
+		"job":        e.job,
+		"index":      e.index,
+		"ip":         e.ip,
+	} {
+		if v != "" {
+			v2e.Tags[k] = v
+		}
+	}
+
+	switch {
+	case e.eventType == valueMetricType && e.valueMetric != nil:
+		v2e.Tags["__v1_type"] = "ValueMetric"
+		v2e.Message = &loggregator_v2.Envelope_Gauge{
+			Gauge: &loggregator_v2.Gauge{
+				Metrics: map[string]*loggregator_v2.GaugeValue{
+					e.valueMetric.name: {
+						Unit:  e.valueMetric.unit,
+						Value: e.valueMetric.value,
+					},
+				},
+			},
+		}
+	case e.eventType == counterEventType && e.counterEvent != nil:
+		v2e.Tags["__v1_type"] = "CounterEvent"
+		v2e.Message = &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{
+				Name:  e.counterEvent.name,
+				Delta: e.counterEvent.delta,
+				Total: e.counterEvent.total,
+			},
+		}
+	case e.eventType == containerMetricType && e.containerMetric != nil:
+		m := e.containerMetric
+		v2e.Tags["__v1_type"] = "ContainerMetric"
+		v2e.SourceId = m.applicationID
+		v2e.InstanceId = strconv.Itoa(int(m.instanceIndex))
+		v2e.Message = &loggregator_v2.Envelope_Gauge{
+			Gauge: &loggregator_v2.Gauge{
+				Metrics: map[string]*loggregator_v2.GaugeValue{
+					"cpu":          {Unit: "percentage", Value: m.cpuPercentage},
+					"memory":       {Unit: "bytes", Value: float64(m.memoryBytes)},
+					"disk":         {Unit: "bytes", Value: float64(m.diskBytes)},
+					"memory_quota": {Unit: "bytes", Value: float64(m.memoryBytesQuota)},
+					"disk_quota":   {Unit: "bytes", Value: float64(m.diskBytesQuota)},
+				},
+			},
+		}
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+
+	return v2e, nil
+}
@@ -0,0 +1,98 @@
+package dropsonde_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metrics-discovery/internal/dropsonde"
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("ToV2", func() {
+	It("converts value metrics to gauges", func() {
+		env, err := dropsonde.ToV2(testhelpers.DropsondeEnvelope{
+			Origin:     "some-origin",
+			Deployment: "cf",
+			Job:        "router",
+			Tags:       map[string]string{"a": "1"},
+		}.ValueMetric("latency", 1.5, "ms").Marshal())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(proto.Equal(env, &loggregator_v2.Envelope{
+			Timestamp: 1000,
+			SourceId:  "some-origin",
+			Tags: map[string]string{
+				"a":          "1",
+				"origin":     "some-origin",
+				"deployment": "cf",
+				"job":        "router",
+				"__v1_type":  "ValueMetric",
+			},
+			Message: &loggregator_v2.Envelope_Gauge{
+				Gauge: &loggregator_v2.Gauge{
+					Metrics: map[string]*loggregator_v2.GaugeValue{
+						"latency": {Unit: "ms", Value: 1.5},
+					},
+				},
+			},
+		})).To(BeTrue(), env.String())
+	})
+
+	It("converts counter events to counters", func() {
+		env, err := dropsonde.ToV2(testhelpers.DropsondeEnvelope{
+			Origin: "some-origin",
+		}.CounterEvent("requests", 2, 10).Marshal())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(env.GetSourceId()).To(Equal("some-origin"))
+		Expect(env.GetTags()).To(HaveKeyWithValue("__v1_type", "CounterEvent"))
+		Expect(env.GetCounter().GetName()).To(Equal("requests"))
+		Expect(env.GetCounter().GetDelta()).To(Equal(uint64(2)))
+		Expect(env.GetCounter().GetTotal()).To(Equal(uint64(10)))
+	})
+
+	It("converts container metrics to gauges of the application", func() {
+		env, err := dropsonde.ToV2(testhelpers.DropsondeEnvelope{
+			Origin: "rep",
+		}.ContainerMetric("app-guid", 3, 12.5, 1024, 2048).Marshal())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(env.GetSourceId()).To(Equal("app-guid"))
+		Expect(env.GetInstanceId()).To(Equal("3"))
+		Expect(env.GetTags()).To(HaveKeyWithValue("origin", "rep"))
+		Expect(env.GetGauge().GetMetrics()).To(And(
+			HaveKeyWithValue("cpu", HaveField("Value", 12.5)),
+			HaveKeyWithValue("memory", HaveField("Value", 1024.0)),
+			HaveKeyWithValue("disk", HaveField("Value", 2048.0)),
+		))
+	})
+
+	It("uses the source_id and instance_id tags if present", func() {
+		env, err := dropsonde.ToV2(testhelpers.DropsondeEnvelope{
+			Origin: "some-origin",
+			Tags:   map[string]string{"source_id": "some-source-id", "instance_id": "some-instance-id"},
+		}.CounterEvent("requests", 1, 1).Marshal())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(env.GetSourceId()).To(Equal("some-source-id"))
+		Expect(env.GetInstanceId()).To(Equal("some-instance-id"))
+		Expect(env.GetTags()).ToNot(HaveKey("source_id"))
+		Expect(env.GetTags()).ToNot(HaveKey("instance_id"))
+	})
+
+	It("returns an error for events that are not metrics", func() {
+		_, err := dropsonde.ToV2(testhelpers.DropsondeEnvelope{
+			Origin: "some-origin",
+		}.LogMessage("hello").Marshal())
+		Expect(err).To(MatchError(dropsonde.ErrUnsupportedEvent))
+	})
+
+	It("returns an error for invalid envelopes", func() {
+		_, err := dropsonde.ToV2([]byte("not protobuf"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = dropsonde.ToV2(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
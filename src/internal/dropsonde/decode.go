@@ -0,0 +1,235 @@
+package dropsonde
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Event types of the dropsonde events.proto that are converted.
+const (
+	valueMetricType     = 6
+	counterEventType    = 7
+	containerMetricType = 9
+)
+
+// envelope holds the fields of a dropsonde events.Envelope that are
+// needed to convert metrics.
+type envelope struct {
+	origin     string
+	eventType  uint64
+	timestamp  int64
+	deployment string
+	job        string
+	index      string
+	ip         string
+	tags       map[string]string
+
+	valueMetric     *valueMetric
+	counterEvent    *counterEvent
+	containerMetric *containerMetric
+}
+
+type valueMetric struct {
+	name  string
+	value float64
+	unit  string
+}
+
+type counterEvent struct {
+	name  string
+	delta uint64
+	total uint64
+}
+
+type containerMetric struct {
+	applicationID    string
+	instanceIndex    int32
+	cpuPercentage    float64
+	memoryBytes      uint64
+	diskBytes        uint64
+	memoryBytesQuota uint64
+	diskBytesQuota   uint64
+}
+
+// field is a single decoded protobuf field. Varint and fixed values are
+// stored in value, length delimited values in bytes.
+type field struct {
+	num   protowire.Number
+	value uint64
+	bytes []byte
+}
+
+func (f field) string() string {
+	return string(f.bytes)
+}
+
+func (f field) double() float64 {
+	return math.Float64frombits(f.value)
+}
+
+func decodeEnvelope(b []byte) (*envelope, error) {
+	e := &envelope{
+		tags: map[string]string{},
+	}
+
+	var hasOrigin, hasEventType bool
+	err := decodeFields(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			e.origin = f.string()
+			hasOrigin = true
+		case 2:
+			e.eventType = f.value
+			hasEventType = true
+		case 6:
+			e.timestamp = int64(f.value)
+		case 9:
+			e.valueMetric, err = decodeValueMetric(f.bytes)
+		case 10:
+			e.counterEvent, err = decodeCounterEvent(f.bytes)
+		case 12:
+			e.containerMetric, err = decodeContainerMetric(f.bytes)
+		case 13:
+			e.deployment = f.string()
+		case 14:
+			e.job = f.string()
+		case 15:
+			e.index = f.string()
+		case 16:
+			e.ip = f.string()
+		case 17:
+			err = decodeTag(f.bytes, e.tags)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasOrigin || !hasEventType {
+		return nil, errors.New("envelope is missing origin or event type")
+	}
+
+	return e, nil
+}
+
+func decodeValueMetric(b []byte) (*valueMetric, error) {
+	m := &valueMetric{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.name = f.string()
+		case 2:
+			m.value = f.double()
+		case 3:
+			m.unit = f.string()
+		}
+		return nil
+	})
+	return m, err
+}
+
+func decodeCounterEvent(b []byte) (*counterEvent, error) {
+	m := &counterEvent{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.name = f.string()
+		case 2:
+			m.delta = f.value
+		case 3:
+			m.total = f.value
+		}
+		return nil
+	})
+	return m, err
+}
+
+func decodeContainerMetric(b []byte) (*containerMetric, error) {
+	m := &containerMetric{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.applicationID = f.string()
+		case 2:
+			m.instanceIndex = int32(f.value)
+		case 3:
+			m.cpuPercentage = f.double()
+		case 4:
+			m.memoryBytes = f.value
+		case 5:
+			m.diskBytes = f.value
+		case 6:
+			m.memoryBytesQuota = f.value
+		case 7:
+			m.diskBytesQuota = f.value
+		}
+		return nil
+	})
+	return m, err
+}
+
+func decodeTag(b []byte, tags map[string]string) error {
+	var key, value string
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			key = f.string()
+		case 2:
+			value = f.string()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tags[key] = value
+	return nil
+}
+
+// decodeFields calls fn for every field in the protobuf message b. Groups
+// are skipped.
+func decodeFields(b []byte, fn func(field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid field tag: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.value = uint64(v)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				b = b[n:]
+				continue
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid value for field %d: %s", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
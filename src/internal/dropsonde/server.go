@@ -0,0 +1,109 @@
+package dropsonde
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+)
+
+const maxPacketSize = 65535
+
+// DataSetter receives the envelopes converted from dropsonde envelopes.
+type DataSetter interface {
+	Set(e *loggregator_v2.Envelope)
+}
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+// Server receives dropsonde envelopes over UDP like the v1 API of the
+// loggregator agent. ValueMetric, CounterEvent and ContainerMetric events
+// are converted to v2 envelopes, all other events are ignored.
+type Server struct {
+	addr   string
+	setter DataSetter
+	log    *slog.Logger
+
+	ingress metrics.Counter
+	invalid metrics.Counter
+	ignored metrics.Counter
+
+	conn net.PacketConn
+}
+
+// NewServer returns a Server that listens on the given UDP address.
+func NewServer(addr string, setter DataSetter, m metricsRegistry, log *slog.Logger) *Server {
+	return &Server{
+		addr:   addr,
+		setter: setter,
+		log:    log,
+		ingress: m.NewCounter(
+			"dropsonde_ingress",
+			"Total number of dropsonde envelopes ingressed by the agent.",
+		),
+		invalid: m.NewCounter(
+			"dropsonde_invalid_envelopes",
+			"Total number of dropsonde envelopes that could not be decoded.",
+		),
+		ignored: m.NewCounter(
+			"dropsonde_ignored_envelopes",
+			"Total number of dropsonde envelopes ignored because they are not metrics.",
+		),
+	}
+}
+
+// Start binds the listener and handles envelopes in the background.
+func (s *Server) Start() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	go s.serve()
+	return nil
+}
+
+// Addr returns the address of the listener once started.
+func (s *Server) Addr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Stop closes the listener.
+func (s *Server) Stop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Warn("failed to read dropsonde packet", "error", err)
+			continue
+		}
+
+		env, err := ToV2(buf[:n])
+		switch {
+		case errors.Is(err, ErrUnsupportedEvent):
+			s.ignored.Add(1)
+		case err != nil:
+			s.invalid.Add(1)
+		default:
+			s.ingress.Add(1)
+			s.setter.Set(env)
+		}
+	}
+}
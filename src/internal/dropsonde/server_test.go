@@ -0,0 +1,83 @@
+package dropsonde_test
+
+import (
+	"log/slog"
+	"net"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/dropsonde"
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		setter  *spySetter
+		metrics *metrichelpers.SpyMetricsRegistry
+		server  *dropsonde.Server
+	)
+
+	BeforeEach(func() {
+		setter = newSpySetter()
+		metrics = metrichelpers.NewMetricsRegistry()
+		server = dropsonde.NewServer("127.0.0.1:0", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+		Expect(server.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	var send = func(packet []byte) {
+		conn, err := net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write(packet)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("converts dropsonde envelopes", func() {
+		send(testhelpers.DropsondeEnvelope{Origin: "some-origin"}.ValueMetric("latency", 1.5, "ms").Marshal())
+
+		var env *loggregator_v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&env))
+		Expect(env.GetSourceId()).To(Equal("some-origin"))
+		Expect(env.GetGauge().GetMetrics()).To(HaveKey("latency"))
+		Expect(metrics.GetMetricValue("dropsonde_ingress", nil)).To(Equal(1.0))
+	})
+
+	It("ignores events that are not metrics", func() {
+		send(testhelpers.DropsondeEnvelope{Origin: "some-origin"}.LogMessage("hello").Marshal())
+
+		Eventually(func() float64 {
+			return metrics.GetMetricValue("dropsonde_ignored_envelopes", nil)
+		}).Should(Equal(1.0))
+		Consistently(setter.envelopes).ShouldNot(Receive())
+	})
+
+	It("counts invalid envelopes", func() {
+		send([]byte("not protobuf"))
+
+		Eventually(func() float64 {
+			return metrics.GetMetricValue("dropsonde_invalid_envelopes", nil)
+		}).Should(Equal(1.0))
+		Consistently(setter.envelopes).ShouldNot(Receive())
+	})
+})
+
+type spySetter struct {
+	envelopes chan *loggregator_v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{
+		envelopes: make(chan *loggregator_v2.Envelope, 100),
+	}
+}
+
+func (s *spySetter) Set(e *loggregator_v2.Envelope) {
+	s.envelopes <- e
+}
@@ -0,0 +1,248 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/metrics-discovery/internal/pattern"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// defaultRule is the rule label of metrics denied because they match no
+// rule while an allow rule exists.
+const defaultRule = "default"
+
+// Rule matches envelopes by source id, envelope type, metric name and tags.
+// Source ids, metric names and tag values are globs unless they are wrapped
+// in slashes, in which case they are regular expressions. An empty tag value
+// only requires the tag to be present. Empty fields match every envelope.
+type Rule struct {
+	Name      string            `json:"name" yaml:"name"`
+	Action    string            `json:"action" yaml:"action"`
+	SourceIDs []string          `json:"source_ids" yaml:"source_ids"`
+	Types     []string          `json:"types" yaml:"types"`
+	Metrics   []string          `json:"metrics" yaml:"metrics"`
+	Tags      map[string]string `json:"tags" yaml:"tags"`
+}
+
+// Validate returns an error describing the first problem with the rule.
+func (r Rule) Validate() error {
+	_, err := compileRule(r)
+	return err
+}
+
+// Filter drops envelopes and gauge metrics according to an ordered list of
+// rules. The first rule that matches decides whether a metric is kept. When
+// no rule matches, the metric is kept unless an allow rule exists.
+type Filter struct {
+	rules         []*rule
+	defaultAllow  bool
+	defaultDenied metrics.Counter
+}
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+type rule struct {
+	name      string
+	allow     bool
+	sourceIDs []pattern.Matcher
+	types     map[string]struct{}
+	metrics   []pattern.Matcher
+	tags      map[string]pattern.Matcher
+	filtered  metrics.Counter
+}
+
+func New(rules []Rule, m metricsRegistry) (*Filter, error) {
+	f := &Filter{
+		defaultAllow: true,
+	}
+
+	for _, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled.filtered = newFilteredCounter(m, compiled.name)
+		f.rules = append(f.rules, compiled)
+
+		if compiled.allow {
+			f.defaultAllow = false
+		}
+	}
+
+	if !f.defaultAllow {
+		f.defaultDenied = newFilteredCounter(m, defaultRule)
+	}
+
+	return f, nil
+}
+
+func newFilteredCounter(m metricsRegistry, rule string) metrics.Counter {
+	return m.NewCounter(
+		"filtered_envelopes",
+		"Total number of envelopes or gauge metrics denied by a filter rule.",
+		metrics.WithMetricLabels(map[string]string{"rule": rule}),
+	)
+}
+
+// Keep returns false if the envelope should be dropped. Gauge metrics that
+// are denied are removed from the envelope, which is dropped once no gauge
+// metrics are left.
+func (f *Filter) Keep(env *loggregator_v2.Envelope) bool {
+	if len(f.rules) == 0 {
+		return true
+	}
+
+	gauge := env.GetGauge()
+	if gauge == nil {
+		return f.allowed(env, metricName(env))
+	}
+
+	for name := range gauge.GetMetrics() {
+		if !f.allowed(env, name) {
+			delete(gauge.Metrics, name)
+		}
+	}
+
+	return len(gauge.GetMetrics()) > 0
+}
+
+func (f *Filter) allowed(env *loggregator_v2.Envelope, name string) bool {
+	for _, r := range f.rules {
+		if !r.matches(env, name) {
+			continue
+		}
+
+		if !r.allow {
+			r.filtered.Add(1)
+		}
+		return r.allow
+	}
+
+	if !f.defaultAllow {
+		f.defaultDenied.Add(1)
+	}
+	return f.defaultAllow
+}
+
+func (r *rule) matches(env *loggregator_v2.Envelope, name string) bool {
+	if len(r.types) > 0 {
+		if _, ok := r.types[envelopeType(env)]; !ok {
+			return false
+		}
+	}
+
+	if !matchAny(r.sourceIDs, env.GetSourceId()) || !matchAny(r.metrics, name) {
+		return false
+	}
+
+	for tag, valueMatcher := range r.tags {
+		value, ok := env.GetTags()[tag]
+		if !ok || (valueMatcher != nil && !valueMatcher(value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchAny is like pattern.MatchAny but also matches if there are no
+// matchers.
+func matchAny(matchers []pattern.Matcher, value string) bool {
+	return len(matchers) == 0 || pattern.MatchAny(matchers, value)
+}
+
+func compileRule(r Rule) (*rule, error) {
+	compiled := &rule{
+		name:  r.Name,
+		types: map[string]struct{}{},
+		tags:  map[string]pattern.Matcher{},
+	}
+
+	if r.Name == "" {
+		return nil, errors.New("filter rule is missing a name")
+	}
+	if r.Name == defaultRule {
+		return nil, fmt.Errorf("filter rule name %q is reserved for envelopes denied by default", defaultRule)
+	}
+
+	switch r.Action {
+	case Allow:
+		compiled.allow = true
+	case Deny:
+	default:
+		return nil, fmt.Errorf("filter rule %q has unknown action %q", r.Name, r.Action)
+	}
+
+	for _, t := range r.Types {
+		switch t {
+		case "counter", "gauge", "timer", "log", "event":
+			compiled.types[t] = struct{}{}
+		default:
+			return nil, fmt.Errorf("filter rule %q has unknown envelope type %q", r.Name, t)
+		}
+	}
+
+	var err error
+	compiled.sourceIDs, err = pattern.CompileAll(r.SourceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("filter rule %q has invalid source id: %s", r.Name, err)
+	}
+
+	compiled.metrics, err = pattern.CompileAll(r.Metrics)
+	if err != nil {
+		return nil, fmt.Errorf("filter rule %q has invalid metric: %s", r.Name, err)
+	}
+
+	for tag, value := range r.Tags {
+		if value == "" {
+			compiled.tags[tag] = nil
+			continue
+		}
+
+		m, err := pattern.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("filter rule %q has invalid value for tag %s: %s", r.Name, tag, err)
+		}
+		compiled.tags[tag] = m
+	}
+
+	return compiled, nil
+}
+
+func envelopeType(env *loggregator_v2.Envelope) string {
+	switch env.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return "counter"
+	case *loggregator_v2.Envelope_Gauge:
+		return "gauge"
+	case *loggregator_v2.Envelope_Timer:
+		return "timer"
+	case *loggregator_v2.Envelope_Log:
+		return "log"
+	case *loggregator_v2.Envelope_Event:
+		return "event"
+	default:
+		return ""
+	}
+}
+
+func metricName(env *loggregator_v2.Envelope) string {
+	switch env.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return env.GetCounter().GetName()
+	case *loggregator_v2.Envelope_Timer:
+		return env.GetTimer().GetName()
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,138 @@
+package filter_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/filter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var (
+		spyMetrics *testhelpers.SpyMetricsRegistry
+	)
+
+	BeforeEach(func() {
+		spyMetrics = testhelpers.NewMetricsRegistry()
+	})
+
+	var newFilter = func(rules ...filter.Rule) *filter.Filter {
+		f, err := filter.New(rules, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+		return f
+	}
+
+	It("keeps every envelope without rules", func() {
+		f := newFilter()
+		Expect(f.Keep(counter("source", "requests", nil))).To(BeTrue())
+	})
+
+	It("denies envelopes by source id", func() {
+		f := newFilter(filter.Rule{Name: "no-apps", Action: filter.Deny, SourceIDs: []string{"app-*"}})
+
+		Expect(f.Keep(counter("app-1", "requests", nil))).To(BeFalse())
+		Expect(f.Keep(counter("gorouter", "requests", nil))).To(BeTrue())
+		Expect(spyMetrics.GetMetricValue("filtered_envelopes", map[string]string{"rule": "no-apps"})).To(Equal(1.0))
+	})
+
+	It("denies envelopes by type", func() {
+		f := newFilter(filter.Rule{Name: "no-timers", Action: filter.Deny, Types: []string{"timer"}})
+
+		Expect(f.Keep(timer("source", "http"))).To(BeFalse())
+		Expect(f.Keep(counter("source", "requests", nil))).To(BeTrue())
+	})
+
+	It("denies metrics by name using globs and regular expressions", func() {
+		f := newFilter(
+			filter.Rule{Name: "glob", Action: filter.Deny, Metrics: []string{"debug.*"}},
+			filter.Rule{Name: "regex", Action: filter.Deny, Metrics: []string{"/^tmp_[0-9]+$/"}},
+		)
+
+		Expect(f.Keep(counter("source", "debug.requests", nil))).To(BeFalse())
+		Expect(f.Keep(counter("source", "tmp_123", nil))).To(BeFalse())
+		Expect(f.Keep(counter("source", "tmp_abc", nil))).To(BeTrue())
+	})
+
+	It("removes denied metrics from gauges", func() {
+		f := newFilter(filter.Rule{Name: "no-disk", Action: filter.Deny, Metrics: []string{"disk"}})
+
+		env := gauge("source", "disk", "cpu")
+		Expect(f.Keep(env)).To(BeTrue())
+		Expect(env.GetGauge().GetMetrics()).To(HaveLen(1))
+		Expect(env.GetGauge().GetMetrics()).To(HaveKey("cpu"))
+
+		Expect(f.Keep(gauge("source", "disk"))).To(BeFalse())
+	})
+
+	It("matches tag presence and values", func() {
+		f := newFilter(
+			filter.Rule{Name: "has-tag", Action: filter.Deny, Tags: map[string]string{"noisy": ""}},
+			filter.Rule{Name: "tag-value", Action: filter.Deny, Tags: map[string]string{"deployment": "cf-*"}},
+		)
+
+		Expect(f.Keep(counter("source", "requests", map[string]string{"noisy": "true"}))).To(BeFalse())
+		Expect(f.Keep(counter("source", "requests", map[string]string{"deployment": "cf-abc"}))).To(BeFalse())
+		Expect(f.Keep(counter("source", "requests", map[string]string{"deployment": "other"}))).To(BeTrue())
+	})
+
+	It("only keeps allowed envelopes when an allow rule exists", func() {
+		f := newFilter(
+			filter.Rule{Name: "deny-debug", Action: filter.Deny, Metrics: []string{"debug_*"}},
+			filter.Rule{Name: "router", Action: filter.Allow, SourceIDs: []string{"gorouter"}},
+		)
+
+		Expect(f.Keep(counter("gorouter", "requests", nil))).To(BeTrue())
+		Expect(f.Keep(counter("gorouter", "debug_requests", nil))).To(BeFalse())
+		Expect(f.Keep(counter("uaa", "requests", nil))).To(BeFalse())
+		Expect(spyMetrics.GetMetricValue("filtered_envelopes", map[string]string{"rule": "deny-debug"})).To(Equal(1.0))
+		Expect(spyMetrics.GetMetricValue("filtered_envelopes", map[string]string{"rule": "default"})).To(Equal(1.0))
+	})
+
+	DescribeTable("invalid rules",
+		func(rule filter.Rule, errMatcher string) {
+			_, err := filter.New([]filter.Rule{rule}, spyMetrics)
+			Expect(err).To(MatchError(ContainSubstring(errMatcher)))
+			Expect(rule.Validate()).To(MatchError(ContainSubstring(errMatcher)))
+		},
+		Entry("missing name", filter.Rule{Action: filter.Deny}, "missing a name"),
+		Entry("reserved name", filter.Rule{Name: "default", Action: filter.Deny}, `"default" is reserved`),
+		Entry("unknown action", filter.Rule{Name: "r", Action: "drop"}, `unknown action "drop"`),
+		Entry("unknown type", filter.Rule{Name: "r", Action: filter.Deny, Types: []string{"histogram"}}, `unknown envelope type "histogram"`),
+		Entry("invalid regex", filter.Rule{Name: "r", Action: filter.Deny, Metrics: []string{"/(/"}}, "invalid metric"),
+		Entry("invalid glob", filter.Rule{Name: "r", Action: filter.Deny, SourceIDs: []string{"["}}, "invalid source id"),
+	)
+})
+
+func counter(sourceID, name string, tags map[string]string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId: sourceID,
+		Tags:     tags,
+		Message: &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{Name: name, Total: 1},
+		},
+	}
+}
+
+func timer(sourceID, name string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId: sourceID,
+		Message: &loggregator_v2.Envelope_Timer{
+			Timer: &loggregator_v2.Timer{Name: name},
+		},
+	}
+}
+
+func gauge(sourceID string, names ...string) *loggregator_v2.Envelope {
+	metrics := map[string]*loggregator_v2.GaugeValue{}
+	for _, name := range names {
+		metrics[name] = &loggregator_v2.GaugeValue{Value: 1}
+	}
+
+	return &loggregator_v2.Envelope{
+		SourceId: sourceID,
+		Message: &loggregator_v2.Envelope_Gauge{
+			Gauge: &loggregator_v2.Gauge{Metrics: metrics},
+		},
+	}
+}
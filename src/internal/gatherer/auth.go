@@ -0,0 +1,112 @@
+package gatherer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+)
+
+// authenticator adds credentials to scrape requests.
+type authenticator interface {
+	authenticate(ctx context.Context, req *http.Request) error
+}
+
+// newAuthenticator returns the authenticator configured for the target or
+// nil if the target does not require authentication.
+func newAuthenticator(sc scrapeconfig.Config) authenticator {
+	switch {
+	case sc.BearerTokenFile != "":
+		return &bearerTokenAuth{token: newSecretFile(sc.BearerTokenFile)}
+	case sc.BasicAuth != nil:
+		return &basicAuth{
+			username: sc.BasicAuth.Username,
+			password: newSecretFile(sc.BasicAuth.PasswordFile),
+		}
+	case sc.OAuth2 != nil:
+		return newOAuth2Auth(*sc.OAuth2)
+	}
+	return nil
+}
+
+type bearerTokenAuth struct {
+	token *secretFile
+}
+
+func (a *bearerTokenAuth) authenticate(_ context.Context, req *http.Request) error {
+	token, _, err := a.token.read()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type basicAuth struct {
+	username string
+	password *secretFile
+}
+
+func (a *basicAuth) authenticate(_ context.Context, req *http.Request) error {
+	var password string
+	if a.password != nil {
+		var err error
+		if password, _, err = a.password.read(); err != nil {
+			return err
+		}
+	}
+
+	req.SetBasicAuth(a.username, password)
+	return nil
+}
+
+// secretFile holds the content of a file containing a secret. The file is
+// read again when its modification time or size changes, so secrets can be
+// rotated without restarting the agent.
+type secretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	content string
+}
+
+func newSecretFile(path string) *secretFile {
+	if path == "" {
+		return nil
+	}
+	return &secretFile{path: path}
+}
+
+// read returns the content of the file without surrounding whitespace and
+// whether it changed since the last read.
+func (f *secretFile) read() (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to read secret: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.content, false, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to read secret: %w", err)
+	}
+
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.content = strings.TrimSpace(string(content))
+
+	return f.content, true, nil
+}
@@ -0,0 +1,90 @@
+package gatherer_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authentication", func() {
+	var (
+		promServer   *stubPromServer
+		scrapeConfig scrapeconfig.Config
+		secretsDir   string
+	)
+
+	BeforeEach(func() {
+		promServer = newStubPromServer()
+		promServer.resp = promOutput
+		scrapeConfig = scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
+			Port:     promServer.port,
+			Scheme:   "http",
+			Path:     "metrics",
+			SourceID: "auth",
+			Headers:  map[string]string{"X-Static": "value"},
+		}}
+		secretsDir = GinkgoT().TempDir()
+	})
+
+	var newGatherer = func() *gatherer.ProxyGatherer {
+		return gatherer.NewProxyGatherer(scrapeConfig, "", "", "", metrichelpers.NewMetricsRegistry(), slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	}
+
+	var authorization = func(g *gatherer.ProxyGatherer) string {
+		_, err := g.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		var header map[string][]string
+		Expect(promServer.requestHeaders).To(Receive(&header))
+		Expect(header).To(HaveKeyWithValue("X-Static", []string{"value"}))
+		if len(header["Authorization"]) == 0 {
+			return ""
+		}
+		return header["Authorization"][0]
+	}
+
+	It("sends the token of the bearer token file", func() {
+		scrapeConfig.BearerTokenFile = writeSecret(secretsDir, "token", "first-token\n", time.Now())
+		g := newGatherer()
+
+		Expect(authorization(g)).To(Equal("Bearer first-token"))
+
+		writeSecret(secretsDir, "token", "second-token\n", time.Now().Add(time.Second))
+		Expect(authorization(g)).To(Equal("Bearer second-token"))
+	})
+
+	It("sends basic auth credentials", func() {
+		scrapeConfig.BasicAuth = &scrapeconfig.BasicAuth{
+			Username:     "admin",
+			PasswordFile: writeSecret(secretsDir, "password", "secret", time.Now()),
+		}
+
+		Expect(authorization(newGatherer())).To(Equal("Basic YWRtaW46c2VjcmV0"))
+	})
+
+	It("fails the scrape when the secret cannot be read", func() {
+		scrapeConfig.BearerTokenFile = filepath.Join(secretsDir, "missing")
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("unable to read secret")))
+	})
+
+	It("does not send credentials without authentication", func() {
+		Expect(authorization(newGatherer())).To(BeEmpty())
+	})
+})
+
+func writeSecret(dir, name, content string, modTime time.Time) string {
+	path := filepath.Join(dir, name)
+	Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+	return path
+}
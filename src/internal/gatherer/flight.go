@@ -0,0 +1,78 @@
+package gatherer
+
+import (
+	"errors"
+	"sync"
+
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var errScrapePanicked = errors.New("scrape panicked")
+
+// flight collapses concurrent scrapes of a target into a single upstream
+// request.
+type flight struct {
+	mu   sync.Mutex
+	call *flightCall
+}
+
+type flightCall struct {
+	done     chan struct{}
+	joined   int
+	families []*io_prometheus_client.MetricFamily
+	err      error
+}
+
+// do runs fn unless a call is already in flight, in which case it waits for
+// and returns the result of that call. It reports whether the result of
+// another call was shared. Shared families are copied for every caller because gatherers
+// combining them modify them.
+func (f *flight) do(fn func() ([]*io_prometheus_client.MetricFamily, error)) ([]*io_prometheus_client.MetricFamily, bool, error) {
+	f.mu.Lock()
+	if c := f.call; c != nil {
+		c.joined++
+		f.mu.Unlock()
+
+		<-c.done
+		return cloneFamilies(c.families), true, c.err
+	}
+
+	c := &flightCall{done: make(chan struct{})}
+	f.call = c
+	f.mu.Unlock()
+
+	if joined := f.run(c, fn); joined > 0 {
+		return cloneFamilies(c.families), false, c.err
+	}
+	return c.families, false, c.err
+}
+
+// run calls fn and completes the call even if fn panics so that later
+// scrapes do not wait for it forever. It returns the number of callers that
+// joined the call.
+func (f *flight) run(c *flightCall, fn func() ([]*io_prometheus_client.MetricFamily, error)) (joined int) {
+	c.err = errScrapePanicked
+	defer func() {
+		f.mu.Lock()
+		f.call = nil
+		joined = c.joined
+		f.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.families, c.err = fn()
+	return joined
+}
+
+func cloneFamilies(families []*io_prometheus_client.MetricFamily) []*io_prometheus_client.MetricFamily {
+	if families == nil {
+		return nil
+	}
+
+	clones := make([]*io_prometheus_client.MetricFamily, 0, len(families))
+	for _, f := range families {
+		clones = append(clones, proto.Clone(f).(*io_prometheus_client.MetricFamily))
+	}
+	return clones
+}
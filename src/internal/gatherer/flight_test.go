@@ -0,0 +1,61 @@
+package gatherer_test
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Concurrent scrapes", func() {
+	It("share the response of a single upstream scrape", func() {
+		promServer := newStubPromServer()
+		promServer.resp = promOutput
+		promServer.delay = 200 * time.Millisecond
+		metrics := metrichelpers.NewMetricsRegistry()
+
+		g := gatherer.NewProxyGatherer(
+			scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
+				Port:     promServer.port,
+				Scheme:   "http",
+				Path:     "metrics",
+				SourceID: "shared",
+			}},
+			"", "", "",
+			metrics,
+			slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+		)
+
+		results := make([][]*io_prometheus_client.MetricFamily, 3)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				mfs, err := g.Gather()
+				Expect(err).ToNot(HaveOccurred())
+				results[i] = mfs
+			}(i)
+			time.Sleep(20 * time.Millisecond)
+		}
+		wg.Wait()
+
+		Expect(promServer.requestPaths).To(HaveLen(1))
+		Expect(metrics.GetMetricValue("proxy_scrapes_collapsed", map[string]string{
+			"scrape_source_id": "shared",
+		})).To(Equal(2.0))
+
+		for _, mfs := range results {
+			Expect(mfs).To(HaveLen(3))
+		}
+		Expect(results[0][0]).ToNot(BeIdenticalTo(results[1][0]))
+	})
+})
@@ -0,0 +1,55 @@
+package gatherer
+
+import (
+	"context"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+)
+
+// ScrapeLimiter bounds the number of upstream scrapes that run at the same
+// time across all proxy gatherers. Scrapes beyond the limit wait until a
+// scrape finishes or their timeout expires.
+type ScrapeLimiter struct {
+	slots  chan struct{}
+	queued metrics.Counter
+}
+
+// NewScrapeLimiter returns a ScrapeLimiter that allows max concurrent
+// scrapes. A max of 0 allows any number of scrapes.
+func NewScrapeLimiter(max int, m metricsRegistry) *ScrapeLimiter {
+	l := &ScrapeLimiter{
+		queued: m.NewCounter(
+			"proxy_scrapes_queued",
+			"Total scrapes of targets that waited for another scrape to finish.",
+		),
+	}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire waits for a free slot. The returned function releases it.
+func (l *ScrapeLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil || l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	l.queued.Add(1)
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *ScrapeLimiter) release() {
+	<-l.slots
+}
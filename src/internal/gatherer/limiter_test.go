@@ -0,0 +1,115 @@
+package gatherer_test
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ScrapeLimiter", func() {
+	var (
+		metrics *metrichelpers.SpyMetricsRegistry
+		limiter *gatherer.ScrapeLimiter
+	)
+
+	var newGatherer = func(sourceID string, delay time.Duration, timeout time.Duration) *gatherer.ProxyGatherer {
+		promServer := newStubPromServer()
+		promServer.resp = promOutput
+		promServer.delay = delay
+
+		return gatherer.NewProxyGatherer(
+			scrapeconfig.Config{
+				PromScraperConfig: scraper.PromScraperConfig{
+					Port:     promServer.port,
+					Scheme:   "http",
+					Path:     "metrics",
+					SourceID: sourceID,
+				},
+				ScrapeTimeout: timeout,
+			},
+			"", "", "",
+			metrics,
+			slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+			gatherer.WithScrapeLimiter(limiter),
+		)
+	}
+
+	BeforeEach(func() {
+		metrics = metrichelpers.NewMetricsRegistry()
+		limiter = gatherer.NewScrapeLimiter(1, metrics)
+	})
+
+	It("queues scrapes beyond the limit", func() {
+		first := newGatherer("first", 200*time.Millisecond, time.Second)
+		second := newGatherer("second", 0, time.Second)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer GinkgoRecover()
+			_, err := first.Gather()
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		_, err := second.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">", 100*time.Millisecond))
+		Expect(metrics.GetMetricValue("proxy_scrapes_queued", nil)).To(Equal(1.0))
+
+		wg.Wait()
+	})
+
+	It("fails queued scrapes when their timeout expires", func() {
+		first := newGatherer("first", 300*time.Millisecond, time.Second)
+		second := newGatherer("second", 0, 50*time.Millisecond)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = first.Gather()
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		_, err := second.Gather()
+		Expect(err).To(MatchError(ContainSubstring("waiting for a concurrent scrape slot")))
+		Expect(metrics.GetMetricValue("scrape_limit_exceeded", map[string]string{
+			"scrape_source_id": "second",
+			"limit":            "scrape_timeout",
+		})).To(Equal(1.0))
+
+		wg.Wait()
+	})
+
+	It("does not limit scrapes without a max", func() {
+		limiter = gatherer.NewScrapeLimiter(0, metrics)
+		first := newGatherer("first", 200*time.Millisecond, time.Second)
+		second := newGatherer("second", 0, time.Second)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = first.Gather()
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		_, err := second.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+		Expect(metrics.GetMetricValue("proxy_scrapes_queued", nil)).To(Equal(0.0))
+
+		wg.Wait()
+	})
+})
@@ -0,0 +1,117 @@
+package gatherer
+
+import (
+	"fmt"
+	"io"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+// Reasons of the scrape_limit_exceeded metric.
+const (
+	limitScrapeTimeout    = "scrape_timeout"
+	limitBodySize         = "body_size_limit"
+	limitSample           = "sample_limit"
+	limitLabel            = "label_limit"
+	limitLabelValueLength = "label_value_length_limit"
+)
+
+// maxLabelValueLengthInError truncates label values in limit errors.
+const maxLabelValueLengthInError = 64
+
+// limitError fails a scrape that exceeded one of the limits of its scrape
+// config.
+type limitError struct {
+	limit string
+	msg   string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s exceeded: %s", e.limit, e.msg)
+}
+
+// bodyLimitReader returns a limitError once more than limit bytes are read.
+type bodyLimitReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func newBodyLimitReader(r io.Reader, limit scrapeconfig.ByteSize) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &bodyLimitReader{r: r, remaining: int64(limit), limit: int64(limit)}
+}
+
+func (l *bodyLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &limitError{limit: limitBodySize, msg: fmt.Sprintf("body is larger than %d bytes", l.limit)}
+	}
+
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, &limitError{limit: limitBodySize, msg: fmt.Sprintf("body is larger than %d bytes", l.limit)}
+	}
+	return n, err
+}
+
+// checkLimits verifies the sample, label and label value length limits of
+// the scrape config. Histograms and summaries count a sample for every
+// bucket or quantile plus their sum and count, like they do in Prometheus.
+func checkLimits(sc scrapeconfig.Config, families []*io_prometheus_client.MetricFamily) error {
+	var samples int
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			samples += sampleCount(m)
+
+			if sc.LabelLimit > 0 && len(m.GetLabel()) > sc.LabelLimit {
+				return &limitError{
+					limit: limitLabel,
+					msg:   fmt.Sprintf("%s has %d labels, limit is %d", family.GetName(), len(m.GetLabel()), sc.LabelLimit),
+				}
+			}
+
+			if sc.LabelValueLengthLimit <= 0 {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if len(l.GetValue()) > sc.LabelValueLengthLimit {
+					value := l.GetValue()
+					if len(value) > maxLabelValueLengthInError {
+						value = value[:maxLabelValueLengthInError] + "..."
+					}
+					return &limitError{
+						limit: limitLabelValueLength,
+						msg:   fmt.Sprintf("%s label %s has value %q, limit is %d", family.GetName(), l.GetName(), value, sc.LabelValueLengthLimit),
+					}
+				}
+			}
+		}
+	}
+
+	if sc.SampleLimit > 0 && samples > sc.SampleLimit {
+		return &limitError{
+			limit: limitSample,
+			msg:   fmt.Sprintf("scrape has %d samples, limit is %d", samples, sc.SampleLimit),
+		}
+	}
+
+	return nil
+}
+
+func sampleCount(m *io_prometheus_client.Metric) int {
+	switch {
+	case m.GetHistogram() != nil:
+		return len(m.GetHistogram().GetBucket()) + 2
+	case m.GetSummary() != nil:
+		return len(m.GetSummary().GetQuantile()) + 2
+	default:
+		return 1
+	}
+}
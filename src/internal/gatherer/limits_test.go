@@ -0,0 +1,130 @@
+package gatherer_test
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+	var (
+		promServer   *stubPromServer
+		scrapeConfig scrapeconfig.Config
+		metrics      *metrichelpers.SpyMetricsRegistry
+	)
+
+	BeforeEach(func() {
+		promServer = newStubPromServer()
+		promServer.resp = promOutput
+		scrapeConfig = scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
+			Port:     promServer.port,
+			Scheme:   "http",
+			Path:     "metrics",
+			SourceID: "limited",
+		}}
+		metrics = metrichelpers.NewMetricsRegistry()
+	})
+
+	var newGatherer = func() *gatherer.ProxyGatherer {
+		return gatherer.NewProxyGatherer(scrapeConfig, "", "", "", metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	}
+
+	var limitExceeded = func(limit string) float64 {
+		return metrics.GetMetricValue("scrape_limit_exceeded", map[string]string{
+			"scrape_source_id": "limited",
+			"limit":            limit,
+		})
+	}
+
+	It("gathers scrapes within the limits", func() {
+		scrapeConfig.BodySizeLimit = scrapeconfig.ByteSize(len(promOutput))
+		scrapeConfig.SampleLimit = 4
+		scrapeConfig.LabelLimit = 1
+		scrapeConfig.LabelValueLengthLimit = 7
+
+		mfs, err := newGatherer().Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mfs).To(HaveLen(3))
+	})
+
+	It("fails scrapes with a body larger than the body size limit", func() {
+		scrapeConfig.BodySizeLimit = scrapeconfig.ByteSize(len(promOutput) - 1)
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("body_size_limit exceeded")))
+		Expect(limitExceeded("body_size_limit")).To(Equal(1.0))
+		Expect(metrics.GetMetricValue("failed_scrapes", map[string]string{"scrape_source_id": "limited"})).To(Equal(1.0))
+	})
+
+	It("fails scrapes with more samples than the sample limit", func() {
+		scrapeConfig.SampleLimit = 3
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("sample_limit exceeded")))
+		Expect(limitExceeded("sample_limit")).To(Equal(1.0))
+	})
+
+	It("counts the buckets, sum and count of histograms as samples", func() {
+		promServer.resp = strings.Join([]string{
+			"# TYPE latency histogram",
+			`latency_bucket{le="0.1"} 1`,
+			`latency_bucket{le="+Inf"} 2`,
+			"latency_sum 0.3",
+			"latency_count 2",
+			"",
+		}, "\n")
+		scrapeConfig.SampleLimit = 4
+
+		_, err := newGatherer().Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		scrapeConfig.SampleLimit = 3
+		_, err = newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("sample_limit exceeded")))
+	})
+
+	It("fails scrapes with series that have more labels than the label limit", func() {
+		promServer.resp = `metric{a="1",b="2"} 1` + "\n"
+		scrapeConfig.LabelLimit = 1
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("label_limit exceeded")))
+		Expect(limitExceeded("label_limit")).To(Equal(1.0))
+	})
+
+	It("fails scrapes with label values longer than the label value length limit", func() {
+		scrapeConfig.LabelValueLengthLimit = 6
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("label_value_length_limit exceeded")))
+		Expect(limitExceeded("label_value_length_limit")).To(Equal(1.0))
+	})
+
+	It("fails scrapes that take longer than the scrape timeout", func() {
+		promServer.delay = 200 * time.Millisecond
+		scrapeConfig.ScrapeTimeout = 50 * time.Millisecond
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(HaveOccurred())
+		Expect(limitExceeded("scrape_timeout")).To(Equal(1.0))
+	})
+
+	It("lowers the timeout to the timeout of the Prometheus server", func() {
+		promServer.delay = 200 * time.Millisecond
+		g := newGatherer()
+
+		_, err := g.WithScrapeTimeout(100 * time.Millisecond).Gather()
+		Expect(err).To(HaveOccurred())
+		Expect(limitExceeded("scrape_timeout")).To(Equal(1.0))
+
+		_, err = g.WithScrapeTimeout(2 * time.Second).Gather()
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
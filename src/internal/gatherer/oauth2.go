@@ -0,0 +1,168 @@
+package gatherer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	"code.cloudfoundry.org/tlsconfig"
+)
+
+// tokenExpiryDelta renews tokens before they expire, so they do not expire
+// while a scrape is in flight.
+const tokenExpiryDelta = 10 * time.Second
+
+// oauth2Auth fetches tokens with the OAuth2 client credentials grant. Tokens
+// are cached until they expire or the client secret changes.
+type oauth2Auth struct {
+	cfg          scrapeconfig.OAuth2
+	clientSecret *secretFile
+	client       *http.Client
+	// clientErr is returned instead of fetching tokens when the TLS config
+	// of the token URL cannot be built.
+	clientErr error
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newOAuth2Auth(cfg scrapeconfig.OAuth2) *oauth2Auth {
+	client, err := newTokenClient(cfg)
+	return &oauth2Auth{
+		cfg:          cfg,
+		clientSecret: newSecretFile(cfg.ClientSecretFile),
+		client:       client,
+		clientErr:    err,
+	}
+}
+
+// newTokenClient returns the client used to fetch tokens, which verifies
+// the token URL with the CA and server name of the config.
+func newTokenClient(cfg scrapeconfig.OAuth2) (*http.Client, error) {
+	var opts []tlsconfig.ClientOption
+	if cfg.CAFile != "" {
+		opts = append(opts, tlsconfig.WithAuthorityFromFile(cfg.CAFile))
+	}
+	if cfg.ServerName != "" {
+		opts = append(opts, tlsconfig.WithServerName(cfg.ServerName))
+	}
+
+	tlsConfig, err := tlsconfig.Build().Client(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build oauth2 TLS config: %w", err)
+	}
+	tlsConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, nil
+}
+
+func (a *oauth2Auth) authenticate(ctx context.Context, req *http.Request) error {
+	token, err := a.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *oauth2Auth) currentToken(ctx context.Context) (string, error) {
+	if a.clientErr != nil {
+		return "", a.clientErr
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var secret string
+	if a.clientSecret != nil {
+		var (
+			changed bool
+			err     error
+		)
+		secret, changed, err = a.clientSecret.read()
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			a.token = ""
+		}
+	}
+
+	if a.token != "" && (a.expires.IsZero() || time.Now().Before(a.expires)) {
+		return a.token, nil
+	}
+
+	token, expiresIn, err := a.fetchToken(ctx, secret)
+	if err != nil {
+		return "", err
+	}
+
+	a.token = token
+	a.expires = time.Time{}
+	if expiresIn > 0 {
+		a.expires = time.Now().Add(max(expiresIn-tokenExpiryDelta, expiresIn/2))
+	}
+
+	return a.token, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *oauth2Auth) fetchToken(ctx context.Context, secret string) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	}
+	for k, v := range a.cfg.EndpointParams {
+		form.Set(k, v)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(secret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to fetch oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to fetch oauth2 token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("unable to fetch oauth2 token: unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("unable to parse oauth2 token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, fmt.Errorf("oauth2 token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported oauth2 token type %q", token.TokenType)
+	}
+
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,248 @@
+package gatherer_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OAuth2", func() {
+	var (
+		promServer   *stubPromServer
+		tokenServer  *stubTokenServer
+		scrapeConfig scrapeconfig.Config
+		secretsDir   string
+	)
+
+	BeforeEach(func() {
+		promServer = newStubPromServer()
+		promServer.resp = promOutput
+		tokenServer = newStubTokenServer()
+		secretsDir = GinkgoT().TempDir()
+
+		scrapeConfig = scrapeconfig.Config{
+			PromScraperConfig: scraper.PromScraperConfig{
+				Port:     promServer.port,
+				Scheme:   "http",
+				Path:     "metrics",
+				SourceID: "oauth2",
+			},
+			OAuth2: &scrapeconfig.OAuth2{
+				ClientID:         "metrics-agent",
+				ClientSecretFile: writeSecret(secretsDir, "client-secret", "first-secret", time.Now()),
+				TokenURL:         tokenServer.URL + "/oauth/token",
+				Scopes:           []string{"metrics.read", "metrics.admin"},
+				EndpointParams:   map[string]string{"audience": "db"},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		tokenServer.Close()
+	})
+
+	var authorization = func(g *gatherer.ProxyGatherer) string {
+		_, err := g.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		var header map[string][]string
+		Expect(promServer.requestHeaders).To(Receive(&header))
+		return header["Authorization"][0]
+	}
+
+	var newGatherer = func() *gatherer.ProxyGatherer {
+		return gatherer.NewProxyGatherer(scrapeConfig, "", "", "", metrichelpers.NewMetricsRegistry(), slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	}
+
+	It("fetches a token with the client credentials grant", func() {
+		Expect(authorization(newGatherer())).To(Equal("Bearer token-1"))
+
+		req := tokenServer.request(0)
+		Expect(req.username).To(Equal("metrics-agent"))
+		Expect(req.password).To(Equal("first-secret"))
+		Expect(req.formValue("grant_type")).To(Equal("client_credentials"))
+		Expect(req.formValue("scope")).To(Equal("metrics.read metrics.admin"))
+		Expect(req.formValue("audience")).To(Equal("db"))
+	})
+
+	It("caches the token until it expires", func() {
+		tokenServer.setExpiresIn(3600)
+		g := newGatherer()
+
+		Expect(authorization(g)).To(Equal("Bearer token-1"))
+		Expect(authorization(g)).To(Equal("Bearer token-1"))
+		Expect(tokenServer.requestCount()).To(Equal(1))
+	})
+
+	It("fetches a new token once the token expired", func() {
+		tokenServer.setExpiresIn(1)
+		g := newGatherer()
+
+		Expect(authorization(g)).To(Equal("Bearer token-1"))
+		time.Sleep(600 * time.Millisecond)
+		Expect(authorization(g)).To(Equal("Bearer token-2"))
+	})
+
+	It("fetches a new token when the client secret changes", func() {
+		tokenServer.setExpiresIn(3600)
+		g := newGatherer()
+
+		Expect(authorization(g)).To(Equal("Bearer token-1"))
+
+		writeSecret(secretsDir, "client-secret", "second-secret", time.Now().Add(time.Second))
+		Expect(authorization(g)).To(Equal("Bearer token-2"))
+		Expect(tokenServer.request(1).password).To(Equal("second-secret"))
+	})
+
+	Context("when the token URL uses TLS", func() {
+		var tlsTokenServer *stubTokenServer
+
+		BeforeEach(func() {
+			tlsTokenServer = newStubTLSTokenServer()
+			scrapeConfig.OAuth2.TokenURL = tlsTokenServer.URL + "/oauth/token"
+		})
+
+		AfterEach(func() {
+			tlsTokenServer.Close()
+		})
+
+		It("verifies the token URL with the ca_file", func() {
+			scrapeConfig.OAuth2.CAFile = writeCA(secretsDir, tlsTokenServer.Certificate())
+
+			Expect(authorization(newGatherer())).To(Equal("Bearer token-1"))
+		})
+
+		It("verifies the token URL with the server_name", func() {
+			scrapeConfig.OAuth2.CAFile = writeCA(secretsDir, tlsTokenServer.Certificate())
+			scrapeConfig.OAuth2.ServerName = "other.example.org"
+
+			_, err := newGatherer().Gather()
+			Expect(err).To(MatchError(ContainSubstring("other.example.org")))
+		})
+
+		It("fails the scrape when the token URL is not signed by a trusted CA", func() {
+			_, err := newGatherer().Gather()
+			Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+		})
+
+		It("can skip the verification of the token URL", func() {
+			scrapeConfig.OAuth2.InsecureSkipVerify = true
+
+			Expect(authorization(newGatherer())).To(Equal("Bearer token-1"))
+		})
+
+		It("fails the scrape when the ca_file cannot be read", func() {
+			scrapeConfig.OAuth2.CAFile = filepath.Join(secretsDir, "missing.crt")
+
+			_, err := newGatherer().Gather()
+			Expect(err).To(MatchError(ContainSubstring("unable to build oauth2 TLS config")))
+		})
+	})
+
+	It("fails the scrape when no token can be fetched", func() {
+		tokenServer.setStatus(http.StatusUnauthorized)
+
+		_, err := newGatherer().Gather()
+		Expect(err).To(MatchError(ContainSubstring("unable to fetch oauth2 token")))
+	})
+})
+
+type tokenRequest struct {
+	username string
+	password string
+	form     map[string][]string
+}
+
+func (r tokenRequest) formValue(key string) string {
+	if len(r.form[key]) == 0 {
+		return ""
+	}
+	return r.form[key][0]
+}
+
+type stubTokenServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []tokenRequest
+	expiresIn int
+	status    int
+}
+
+func newStubTokenServer() *stubTokenServer {
+	s := &stubTokenServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func newStubTLSTokenServer() *stubTokenServer {
+	s := &stubTokenServer{status: http.StatusOK}
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func writeCA(dir string, cert *x509.Certificate) string {
+	path := filepath.Join(dir, "ca.crt")
+	Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600)).To(Succeed())
+	return path
+}
+
+func (s *stubTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, _ := r.BasicAuth()
+	Expect(r.ParseForm()).To(Succeed())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, tokenRequest{username: username, password: password, form: r.PostForm})
+
+	if s.status != http.StatusOK {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": fmt.Sprintf("token-%d", len(s.requests)),
+		"token_type":   "bearer",
+		"expires_in":   s.expiresIn,
+	})
+}
+
+func (s *stubTokenServer) request(i int) tokenRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[i]
+}
+
+func (s *stubTokenServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func (s *stubTokenServer) setExpiresIn(seconds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiresIn = seconds
+}
+
+func (s *stubTokenServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
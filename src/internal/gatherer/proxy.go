@@ -1,42 +1,124 @@
 package gatherer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	metrics "code.cloudfoundry.org/go-metric-registry"
-	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
 	"code.cloudfoundry.org/tlsconfig"
+	"github.com/prometheus/client_golang/prometheus"
 	io_prometheus_client "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
+// scrapeTimeoutOffset is subtracted from the timeout of the Prometheus
+// server scraping the agent.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
 type ProxyGatherer struct {
-	scrapeConfig scraper.PromScraperConfig
+	scrapeConfig scrapeconfig.Config
 	httpDoer     func(*http.Request) (*http.Response, error)
 	metrics      metricsRegistry
+
+	// jsonConverter converts the responses of json targets.
+	jsonConverter *jsonmetrics.Converter
+
+	auth      authenticator
+	flight    flight
+	limiter   *ScrapeLimiter
+	collapsed metrics.Counter
+	duration  metrics.Histogram
+	log       *slog.Logger
+
+	// certs provides the client certificate and CAs of scrapes.
+	certs *tlsreload.Reloader
+}
+
+type ProxyGathererOption func(*ProxyGatherer)
+
+// WithScrapeLimiter bounds the concurrent upstream scrapes of all gatherers
+// sharing the limiter.
+func WithScrapeLimiter(l *ScrapeLimiter) ProxyGathererOption {
+	return func(pg *ProxyGatherer) {
+		pg.limiter = l
+	}
+}
+
+// WithCertificates uses the certificates of the reloader instead of loading
+// them from the paths given to NewProxyGatherer. This allows gatherers to
+// share a single reloader.
+func WithCertificates(r *tlsreload.Reloader) ProxyGathererOption {
+	return func(pg *ProxyGatherer) {
+		pg.certs = r
+	}
 }
 
 type metricsRegistry interface {
 	NewCounter(string, string, ...metrics.MetricOption) metrics.Counter
+	NewHistogram(string, string, []float64, ...metrics.MetricOption) metrics.Histogram
 }
 
 func NewProxyGatherer(
-	scrapeConfig scraper.PromScraperConfig,
+	scrapeConfig scrapeconfig.Config,
 	certPath,
 	keyPath,
 	caPath string,
-	metrics metricsRegistry,
-	loggr *log.Logger,
+	m metricsRegistry,
+	loggr *slog.Logger,
+	opts ...ProxyGathererOption,
 ) *ProxyGatherer {
 	pg := &ProxyGatherer{
 		scrapeConfig: scrapeConfig,
-		metrics:      metrics,
-		httpDoer:     buildHttpClient(certPath, keyPath, caPath, scrapeConfig.ServerName, loggr).Do,
+		metrics:      m,
+		auth:         newAuthenticator(scrapeConfig),
+		log:          loggr,
+		collapsed: m.NewCounter(
+			"proxy_scrapes_collapsed",
+			"Total scrapes of target that shared the response of a concurrent scrape.",
+			metrics.WithMetricLabels(map[string]string{
+				"scrape_source_id": scrapeConfig.SourceID,
+			}),
+		),
+		duration: m.NewHistogram(
+			"proxy_scrape_duration_seconds",
+			"Duration of scrapes of target, excluding the time waiting for a concurrent scrape slot.",
+			prometheus.DefBuckets,
+			metrics.WithMetricLabels(map[string]string{
+				"scrape_source_id": scrapeConfig.SourceID,
+			}),
+		),
+	}
+
+	for _, opt := range opts {
+		opt(pg)
+	}
+
+	if pg.certs == nil {
+		certs, err := tlsreload.New(certPath, keyPath, caPath, tlsreload.WithLogger(loggr))
+		if err != nil {
+			logging.Fatal(loggr, "unable to load scrape certificates", "source_id", scrapeConfig.SourceID, "error", err)
+		}
+		pg.certs = certs
+	}
+	pg.httpDoer = buildHttpClient(pg.certs, scrapeConfig, loggr).Do
+
+	if scrapeConfig.Type == scrapeconfig.TypeJSON {
+		converter, err := scrapeConfig.JSONConverter()
+		if err != nil {
+			loggr.Error("invalid json config", "source_id", scrapeConfig.SourceID, "error", err)
+		}
+		pg.jsonConverter = converter
 	}
 
 	pg.newFailedScrapeMetric(scrapeConfig.SourceID)
@@ -44,43 +126,112 @@ func NewProxyGatherer(
 	return pg
 }
 
-func buildHttpClient(certPath, keyPath, caPath, serverName string, loggr *log.Logger) *http.Client {
-	tlsOptions := []tlsconfig.TLSOption{tlsconfig.WithInternalServiceDefaults()}
+func buildHttpClient(certs *tlsreload.Reloader, scrapeConfig scrapeconfig.Config, loggr *slog.Logger) *http.Client {
 	var clientOptions []tlsconfig.ClientOption
-
-	if certPath != "" && keyPath != "" {
-		tlsOptions = append(tlsOptions, tlsconfig.WithIdentityFromFile(certPath, keyPath))
-		clientOptions = append(clientOptions, tlsconfig.WithServerName(serverName))
+	if certs.Certificate() != nil {
+		clientOptions = append(clientOptions, tlsconfig.WithServerName(scrapeConfig.ServerName))
 	}
 
-	if caPath != "" {
-		clientOptions = append(clientOptions, tlsconfig.WithAuthorityFromFile(caPath))
+	base, err := tlsconfig.Build(tlsconfig.WithInternalServiceDefaults()).Client(clientOptions...)
+	if err != nil {
+		logging.Fatal(loggr, "unable to build scrape TLS config", "source_id", scrapeConfig.SourceID, "error", err)
 	}
+	tlsConfig := certs.ClientConfig(base)
 
-	tlsConfig, err := tlsconfig.Build(tlsOptions...).Client(clientOptions...)
-	if err != nil {
-		loggr.Fatal(err)
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		IdleConnTimeout:   scrapeConfig.GetIdleConnTimeout(),
+		DisableKeepAlives: scrapeConfig.DisableKeepAlives,
+	}
+	if scrapeConfig.Socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", scrapeConfig.Socket)
+		}
 	}
 
 	return &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-		Timeout: 5 * time.Second,
+		Transport: transport,
 	}
 }
 
 // Gather implements prometheus.Gatherer
 func (c *ProxyGatherer) Gather() ([]*io_prometheus_client.MetricFamily, error) {
-	scrapeResults, err := c.scrape(c.scrapeConfig)
+	return c.gather(0)
+}
+
+// WithScrapeTimeout returns a Gatherer that limits the scrape to the timeout
+// of the Prometheus server scraping the agent, as sent in the
+// X-Prometheus-Scrape-Timeout-Seconds header.
+func (c *ProxyGatherer) WithScrapeTimeout(scraperTimeout time.Duration) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*io_prometheus_client.MetricFamily, error) {
+		return c.gather(scraperTimeout)
+	})
+}
+
+// gather scrapes the target. Concurrent calls share the response of a
+// single scrape, which uses the timeout of the first caller.
+func (c *ProxyGatherer) gather(scraperTimeout time.Duration) ([]*io_prometheus_client.MetricFamily, error) {
+	scrapeResults, shared, err := c.flight.do(func() ([]*io_prometheus_client.MetricFamily, error) {
+		return c.limitedScrape(scraperTimeout)
+	})
+	if shared {
+		c.collapsed.Add(1)
+	}
+	return scrapeResults, err
+}
+
+func (c *ProxyGatherer) limitedScrape(scraperTimeout time.Duration) ([]*io_prometheus_client.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTimeout(scraperTimeout))
+	defer cancel()
+
+	scrapeResults, err := c.acquireAndScrape(ctx)
 	if err != nil {
 		c.incFailedScrapes(c.scrapeConfig.SourceID)
+		c.log.Warn("scrape failed", "source_id", c.scrapeConfig.SourceID, "target", c.scrapeConfig.Address(), "error", err)
+
+		var limitErr *limitError
+		switch {
+		case errors.As(err, &limitErr):
+			c.incLimitExceeded(c.scrapeConfig.SourceID, limitErr.limit)
+		case errors.Is(err, context.DeadlineExceeded):
+			c.incLimitExceeded(c.scrapeConfig.SourceID, limitScrapeTimeout)
+		}
 		return nil, err
 	}
 
+	c.log.Debug("scraped target", "source_id", c.scrapeConfig.SourceID, "target", c.scrapeConfig.Address(), "families", len(scrapeResults))
 	return scrapeResults, nil
 }
 
+func (c *ProxyGatherer) acquireAndScrape(ctx context.Context) ([]*io_prometheus_client.MetricFamily, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for a concurrent scrape slot: %w", err)
+	}
+	defer release()
+
+	start := time.Now()
+	defer func() { c.duration.Observe(time.Since(start).Seconds()) }()
+
+	return c.scrape(ctx, c.scrapeConfig)
+}
+
+// scrapeTimeout returns the timeout of the scrape config. It is lowered to
+// the timeout of the Prometheus server minus an offset, which leaves time to
+// respond before the server gives up.
+func (c *ProxyGatherer) scrapeTimeout(scraperTimeout time.Duration) time.Duration {
+	timeout := c.scrapeConfig.GetScrapeTimeout()
+	if scraperTimeout <= 0 {
+		return timeout
+	}
+
+	if scraperTimeout > 2*scrapeTimeoutOffset {
+		scraperTimeout -= scrapeTimeoutOffset
+	}
+	return min(timeout, scraperTimeout)
+}
+
 func (c *ProxyGatherer) incFailedScrapes(sourceID string) {
 	c.newFailedScrapeMetric(sourceID).Add(1)
 }
@@ -95,11 +246,29 @@ func (c *ProxyGatherer) newFailedScrapeMetric(sourceID string) metrics.Counter {
 	)
 }
 
-func (c *ProxyGatherer) scrape(scrapeConfig scraper.PromScraperConfig) ([]*io_prometheus_client.MetricFamily, error) {
+func (c *ProxyGatherer) incLimitExceeded(sourceID, limit string) {
+	c.metrics.NewCounter(
+		"scrape_limit_exceeded",
+		"Total scrapes of target that failed because they exceeded a limit.",
+		metrics.WithMetricLabels(map[string]string{
+			"scrape_source_id": sourceID,
+			"limit":            limit,
+		}),
+	).Add(1)
+}
+
+func (c *ProxyGatherer) scrape(ctx context.Context, scrapeConfig scrapeconfig.Config) ([]*io_prometheus_client.MetricFamily, error) {
 	req, err := c.scrapeRequest(scrapeConfig)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
+
+	if c.auth != nil {
+		if err := c.auth.authenticate(ctx, req); err != nil {
+			return nil, err
+		}
+	}
 
 	resp, err := c.httpDoer(req)
 	if err != nil {
@@ -116,8 +285,28 @@ func (c *ProxyGatherer) scrape(scrapeConfig scraper.PromScraperConfig) ([]*io_pr
 		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
 	}
 
+	families, err := c.parse(newBodyLimitReader(resp.Body, scrapeConfig.BodySizeLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkLimits(scrapeConfig, families); err != nil {
+		return nil, err
+	}
+
+	return families, nil
+}
+
+func (c *ProxyGatherer) parse(body io.Reader) ([]*io_prometheus_client.MetricFamily, error) {
+	if c.scrapeConfig.Type == scrapeconfig.TypeJSON {
+		if c.jsonConverter == nil {
+			return nil, fmt.Errorf("invalid json config")
+		}
+		return c.jsonConverter.Convert(body)
+	}
+
 	p := &expfmt.TextParser{}
-	res, err := p.TextToMetricFamilies(resp.Body)
+	res, err := p.TextToMetricFamilies(body)
 	if err != nil {
 		return nil, err
 	}
@@ -127,12 +316,12 @@ func (c *ProxyGatherer) scrape(scrapeConfig scraper.PromScraperConfig) ([]*io_pr
 		families = append(families, family)
 	}
 
-	return families, err
+	return families, nil
 }
 
-func (c *ProxyGatherer) scrapeRequest(scrapeConfig scraper.PromScraperConfig) (*http.Request, error) {
-	url := fmt.Sprintf("%s://127.0.0.1:%s/%s",
-		scrapeConfig.Scheme, scrapeConfig.Port, strings.TrimPrefix(scrapeConfig.Path, "/"))
+func (c *ProxyGatherer) scrapeRequest(scrapeConfig scrapeconfig.Config) (*http.Request, error) {
+	url := fmt.Sprintf("%s://%s/%s",
+		scrapeConfig.Scheme, scrapeConfig.Address(), strings.TrimPrefix(scrapeConfig.Path, "/"))
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
@@ -1,14 +1,19 @@
 package gatherer_test
 
 import (
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"time"
 
 	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
 	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
 	"code.cloudfoundry.org/metrics-discovery/internal/gatherer"
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
 	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
 	"code.cloudfoundry.org/tlsconfig"
 	. "github.com/onsi/ginkgo/v2"
@@ -21,9 +26,9 @@ var _ = Describe("Proxy", func() {
 	type testContext struct {
 		promServer   *stubPromServer
 		scrapeCerts  *testhelpers.TestCerts
-		scrapeConfig scraper.PromScraperConfig
+		scrapeConfig scrapeconfig.Config
 		metrics      *metrichelpers.SpyMetricsRegistry
-		loggr        *log.Logger
+		loggr        *slog.Logger
 	}
 
 	var setup = func(scheme, scrapePath string, scrapeHeaders map[string]string) *testContext {
@@ -39,20 +44,20 @@ var _ = Describe("Proxy", func() {
 		}
 		promServer.resp = promOutput
 
-		scrapeConfig := scraper.PromScraperConfig{
+		scrapeConfig := scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
 			Port:       promServer.port,
 			Scheme:     scheme,
 			Path:       scrapePath,
 			ServerName: serverName,
 			Headers:    scrapeHeaders,
-		}
+		}}
 
 		return &testContext{
 			promServer:   promServer,
 			scrapeCerts:  scrapeCerts,
 			scrapeConfig: scrapeConfig,
 			metrics:      metrichelpers.NewMetricsRegistry(),
-			loggr:        log.New(GinkgoWriter, "", 0),
+			loggr:        slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
 		}
 	}
 
@@ -151,14 +156,105 @@ var _ = Describe("Proxy", func() {
 		))
 	})
 
+	It("scrapes targets on a unix socket", func() {
+		tc := setup("http", "metrics", nil)
+		socket := filepath.Join(GinkgoT().TempDir(), "metrics.sock")
+		lis, err := net.Listen("unix", socket)
+		Expect(err).ToNot(HaveOccurred())
+		server := &httptest.Server{Listener: lis, Config: &http.Server{Handler: tc.promServer}}
+		server.Start()
+		defer server.Close()
+
+		tc.scrapeConfig.Port = ""
+		tc.scrapeConfig.Socket = socket
+		proxyCollector := buildProxyCollector(tc)
+
+		mfs, err := proxyCollector.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tc.promServer.requestPaths).To(Receive(Equal("/metrics")))
+		Expect(mfs).To(ContainElement(haveFamilyName("metric1")))
+	})
+
+	It("scrapes targets on the configured host", func() {
+		tc := setup("http", "metrics", nil)
+		tc.scrapeConfig.Host = "localhost"
+		proxyCollector := buildProxyCollector(tc)
+
+		mfs, err := proxyCollector.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tc.promServer.requestHosts).To(Receive(Equal("localhost:" + tc.promServer.port)))
+		Expect(mfs).To(ContainElement(haveFamilyName("metric1")))
+	})
+
+	It("converts the response of json targets", func() {
+		tc := setup("http", "debug/vars", nil)
+		tc.promServer.resp = `{"queues":{"jobs":{"depth":11},"mail":{"depth":22}}}`
+		tc.scrapeConfig.Type = scrapeconfig.TypeJSON
+		tc.scrapeConfig.JSON = scrapeconfig.JSONConfig{
+			Metrics: []jsonmetrics.Mapping{{
+				Name:   "queue_depth",
+				Path:   "$.queues.*",
+				Value:  "depth",
+				Labels: map[string]string{"queue": "@key"},
+			}},
+		}
+		proxyCollector := buildProxyCollector(tc)
+
+		mfs, err := proxyCollector.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tc.promServer.requestPaths).To(Receive(Equal("/debug/vars")))
+		Expect(mfs).To(ConsistOf(
+			And(
+				haveFamilyName("queue_depth"),
+				haveMetrics(
+					gaugeWith(11, map[string]string{"queue": "jobs"}),
+					gaugeWith(22, map[string]string{"queue": "mail"}),
+				),
+			),
+		))
+	})
+
+	It("returns an error if the response of a json target is invalid", func() {
+		tc := setup("http", "debug/vars", nil)
+		tc.scrapeConfig.Type = scrapeconfig.TypeJSON
+		tc.scrapeConfig.JSON = scrapeconfig.JSONConfig{Preset: "expvar"}
+		tc.scrapeConfig.SourceID = "json_id"
+		proxyCollector := buildProxyCollector(tc)
+
+		_, err := proxyCollector.Gather()
+		Expect(err).To(HaveOccurred())
+
+		Expect(tc.metrics.GetMetric(
+			"failed_scrapes",
+			map[string]string{"scrape_source_id": "json_id"}).Value(),
+		).To(Equal(1.0))
+	})
+
+	It("records the duration of scrapes", func() {
+		tc := setup("http", "metrics", nil)
+		tc.scrapeConfig.SourceID = "slow_id"
+		tc.promServer.delay = 100 * time.Millisecond
+
+		proxyCollector := buildProxyCollector(tc)
+
+		_, err := proxyCollector.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		duration := tc.metrics.GetMetric("proxy_scrape_duration_seconds", map[string]string{"scrape_source_id": "slow_id"})
+		Expect(duration.Value()).To(BeNumerically(">=", 0.1))
+	})
+
 	It("returns an error if the scrape fails", func() {
 		tc := setup("http", "metrics", nil)
-		tc.scrapeConfig = scraper.PromScraperConfig{
+		tc.scrapeConfig = scrapeconfig.Config{PromScraperConfig: scraper.PromScraperConfig{
 			Port:     "9091",
 			Scheme:   "http",
 			Path:     "this_server_does_not_exist",
 			SourceID: "failed_scrape_id",
-		}
+		}}
 
 		proxyCollector := buildProxyCollector(tc)
 
@@ -173,17 +269,20 @@ var _ = Describe("Proxy", func() {
 })
 
 type stubPromServer struct {
-	resp string
-	port string
+	resp  string
+	port  string
+	delay time.Duration
 
 	requestHeaders chan http.Header
 	requestPaths   chan string
+	requestHosts   chan string
 }
 
 func newStubPromServer() *stubPromServer {
 	s := &stubPromServer{
 		requestHeaders: make(chan http.Header, 100),
 		requestPaths:   make(chan string, 100),
+		requestHosts:   make(chan string, 100),
 	}
 
 	server := httptest.NewServer(s)
@@ -199,6 +298,7 @@ func newStubHttpsPromServer(scrapeCerts *testhelpers.TestCerts) *stubPromServer
 	s := &stubPromServer{
 		requestHeaders: make(chan http.Header, 100),
 		requestPaths:   make(chan string, 100),
+		requestHosts:   make(chan string, 100),
 	}
 
 	var serverOpts []tlsconfig.ServerOption
@@ -222,6 +322,8 @@ func newStubHttpsPromServer(scrapeCerts *testhelpers.TestCerts) *stubPromServer
 func (s *stubPromServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	s.requestHeaders <- req.Header
 	s.requestPaths <- req.URL.Path
+	s.requestHosts <- req.Host
+	time.Sleep(s.delay)
 	_, err := w.Write([]byte(s.resp))
 	Expect(err).ToNot(HaveOccurred())
 }
@@ -0,0 +1,198 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Config holds the health settings shared by all commands. Commands nest it
+// under the health key of their config file.
+type Config struct {
+	// Addr is the address of the health server. The server is not started
+	// when it is empty.
+	Addr string `env:"HEALTH_ADDR, report" yaml:"addr"`
+}
+
+// Check returns an error describing why a component is not healthy.
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the checks of a process and serves them at /healthz and
+// /readyz. Liveness checks fail when the process needs to be restarted and
+// are part of both endpoints. Readiness checks fail while the process cannot
+// do its work and are only part of /readyz.
+type Registry struct {
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// AddLiveness adds a check to /healthz and /readyz.
+func (r *Registry) AddLiveness(name string, c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, namedCheck{name: name, check: c})
+}
+
+// AddReadiness adds a check to /readyz.
+func (r *Registry) AddReadiness(name string, c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, namedCheck{name: name, check: c})
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of the health endpoints.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Handler serves /healthz and /readyz. Both respond with 200 if all of their
+// checks pass and 503 otherwise, along with the result of every check.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		r.serve(w, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		r.serve(w, true)
+	})
+	return mux
+}
+
+func (r *Registry) serve(w http.ResponseWriter, readiness bool) {
+	report := r.run(readiness)
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (r *Registry) run(readiness bool) Report {
+	r.mu.Lock()
+	checks := append([]namedCheck(nil), r.liveness...)
+	if readiness {
+		checks = append(checks, r.readiness...)
+	}
+	r.mu.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			report.Status = StatusFailing
+			report.Checks[c.name] = Result{Status: StatusFailing, Error: err.Error()}
+			continue
+		}
+		report.Checks[c.name] = Result{Status: StatusOK}
+	}
+	return report
+}
+
+// Serve serves the health endpoints at addr until the returned function is
+// called. Nothing is served when addr is empty.
+func Serve(addr string, r *Registry, log *slog.Logger) (stop func()) {
+	if addr == "" {
+		return func() {}
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           r.Handler(),
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() { log.Info("health server closed", "error", server.ListenAndServe()) }()
+
+	return func() { _ = server.Close() }
+}
+
+// Heartbeat records the time of the last success of a recurring task.
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+// NewHeartbeat returns a heartbeat that counts its creation as the last
+// success, so that a task has time to succeed for the first time.
+func NewHeartbeat() *Heartbeat {
+	h := &Heartbeat{}
+	h.Beat()
+	return h
+}
+
+// Beat records a success.
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Check fails when the last success is older than maxAge.
+func (h *Heartbeat) Check(task string, maxAge time.Duration) Check {
+	return func() error {
+		last := time.Unix(0, h.last.Load())
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("last successful %s %s ago, expected within %s", task, age.Round(time.Second), maxAge)
+		}
+		return nil
+	}
+}
+
+// Writable fails when the file at path cannot be written, either in place
+// or by replacing it with a file created in the same directory.
+func Writable(path string) Check {
+	return func() error {
+		if _, err := os.Stat(path); err == nil {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			f.Close()
+		}
+
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".health-check")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+}
+
+// NATS fails while the connection is not connected to a NATS server.
+func NATS(conn *nats.Conn) Check {
+	return func() error {
+		if conn.IsConnected() {
+			return nil
+		}
+		return fmt.Errorf("not connected to NATS, connection is %s", conn.Status())
+	}
+}
@@ -0,0 +1,118 @@
+package health_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/health"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		registry *health.Registry
+		handler  http.Handler
+	)
+
+	BeforeEach(func() {
+		registry = health.NewRegistry()
+		handler = registry.Handler()
+	})
+
+	var get = func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	It("reports ok without checks", func() {
+		rec := get("/healthz")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"status":"ok","checks":{}}`))
+	})
+
+	It("reports the result of every check", func() {
+		registry.AddLiveness("ingress", func() error { return nil })
+		registry.AddReadiness("nats", func() error { return errors.New("not connected") })
+
+		rec := get("/readyz")
+
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Body.String()).To(MatchJSON(`{
+			"status": "failing",
+			"checks": {
+				"ingress": {"status": "ok"},
+				"nats": {"status": "failing", "error": "not connected"}
+			}
+		}`))
+	})
+
+	It("only runs liveness checks for /healthz", func() {
+		registry.AddLiveness("ingress", func() error { return nil })
+		registry.AddReadiness("nats", func() error { return errors.New("not connected") })
+
+		rec := get("/healthz")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"status":"ok","checks":{"ingress":{"status":"ok"}}}`))
+	})
+
+	It("fails /healthz when a liveness check fails", func() {
+		registry.AddLiveness("ingress", func() error { return errors.New("ingress server is not serving") })
+
+		Expect(get("/healthz").Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(get("/readyz").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+})
+
+var _ = Describe("Heartbeat", func() {
+	It("passes within the max age of the last beat", func() {
+		h := health.NewHeartbeat()
+
+		Expect(h.Check("publish", time.Minute)()).To(Succeed())
+	})
+
+	It("fails when the last beat is too old", func() {
+		h := health.NewHeartbeat()
+		time.Sleep(20 * time.Millisecond)
+
+		check := h.Check("publish", 10*time.Millisecond)
+		Expect(check()).To(MatchError(ContainSubstring("last successful publish")))
+
+		h.Beat()
+		Expect(h.Check("publish", time.Minute)()).To(Succeed())
+	})
+})
+
+var _ = Describe("Writable", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("passes for a writable file", func() {
+		path := filepath.Join(dir, "targets.yml")
+		Expect(os.WriteFile(path, nil, 0600)).To(Succeed())
+
+		Expect(health.Writable(path)()).To(Succeed())
+		entries, err := os.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("passes for a file that can be created", func() {
+		Expect(health.Writable(filepath.Join(dir, "targets.yml"))()).To(Succeed())
+	})
+
+	It("fails when the directory does not exist", func() {
+		Expect(health.Writable(filepath.Join(dir, "missing", "targets.yml"))()).ToNot(Succeed())
+	})
+})
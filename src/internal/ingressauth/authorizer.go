@@ -0,0 +1,147 @@
+package ingressauth
+
+import (
+	"context"
+	"crypto/x509"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+// Authorizer drops envelopes received via gRPC whose source id the client
+// certificate is not allowed to emit according to the source ids of the
+// policy. Envelopes without source id are checked by their origin tag,
+// which the receiver uses as source id.
+type Authorizer struct {
+	policy  *authz.Policy
+	metrics metricsRegistry
+}
+
+// New returns an Authorizer for the policy.
+func New(policy *authz.Policy, m metricsRegistry) *Authorizer {
+	return &Authorizer{
+		policy:  policy,
+		metrics: m,
+	}
+}
+
+// StreamInterceptor authorizes the envelopes of the Sender and BatchSender
+// streams.
+func (a *Authorizer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authorizedStream{
+			ServerStream: ss,
+			client:       a.client(ss.Context()),
+		})
+	}
+}
+
+// UnaryInterceptor authorizes the envelopes of Send requests.
+func (a *Authorizer) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if batch, ok := req.(*loggregator_v2.EnvelopeBatch); ok {
+			batch.Batch = a.client(ctx).filter(batch.Batch)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authorizer) client(ctx context.Context) *client {
+	cert := peerCertificate(ctx)
+	return &client{
+		authorizer: a,
+		identity:   authz.Identity(cert),
+		grant:      a.policy.Grant(cert),
+	}
+}
+
+type client struct {
+	authorizer *Authorizer
+	identity   string
+	grant      authz.Grant
+	rejected   metrics.Counter
+}
+
+func (c *client) allowed(e *loggregator_v2.Envelope) bool {
+	if c.grant.SourceID(sourceID(e)) {
+		return true
+	}
+
+	if c.rejected == nil {
+		c.rejected = c.authorizer.metrics.NewCounter(
+			"ingress_unauthorized_envelopes",
+			"Total number of envelopes dropped because the client is not allowed to emit their source id.",
+			metrics.WithMetricLabels(map[string]string{"identity": c.identity}),
+		)
+	}
+	c.rejected.Add(1)
+	return false
+}
+
+func (c *client) filter(envelopes []*loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	allowed := envelopes[:0]
+	for _, e := range envelopes {
+		if c.allowed(e) {
+			allowed = append(allowed, e)
+		}
+	}
+	return allowed
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	client *client
+}
+
+// RecvMsg skips envelopes that are not allowed and removes them from
+// batches.
+func (s *authorizedStream) RecvMsg(m any) error {
+	for {
+		if err := s.ServerStream.RecvMsg(m); err != nil {
+			return err
+		}
+
+		switch msg := m.(type) {
+		case *loggregator_v2.Envelope:
+			if !s.client.allowed(msg) {
+				continue
+			}
+		case *loggregator_v2.EnvelopeBatch:
+			msg.Batch = s.client.filter(msg.Batch)
+		}
+		return nil
+	}
+}
+
+func sourceID(e *loggregator_v2.Envelope) string {
+	if e.GetSourceId() != "" {
+		return e.GetSourceId()
+	}
+
+	if id, ok := e.GetTags()["origin"]; ok {
+		return id
+	}
+
+	return e.GetDeprecatedTags()["origin"].GetText()
+}
+
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil
+	}
+	return info.State.PeerCertificates[0]
+}
@@ -0,0 +1,172 @@
+package ingressauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/authz"
+	"code.cloudfoundry.org/metrics-discovery/internal/ingressauth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Authorizer", func() {
+	var (
+		spyMetrics *metrichelpers.SpyMetricsRegistry
+		authorizer *ingressauth.Authorizer
+	)
+
+	BeforeEach(func() {
+		spyMetrics = metrichelpers.NewMetricsRegistry()
+		policy, err := authz.New([]authz.Rule{{
+			Name:      "gorouter",
+			Subjects:  []string{"gorouter"},
+			SourceIDs: []string{"gorouter", "gorouter-*"},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+
+		authorizer = ingressauth.New(policy, spyMetrics)
+	})
+
+	var rejected = func(identity string) float64 {
+		return spyMetrics.GetMetricValue("ingress_unauthorized_envelopes", map[string]string{"identity": identity})
+	}
+
+	Describe("StreamInterceptor", func() {
+		var receive = func(commonName string, newMsg func() proto.Message, msgs ...proto.Message) []proto.Message {
+			stream := &fakeStream{ctx: clientContext(commonName), msgs: msgs}
+
+			var received []proto.Message
+			err := authorizer.StreamInterceptor()(nil, stream, nil, func(_ any, ss grpc.ServerStream) error {
+				for {
+					m := newMsg()
+					if err := ss.RecvMsg(m); err != nil {
+						return err
+					}
+					received = append(received, m)
+				}
+			})
+			Expect(err).To(Equal(io.EOF))
+
+			return received
+		}
+
+		var newEnvelope = func() proto.Message { return &loggregator_v2.Envelope{} }
+		var newBatch = func() proto.Message { return &loggregator_v2.EnvelopeBatch{} }
+
+		It("skips envelopes of source ids the client may not emit", func() {
+			received := receive("gorouter", newEnvelope,
+				envelope("gorouter"),
+				envelope("uaa"),
+				envelope("gorouter-1"),
+			)
+
+			Expect(received).To(HaveLen(2))
+			Expect(received[0].(*loggregator_v2.Envelope).GetSourceId()).To(Equal("gorouter"))
+			Expect(received[1].(*loggregator_v2.Envelope).GetSourceId()).To(Equal("gorouter-1"))
+			Expect(rejected("gorouter")).To(Equal(1.0))
+		})
+
+		It("removes envelopes from batches", func() {
+			received := receive("gorouter", newBatch,
+				&loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{envelope("uaa"), envelope("gorouter")}},
+			)
+
+			Expect(received).To(HaveLen(1))
+			batch := received[0].(*loggregator_v2.EnvelopeBatch).GetBatch()
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].GetSourceId()).To(Equal("gorouter"))
+			Expect(rejected("gorouter")).To(Equal(1.0))
+		})
+
+		It("checks the origin tag of envelopes without source id", func() {
+			allowed := &loggregator_v2.Envelope{Tags: map[string]string{"origin": "gorouter"}}
+			denied := &loggregator_v2.Envelope{DeprecatedTags: map[string]*loggregator_v2.Value{
+				"origin": {Data: &loggregator_v2.Value_Text{Text: "uaa"}},
+			}}
+
+			received := receive("gorouter", newEnvelope, allowed, denied)
+
+			Expect(received).To(HaveLen(1))
+			Expect(rejected("gorouter")).To(Equal(1.0))
+		})
+
+		It("counts rejected envelopes per client", func() {
+			receive("uaa", newEnvelope, envelope("gorouter"), envelope("uaa"))
+
+			Expect(rejected("uaa")).To(Equal(2.0))
+			Expect(spyMetrics.HasMetric("ingress_unauthorized_envelopes", map[string]string{"identity": "gorouter"})).To(BeFalse())
+		})
+	})
+
+	Describe("UnaryInterceptor", func() {
+		It("removes envelopes from batches", func() {
+			batch := &loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{envelope("uaa"), envelope("gorouter")}}
+
+			var received *loggregator_v2.EnvelopeBatch
+			_, err := authorizer.UnaryInterceptor()(clientContext("gorouter"), batch, nil, func(_ context.Context, req any) (any, error) {
+				received = req.(*loggregator_v2.EnvelopeBatch)
+				return &loggregator_v2.SendResponse{}, nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(received.GetBatch()).To(HaveLen(1))
+			Expect(received.GetBatch()[0].GetSourceId()).To(Equal("gorouter"))
+			Expect(rejected("gorouter")).To(Equal(1.0))
+		})
+
+		It("rejects all envelopes of clients without certificate", func() {
+			batch := &loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{envelope("gorouter")}}
+
+			_, err := authorizer.UnaryInterceptor()(context.Background(), batch, nil, func(_ context.Context, req any) (any, error) {
+				return &loggregator_v2.SendResponse{}, nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(batch.GetBatch()).To(BeEmpty())
+			Expect(rejected("")).To(Equal(1.0))
+		})
+	})
+})
+
+func envelope(sourceID string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{SourceId: sourceID}
+}
+
+func clientContext(commonName string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []proto.Message
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+
+	msg := m.(proto.Message)
+	proto.Reset(msg)
+	proto.Merge(msg, s.msgs[0])
+	s.msgs = s.msgs[1:]
+	return nil
+}
@@ -0,0 +1,13 @@
+package ingressauth_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIngressAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ingress Auth Suite")
+}
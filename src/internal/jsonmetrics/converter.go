@@ -0,0 +1,267 @@
+package jsonmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// MetricTypeGauge exposes the selected values as gauges.
+	MetricTypeGauge = "gauge"
+
+	// MetricTypeCounter exposes the selected values as counters.
+	MetricTypeCounter = "counter"
+
+	// MetricTypeUntyped exposes the selected values as untyped metrics.
+	MetricTypeUntyped = "untyped"
+)
+
+// Mapping converts the values selected by Path to a metric. Value and the
+// label values are selectors relative to the values selected by Path. A
+// label value of @key uses the object key or array index matched by the
+// last wildcard of Path.
+type Mapping struct {
+	Name   string            `yaml:"name"`
+	Help   string            `yaml:"help"`
+	Type   string            `yaml:"type"`
+	Path   string            `yaml:"path"`
+	Value  string            `yaml:"value"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+type compiledMapping struct {
+	name       string
+	help       string
+	metricType dto.MetricType
+	path       path
+	value      path
+	labels     []compiledLabel
+}
+
+type compiledLabel struct {
+	name  string
+	key   bool
+	value path
+}
+
+// Converter converts JSON documents to metric families.
+type Converter struct {
+	mappings []compiledMapping
+	expvar   bool
+}
+
+// NewConverter returns a Converter for the given preset and mappings. The
+// mappings are applied in addition to the ones of the preset. Mappings with
+// the same name must have the same type.
+func NewConverter(preset string, mappings []Mapping) (*Converter, error) {
+	c := &Converter{}
+	switch preset {
+	case "":
+	case PresetMemStats:
+		mappings = append(memStatsMappings(), mappings...)
+	case PresetExpvar:
+		mappings = append(memStatsMappings(), mappings...)
+		c.expvar = true
+	default:
+		return nil, fmt.Errorf("unknown preset %q", preset)
+	}
+
+	if len(mappings) == 0 && !c.expvar {
+		return nil, fmt.Errorf("a preset or metrics are required")
+	}
+
+	types := map[string]dto.MetricType{}
+	for _, m := range mappings {
+		compiled, err := compile(m)
+		if err != nil {
+			return nil, fmt.Errorf("metric %q: %s", m.Name, err)
+		}
+		if t, ok := types[compiled.name]; ok && t != compiled.metricType {
+			return nil, fmt.Errorf("metric %q: type %s conflicts with type %s of another mapping",
+				m.Name, strings.ToLower(compiled.metricType.String()), strings.ToLower(t.String()))
+		}
+		types[compiled.name] = compiled.metricType
+		c.mappings = append(c.mappings, compiled)
+	}
+
+	return c, nil
+}
+
+func compile(m Mapping) (compiledMapping, error) {
+	if !model.IsValidLegacyMetricName(m.Name) {
+		return compiledMapping{}, fmt.Errorf("invalid metric name")
+	}
+
+	compiled := compiledMapping{
+		name: m.Name,
+		help: m.Help,
+	}
+	if compiled.help == "" {
+		compiled.help = "Metric converted from JSON"
+	}
+
+	switch m.Type {
+	case "", MetricTypeGauge:
+		compiled.metricType = dto.MetricType_GAUGE
+	case MetricTypeCounter:
+		compiled.metricType = dto.MetricType_COUNTER
+	case MetricTypeUntyped:
+		compiled.metricType = dto.MetricType_UNTYPED
+	default:
+		return compiledMapping{}, fmt.Errorf("unknown type %q", m.Type)
+	}
+
+	if m.Path == "" {
+		return compiledMapping{}, fmt.Errorf("path is required")
+	}
+
+	var err error
+	if compiled.path, err = parsePath(m.Path); err != nil {
+		return compiledMapping{}, err
+	}
+	if compiled.value, err = parsePath(m.Value); err != nil {
+		return compiledMapping{}, err
+	}
+
+	for name, value := range m.Labels {
+		if !model.LabelName(name).IsValidLegacy() {
+			return compiledMapping{}, fmt.Errorf("invalid label name %q", name)
+		}
+
+		l := compiledLabel{name: name, key: value == keyLabelValue}
+		if !l.key {
+			if l.value, err = parsePath(value); err != nil {
+				return compiledMapping{}, err
+			}
+		}
+		compiled.labels = append(compiled.labels, l)
+	}
+	sort.Slice(compiled.labels, func(i, j int) bool {
+		return compiled.labels[i].name < compiled.labels[j].name
+	})
+
+	return compiled, nil
+}
+
+// Convert decodes the JSON document from r and returns the metric families
+// of all mappings. Selected values that are not numbers, booleans or
+// numeric strings are skipped, as are duplicate label sets.
+func (c *Converter) Convert(r io.Reader) ([]*dto.MetricFamily, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	families := newFamilies()
+	for _, m := range c.mappings {
+		for _, selected := range m.path.find(doc) {
+			v, ok := m.value.first(selected.value)
+			if !ok {
+				continue
+			}
+			value, ok := numberValue(v)
+			if !ok {
+				continue
+			}
+
+			families.add(m.name, m.help, m.metricType, m.labelPairs(selected), value)
+		}
+	}
+
+	if c.expvar {
+		addExpvars(doc, families)
+	}
+
+	return families.sorted(), nil
+}
+
+func (m compiledMapping) labelPairs(selected match) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(m.labels))
+	for _, l := range m.labels {
+		var value string
+		if l.key {
+			if len(selected.keys) > 0 {
+				value = selected.keys[len(selected.keys)-1]
+			}
+		} else if v, ok := l.value.first(selected.value); ok {
+			value, _ = stringValue(v)
+		}
+
+		if value == "" {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{
+			Name:  proto.String(l.name),
+			Value: proto.String(value),
+		})
+	}
+	return pairs
+}
+
+// families collects metrics by name, skipping metrics with a label set that
+// was already added.
+type families struct {
+	byName map[string]*dto.MetricFamily
+	seen   map[string]struct{}
+}
+
+func newFamilies() *families {
+	return &families{
+		byName: map[string]*dto.MetricFamily{},
+		seen:   map[string]struct{}{},
+	}
+}
+
+func (f *families) add(name, help string, metricType dto.MetricType, labels []*dto.LabelPair, value float64) {
+	var id strings.Builder
+	id.WriteString(name)
+	for _, l := range labels {
+		id.WriteString("\xff" + l.GetName() + "\xff" + l.GetValue())
+	}
+	if _, ok := f.seen[id.String()]; ok {
+		return
+	}
+	f.seen[id.String()] = struct{}{}
+
+	family, ok := f.byName[name]
+	if !ok {
+		family = &dto.MetricFamily{
+			Name: proto.String(name),
+			Help: proto.String(help),
+			Type: metricType.Enum(),
+		}
+		f.byName[name] = family
+	}
+
+	metric := &dto.Metric{Label: labels}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: proto.Float64(value)}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: proto.Float64(value)}
+	default:
+		metric.Untyped = &dto.Untyped{Value: proto.Float64(value)}
+	}
+	family.Metric = append(family.Metric, metric)
+}
+
+func (f *families) sorted() []*dto.MetricFamily {
+	sorted := make([]*dto.MetricFamily, 0, len(f.byName))
+	for _, family := range f.byName {
+		sorted = append(sorted, family)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+	return sorted
+}
@@ -0,0 +1,154 @@
+package jsonmetrics_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Converter", func() {
+	var convert = func(doc string, mappings ...jsonmetrics.Mapping) map[string]*dto.MetricFamily {
+		c, err := jsonmetrics.NewConverter("", mappings)
+		Expect(err).ToNot(HaveOccurred())
+
+		families, err := c.Convert(strings.NewReader(doc))
+		Expect(err).ToNot(HaveOccurred())
+
+		byName := map[string]*dto.MetricFamily{}
+		for _, f := range families {
+			byName[f.GetName()] = f
+		}
+		return byName
+	}
+
+	It("converts the selected value", func() {
+		families := convert(`{"status":{"uptime":42,"healthy":true}}`,
+			jsonmetrics.Mapping{Name: "uptime_seconds", Type: "counter", Path: "$.status.uptime"},
+			jsonmetrics.Mapping{Name: "healthy", Path: "status.healthy"},
+		)
+
+		Expect(families).To(HaveLen(2))
+		Expect(families["uptime_seconds"].GetType()).To(Equal(dto.MetricType_COUNTER))
+		Expect(families["uptime_seconds"].GetMetric()[0].GetCounter().GetValue()).To(Equal(42.0))
+		Expect(families["healthy"].GetType()).To(Equal(dto.MetricType_GAUGE))
+		Expect(families["healthy"].GetMetric()[0].GetGauge().GetValue()).To(Equal(1.0))
+	})
+
+	It("converts every value matched by wildcards", func() {
+		families := convert(`{"pools":[{"name":"eden","usage":{"used":10}},{"name":"old","usage":{"used":"20"}}]}`,
+			jsonmetrics.Mapping{
+				Name:   "jvm_memory_used_bytes",
+				Path:   "$.pools[*]",
+				Value:  "usage.used",
+				Labels: map[string]string{"pool": "name"},
+			},
+		)
+
+		metrics := families["jvm_memory_used_bytes"].GetMetric()
+		Expect(metrics).To(HaveLen(2))
+		Expect(labels(metrics[0])).To(Equal(map[string]string{"pool": "eden"}))
+		Expect(metrics[0].GetGauge().GetValue()).To(Equal(10.0))
+		Expect(labels(metrics[1])).To(Equal(map[string]string{"pool": "old"}))
+		Expect(metrics[1].GetGauge().GetValue()).To(Equal(20.0))
+	})
+
+	It("uses the key matched by the last wildcard for @key labels", func() {
+		families := convert(`{"requests":{"GET":5,"POST":3}}`,
+			jsonmetrics.Mapping{
+				Name:   "requests_total",
+				Type:   "counter",
+				Path:   "$.requests.*",
+				Labels: map[string]string{"method": "@key"},
+			},
+		)
+
+		metrics := families["requests_total"].GetMetric()
+		Expect(metrics).To(HaveLen(2))
+		Expect(labels(metrics[0])).To(Equal(map[string]string{"method": "GET"}))
+		Expect(metrics[0].GetCounter().GetValue()).To(Equal(5.0))
+		Expect(labels(metrics[1])).To(Equal(map[string]string{"method": "POST"}))
+	})
+
+	It("supports indexes and quoted keys", func() {
+		families := convert(`{"a.b":[1,2,3]}`,
+			jsonmetrics.Mapping{Name: "second", Path: `$['a.b'][1]`},
+		)
+
+		Expect(families["second"].GetMetric()[0].GetGauge().GetValue()).To(Equal(2.0))
+	})
+
+	It("skips values that are not numeric and duplicate label sets", func() {
+		families := convert(`{"items":[{"id":"a","v":"n/a"},{"id":"b","v":1},{"id":"b","v":2}]}`,
+			jsonmetrics.Mapping{
+				Name:   "item_value",
+				Path:   "$.items[*]",
+				Value:  "v",
+				Labels: map[string]string{"id": "id"},
+			},
+		)
+
+		metrics := families["item_value"].GetMetric()
+		Expect(metrics).To(HaveLen(1))
+		Expect(labels(metrics[0])).To(Equal(map[string]string{"id": "b"}))
+		Expect(metrics[0].GetGauge().GetValue()).To(Equal(1.0))
+	})
+
+	It("returns an error for invalid JSON", func() {
+		c, err := jsonmetrics.NewConverter("", []jsonmetrics.Mapping{{Name: "value", Path: "$.value"}})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = c.Convert(strings.NewReader("value 1"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("rejects invalid mappings",
+		func(preset string, m jsonmetrics.Mapping) {
+			_, err := jsonmetrics.NewConverter(preset, []jsonmetrics.Mapping{m})
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("unknown preset", "unknown", jsonmetrics.Mapping{Name: "value", Path: "$.value"}),
+		Entry("invalid name", "", jsonmetrics.Mapping{Name: "invalid-name", Path: "$.value"}),
+		Entry("unknown type", "", jsonmetrics.Mapping{Name: "value", Type: "summary", Path: "$.value"}),
+		Entry("missing path", "", jsonmetrics.Mapping{Name: "value"}),
+		Entry("invalid path", "", jsonmetrics.Mapping{Name: "value", Path: "$.values[x]"}),
+		Entry("unterminated key", "", jsonmetrics.Mapping{Name: "value", Path: "$['value"}),
+		Entry("invalid label name", "", jsonmetrics.Mapping{Name: "value", Path: "$.value", Labels: map[string]string{"in-valid": "id"}}),
+		Entry("type of a preset metric", jsonmetrics.PresetMemStats, jsonmetrics.Mapping{Name: "go_memstats_alloc_bytes", Type: "counter", Path: "$.value"}),
+	)
+
+	It("rejects mappings with the same name and different types", func() {
+		_, err := jsonmetrics.NewConverter("", []jsonmetrics.Mapping{
+			{Name: "value", Type: "gauge", Path: "$.a"},
+			{Name: "value", Type: "counter", Path: "$.b"},
+		})
+		Expect(err).To(MatchError(`metric "value": type counter conflicts with type gauge of another mapping`))
+	})
+
+	It("merges mappings with the same name and type", func() {
+		families := convert(`{"heap":{"area":"heap","used":1},"non_heap":{"area":"nonheap","used":2}}`,
+			jsonmetrics.Mapping{Name: "memory_used_bytes", Path: "$.heap", Value: "used", Labels: map[string]string{"area": "area"}},
+			jsonmetrics.Mapping{Name: "memory_used_bytes", Path: "$.non_heap", Value: "used", Labels: map[string]string{"area": "area"}},
+		)
+
+		metrics := families["memory_used_bytes"].GetMetric()
+		Expect(metrics).To(HaveLen(2))
+		Expect(labels(metrics[0])).To(Equal(map[string]string{"area": "heap"}))
+		Expect(labels(metrics[1])).To(Equal(map[string]string{"area": "nonheap"}))
+	})
+
+	It("requires a preset or mappings", func() {
+		_, err := jsonmetrics.NewConverter("", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func labels(m *dto.Metric) map[string]string {
+	labels := map[string]string{}
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return labels
+}
@@ -0,0 +1,13 @@
+package jsonmetrics_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestJSONMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "JSON Metrics Suite")
+}
@@ -0,0 +1,193 @@
+package jsonmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyLabelValue is the label value selector for the key or index matched by
+// the last wildcard of the metric path.
+const keyLabelValue = "@key"
+
+// path selects values in a decoded JSON document. It is parsed from a
+// JSONPath style selector such as $.pools[*].used.
+type path []segment
+
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsePath parses a selector made of .key, .*, [n], [*] and ['key']
+// segments. The leading $ and, for relative selectors, the leading dot are
+// optional. An empty selector selects the value itself.
+func parsePath(s string) (path, error) {
+	rest := strings.TrimPrefix(s, "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+
+	var p path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			rest = rest[end:]
+
+			switch key {
+			case "":
+				return nil, fmt.Errorf("empty key in path %q", s)
+			case "*":
+				p = append(p, segment{wildcard: true})
+			default:
+				p = append(p, segment{key: key})
+			}
+		case '[':
+			seg, n, err := parseBracket(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s in path %q", err, s)
+			}
+			p = append(p, seg)
+			rest = rest[n:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %q", rest[0], s)
+		}
+	}
+
+	return p, nil
+}
+
+// parseBracket parses a bracket segment at the start of s and returns the
+// number of bytes it spans.
+func parseBracket(s string) (segment, int, error) {
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		end := strings.IndexByte(s[2:], s[1])
+		if end < 0 || len(s) < end+4 || s[end+3] != ']' {
+			return segment{}, 0, fmt.Errorf("unterminated key")
+		}
+		return segment{key: s[2 : end+2]}, end + 4, nil
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return segment{}, 0, fmt.Errorf("missing ]")
+	}
+
+	content := s[1:end]
+	if content == "*" {
+		return segment{wildcard: true}, end + 1, nil
+	}
+
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return segment{}, 0, fmt.Errorf("invalid index %q", content)
+	}
+	return segment{index: index, isIndex: true}, end + 1, nil
+}
+
+// match is a value selected by a path together with the keys or indexes
+// matched by its wildcards.
+type match struct {
+	value any
+	keys  []string
+}
+
+// find returns all values selected by the path. Object keys matched by
+// wildcards are visited in sorted order.
+func (p path) find(v any) []match {
+	var matches []match
+	p.walk(v, nil, func(m match) {
+		matches = append(matches, m)
+	})
+	return matches
+}
+
+func (p path) walk(v any, keys []string, fn func(match)) {
+	if len(p) == 0 {
+		fn(match{value: v, keys: keys})
+		return
+	}
+
+	seg, rest := p[0], p[1:]
+	switch v := v.(type) {
+	case map[string]any:
+		if seg.wildcard {
+			objectKeys := make([]string, 0, len(v))
+			for k := range v {
+				objectKeys = append(objectKeys, k)
+			}
+			sort.Strings(objectKeys)
+			for _, k := range objectKeys {
+				rest.walk(v[k], appendKey(keys, k), fn)
+			}
+			return
+		}
+		if child, ok := v[seg.key]; ok && !seg.isIndex {
+			rest.walk(child, keys, fn)
+		}
+	case []any:
+		if seg.wildcard {
+			for i, child := range v {
+				rest.walk(child, appendKey(keys, strconv.Itoa(i)), fn)
+			}
+			return
+		}
+		if seg.isIndex && seg.index < len(v) {
+			rest.walk(v[seg.index], keys, fn)
+		}
+	}
+}
+
+func appendKey(keys []string, k string) []string {
+	return append(keys[:len(keys):len(keys)], k)
+}
+
+// first returns the first value selected by the path.
+func (p path) first(v any) (any, bool) {
+	matches := p.find(v)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0].value, true
+}
+
+// numberValue converts numbers, booleans and numeric strings to a float.
+func numberValue(v any) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// stringValue converts scalar values to a label value.
+func stringValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,125 @@
+package jsonmetrics
+
+import (
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// PresetMemStats converts the memstats of the Go expvar endpoint to
+	// the go_memstats metrics of the Prometheus Go client.
+	PresetMemStats = "memstats"
+
+	// PresetExpvar converts the memstats and all other numeric variables of
+	// the Go expvar endpoint. Top level numbers become expvar_<name> and
+	// maps of numbers expvar_<name> with a key label.
+	PresetExpvar = "expvar"
+)
+
+func memStatsMappings() []Mapping {
+	gauge := func(name, field, help string) Mapping {
+		return Mapping{Name: name, Help: help, Type: MetricTypeGauge, Path: "$.memstats." + field}
+	}
+	counter := func(name, field, help string) Mapping {
+		return Mapping{Name: name, Help: help, Type: MetricTypeCounter, Path: "$.memstats." + field}
+	}
+
+	return []Mapping{
+		gauge("go_memstats_alloc_bytes", "Alloc", "Number of bytes allocated and still in use."),
+		counter("go_memstats_alloc_bytes_total", "TotalAlloc", "Total number of bytes allocated, even if freed."),
+		gauge("go_memstats_sys_bytes", "Sys", "Number of bytes obtained from system."),
+		counter("go_memstats_lookups_total", "Lookups", "Total number of pointer lookups."),
+		counter("go_memstats_mallocs_total", "Mallocs", "Total number of mallocs."),
+		counter("go_memstats_frees_total", "Frees", "Total number of frees."),
+		gauge("go_memstats_heap_alloc_bytes", "HeapAlloc", "Number of heap bytes allocated and still in use."),
+		gauge("go_memstats_heap_sys_bytes", "HeapSys", "Number of heap bytes obtained from system."),
+		gauge("go_memstats_heap_idle_bytes", "HeapIdle", "Number of heap bytes waiting to be used."),
+		gauge("go_memstats_heap_inuse_bytes", "HeapInuse", "Number of heap bytes that are in use."),
+		gauge("go_memstats_heap_released_bytes", "HeapReleased", "Number of heap bytes released to OS."),
+		gauge("go_memstats_heap_objects", "HeapObjects", "Number of allocated objects."),
+		gauge("go_memstats_stack_inuse_bytes", "StackInuse", "Number of bytes in use by the stack allocator."),
+		gauge("go_memstats_stack_sys_bytes", "StackSys", "Number of bytes obtained from system for stack allocator."),
+		gauge("go_memstats_mspan_inuse_bytes", "MSpanInuse", "Number of bytes in use by mspan structures."),
+		gauge("go_memstats_mspan_sys_bytes", "MSpanSys", "Number of bytes used for mspan structures obtained from system."),
+		gauge("go_memstats_mcache_inuse_bytes", "MCacheInuse", "Number of bytes in use by mcache structures."),
+		gauge("go_memstats_mcache_sys_bytes", "MCacheSys", "Number of bytes used for mcache structures obtained from system."),
+		gauge("go_memstats_buck_hash_sys_bytes", "BuckHashSys", "Number of bytes used by the profiling bucket hash table."),
+		gauge("go_memstats_gc_sys_bytes", "GCSys", "Number of bytes used for garbage collection system metadata."),
+		gauge("go_memstats_other_sys_bytes", "OtherSys", "Number of bytes used for other system allocations."),
+		gauge("go_memstats_next_gc_bytes", "NextGC", "Number of heap bytes when next garbage collection will take place."),
+		gauge("go_memstats_last_gc_time_nanoseconds", "LastGC", "Time of the last garbage collection in nanoseconds since 1970."),
+		counter("go_memstats_gc_pause_nanoseconds_total", "PauseTotalNs", "Total time spent in garbage collection pauses in nanoseconds."),
+		counter("go_memstats_gc_completed_total", "NumGC", "Total number of completed garbage collection cycles."),
+		gauge("go_memstats_gc_cpu_fraction", "GCCPUFraction", "The fraction of this program's available CPU time used by the GC since the program started."),
+	}
+}
+
+// addExpvars adds the numeric top level variables other than memstats and
+// maps of numbers as untyped metrics.
+func addExpvars(doc any, families *families) {
+	vars, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+
+	// Variables are added in order so that the first of several names that
+	// sanitize to the same metric name wins on every conversion.
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v := vars[name]
+		if name == "memstats" || name == "cmdline" {
+			continue
+		}
+
+		metricName := "expvar_" + sanitize(name)
+		if !model.IsValidLegacyMetricName(metricName) {
+			continue
+		}
+
+		if _, ok := v.(string); ok {
+			continue
+		}
+		if value, ok := numberValue(v); ok {
+			families.add(metricName, "Go expvar "+name, dto.MetricType_UNTYPED, nil, value)
+			continue
+		}
+
+		entries, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, m := range (path{{wildcard: true}}).find(entries) {
+			if _, ok := m.value.(string); ok {
+				continue
+			}
+			value, ok := numberValue(m.value)
+			if !ok {
+				continue
+			}
+			families.add(metricName, "Go expvar "+name, dto.MetricType_UNTYPED, []*dto.LabelPair{{
+				Name:  proto.String("key"),
+				Value: proto.String(m.keys[0]),
+			}}, value)
+		}
+	}
+}
+
+// sanitize replaces all characters that are not allowed in metric names
+// with underscores.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,73 @@
+package jsonmetrics_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const expvarDoc = `{
+	"cmdline": ["/var/vcap/packages/app/app"],
+	"requests": 12,
+	"version": "1.2.3",
+	"errors": {"timeout": 2, "refused": 1},
+	"memstats": {"Alloc": 1024, "TotalAlloc": 4096, "NumGC": 3, "GCCPUFraction": 0.01}
+}`
+
+var _ = Describe("Presets", func() {
+	var convert = func(preset string) map[string]*dto.MetricFamily {
+		c, err := jsonmetrics.NewConverter(preset, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		families, err := c.Convert(strings.NewReader(expvarDoc))
+		Expect(err).ToNot(HaveOccurred())
+
+		byName := map[string]*dto.MetricFamily{}
+		for _, f := range families {
+			byName[f.GetName()] = f
+		}
+		return byName
+	}
+
+	It("converts memstats", func() {
+		families := convert(jsonmetrics.PresetMemStats)
+
+		Expect(families).To(HaveLen(4))
+		Expect(families["go_memstats_alloc_bytes"].GetMetric()[0].GetGauge().GetValue()).To(Equal(1024.0))
+		Expect(families["go_memstats_alloc_bytes_total"].GetMetric()[0].GetCounter().GetValue()).To(Equal(4096.0))
+		Expect(families["go_memstats_gc_completed_total"].GetMetric()[0].GetCounter().GetValue()).To(Equal(3.0))
+		Expect(families["go_memstats_gc_cpu_fraction"].GetMetric()[0].GetGauge().GetValue()).To(Equal(0.01))
+	})
+
+	It("converts numeric expvars and memstats", func() {
+		families := convert(jsonmetrics.PresetExpvar)
+
+		Expect(families).To(HaveKey("go_memstats_alloc_bytes"))
+		Expect(families).ToNot(HaveKey("expvar_version"))
+		Expect(families).ToNot(HaveKey("expvar_cmdline"))
+
+		Expect(families["expvar_requests"].GetType()).To(Equal(dto.MetricType_UNTYPED))
+		Expect(families["expvar_requests"].GetMetric()[0].GetUntyped().GetValue()).To(Equal(12.0))
+
+		errors := families["expvar_errors"].GetMetric()
+		Expect(errors).To(HaveLen(2))
+		Expect(labels(errors[0])).To(Equal(map[string]string{"key": "refused"}))
+		Expect(errors[0].GetUntyped().GetValue()).To(Equal(1.0))
+		Expect(labels(errors[1])).To(Equal(map[string]string{"key": "timeout"}))
+	})
+
+	It("converts the first of the expvars with the same metric name", func() {
+		c, err := jsonmetrics.NewConverter(jsonmetrics.PresetExpvar, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		for range 10 {
+			families, err := c.Convert(strings.NewReader(`{"queue_depth": 1, "queue.depth": 2, "queue-depth": 3}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(families).To(HaveLen(1))
+			Expect(families[0].GetMetric()[0].GetUntyped().GetValue()).To(Equal(3.0))
+		}
+	})
+})
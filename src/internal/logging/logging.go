@@ -0,0 +1,321 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelPath is the path of the endpoint that reads and sets the log level.
+const LevelPath = "/debug/log-level"
+
+// RepeatInterval is the interval in which a warning or error with the same
+// message is logged at most once.
+const RepeatInterval = 10 * time.Second
+
+// Config holds the log settings shared by all commands. Commands nest it
+// under the log key of their config file.
+type Config struct {
+	Level  string `env:"LOG_LEVEL, report" yaml:"level"`
+	Format string `env:"LOG_FORMAT, report" yaml:"format"`
+}
+
+// DefaultConfig logs records of level info and above as JSON.
+func DefaultConfig() Config {
+	return Config{
+		Level:  "info",
+		Format: "json",
+	}
+}
+
+// Validate returns an error if the level or the format is unknown.
+func (c Config) Validate() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return fmt.Errorf("LOG_LEVEL (log.level): %s", err)
+	}
+	if err := ValidateFormat(c.Format); err != nil {
+		return fmt.Errorf("LOG_FORMAT (log.format): %s", err)
+	}
+	return nil
+}
+
+// NewFromConfig returns a logger writing to w and the level variable that
+// controls it, initially set to the configured level.
+func NewFromConfig(w io.Writer, c Config) (*slog.Logger, *slog.LevelVar, error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	l, _ := ParseLevel(c.Level)
+	level := &slog.LevelVar{}
+	level.Set(l)
+
+	logger, err := New(w, c.Format, level)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logger, level, nil
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, must be one of debug, info, warn or error", s)
+	}
+}
+
+// ValidateFormat returns an error unless format is json or logfmt.
+func ValidateFormat(format string) error {
+	switch format {
+	case "json", "logfmt":
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, must be json or logfmt", format)
+	}
+}
+
+// New returns a logger that writes records of at least the given level to
+// w in the given format. Repeated warnings and errors are rate limited.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if format == "logfmt" {
+		h = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(NewRateLimitHandler(h, RepeatInterval)), nil
+}
+
+// Fatal logs msg as an error and exits the process.
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Discard returns a logger that drops all records.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// RateLimitHandler passes on the first warning or error with a given message
+// and drops records with the same message for an interval. Records about
+// different things, as told by their key attributes, are limited separately.
+// The next record passed on reports the number of dropped records in the
+// suppressed field. Once a message was not seen for an interval, its entry
+// is removed and the last dropped record is passed on with the number of
+// dropped records, so counts are only lost if nothing is logged anymore.
+type RateLimitHandler struct {
+	next     slog.Handler
+	interval time.Duration
+	state    *rateLimitState
+
+	// attrs are the key attributes added with WithAttrs.
+	attrs []slog.Attr
+}
+
+// keyAttrs are the attributes that identify what a record is about.
+var keyAttrs = map[string]struct{}{
+	"source_id": {},
+	"target":    {},
+	"file":      {},
+	"error":     {},
+}
+
+type rateLimitState struct {
+	mu       sync.Mutex
+	messages map[string]*repeat
+	pruned   time.Time
+}
+
+type repeat struct {
+	last       time.Time
+	seen       time.Time
+	suppressed int
+
+	// handler and record are the last dropped record and the handler it
+	// was passed to.
+	handler slog.Handler
+	record  slog.Record
+}
+
+func NewRateLimitHandler(next slog.Handler, interval time.Duration) *RateLimitHandler {
+	return &RateLimitHandler{
+		next:     next,
+		interval: interval,
+		state: &rateLimitState{
+			messages: make(map[string]*repeat),
+		},
+	}
+}
+
+func (h *RateLimitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RateLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	suppressed, ok, pruned := h.allow(r)
+	for _, rep := range pruned {
+		rec := rep.record.Clone()
+		rec.AddAttrs(slog.Int("suppressed", rep.suppressed))
+		if err := rep.handler.Handle(ctx, rec); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// allow returns whether r is passed on and how many records it drops
+// before. It also returns the entries with dropped records that were pruned.
+func (h *RateLimitHandler) allow(r slog.Record) (int, bool, []*repeat) {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	pruned := h.prune(r.Time)
+
+	key := h.key(r)
+	rep, ok := h.state.messages[key]
+	if !ok {
+		h.state.messages[key] = &repeat{last: r.Time, seen: r.Time}
+		return 0, true, pruned
+	}
+	rep.seen = r.Time
+
+	if r.Time.Sub(rep.last) < h.interval {
+		rep.suppressed++
+		rep.handler = h.next
+		rep.record = r.Clone()
+		return 0, false, pruned
+	}
+
+	suppressed := rep.suppressed
+	rep.last = r.Time
+	rep.suppressed = 0
+	rep.record = slog.Record{}
+	return suppressed, true, pruned
+}
+
+// prune removes the entries of messages not seen for an interval, at most
+// once per interval. It must be called with the lock held.
+func (h *RateLimitHandler) prune(now time.Time) []*repeat {
+	if now.Sub(h.state.pruned) < h.interval {
+		return nil
+	}
+	h.state.pruned = now
+
+	var pruned []*repeat
+	for key, rep := range h.state.messages {
+		if now.Sub(rep.seen) < h.interval {
+			continue
+		}
+		delete(h.state.messages, key)
+		if rep.suppressed > 0 {
+			pruned = append(pruned, rep)
+		}
+	}
+	return pruned
+}
+
+// key returns the message and the values of the key attributes of r.
+func (h *RateLimitHandler) key(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	add := func(a slog.Attr) bool {
+		if _, ok := keyAttrs[a.Key]; ok {
+			b.WriteString("\x00" + a.Key + "=" + a.Value.String())
+		}
+		return true
+	}
+
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	return b.String()
+}
+
+func (h *RateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	keys := slices.Clone(h.attrs)
+	for _, a := range attrs {
+		if _, ok := keyAttrs[a.Key]; ok {
+			keys = append(keys, a)
+		}
+	}
+	return &RateLimitHandler{next: h.next.WithAttrs(attrs), interval: h.interval, state: h.state, attrs: keys}
+}
+
+func (h *RateLimitHandler) WithGroup(name string) slog.Handler {
+	return &RateLimitHandler{next: h.next.WithGroup(name), interval: h.interval, state: h.state, attrs: h.attrs}
+}
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler serves the current log level on GET and sets it on PUT with
+// a JSON body such as {"level":"debug"}.
+func LevelHandler(level *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body levelBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %s", err), http.StatusBadRequest)
+				return
+			}
+			l, err := ParseLevel(body.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level.Set(l)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(levelBody{Level: strings.ToLower(level.Level().String())})
+	})
+}
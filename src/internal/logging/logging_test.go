@@ -0,0 +1,230 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("New", func() {
+	It("writes JSON records with fields", func() {
+		buf := &bytes.Buffer{}
+		logger, err := logging.New(buf, "json", slog.LevelInfo)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Warn("unable to write envelope", "source_id", "app", "error", errors.New("boom"))
+
+		var record map[string]any
+		Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("level", "WARN"))
+		Expect(record).To(HaveKeyWithValue("msg", "unable to write envelope"))
+		Expect(record).To(HaveKeyWithValue("source_id", "app"))
+		Expect(record).To(HaveKeyWithValue("error", "boom"))
+	})
+
+	It("writes logfmt records", func() {
+		buf := &bytes.Buffer{}
+		logger, err := logging.New(buf, "logfmt", slog.LevelInfo)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Info("started", "queue", "metrics.scrape_targets")
+
+		Expect(buf.String()).To(ContainSubstring(`level=INFO msg=started queue=metrics.scrape_targets`))
+	})
+
+	It("drops records below the level", func() {
+		buf := &bytes.Buffer{}
+		level := &slog.LevelVar{}
+		logger, err := logging.New(buf, "json", level)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Debug("detail")
+		Expect(buf.Len()).To(BeZero())
+
+		level.Set(slog.LevelDebug)
+		logger.Debug("detail")
+		Expect(buf.String()).To(ContainSubstring("detail"))
+	})
+
+	It("returns an error for unknown formats", func() {
+		_, err := logging.New(&bytes.Buffer{}, "xml", slog.LevelInfo)
+		Expect(err).To(MatchError(`unknown log format "xml", must be json or logfmt`))
+	})
+})
+
+var _ = Describe("NewFromConfig", func() {
+	It("sets the level variable to the configured level", func() {
+		buf := &bytes.Buffer{}
+		logger, level, err := logging.NewFromConfig(buf, logging.Config{Level: "warn", Format: "logfmt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(level.Level()).To(Equal(slog.LevelWarn))
+
+		logger.Info("started")
+		Expect(buf.Len()).To(BeZero())
+
+		level.Set(slog.LevelInfo)
+		logger.Info("started")
+		Expect(buf.String()).To(ContainSubstring("msg=started"))
+	})
+
+	It("returns an error for invalid settings", func() {
+		_, _, err := logging.NewFromConfig(&bytes.Buffer{}, logging.Config{Level: "verbose", Format: "json"})
+		Expect(err).To(MatchError(HavePrefix("LOG_LEVEL (log.level): unknown log level")))
+
+		_, _, err = logging.NewFromConfig(&bytes.Buffer{}, logging.Config{Level: "info", Format: "xml"})
+		Expect(err).To(MatchError(HavePrefix("LOG_FORMAT (log.format): unknown log format")))
+	})
+})
+
+var _ = Describe("ParseLevel", func() {
+	It("parses levels case insensitively", func() {
+		Expect(logging.ParseLevel("DEBUG")).To(Equal(slog.LevelDebug))
+		Expect(logging.ParseLevel("info")).To(Equal(slog.LevelInfo))
+		Expect(logging.ParseLevel("warn")).To(Equal(slog.LevelWarn))
+		Expect(logging.ParseLevel("error")).To(Equal(slog.LevelError))
+	})
+
+	It("returns an error for unknown levels", func() {
+		_, err := logging.ParseLevel("verbose")
+		Expect(err).To(MatchError(`unknown log level "verbose", must be one of debug, info, warn or error`))
+	})
+})
+
+var _ = Describe("RateLimitHandler", func() {
+	var (
+		buf     *bytes.Buffer
+		handler *logging.RateLimitHandler
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		handler = logging.NewRateLimitHandler(slog.NewTextHandler(buf, nil), time.Minute)
+		now = time.Now()
+	})
+
+	var handle = func(t time.Time, level slog.Level, msg string) {
+		Expect(handler.Handle(context.Background(), slog.NewRecord(t, level, msg, 0))).To(Succeed())
+	}
+
+	var lines = func() []string {
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	It("drops repeated errors within the interval", func() {
+		handle(now, slog.LevelError, "unable to write envelope")
+		handle(now.Add(time.Second), slog.LevelError, "unable to write envelope")
+		handle(now.Add(2*time.Second), slog.LevelWarn, "unable to write envelope")
+		handle(now.Add(time.Second), slog.LevelError, "other")
+
+		Expect(lines()).To(HaveLen(2))
+	})
+
+	It("reports the number of dropped records", func() {
+		handle(now, slog.LevelError, "unable to write envelope")
+		handle(now.Add(time.Second), slog.LevelError, "unable to write envelope")
+		handle(now.Add(2*time.Second), slog.LevelError, "unable to write envelope")
+		handle(now.Add(time.Minute), slog.LevelError, "unable to write envelope")
+
+		Expect(lines()).To(HaveLen(2))
+		Expect(lines()[1]).To(HaveSuffix("suppressed=2"))
+	})
+
+	It("reports the dropped records of messages that are no longer logged", func() {
+		handle(now, slog.LevelError, "unable to write envelope")
+		handle(now.Add(time.Second), slog.LevelError, "unable to write envelope")
+		handle(now.Add(2*time.Second), slog.LevelError, "unable to write envelope")
+		handle(now.Add(2*time.Minute), slog.LevelError, "other")
+		handle(now.Add(2*time.Minute), slog.LevelError, "unable to write envelope")
+
+		Expect(lines()).To(HaveLen(4))
+		Expect(lines()[1]).To(And(ContainSubstring("unable to write envelope"), HaveSuffix("suppressed=2")))
+		Expect(lines()[2]).To(ContainSubstring("other"))
+		Expect(lines()[3]).ToNot(ContainSubstring("suppressed"))
+	})
+
+	It("does not limit info records", func() {
+		handle(now, slog.LevelInfo, "reloaded configuration")
+		handle(now, slog.LevelInfo, "reloaded configuration")
+
+		Expect(lines()).To(HaveLen(2))
+	})
+
+	It("shares the limit with derived handlers", func() {
+		logger := slog.New(handler)
+		logger.Error("unable to write envelope")
+		logger.With("component", "ingress").Error("unable to write envelope")
+
+		Expect(lines()).To(HaveLen(1))
+	})
+
+	It("limits records about different source ids separately", func() {
+		logger := slog.New(handler)
+		logger.Warn("scrape failed", "source_id", "app-a", "target", "10.0.0.1:9090")
+		logger.Warn("scrape failed", "source_id", "app-b", "target", "10.0.0.2:9090")
+		logger.Warn("scrape failed", "source_id", "app-a", "target", "10.0.0.1:9090")
+		logger.With("source_id", "app-c").Warn("scrape failed")
+		logger.With("source_id", "app-c").Warn("scrape failed")
+
+		Expect(lines()).To(HaveLen(3))
+		Expect(lines()[0]).To(ContainSubstring("source_id=app-a"))
+		Expect(lines()[1]).To(ContainSubstring("source_id=app-b"))
+		Expect(lines()[2]).To(ContainSubstring("source_id=app-c"))
+	})
+})
+
+var _ = Describe("LevelHandler", func() {
+	var (
+		level   *slog.LevelVar
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		level = &slog.LevelVar{}
+		handler = logging.LevelHandler(level)
+	})
+
+	var request = func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, logging.LevelPath, strings.NewReader(body)))
+		return rec
+	}
+
+	It("returns the level", func() {
+		rec := request(http.MethodGet, "")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level":"info"}`))
+	})
+
+	It("sets the level", func() {
+		rec := request(http.MethodPut, `{"level":"debug"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level":"debug"}`))
+		Expect(level.Level()).To(Equal(slog.LevelDebug))
+	})
+
+	It("rejects unknown levels", func() {
+		rec := request(http.MethodPut, `{"level":"verbose"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(level.Level()).To(Equal(slog.LevelInfo))
+	})
+
+	It("rejects other methods", func() {
+		rec := request(http.MethodPost, `{"level":"debug"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
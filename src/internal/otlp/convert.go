@@ -0,0 +1,205 @@
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+const (
+	serviceNameAttribute       = "service.name"
+	serviceInstanceIDAttribute = "service.instance.id"
+)
+
+// Writer stores the converted data points.
+type Writer interface {
+	WriteCounter(s collector.Series, value float64) error
+	WriteGauge(s collector.Series, value float64) error
+	WriteHistogram(s collector.Series, count uint64, sum float64, buckets map[float64]uint64) error
+}
+
+// converter writes the data points of OTLP resource metrics. It returns the
+// number of data points that were rejected.
+type converter struct {
+	writer          Writer
+	defaultSourceID string
+}
+
+func (c converter) convert(resourceMetrics []*metricspb.ResourceMetrics) int64 {
+	var rejected int64
+	for _, rm := range resourceMetrics {
+		resourceTags := attributes(rm.GetResource().GetAttributes(), nil)
+
+		sourceID := c.defaultSourceID
+		if name, ok := resourceTags[serviceNameAttribute]; ok && name != "" {
+			sourceID = name
+		}
+		instanceID := resourceTags[serviceInstanceIDAttribute]
+		delete(resourceTags, serviceNameAttribute)
+		delete(resourceTags, serviceInstanceIDAttribute)
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				rejected += c.convertMetric(m, collector.Series{
+					SourceID:   sourceID,
+					InstanceID: instanceID,
+					Name:       m.GetName(),
+					Tags:       resourceTags,
+				})
+			}
+		}
+	}
+
+	return rejected
+}
+
+func (c converter) convertMetric(m *metricspb.Metric, series collector.Series) int64 {
+	var rejected int64
+	write := func(attrs []*commonpb.KeyValue, flags uint32, w func(collector.Series) error) {
+		if flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+			return
+		}
+
+		s := series
+		s.Tags = attributes(attrs, series.Tags)
+		if err := w(s); err != nil {
+			rejected++
+		}
+	}
+
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			write(dp.GetAttributes(), dp.GetFlags(), func(s collector.Series) error {
+				return c.writer.WriteGauge(s, numberValue(dp))
+			})
+		}
+	case *metricspb.Metric_Sum:
+		if data.Sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return int64(len(data.Sum.GetDataPoints()))
+		}
+		for _, dp := range data.Sum.GetDataPoints() {
+			write(dp.GetAttributes(), dp.GetFlags(), func(s collector.Series) error {
+				if data.Sum.GetIsMonotonic() {
+					return c.writer.WriteCounter(s, numberValue(dp))
+				}
+				return c.writer.WriteGauge(s, numberValue(dp))
+			})
+		}
+	case *metricspb.Metric_Histogram:
+		if data.Histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return int64(len(data.Histogram.GetDataPoints()))
+		}
+		for _, dp := range data.Histogram.GetDataPoints() {
+			write(dp.GetAttributes(), dp.GetFlags(), func(s collector.Series) error {
+				return c.writer.WriteHistogram(s, dp.GetCount(), dp.GetSum(), explicitBuckets(dp))
+			})
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		if data.ExponentialHistogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return int64(len(data.ExponentialHistogram.GetDataPoints()))
+		}
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			write(dp.GetAttributes(), dp.GetFlags(), func(s collector.Series) error {
+				return c.writer.WriteHistogram(s, dp.GetCount(), dp.GetSum(), exponentialBuckets(dp))
+			})
+		}
+	case *metricspb.Metric_Summary:
+		return int64(len(data.Summary.GetDataPoints()))
+	}
+
+	return rejected
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+// attributes converts the attributes to tags, overriding the given tags.
+// Attributes with array, map or byte values are ignored.
+func attributes(attrs []*commonpb.KeyValue, tags map[string]string) map[string]string {
+	converted := make(map[string]string, len(tags)+len(attrs))
+	for k, v := range tags {
+		converted[k] = v
+	}
+
+	for _, attr := range attrs {
+		switch v := attr.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			converted[attr.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			converted[attr.GetKey()] = strconv.FormatBool(v.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			converted[attr.GetKey()] = strconv.FormatInt(v.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			converted[attr.GetKey()] = strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+		}
+	}
+
+	return converted
+}
+
+// explicitBuckets converts the per bucket counts of a histogram to
+// cumulative counts by upper bound. The +Inf bucket is implied by the count.
+func explicitBuckets(dp *metricspb.HistogramDataPoint) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(dp.GetExplicitBounds()))
+
+	var cumulative uint64
+	counts := dp.GetBucketCounts()
+	for i, bound := range dp.GetExplicitBounds() {
+		if i < len(counts) {
+			cumulative += counts[i]
+		}
+		buckets[bound] = cumulative
+	}
+
+	return buckets
+}
+
+// exponentialBuckets converts the buckets of an exponential histogram to
+// cumulative counts by upper bound. Bucket i of the positive range holds
+// values in (base^i, base^(i+1)] and bucket i of the negative range the
+// same values negated.
+func exponentialBuckets(dp *metricspb.ExponentialHistogramDataPoint) map[float64]uint64 {
+	base := math.Pow(2, math.Pow(2, -float64(dp.GetScale())))
+
+	type bucket struct {
+		upperBound float64
+		count      uint64
+	}
+	var ordered []bucket
+
+	negative := dp.GetNegative()
+	for i := len(negative.GetBucketCounts()) - 1; i >= 0; i-- {
+		index := float64(negative.GetOffset()) + float64(i)
+		ordered = append(ordered, bucket{-math.Pow(base, index), negative.GetBucketCounts()[i]})
+	}
+
+	ordered = append(ordered, bucket{dp.GetZeroThreshold(), dp.GetZeroCount()})
+
+	positive := dp.GetPositive()
+	for i, count := range positive.GetBucketCounts() {
+		index := float64(positive.GetOffset()) + float64(i)
+		ordered = append(ordered, bucket{math.Pow(base, index+1), count})
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].upperBound < ordered[j].upperBound
+	})
+
+	buckets := make(map[float64]uint64, len(ordered))
+	var cumulative uint64
+	for _, b := range ordered {
+		cumulative += b.count
+		buckets[b.upperBound] = cumulative
+	}
+
+	return buckets
+}
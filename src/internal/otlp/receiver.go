@@ -0,0 +1,141 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	protobufContentType = "application/x-protobuf"
+	jsonContentType     = "application/json"
+
+	// maxRequestSize matches the maximum message size of the gRPC ingress.
+	maxRequestSize = 10 * 1024 * 1024
+)
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+// Receiver accepts OTLP metrics over gRPC and HTTP. Cumulative sums,
+// gauges, histograms and exponential histograms are written to the writer.
+// The service.name resource attribute is used as source id and
+// service.instance.id as instance id, all other resource and data point
+// attributes become tags. Data points with delta temporality and summaries
+// are rejected.
+type Receiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	converter converter
+	log       *slog.Logger
+
+	received metrics.Counter
+	rejected metrics.Counter
+}
+
+// NewReceiver returns a Receiver that writes to w. Data points without a
+// service.name resource attribute use defaultSourceID.
+func NewReceiver(w Writer, defaultSourceID string, m metricsRegistry, log *slog.Logger) *Receiver {
+	return &Receiver{
+		converter: converter{
+			writer:          w,
+			defaultSourceID: defaultSourceID,
+		},
+		log: log,
+		received: m.NewCounter(
+			"otlp_ingress",
+			"Total number of OTLP metrics export requests received by the agent.",
+		),
+		rejected: m.NewCounter(
+			"otlp_rejected_data_points",
+			"Total number of OTLP data points that could not be converted.",
+		),
+	}
+}
+
+// Export implements the OTLP MetricsService.
+func (r *Receiver) Export(_ context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	return r.export(req), nil
+}
+
+func (r *Receiver) export(req *colmetricspb.ExportMetricsServiceRequest) *colmetricspb.ExportMetricsServiceResponse {
+	r.received.Add(1)
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	rejected := r.converter.convert(req.GetResourceMetrics())
+	if rejected > 0 {
+		r.rejected.Add(float64(rejected))
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       "only cumulative sums, gauges, histograms and exponential histograms are supported",
+		}
+	}
+
+	return resp
+}
+
+// ServeHTTP implements OTLP/HTTP for metrics with protobuf and JSON
+// encoding.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	var (
+		unmarshal func([]byte, proto.Message) error
+		marshal   func(proto.Message) ([]byte, error)
+	)
+	switch contentType {
+	case protobufContentType:
+		unmarshal, marshal = proto.Unmarshal, proto.Marshal
+	case jsonContentType:
+		unmarshal, marshal = protojson.Unmarshal, protojson.Marshal
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := io.Reader(http.MaxBytesReader(w, req.Body, maxRequestSize))
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxRequestSize)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exportReq := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := unmarshal(data, exportReq); err != nil {
+		r.log.Warn("failed to parse OTLP metrics", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respData, err := marshal(r.export(exportReq))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(respData)
+}
@@ -0,0 +1,291 @@
+package otlp_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/collector"
+	"code.cloudfoundry.org/metrics-discovery/internal/otlp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Receiver", func() {
+	var (
+		writer   *spyWriter
+		metrics  *metrichelpers.SpyMetricsRegistry
+		receiver *otlp.Receiver
+	)
+
+	BeforeEach(func() {
+		writer = &spyWriter{}
+		metrics = metrichelpers.NewMetricsRegistry()
+		receiver = otlp.NewReceiver(writer, "otlp", metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	})
+
+	var export = func(ms ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceResponse {
+		resp, err := receiver.Export(context.Background(), exportRequest(ms...))
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("maps resource attributes to the series", func() {
+		export(gaugeMetric("queue.depth", 3))
+
+		Expect(writer.gauges).To(ConsistOf(point{
+			series: collector.Series{
+				SourceID:   "checkout",
+				InstanceID: "checkout-0",
+				Name:       "queue.depth",
+				Tags:       map[string]string{"deployment.environment": "prod", "queue": "orders"},
+			},
+			value: 3,
+		}))
+		Expect(metrics.GetMetricValue("otlp_ingress", nil)).To(Equal(1.0))
+	})
+
+	It("uses the default source id without a service.name", func() {
+		req := exportRequest(gaugeMetric("queue.depth", 3))
+		req.ResourceMetrics[0].Resource = nil
+
+		_, err := receiver.Export(context.Background(), req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.gauges[0].series.SourceID).To(Equal("otlp"))
+	})
+
+	It("converts monotonic cumulative sums to counters", func() {
+		export(sumMetric("requests", true, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, 12))
+
+		Expect(writer.counters).To(HaveLen(1))
+		Expect(writer.counters[0].value).To(Equal(12.0))
+	})
+
+	It("converts non-monotonic sums to gauges", func() {
+		export(sumMetric("connections", false, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, 4))
+
+		Expect(writer.gauges).To(HaveLen(1))
+		Expect(writer.gauges[0].value).To(Equal(4.0))
+	})
+
+	It("converts histograms with cumulative buckets", func() {
+		export(&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					Count:          6,
+					Sum:            proto.Float64(12),
+					ExplicitBounds: []float64{1, 5},
+					BucketCounts:   []uint64{1, 2, 3},
+				}},
+			}},
+		})
+
+		Expect(writer.histograms).To(HaveLen(1))
+		Expect(writer.histograms[0].count).To(Equal(uint64(6)))
+		Expect(writer.histograms[0].sum).To(Equal(12.0))
+		Expect(writer.histograms[0].buckets).To(Equal(map[float64]uint64{1: 1, 5: 3}))
+	})
+
+	It("converts exponential histograms to buckets", func() {
+		export(&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.ExponentialHistogramDataPoint{{
+					Count:     7,
+					Sum:       proto.Float64(10),
+					Scale:     0,
+					ZeroCount: 1,
+					Positive:  &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 0, BucketCounts: []uint64{2, 3}},
+					Negative:  &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 1, BucketCounts: []uint64{1}},
+				}},
+			}},
+		})
+
+		Expect(writer.histograms).To(HaveLen(1))
+		Expect(writer.histograms[0].buckets).To(Equal(map[float64]uint64{
+			-2: 1,
+			0:  2,
+			2:  4,
+			4:  7,
+		}))
+	})
+
+	It("rejects delta temporality and summaries", func() {
+		resp := export(
+			sumMetric("requests", true, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, 1),
+			&metricspb.Metric{
+				Name: "latency",
+				Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+					DataPoints: []*metricspb.SummaryDataPoint{{Count: 1}},
+				}},
+			},
+		)
+
+		Expect(resp.GetPartialSuccess().GetRejectedDataPoints()).To(Equal(int64(2)))
+		Expect(metrics.GetMetricValue("otlp_rejected_data_points", nil)).To(Equal(2.0))
+		Expect(writer.counters).To(BeEmpty())
+	})
+
+	It("rejects data points that can not be written", func() {
+		writer.err = errors.New("conflict")
+
+		resp := export(gaugeMetric("queue.depth", 3))
+		Expect(resp.GetPartialSuccess().GetRejectedDataPoints()).To(Equal(int64(1)))
+	})
+
+	It("skips data points without a recorded value", func() {
+		m := gaugeMetric("queue.depth", 3)
+		m.GetGauge().DataPoints[0].Flags = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+
+		export(m)
+		Expect(writer.gauges).To(BeEmpty())
+	})
+
+	Describe("OTLP/HTTP", func() {
+		var post = func(body []byte, header http.Header) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+			req.Header = header
+			rec := httptest.NewRecorder()
+			receiver.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("accepts protobuf", func() {
+			body, err := proto.Marshal(exportRequest(gaugeMetric("queue.depth", 3)))
+			Expect(err).ToNot(HaveOccurred())
+
+			rec := post(body, http.Header{"Content-Type": {"application/x-protobuf"}})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/x-protobuf"))
+			Expect(writer.gauges).To(HaveLen(1))
+		})
+
+		It("accepts gzipped json", func() {
+			body, err := protojson.Marshal(exportRequest(gaugeMetric("queue.depth", 3)))
+			Expect(err).ToNot(HaveOccurred())
+
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, err = gz.Write(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gz.Close()).To(Succeed())
+
+			rec := post(buf.Bytes(), http.Header{
+				"Content-Type":     {"application/json"},
+				"Content-Encoding": {"gzip"},
+			})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(writer.gauges).To(HaveLen(1))
+		})
+
+		It("rejects unsupported content types", func() {
+			rec := post([]byte("{}"), http.Header{"Content-Type": {"text/plain"}})
+			Expect(rec.Code).To(Equal(http.StatusUnsupportedMediaType))
+		})
+
+		It("rejects invalid bodies", func() {
+			rec := post([]byte("not protobuf"), http.Header{"Content-Type": {"application/x-protobuf"}})
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
+
+type point struct {
+	series  collector.Series
+	value   float64
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+type spyWriter struct {
+	counters   []point
+	gauges     []point
+	histograms []point
+	err        error
+}
+
+func (w *spyWriter) WriteCounter(s collector.Series, value float64) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.counters = append(w.counters, point{series: s, value: value})
+	return nil
+}
+
+func (w *spyWriter) WriteGauge(s collector.Series, value float64) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.gauges = append(w.gauges, point{series: s, value: value})
+	return nil
+}
+
+func (w *spyWriter) WriteHistogram(s collector.Series, count uint64, sum float64, buckets map[float64]uint64) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.histograms = append(w.histograms, point{series: s, count: count, sum: sum, buckets: buckets})
+	return nil
+}
+
+func exportRequest(ms ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{
+					stringAttribute("service.name", "checkout"),
+					stringAttribute("service.instance.id", "checkout-0"),
+					stringAttribute("deployment.environment", "prod"),
+				},
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: ms}},
+		}},
+	}
+}
+
+func gaugeMetric(name string, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{stringAttribute("queue", "orders")},
+				Value:      &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+func sumMetric(name string, monotonic bool, temporality metricspb.AggregationTemporality, value float64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			IsMonotonic:            monotonic,
+			AggregationTemporality: temporality,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			}},
+		}},
+	}
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
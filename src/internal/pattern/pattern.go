@@ -0,0 +1,79 @@
+package pattern
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Matcher reports whether a value matches a pattern.
+type Matcher func(string) bool
+
+// Compile returns a Matcher for the pattern. Patterns are globs unless they
+// are wrapped in slashes, in which case they are regular expressions that
+// match any part of a value. Globs match the whole value with the syntax of
+// path.Match, so * and ? do not match a slash.
+func Compile(pattern string) (Matcher, error) {
+	return compile(pattern, false)
+}
+
+// CompileFull is like Compile but regular expressions have to match the whole
+// value, as if they were enclosed in ^(?:...)$.
+func CompileFull(pattern string) (Matcher, error) {
+	return compile(pattern, true)
+}
+
+func compile(pattern string, full bool) (Matcher, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr := pattern[1 : len(pattern)-1]
+		if full {
+			expr = "^(?:" + expr + ")$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%s: %s", pattern, err)
+	}
+	return func(v string) bool {
+		matched, _ := path.Match(pattern, v)
+		return matched
+	}, nil
+}
+
+// CompileAll compiles each of the patterns with Compile.
+func CompileAll(patterns []string) ([]Matcher, error) {
+	return compileAll(patterns, Compile)
+}
+
+// CompileAllFull compiles each of the patterns with CompileFull.
+func CompileAllFull(patterns []string) ([]Matcher, error) {
+	return compileAll(patterns, CompileFull)
+}
+
+func compileAll(patterns []string, compile func(string) (Matcher, error)) ([]Matcher, error) {
+	var matchers []Matcher
+	for _, p := range patterns {
+		m, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// MatchAny reports whether any of the matchers matches the value.
+func MatchAny(matchers []Matcher, value string) bool {
+	for _, m := range matchers {
+		if m(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package pattern_test
+
+import (
+	"code.cloudfoundry.org/metrics-discovery/internal/pattern"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pattern", func() {
+	It("matches globs", func() {
+		m, err := pattern.Compile("app-*")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m("app-1")).To(BeTrue())
+		Expect(m("gorouter")).To(BeFalse())
+	})
+
+	It("matches regular expressions wrapped in slashes", func() {
+		m, err := pattern.Compile("/^tmp_[0-9]+$/")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m("tmp_123")).To(BeTrue())
+		Expect(m("tmp_abc")).To(BeFalse())
+	})
+
+	It("matches regular expressions against any part of a value", func() {
+		m, err := pattern.Compile("/tmp_[0-9]+/")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m("my_tmp_123_metric")).To(BeTrue())
+	})
+
+	It("matches full regular expressions against the whole value", func() {
+		m, err := pattern.CompileFull("/tenant-a|tenant-b/")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m("tenant-a")).To(BeTrue())
+		Expect(m("tenant-b")).To(BeTrue())
+		Expect(m("evil-tenant-a-x")).To(BeFalse())
+		Expect(m("tenant-b-x")).To(BeFalse())
+	})
+
+	It("does not match slashes with glob wildcards", func() {
+		m, err := pattern.CompileFull("spiffe://cluster/*")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m("spiffe://cluster/app")).To(BeTrue())
+		Expect(m("spiffe://cluster/ns/app")).To(BeFalse())
+
+		m, err = pattern.CompileFull("/spiffe://cluster/.*/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m("spiffe://cluster/ns/app")).To(BeTrue())
+	})
+
+	It("returns an error for invalid patterns", func() {
+		_, err := pattern.Compile("[")
+		Expect(err).To(HaveOccurred())
+
+		_, err = pattern.Compile("/(/")
+		Expect(err).To(HaveOccurred())
+
+		_, err = pattern.CompileAll([]string{"valid", "["})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("matches any of the matchers", func() {
+		matchers, err := pattern.CompileAll([]string{"a", "b*"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(pattern.MatchAny(matchers, "a")).To(BeTrue())
+		Expect(pattern.MatchAny(matchers, "bc")).To(BeTrue())
+		Expect(pattern.MatchAny(matchers, "c")).To(BeFalse())
+		Expect(pattern.MatchAny(nil, "a")).To(BeFalse())
+	})
+})
@@ -0,0 +1,140 @@
+package push
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+const pathPrefix = "/metrics/job/"
+
+// Handler implements the push API of the Prometheus Pushgateway. Metrics
+// are pushed to /metrics/job/<job>{/<label>/<value>} in the text or
+// protobuf exposition format. Label values can be base64url encoded by
+// appending @base64 to the label name. PUT replaces the group, POST
+// replaces the families with the same names and DELETE removes the group.
+// Bodies larger than maxBodySize bytes are rejected.
+type Handler struct {
+	store       *Store
+	maxBodySize int64
+	log         *slog.Logger
+}
+
+// NewHandler returns a Handler that pushes to the given store.
+func NewHandler(store *Store, maxBodySize int64, log *slog.Logger) *Handler {
+	return &Handler{
+		store:       store,
+		maxBodySize: maxBodySize,
+		log:         log,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	labels, err := groupingLabels(r.URL.EscapedPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		families, err := decodeFamilies(http.MaxBytesReader(w, r.Body, h.maxBodySize), r.Header)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.log.Warn("pushed metrics are too large", "error", err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			h.log.Warn("failed to parse pushed metrics", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		h.store.Push(labels, families, r.Method == http.MethodPut)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		h.store.Delete(labels)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func decodeFamilies(body io.Reader, header http.Header) ([]*dto.MetricFamily, error) {
+	var families []*dto.MetricFamily
+	decoder := expfmt.NewDecoder(body, expfmt.ResponseFormat(header))
+	for {
+		f := &dto.MetricFamily{}
+		err := decoder.Decode(f)
+		if errors.Is(err, io.EOF) {
+			return families, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		families = append(families, f)
+	}
+}
+
+// groupingLabels parses the grouping labels from a path of the form
+// /metrics/job/<job>{/<label>/<value>}.
+func groupingLabels(path string) (map[string]string, error) {
+	if !strings.HasPrefix(path, pathPrefix) {
+		return nil, fmt.Errorf("path must start with %s", pathPrefix)
+	}
+
+	segments := strings.Split("job/"+strings.TrimPrefix(path, pathPrefix), "/")
+	if len(segments)%2 != 0 {
+		return nil, errors.New("grouping labels must be name and value pairs")
+	}
+
+	labels := make(map[string]string, len(segments)/2)
+	for i := 0; i < len(segments); i += 2 {
+		name, value, err := groupingLabel(segments[i], segments[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("duplicate grouping label %s", name)
+		}
+		labels[name] = value
+	}
+
+	if labels["job"] == "" {
+		return nil, errors.New("job name is required")
+	}
+
+	return labels, nil
+}
+
+func groupingLabel(name, value string) (string, string, error) {
+	value, err := url.PathUnescape(value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid grouping label value: %s", err)
+	}
+
+	if encodedName, ok := strings.CutSuffix(name, "@base64"); ok {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value for grouping label %s: %s", encodedName, err)
+		}
+		name, value = encodedName, string(decoded)
+	}
+
+	if !model.LabelName(name).IsValidLegacy() {
+		return "", "", fmt.Errorf("invalid grouping label name %q", name)
+	}
+
+	return name, value, nil
+}
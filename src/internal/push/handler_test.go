@@ -0,0 +1,107 @@
+package push_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/push"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/expfmt"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		store   *push.Store
+		handler *push.Handler
+	)
+
+	BeforeEach(func() {
+		store = push.NewStore(time.Minute)
+		handler = push.NewHandler(store, 1024, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	})
+
+	var do = func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("accepts metrics in the text format", func() {
+		rec := do(http.MethodPut, "/metrics/job/backup/instance/0", "# TYPE last_success gauge\nlast_success 10\n", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetMetric()[0].GetGauge().GetValue()).To(Equal(10.0))
+		Expect(labels(families[0].GetMetric()[0])).To(Equal(map[string]string{
+			"job":      "backup",
+			"instance": "0",
+		}))
+	})
+
+	It("rejects bodies larger than the maximum size", func() {
+		body := "# TYPE last_success gauge\n" + strings.Repeat("last_success 10\n", 100)
+		rec := do(http.MethodPut, "/metrics/job/backup", body, nil)
+		Expect(rec.Code).To(Equal(http.StatusRequestEntityTooLarge))
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(BeEmpty())
+	})
+
+	It("accepts metrics in the protobuf format", func() {
+		var body bytes.Buffer
+		enc := expfmt.NewEncoder(&body, expfmt.NewFormat(expfmt.TypeProtoDelim))
+		Expect(enc.Encode(gaugeFamily("last_success", 10))).To(Succeed())
+
+		req := httptest.NewRequest(http.MethodPost, "/metrics/job/backup", &body)
+		req.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		Expect(store.Gather()).To(ConsistOf(HaveField("GetName()", "last_success")))
+	})
+
+	It("decodes base64 encoded grouping label values", func() {
+		rec := do(http.MethodPut, "/metrics/job/backup/path@base64/L3Zhci92Y2Fw", "last_success 10\n", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(labels(families[0].GetMetric()[0])).To(HaveKeyWithValue("path", "/var/vcap"))
+	})
+
+	It("deletes groups", func() {
+		do(http.MethodPut, "/metrics/job/backup", "last_success 10\n", nil)
+
+		rec := do(http.MethodDelete, "/metrics/job/backup", "", nil)
+		Expect(rec.Code).To(Equal(http.StatusAccepted))
+		Expect(store.Gather()).To(BeEmpty())
+	})
+
+	DescribeTable("rejects invalid pushes",
+		func(method, path, body string, status int) {
+			rec := do(method, path, body, nil)
+			Expect(rec.Code).To(Equal(status))
+			Expect(store.Gather()).To(BeEmpty())
+		},
+		Entry("missing job", http.MethodPut, "/metrics/job/", "a 1\n", http.StatusBadRequest),
+		Entry("unpaired label", http.MethodPut, "/metrics/job/backup/instance", "a 1\n", http.StatusBadRequest),
+		Entry("invalid label name", http.MethodPut, "/metrics/job/backup/in-stance/0", "a 1\n", http.StatusBadRequest),
+		Entry("invalid base64", http.MethodPut, "/metrics/job/backup/path@base64/!!", "a 1\n", http.StatusBadRequest),
+		Entry("invalid body", http.MethodPut, "/metrics/job/backup", "not metrics", http.StatusBadRequest),
+		Entry("wrong path", http.MethodPut, "/metrics", "a 1\n", http.StatusBadRequest),
+		Entry("unsupported method", http.MethodGet, "/metrics/job/backup", "", http.StatusMethodNotAllowed),
+	)
+})
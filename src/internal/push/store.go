@@ -0,0 +1,163 @@
+package push
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// Store holds pushed metric families by their grouping labels until they
+// have not been pushed for the configured time to live. It implements
+// prometheus.Gatherer.
+type Store struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	groups map[string]*group
+}
+
+type group struct {
+	labels   map[string]string
+	families map[string]*dto.MetricFamily
+	lastPush time.Time
+}
+
+// NewStore returns a Store that drops groups that have not been pushed for
+// ttl.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:    ttl,
+		groups: map[string]*group{},
+	}
+}
+
+// Push stores the families for the group identified by labels. If replace
+// is true all families previously pushed for the group are removed,
+// otherwise only families with the same names are replaced.
+func (s *Store) Push(labels map[string]string, families []*dto.MetricFamily, replace bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := groupKey(labels)
+	g, ok := s.groups[key]
+	if !ok || replace {
+		g = &group{
+			labels:   labels,
+			families: map[string]*dto.MetricFamily{},
+		}
+		s.groups[key] = g
+	}
+
+	for _, f := range families {
+		g.families[f.GetName()] = f
+	}
+	g.lastPush = time.Now()
+}
+
+// Delete removes all families of the group identified by labels.
+func (s *Store) Delete(labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.groups, groupKey(labels))
+}
+
+// SetTTL changes how long groups are kept after their last update.
+func (s *Store) SetTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ttl = ttl
+}
+
+// Gather implements prometheus.Gatherer. The grouping labels are added to
+// every pushed metric. Families with the same name are merged unless their
+// types differ, in which case the family pushed for the group sorting
+// first is kept.
+func (s *Store) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tooOld := time.Now().Add(-s.ttl)
+	keys := make([]string, 0, len(s.groups))
+	for key, g := range s.groups {
+		if g.lastPush.Before(tooOld) {
+			delete(s.groups, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	merged := map[string]*dto.MetricFamily{}
+	for _, key := range keys {
+		g := s.groups[key]
+		for name, f := range g.families {
+			m, ok := merged[name]
+			if !ok {
+				m = &dto.MetricFamily{
+					Name: f.Name,
+					Help: f.Help,
+					Type: f.Type,
+					Unit: f.Unit,
+				}
+				merged[name] = m
+			}
+			if m.GetType() != f.GetType() {
+				continue
+			}
+
+			for _, metric := range f.GetMetric() {
+				m.Metric = append(m.Metric, withGroupingLabels(metric, g.labels))
+			}
+		}
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(merged))
+	for _, f := range merged {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	return families, nil
+}
+
+// withGroupingLabels returns a copy of the metric with the grouping labels
+// set, overriding pushed labels with the same name.
+func withGroupingLabels(metric *dto.Metric, labels map[string]string) *dto.Metric {
+	m := proto.Clone(metric).(*dto.Metric)
+
+	pairs := make([]*dto.LabelPair, 0, len(m.GetLabel())+len(labels))
+	for _, l := range m.GetLabel() {
+		if _, ok := labels[l.GetName()]; !ok {
+			pairs = append(pairs, l)
+		}
+	}
+	for name, value := range labels {
+		pairs = append(pairs, &dto.LabelPair{
+			Name:  proto.String(name),
+			Value: proto.String(value),
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	m.Label = pairs
+
+	return m
+}
+
+func groupKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"\xff"+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "\xfe")
+}
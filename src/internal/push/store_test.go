@@ -0,0 +1,125 @@
+package push_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/push"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Store", func() {
+	var store *push.Store
+
+	BeforeEach(func() {
+		store = push.NewStore(time.Minute)
+	})
+
+	It("adds the grouping labels to pushed metrics", func() {
+		store.Push(
+			map[string]string{"job": "backup", "instance": "0"},
+			[]*dto.MetricFamily{gaugeFamily("last_success", 10, "instance", "pushed")},
+			true,
+		)
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetName()).To(Equal("last_success"))
+		Expect(labels(families[0].GetMetric()[0])).To(Equal(map[string]string{
+			"job":      "backup",
+			"instance": "0",
+		}))
+	})
+
+	It("merges families of different groups", func() {
+		store.Push(map[string]string{"job": "backup"}, []*dto.MetricFamily{gaugeFamily("last_success", 10)}, true)
+		store.Push(map[string]string{"job": "rotate"}, []*dto.MetricFamily{gaugeFamily("last_success", 20)}, true)
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetMetric()).To(HaveLen(2))
+	})
+
+	It("replaces the group on put and only families with the same name on post", func() {
+		group := map[string]string{"job": "backup"}
+		store.Push(group, []*dto.MetricFamily{gaugeFamily("a", 1), gaugeFamily("b", 1)}, true)
+		store.Push(group, []*dto.MetricFamily{gaugeFamily("b", 2)}, false)
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(2))
+		Expect(families[1].GetMetric()[0].GetGauge().GetValue()).To(Equal(2.0))
+
+		store.Push(group, []*dto.MetricFamily{gaugeFamily("c", 3)}, true)
+		families, err = store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetName()).To(Equal("c"))
+	})
+
+	It("deletes groups", func() {
+		store.Push(map[string]string{"job": "backup"}, []*dto.MetricFamily{gaugeFamily("a", 1)}, true)
+		store.Delete(map[string]string{"job": "backup"})
+
+		Expect(store.Gather()).To(BeEmpty())
+	})
+
+	It("expires groups that are not pushed within the ttl", func() {
+		store = push.NewStore(50 * time.Millisecond)
+		store.Push(map[string]string{"job": "backup"}, []*dto.MetricFamily{gaugeFamily("a", 1)}, true)
+
+		Expect(store.Gather()).To(HaveLen(1))
+		Eventually(store.Gather).Should(BeEmpty())
+	})
+
+	It("expires groups with a changed ttl", func() {
+		store.SetTTL(50 * time.Millisecond)
+		store.Push(map[string]string{"job": "backup"}, []*dto.MetricFamily{gaugeFamily("a", 1)}, true)
+
+		Eventually(store.Gather).Should(BeEmpty())
+	})
+
+	It("skips families that conflict with the type of another group", func() {
+		counter := gaugeFamily("a", 1)
+		counter.Type = dto.MetricType_COUNTER.Enum()
+		counter.Metric[0].Gauge = nil
+		counter.Metric[0].Counter = &dto.Counter{Value: proto.Float64(1)}
+
+		store.Push(map[string]string{"job": "a"}, []*dto.MetricFamily{gaugeFamily("a", 1)}, true)
+		store.Push(map[string]string{"job": "b"}, []*dto.MetricFamily{counter}, true)
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetType()).To(Equal(dto.MetricType_GAUGE))
+		Expect(families[0].GetMetric()).To(HaveLen(1))
+	})
+})
+
+func gaugeFamily(name string, value float64, labelPairs ...string) *dto.MetricFamily {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i < len(labelPairs); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{
+			Name:  proto.String(labelPairs[i]),
+			Value: proto.String(labelPairs[i+1]),
+		})
+	}
+
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{m},
+	}
+}
+
+func labels(m *dto.Metric) map[string]string {
+	l := map[string]string{}
+	for _, pair := range m.GetLabel() {
+		l[pair.GetName()] = pair.GetValue()
+	}
+	return l
+}
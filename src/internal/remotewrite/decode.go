@@ -0,0 +1,168 @@
+package remotewrite
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Metric types of the remote write MetricMetadata message.
+const (
+	counterMetadataType = 1
+	gaugeMetadataType   = 2
+)
+
+// writeRequest holds the fields of a remote write 1.0 WriteRequest that are
+// stored.
+type writeRequest struct {
+	timeseries []timeseries
+	metadata   map[string]uint64
+}
+
+type timeseries struct {
+	labels     []label
+	samples    []sample
+	histograms int
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+func decodeWriteRequest(b []byte) (*writeRequest, error) {
+	req := &writeRequest{
+		metadata: map[string]uint64{},
+	}
+
+	err := decodeFields(b, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			ts, err := decodeTimeseries(b)
+			if err != nil {
+				return err
+			}
+			req.timeseries = append(req.timeseries, ts)
+		case 3:
+			return decodeMetadata(b, req.metadata)
+		}
+		return nil
+	})
+
+	return req, err
+}
+
+func decodeTimeseries(b []byte) (timeseries, error) {
+	var ts timeseries
+	err := decodeFields(b, func(num protowire.Number, _ uint64, b []byte) error {
+		switch num {
+		case 1:
+			var l label
+			err := decodeFields(b, func(num protowire.Number, _ uint64, b []byte) error {
+				switch num {
+				case 1:
+					l.name = string(b)
+				case 2:
+					l.value = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.labels = append(ts.labels, l)
+		case 2:
+			var s sample
+			err := decodeFields(b, func(num protowire.Number, v uint64, _ []byte) error {
+				switch num {
+				case 1:
+					s.value = math.Float64frombits(v)
+				case 2:
+					s.timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, s)
+		case 4:
+			ts.histograms++
+		}
+		return nil
+	})
+
+	return ts, err
+}
+
+func decodeMetadata(b []byte, metadata map[string]uint64) error {
+	var (
+		metricType uint64
+		familyName string
+	)
+	err := decodeFields(b, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			metricType = v
+		case 2:
+			familyName = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	metadata[familyName] = metricType
+	return nil
+}
+
+// decodeFields calls fn for every field in the protobuf message b with the
+// varint or fixed value or the bytes of length delimited fields.
+func decodeFields(b []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid field tag: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var (
+			v     uint64
+			bytes []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid value for field %d: %s", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if typ == protowire.StartGroupType {
+			continue
+		}
+		if err := fn(num, v, bytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package remotewrite
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"github.com/klauspost/compress/snappy"
+)
+
+const (
+	protobufContentType = "application/x-protobuf"
+	writeRequestV1Proto = "prometheus.WriteRequest"
+
+	// maxRequestSize limits the size of the compressed and the decompressed
+	// request body.
+	maxRequestSize = 10 * 1024 * 1024
+)
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+// Handler implements the receiving side of the Prometheus remote write 1.0
+// protocol. Requests are snappy compressed protobuf WriteRequests. Float
+// samples are written to the store, native histograms are rejected.
+type Handler struct {
+	store *Store
+	log   *slog.Logger
+
+	received metrics.Counter
+	rejected metrics.Counter
+}
+
+// NewHandler returns a Handler that writes to the given store.
+func NewHandler(store *Store, m metricsRegistry, log *slog.Logger) *Handler {
+	return &Handler{
+		store: store,
+		log:   log,
+		received: m.NewCounter(
+			"remote_write_ingress",
+			"Total number of remote write requests received by the agent.",
+		),
+		rejected: m.NewCounter(
+			"remote_write_rejected_series",
+			"Total number of remote write series that could not be stored.",
+		),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "" && contentType != protobufContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if proto, ok := params["proto"]; ok && proto != writeRequestV1Proto {
+		http.Error(w, "only remote write 1.0 is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := snappy.DecodedLen(compressed)
+	if err == nil && n > maxRequestSize {
+		err = snappy.ErrTooLarge
+	}
+	var data []byte
+	if err == nil {
+		data, err = snappy.Decode(nil, compressed)
+	}
+	if err != nil {
+		h.log.Warn("failed to decompress remote write request", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := decodeWriteRequest(data)
+	if err != nil {
+		h.log.Warn("failed to parse remote write request", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.received.Add(1)
+	if rejected := h.store.write(req); rejected > 0 {
+		h.rejected.Add(float64(rejected))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
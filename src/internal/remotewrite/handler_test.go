@@ -0,0 +1,115 @@
+package remotewrite_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/remotewrite"
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	"github.com/klauspost/compress/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		store   *remotewrite.Store
+		spy     *metrichelpers.SpyMetricsRegistry
+		handler *remotewrite.Handler
+	)
+
+	BeforeEach(func() {
+		store = remotewrite.NewStore(time.Minute)
+		spy = metrichelpers.NewMetricsRegistry()
+		handler = remotewrite.NewHandler(store, spy, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	})
+
+	var do = func(method string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/write", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("stores the samples of snappy compressed write requests", func() {
+		rec := do(http.MethodPost, testhelpers.RemoteWriteRequest{
+			Series: []testhelpers.RemoteWriteSeries{
+				{
+					Labels:  map[string]string{"__name__": "queue_depth", "queue": "jobs"},
+					Samples: []testhelpers.RemoteWriteSample{{Value: 3, Timestamp: 1000}},
+				},
+			},
+		}.Compressed(), nil)
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetName()).To(Equal("queue_depth"))
+		Expect(families[0].GetMetric()[0].GetUntyped().GetValue()).To(Equal(3.0))
+
+		Expect(spy.GetMetricValue("remote_write_ingress", nil)).To(Equal(1.0))
+	})
+
+	It("rejects series with only native histograms", func() {
+		rec := do(http.MethodPost, testhelpers.RemoteWriteRequest{
+			Series: []testhelpers.RemoteWriteSeries{
+				{
+					Labels:     map[string]string{"__name__": "request_duration_seconds"},
+					Histograms: 1,
+				},
+				{
+					Labels:  map[string]string{"__name__": "queue_depth"},
+					Samples: []testhelpers.RemoteWriteSample{{Value: 3, Timestamp: 1000}},
+				},
+			},
+		}.Compressed(), nil)
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetName()).To(Equal("queue_depth"))
+
+		Expect(spy.GetMetricValue("remote_write_rejected_series", nil)).To(Equal(1.0))
+	})
+
+	It("returns bad request for bodies that are not snappy compressed", func() {
+		rec := do(http.MethodPost, []byte("not snappy"), nil)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("returns bad request for invalid write requests", func() {
+		rec := do(http.MethodPost, snappy.Encode(nil, []byte{0x0a, 0xff}), nil)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects remote write 2.0 requests", func() {
+		rec := do(http.MethodPost, testhelpers.RemoteWriteRequest{}.Compressed(), http.Header{
+			"Content-Type": []string{"application/x-protobuf;proto=io.prometheus.write.v2.Request"},
+		})
+		Expect(rec.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("rejects unsupported content encodings", func() {
+		rec := do(http.MethodPost, testhelpers.RemoteWriteRequest{}.Compressed(), http.Header{
+			"Content-Encoding": []string{"gzip"},
+		})
+		Expect(rec.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("only allows POST", func() {
+		rec := do(http.MethodGet, nil, nil)
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,198 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// staleNaN is the value Prometheus uses to mark a series as stale.
+const staleNaN uint64 = 0x7ff0000000000002
+
+// Store holds the latest sample of every series received via remote write
+// until it has not been updated for the configured time to live. It
+// implements prometheus.Gatherer.
+type Store struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	series map[string]*storedSeries
+	types  map[string]dto.MetricType
+}
+
+type storedSeries struct {
+	name       string
+	labels     []*dto.LabelPair
+	value      float64
+	timestamp  int64
+	lastUpdate time.Time
+}
+
+// NewStore returns a Store that drops series that have not been updated for
+// ttl.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:    ttl,
+		series: map[string]*storedSeries{},
+		types:  map[string]dto.MetricType{},
+	}
+}
+
+// write stores the latest sample of every series in the request and returns
+// the number of series that were rejected because they have no name or only
+// native histograms.
+func (s *Store) write(req *writeRequest) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, metricType := range req.metadata {
+		switch metricType {
+		case counterMetadataType:
+			s.types[name] = dto.MetricType_COUNTER
+		case gaugeMetadataType:
+			s.types[name] = dto.MetricType_GAUGE
+		default:
+			delete(s.types, name)
+		}
+	}
+
+	var rejected int
+	now := time.Now()
+	for _, ts := range req.timeseries {
+		name, labels := splitName(ts.labels)
+		if name == "" || len(ts.samples) == 0 {
+			rejected++
+			continue
+		}
+
+		latest := ts.samples[0]
+		for _, smp := range ts.samples[1:] {
+			if smp.timestamp >= latest.timestamp {
+				latest = smp
+			}
+		}
+
+		key := seriesKey(name, labels)
+		if math.Float64bits(latest.value) == staleNaN {
+			delete(s.series, key)
+			continue
+		}
+
+		stored, ok := s.series[key]
+		if ok && latest.timestamp < stored.timestamp {
+			stored.lastUpdate = now
+			continue
+		}
+
+		s.series[key] = &storedSeries{
+			name:       name,
+			labels:     labels,
+			value:      latest.value,
+			timestamp:  latest.timestamp,
+			lastUpdate: now,
+		}
+	}
+
+	return rejected
+}
+
+// SetTTL changes how long series are kept after their last update.
+func (s *Store) SetTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ttl = ttl
+}
+
+// Gather implements prometheus.Gatherer. Series are exposed as counters or
+// gauges if the sender provided the type in the metadata and untyped
+// otherwise.
+func (s *Store) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tooOld := time.Now().Add(-s.ttl)
+	families := map[string]*dto.MetricFamily{}
+	for key, series := range s.series {
+		if series.lastUpdate.Before(tooOld) {
+			delete(s.series, key)
+			continue
+		}
+
+		family, ok := families[series.name]
+		if !ok {
+			metricType, ok := s.types[series.name]
+			if !ok {
+				metricType = dto.MetricType_UNTYPED
+			}
+			family = &dto.MetricFamily{
+				Name: proto.String(series.name),
+				Help: proto.String("Metric received via remote write"),
+				Type: metricType.Enum(),
+			}
+			families[series.name] = family
+		}
+
+		m := &dto.Metric{Label: series.labels}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Counter = &dto.Counter{Value: proto.Float64(series.value)}
+		case dto.MetricType_GAUGE:
+			m.Gauge = &dto.Gauge{Value: proto.Float64(series.value)}
+		default:
+			m.Untyped = &dto.Untyped{Value: proto.Float64(series.value)}
+		}
+		family.Metric = append(family.Metric, m)
+	}
+
+	sorted := make([]*dto.MetricFamily, 0, len(families))
+	for _, f := range families {
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+
+	return sorted, nil
+}
+
+// splitName returns the metric name and the remaining labels sorted by
+// name.
+func splitName(labels []label) (string, []*dto.LabelPair) {
+	var name string
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for _, l := range labels {
+		if l.name == "__name__" {
+			name = l.value
+			continue
+		}
+		if l.value == "" {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{
+			Name:  proto.String(l.name),
+			Value: proto.String(l.value),
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+
+	return name, pairs
+}
+
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteString("\xff")
+		b.WriteString(l.GetName())
+		b.WriteString("\xff")
+		b.WriteString(l.GetValue())
+	}
+	return b.String()
+}
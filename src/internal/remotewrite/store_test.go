@@ -0,0 +1,135 @@
+package remotewrite_test
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/remotewrite"
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Store", func() {
+	var write = func(store *remotewrite.Store, req testhelpers.RemoteWriteRequest) {
+		handler := remotewrite.NewHandler(store, metrichelpers.NewMetricsRegistry(), slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(req.Compressed())))
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+	}
+
+	var series = func(value float64, timestamp int64, labels ...string) testhelpers.RemoteWriteSeries {
+		s := testhelpers.RemoteWriteSeries{
+			Labels:  map[string]string{"__name__": "queue_depth"},
+			Samples: []testhelpers.RemoteWriteSample{{Value: value, Timestamp: timestamp}},
+		}
+		for i := 0; i+1 < len(labels); i += 2 {
+			s.Labels[labels[i]] = labels[i+1]
+		}
+		return s
+	}
+
+	var store *remotewrite.Store
+
+	BeforeEach(func() {
+		store = remotewrite.NewStore(time.Minute)
+	})
+
+	It("keeps the latest sample of every series", func() {
+		s := series(1, 1000, "queue", "jobs")
+		s.Samples = append(s.Samples, testhelpers.RemoteWriteSample{Value: 3, Timestamp: 3000})
+		s.Samples = append(s.Samples, testhelpers.RemoteWriteSample{Value: 2, Timestamp: 2000})
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{
+			s,
+			series(5, 1000, "queue", "mail"),
+		}})
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(values(families[0])).To(Equal(map[string]float64{
+			"jobs": 3,
+			"mail": 5,
+		}))
+	})
+
+	It("ignores samples older than the stored sample", func() {
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{series(3, 3000, "queue", "jobs")}})
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{series(1, 1000, "queue", "jobs")}})
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values(families[0])).To(Equal(map[string]float64{"jobs": 3}))
+	})
+
+	It("uses the metric type of the metadata", func() {
+		counter := series(10, 1000)
+		counter.Labels["__name__"] = "requests_total"
+		write(store, testhelpers.RemoteWriteRequest{
+			Series: []testhelpers.RemoteWriteSeries{counter, series(3, 1000)},
+			Types: map[string]uint64{
+				"requests_total": testhelpers.RemoteWriteCounter,
+				"queue_depth":    testhelpers.RemoteWriteGauge,
+			},
+		})
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(HaveLen(2))
+		Expect(families[0].GetName()).To(Equal("queue_depth"))
+		Expect(families[0].GetType()).To(Equal(dto.MetricType_GAUGE))
+		Expect(families[0].GetMetric()[0].GetGauge().GetValue()).To(Equal(3.0))
+		Expect(families[1].GetName()).To(Equal("requests_total"))
+		Expect(families[1].GetType()).To(Equal(dto.MetricType_COUNTER))
+		Expect(families[1].GetMetric()[0].GetCounter().GetValue()).To(Equal(10.0))
+	})
+
+	It("removes series marked as stale", func() {
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{series(3, 1000)}})
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{
+			series(math.Float64frombits(0x7ff0000000000002), 2000),
+		}})
+
+		families, err := store.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(families).To(BeEmpty())
+	})
+
+	It("expires series that were not updated within the ttl", func() {
+		store = remotewrite.NewStore(50 * time.Millisecond)
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{series(3, 1000)}})
+
+		Eventually(func() ([]*dto.MetricFamily, error) {
+			return store.Gather()
+		}).Should(BeEmpty())
+	})
+
+	It("expires series with a changed ttl", func() {
+		store.SetTTL(50 * time.Millisecond)
+		write(store, testhelpers.RemoteWriteRequest{Series: []testhelpers.RemoteWriteSeries{series(3, 1000)}})
+
+		Eventually(func() ([]*dto.MetricFamily, error) {
+			return store.Gather()
+		}).Should(BeEmpty())
+	})
+})
+
+func values(f *dto.MetricFamily) map[string]float64 {
+	values := map[string]float64{}
+	for _, m := range f.GetMetric() {
+		var queue string
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "queue" {
+				queue = l.GetValue()
+			}
+		}
+		values[queue] = m.GetUntyped().GetValue()
+	}
+	return values
+}
@@ -0,0 +1,280 @@
+package scrapeconfig
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+)
+
+const (
+	// EnvelopePolicyDrop drops envelopes with the source id of the scrape
+	// config.
+	EnvelopePolicyDrop = "drop"
+
+	// EnvelopePolicyKeep exposes envelopes with the source id of the scrape
+	// config alongside all other envelopes.
+	EnvelopePolicyKeep = "keep"
+
+	// EnvelopePolicyMerge exposes envelopes with the source id of the scrape
+	// config together with the metrics proxied for that source id.
+	EnvelopePolicyMerge = "merge"
+)
+
+const (
+	// DefaultScrapeTimeout is the timeout of proxied scrapes without a
+	// scrape_timeout.
+	DefaultScrapeTimeout = 5 * time.Second
+
+	// DefaultIdleConnTimeout is the idle timeout of connections to targets
+	// without an idle_conn_timeout.
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+const (
+	// TypePrometheus targets expose metrics in the Prometheus text format.
+	TypePrometheus = "prometheus"
+
+	// TypeJSON targets expose a JSON document that is converted to metrics
+	// with the JSON mappings.
+	TypeJSON = "json"
+)
+
+// Config holds a prom_scraper_config.yml. In addition to the properties
+// supported by prom scraper it holds properties only used by the metrics
+// agent when proxying scrapes.
+type Config struct {
+	scraper.PromScraperConfig `yaml:",inline"`
+
+	// EnvelopePolicy decides what happens to envelopes that have the same
+	// source id as the scrape config. It defaults to EnvelopePolicyDrop.
+	EnvelopePolicy string `yaml:"envelope_policy"`
+
+	// Socket is the path of a Unix domain socket the target is served on.
+	// The port is ignored when it is set.
+	Socket string `yaml:"socket"`
+
+	// Host is the address the target is served on. It defaults to
+	// 127.0.0.1 and has to be an address of a local interface unless it is
+	// allowed explicitly.
+	Host string `yaml:"host"`
+
+	// ScrapeTimeout limits the duration of proxied scrapes. It defaults to
+	// DefaultScrapeTimeout and is lowered to the timeout announced by the
+	// Prometheus server scraping the agent.
+	ScrapeTimeout time.Duration `yaml:"scrape_timeout"`
+
+	// BodySizeLimit is the maximum size of an uncompressed response body.
+	// Zero means no limit.
+	BodySizeLimit ByteSize `yaml:"body_size_limit"`
+
+	// SampleLimit is the maximum number of samples of a scrape. Zero means
+	// no limit.
+	SampleLimit int `yaml:"sample_limit"`
+
+	// LabelLimit is the maximum number of labels of a series. Zero means no
+	// limit.
+	LabelLimit int `yaml:"label_limit"`
+
+	// LabelValueLengthLimit is the maximum length of a label value. Zero
+	// means no limit.
+	LabelValueLengthLimit int `yaml:"label_value_length_limit"`
+
+	// IdleConnTimeout is how long idle keep-alive connections to the target
+	// are kept open between scrapes. It defaults to DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+
+	// DisableKeepAlives closes the connection to the target after every
+	// scrape.
+	DisableKeepAlives bool `yaml:"disable_keep_alives"`
+
+	// BearerTokenFile is the path of a file containing a token sent in the
+	// Authorization header.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+
+	// BasicAuth configures basic authentication.
+	BasicAuth *BasicAuth `yaml:"basic_auth"`
+
+	// OAuth2 configures the OAuth2 client credentials grant.
+	OAuth2 *OAuth2 `yaml:"oauth2"`
+
+	// Type is the format of the scrape target. It defaults to
+	// TypePrometheus.
+	Type string `yaml:"type"`
+
+	// JSON configures the conversion of TypeJSON targets.
+	JSON JSONConfig `yaml:"json"`
+}
+
+// BasicAuth holds the username and the path of a file containing the
+// password for basic authentication.
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// OAuth2 holds the client credentials used to fetch tokens from the token
+// URL.
+type OAuth2 struct {
+	ClientID         string            `yaml:"client_id"`
+	ClientSecretFile string            `yaml:"client_secret_file"`
+	TokenURL         string            `yaml:"token_url"`
+	Scopes           []string          `yaml:"scopes"`
+	EndpointParams   map[string]string `yaml:"endpoint_params"`
+
+	// CAFile is the path of the CA certificate used to verify the token
+	// URL. The system roots are used when it is not set.
+	CAFile string `yaml:"ca_file"`
+
+	// ServerName is the name used to verify the certificate of the token
+	// URL. It defaults to the host of the token URL.
+	ServerName string `yaml:"server_name"`
+
+	// InsecureSkipVerify disables the verification of the certificate of
+	// the token URL.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// JSONConfig holds the preset and the mappings used to convert the JSON
+// document of a target to metrics.
+type JSONConfig struct {
+	Preset  string                `yaml:"preset"`
+	Metrics []jsonmetrics.Mapping `yaml:"metrics"`
+}
+
+// Validate returns an error describing the first invalid property.
+func (c Config) Validate() error {
+	switch c.EnvelopePolicy {
+	case "", EnvelopePolicyDrop, EnvelopePolicyKeep, EnvelopePolicyMerge:
+	default:
+		return fmt.Errorf("unknown envelope_policy %q", c.EnvelopePolicy)
+	}
+
+	if c.ScrapeTimeout < 0 {
+		return fmt.Errorf("negative scrape_timeout")
+	}
+	if c.IdleConnTimeout < 0 {
+		return fmt.Errorf("negative idle_conn_timeout")
+	}
+	if c.SampleLimit < 0 || c.LabelLimit < 0 || c.LabelValueLengthLimit < 0 {
+		return fmt.Errorf("negative limit")
+	}
+
+	if err := c.validateAuth(); err != nil {
+		return err
+	}
+
+	if c.Socket != "" && c.Host != "" {
+		return fmt.Errorf("socket and host are mutually exclusive")
+	}
+	if c.Socket != "" && !filepath.IsAbs(c.Socket) {
+		return fmt.Errorf("socket %q is not an absolute path", c.Socket)
+	}
+	if strings.ContainsAny(c.Host, "/[]") {
+		return fmt.Errorf("invalid host %q", c.Host)
+	}
+
+	switch c.Type {
+	case "", TypePrometheus:
+	case TypeJSON:
+		if _, err := c.JSONConverter(); err != nil {
+			return fmt.Errorf("invalid json: %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+
+	return nil
+}
+
+// ValidatePort returns an error if the config is not served on a socket and
+// does not have a valid port.
+func (c Config) ValidatePort() error {
+	if c.Socket != "" {
+		return nil
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+	return nil
+}
+
+func (c Config) validateAuth() error {
+	var methods int
+	for _, configured := range []bool{c.BearerTokenFile != "", c.BasicAuth != nil, c.OAuth2 != nil} {
+		if configured {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return fmt.Errorf("bearer_token_file, basic_auth and oauth2 are mutually exclusive")
+	}
+
+	if c.BasicAuth != nil && c.BasicAuth.Username == "" {
+		return fmt.Errorf("basic_auth requires a username")
+	}
+
+	if c.OAuth2 != nil {
+		if c.OAuth2.ClientID == "" || c.OAuth2.TokenURL == "" {
+			return fmt.Errorf("oauth2 requires a client_id and a token_url")
+		}
+		if u, err := url.Parse(c.OAuth2.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid oauth2 token_url %q", c.OAuth2.TokenURL)
+		}
+	}
+
+	return nil
+}
+
+// Address returns the host and port used in scrape requests. Requests to
+// targets served on a socket use localhost.
+func (c Config) Address() string {
+	if c.Socket != "" {
+		return "localhost"
+	}
+
+	host := c.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, c.Port)
+}
+
+// GetScrapeTimeout returns the scrape timeout, applying the default.
+func (c Config) GetScrapeTimeout() time.Duration {
+	if c.ScrapeTimeout == 0 {
+		return DefaultScrapeTimeout
+	}
+	return c.ScrapeTimeout
+}
+
+// GetIdleConnTimeout returns the idle connection timeout, applying the
+// default.
+func (c Config) GetIdleConnTimeout() time.Duration {
+	if c.IdleConnTimeout == 0 {
+		return DefaultIdleConnTimeout
+	}
+	return c.IdleConnTimeout
+}
+
+// JSONConverter returns the converter for the JSON document of TypeJSON
+// targets.
+func (c Config) JSONConverter() (*jsonmetrics.Converter, error) {
+	return jsonmetrics.NewConverter(c.JSON.Preset, c.JSON.Metrics)
+}
+
+// GetEnvelopePolicy returns the envelope policy, applying the default.
+func (c Config) GetEnvelopePolicy() string {
+	if c.EnvelopePolicy == "" {
+		return EnvelopePolicyDrop
+	}
+	return c.EnvelopePolicy
+}
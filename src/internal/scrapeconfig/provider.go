@@ -0,0 +1,357 @@
+package scrapeconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// StatusLoaded is the status of files whose config is in use.
+	StatusLoaded = "loaded"
+
+	// StatusStale is the status of files that cannot be read anymore. The
+	// config last loaded from the file stays in use.
+	StatusStale = "stale"
+
+	// StatusUnreadable is the status of files that cannot be read and were
+	// never loaded.
+	StatusUnreadable = "unreadable"
+
+	// StatusInvalid is the status of files that cannot be parsed or contain
+	// an invalid config. They are skipped.
+	StatusInvalid = "invalid"
+)
+
+var statuses = []string{StatusLoaded, StatusStale, StatusUnreadable, StatusInvalid}
+
+// FileStatus is the result of the last load of a scrape config file.
+type FileStatus struct {
+	File     string `json:"file"`
+	Status   string `json:"status"`
+	SourceID string `json:"source_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// LastLoaded is the time the config in use was loaded.
+	LastLoaded time.Time `json:"last_loaded"`
+}
+
+// Provider reads scrape configs from the files matching a list of globs.
+// Files that cannot be loaded are skipped so that they do not affect the
+// configs of other files.
+type Provider struct {
+	globs                 []string
+	defaultScrapeInterval time.Duration
+	allowedHosts          []string
+	interfaceAddrs        func() ([]net.Addr, error)
+	log                   *slog.Logger
+	metrics               metricsRegistry
+
+	mu       sync.Mutex
+	lastGood map[string]loadedConfig
+	status   map[string]FileStatus
+	gauges   map[string]map[string]metrics.Gauge
+}
+
+type loadedConfig struct {
+	config Config
+	loaded time.Time
+}
+
+type metricsRegistry interface {
+	NewGauge(name, helpText string, opts ...metrics.MetricOption) metrics.Gauge
+	RemoveGauge(metrics.Gauge)
+}
+
+type ProviderOption func(*Provider)
+
+func NewProvider(globs []string, defaultScrapeInterval time.Duration, log *slog.Logger, opts ...ProviderOption) *Provider {
+	p := &Provider{
+		globs:                 globs,
+		defaultScrapeInterval: defaultScrapeInterval,
+		interfaceAddrs:        net.InterfaceAddrs,
+		log:                   log,
+		lastGood:              map[string]loadedConfig{},
+		status:                map[string]FileStatus{},
+		gauges:                map[string]map[string]metrics.Gauge{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithAllowedHosts allows scrape configs to use hosts that are not
+// addresses of local interfaces. Entries are host names, IP addresses or
+// CIDR ranges.
+func WithAllowedHosts(hosts []string) ProviderOption {
+	return func(p *Provider) {
+		p.allowedHosts = hosts
+	}
+}
+
+// WithMetrics exposes the load status of every file in the
+// scrape_config_file_status gauge.
+func WithMetrics(m metricsRegistry) ProviderOption {
+	return func(p *Provider) {
+		p.metrics = m
+	}
+}
+
+// Configs returns the scrape configs from all files. Files that cannot be
+// parsed, have an invalid port, a host that is not allowed, invalid
+// properties or a source id used by a previous file are skipped. Files that
+// cannot be read anymore keep the config last loaded from them. The error is
+// always nil and only kept for compatibility with other config providers.
+func (p *Provider) Configs() ([]Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := p.filesForGlobs()
+
+	var (
+		configs   []Config
+		status    = make(map[string]FileStatus, len(files))
+		sourceIDs = map[string]string{}
+	)
+	for _, f := range files {
+		scrapeConfig, fs := p.load(f)
+
+		if fs.Status == StatusLoaded || fs.Status == StatusStale {
+			if other, ok := sourceIDs[scrapeConfig.SourceID]; ok && scrapeConfig.SourceID != "" {
+				p.log.Warn("skipping scrape config with a source_id of another file", "file", f, "source_id", scrapeConfig.SourceID, "other_file", other)
+				fs = FileStatus{
+					File:     f,
+					Status:   StatusInvalid,
+					SourceID: scrapeConfig.SourceID,
+					Error:    fmt.Sprintf("source_id %s is already used by %s", scrapeConfig.SourceID, other),
+				}
+			} else {
+				sourceIDs[scrapeConfig.SourceID] = f
+				configs = append(configs, scrapeConfig)
+			}
+		}
+
+		status[f] = fs
+	}
+
+	for f := range p.lastGood {
+		if _, ok := status[f]; !ok {
+			delete(p.lastGood, f)
+		}
+	}
+	p.status = status
+	p.updateMetrics()
+
+	return configs, nil
+}
+
+// load returns the config of the file and its status. The config is only
+// valid if the file is loaded or stale.
+func (p *Provider) load(f string) (Config, FileStatus) {
+	data, err := os.ReadFile(f)
+	if err != nil {
+		last, ok := p.lastGood[f]
+		if !ok {
+			p.log.Warn("skipping unreadable scrape config", "file", f, "error", err)
+			return Config{}, FileStatus{File: f, Status: StatusUnreadable, Error: err.Error()}
+		}
+
+		p.log.Warn("using last loaded config for unreadable scrape config", "file", f, "source_id", last.config.SourceID, "last_loaded", last.loaded, "error", err)
+		return last.config, FileStatus{
+			File:       f,
+			Status:     StatusStale,
+			SourceID:   last.config.SourceID,
+			Error:      err.Error(),
+			LastLoaded: last.loaded,
+		}
+	}
+
+	scrapeConfig, err := p.parse(f, data)
+	if err != nil {
+		delete(p.lastGood, f)
+		return Config{}, FileStatus{File: f, Status: StatusInvalid, SourceID: scrapeConfig.SourceID, Error: err.Error()}
+	}
+
+	now := time.Now()
+	p.lastGood[f] = loadedConfig{config: scrapeConfig, loaded: now}
+	return scrapeConfig, FileStatus{File: f, Status: StatusLoaded, SourceID: scrapeConfig.SourceID, LastLoaded: now}
+}
+
+func (p *Provider) parse(f string, data []byte) (Config, error) {
+	scrapeConfig, err := Parse(data, p.defaultScrapeInterval)
+	if err != nil {
+		p.log.Warn("skipping unparsable scrape config", "file", f, "error", err)
+		return Config{}, err
+	}
+
+	if err := scrapeConfig.ValidatePort(); err != nil {
+		p.log.Warn("skipping scrape config without a valid port", "file", f, "source_id", scrapeConfig.SourceID, "error", err)
+		return scrapeConfig, err
+	}
+
+	if err := scrapeConfig.Validate(); err != nil {
+		p.log.Warn("skipping invalid scrape config", "file", f, "source_id", scrapeConfig.SourceID, "error", err)
+		return scrapeConfig, err
+	}
+
+	if scrapeConfig.Host != "" && !p.hostAllowed(scrapeConfig.Host) {
+		p.log.Warn("skipping scrape config with a host that is not allowed", "file", f, "source_id", scrapeConfig.SourceID, "host", scrapeConfig.Host)
+		return scrapeConfig, fmt.Errorf("host %s is not allowed", scrapeConfig.Host)
+	}
+
+	return scrapeConfig, nil
+}
+
+// Status returns the status of every file of the last call to Configs,
+// sorted by file.
+func (p *Provider) Status() []FileStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := make([]FileStatus, 0, len(p.status))
+	for _, fs := range p.status {
+		status = append(status, fs)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].File < status[j].File
+	})
+	return status
+}
+
+// updateMetrics sets the gauge of the current status of every file to 1 and
+// all others to 0. The gauges of files that are gone are removed.
+func (p *Provider) updateMetrics() {
+	if p.metrics == nil {
+		return
+	}
+
+	for f, gauges := range p.gauges {
+		if _, ok := p.status[f]; ok {
+			continue
+		}
+
+		for _, g := range gauges {
+			p.metrics.RemoveGauge(g)
+		}
+		delete(p.gauges, f)
+	}
+
+	for f := range p.status {
+		if _, ok := p.gauges[f]; ok {
+			continue
+		}
+
+		p.gauges[f] = make(map[string]metrics.Gauge, len(statuses))
+		for _, s := range statuses {
+			p.gauges[f][s] = p.metrics.NewGauge(
+				"scrape_config_file_status",
+				"Load status of a scrape config file. The gauge of the current status is 1.",
+				metrics.WithMetricLabels(map[string]string{"file": f, "status": s}),
+			)
+		}
+	}
+
+	for f, gauges := range p.gauges {
+		current := p.status[f].Status
+		for s, g := range gauges {
+			if s == current {
+				g.Set(1)
+				continue
+			}
+			g.Set(0)
+		}
+	}
+}
+
+// hostAllowed returns true for localhost, loopback addresses, addresses of
+// local interfaces and hosts that are allowed explicitly.
+func (p *Provider) hostAllowed(host string) bool {
+	ip := net.ParseIP(host)
+	for _, allowed := range p.allowedHosts {
+		if allowed == host {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(allowed); err == nil && ip != nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	if host == "localhost" {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := p.interfaceAddrs()
+	if err != nil {
+		p.log.Error("unable to list interface addresses", "error", err)
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Provider) filesForGlobs() []string {
+	var files []string
+
+	for _, glob := range p.globs {
+		globFiles, err := filepath.Glob(glob)
+		if err != nil {
+			p.log.Error("unable to read scrape configs from glob", "glob", glob, "error", err)
+		}
+
+		files = append(files, globFiles...)
+	}
+
+	return files
+}
+
+// ParseFile reads the scrape config in file and applies the defaults of
+// prom scraper.
+func ParseFile(file string, defaultScrapeInterval time.Duration) (Config, error) {
+	yamlFile, err := os.ReadFile(file)
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read file: %s", err)
+	}
+
+	return Parse(yamlFile, defaultScrapeInterval)
+}
+
+// Parse parses a scrape config and applies the defaults of prom scraper.
+func Parse(data []byte, defaultScrapeInterval time.Duration) (Config, error) {
+	scrapeConfig := Config{
+		PromScraperConfig: scraper.PromScraperConfig{
+			Scheme:         "http",
+			Path:           "/metrics",
+			ScrapeInterval: defaultScrapeInterval,
+		},
+	}
+
+	err := yaml.Unmarshal(data, &scrapeConfig)
+	if err != nil {
+		return Config{}, fmt.Errorf("unmarshal: %v", err)
+	}
+
+	return scrapeConfig, nil
+}
@@ -0,0 +1,390 @@
+package scrapeconfig_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/jsonmetrics"
+	"code.cloudfoundry.org/metrics-discovery/internal/scrapeconfig"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Provider", func() {
+	var (
+		configDir string
+		provider  *scrapeconfig.Provider
+	)
+
+	BeforeEach(func() {
+		configDir = GinkgoT().TempDir()
+		provider = scrapeconfig.NewProvider(
+			[]string{filepath.Join(configDir, "*/prom_scraper_config.yml")},
+			time.Minute,
+			slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+		)
+	})
+
+	It("parses prom scraper configs with defaults", func() {
+		writeScrapeConfig(configDir, "job-1", `---
+port: 9090
+source_id: some-source-id
+instance_id: some-instance-id
+headers:
+  Authorization: some-token
+labels:
+  some: label
+envelope_policy: merge
+`)
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(ConsistOf(scrapeconfig.Config{
+			PromScraperConfig: scraper.PromScraperConfig{
+				Port:           "9090",
+				SourceID:       "some-source-id",
+				InstanceID:     "some-instance-id",
+				Scheme:         "http",
+				Path:           "/metrics",
+				Headers:        map[string]string{"Authorization": "some-token"},
+				Labels:         map[string]string{"some": "label"},
+				ScrapeInterval: time.Minute,
+			},
+			EnvelopePolicy: scrapeconfig.EnvelopePolicyMerge,
+		}))
+	})
+
+	It("parses scrape intervals", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nscrape_interval: 15s\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].ScrapeInterval).To(Equal(15 * time.Second))
+	})
+
+	It("skips configs with an invalid port", func() {
+		writeScrapeConfig(configDir, "job-1", "port: not-a-port\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9090\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Port).To(Equal("9090"))
+	})
+
+	It("skips configs with an unknown envelope policy", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nenvelope_policy: ignore\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(BeEmpty())
+	})
+
+	It("parses json targets", func() {
+		writeScrapeConfig(configDir, "job-1", `port: 9090
+path: /debug/vars
+type: json
+json:
+  preset: expvar
+  metrics:
+  - name: jobs_queued
+    path: $.queue.jobs
+    labels:
+      queue: "@key"
+`)
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Type).To(Equal(scrapeconfig.TypeJSON))
+		Expect(configs[0].JSON.Preset).To(Equal("expvar"))
+		Expect(configs[0].JSON.Metrics).To(ConsistOf(jsonmetrics.Mapping{
+			Name:   "jobs_queued",
+			Path:   "$.queue.jobs",
+			Labels: map[string]string{"queue": "@key"},
+		}))
+	})
+
+	It("skips configs with an unknown type or invalid json mappings", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\ntype: xml\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9090\ntype: json\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9090\ntype: json\njson:\n  metrics:\n  - name: invalid-name\n    path: $.value\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(BeEmpty())
+	})
+
+	It("parses configs for targets on a unix socket without a port", func() {
+		writeScrapeConfig(configDir, "job-1", "socket: /var/vcap/sys/run/db/metrics.sock\n")
+		writeScrapeConfig(configDir, "job-2", "socket: run/db/metrics.sock\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9090\nsocket: /var/vcap/sys/run/db/metrics.sock\nhost: ::1\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Socket).To(Equal("/var/vcap/sys/run/db/metrics.sock"))
+		Expect(configs[0].Address()).To(Equal("localhost"))
+	})
+
+	It("allows hosts of local interfaces", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nhost: ::1\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9090\nhost: localhost\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9090\nhost: 192.0.2.10\n")
+		writeScrapeConfig(configDir, "job-4", "port: 9090\nhost: db.internal\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[0].Address()).To(Equal("[::1]:9090"))
+		Expect(configs[1].Address()).To(Equal("localhost:9090"))
+	})
+
+	It("allows hosts that are allowed explicitly", func() {
+		provider = scrapeconfig.NewProvider(
+			[]string{filepath.Join(configDir, "*/prom_scraper_config.yml")},
+			time.Second,
+			slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+			scrapeconfig.WithAllowedHosts([]string{"192.0.2.0/24", "db.internal"}),
+		)
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nhost: 192.0.2.10\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9090\nhost: db.internal\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9090\nhost: 198.51.100.10\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[0].Address()).To(Equal("192.0.2.10:9090"))
+		Expect(configs[1].Address()).To(Equal("db.internal:9090"))
+	})
+
+	It("parses timeouts and limits", func() {
+		writeScrapeConfig(configDir, "job-1", `port: 9090
+scrape_timeout: 2s
+body_size_limit: 10MB
+sample_limit: 1000
+label_limit: 20
+label_value_length_limit: 200
+`)
+		writeScrapeConfig(configDir, "job-2", "port: 9091\nbody_size_limit: 2048\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9092\nsample_limit: -1\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[0].GetScrapeTimeout()).To(Equal(2 * time.Second))
+		Expect(configs[0].BodySizeLimit).To(Equal(scrapeconfig.ByteSize(10 * 1024 * 1024)))
+		Expect(configs[0].SampleLimit).To(Equal(1000))
+		Expect(configs[0].LabelLimit).To(Equal(20))
+		Expect(configs[0].LabelValueLengthLimit).To(Equal(200))
+		Expect(configs[1].GetScrapeTimeout()).To(Equal(scrapeconfig.DefaultScrapeTimeout))
+		Expect(configs[1].BodySizeLimit).To(Equal(scrapeconfig.ByteSize(2048)))
+	})
+
+	It("parses keep-alive settings", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nidle_conn_timeout: 5m\ndisable_keep_alives: true\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[0].GetIdleConnTimeout()).To(Equal(5 * time.Minute))
+		Expect(configs[0].DisableKeepAlives).To(BeTrue())
+		Expect(configs[1].GetIdleConnTimeout()).To(Equal(scrapeconfig.DefaultIdleConnTimeout))
+		Expect(configs[1].DisableKeepAlives).To(BeFalse())
+	})
+
+	It("parses authentication settings", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nbearer_token_file: /var/vcap/jobs/db/config/token\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\nbasic_auth:\n  username: admin\n  password_file: /var/vcap/jobs/db/config/password\n")
+		writeScrapeConfig(configDir, "job-3", `port: 9092
+oauth2:
+  client_id: metrics-agent
+  client_secret_file: /var/vcap/jobs/db/config/client_secret
+  token_url: https://uaa.service.internal/oauth/token
+  scopes: [metrics.read]
+`)
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(3))
+		Expect(configs[0].BearerTokenFile).To(Equal("/var/vcap/jobs/db/config/token"))
+		Expect(configs[1].BasicAuth).To(Equal(&scrapeconfig.BasicAuth{
+			Username:     "admin",
+			PasswordFile: "/var/vcap/jobs/db/config/password",
+		}))
+		Expect(configs[2].OAuth2).To(Equal(&scrapeconfig.OAuth2{
+			ClientID:         "metrics-agent",
+			ClientSecretFile: "/var/vcap/jobs/db/config/client_secret",
+			TokenURL:         "https://uaa.service.internal/oauth/token",
+			Scopes:           []string{"metrics.read"},
+		}))
+	})
+
+	It("skips configs with invalid authentication settings", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nbearer_token_file: /token\nbasic_auth:\n  username: admin\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\nbasic_auth:\n  password_file: /password\n")
+		writeScrapeConfig(configDir, "job-3", "port: 9092\noauth2:\n  client_id: metrics-agent\n")
+		writeScrapeConfig(configDir, "job-4", "port: 9093\noauth2:\n  client_id: metrics-agent\n  token_url: uaa/oauth/token\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(BeEmpty())
+	})
+
+	It("skips configs with invalid body size limits", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nbody_size_limit: lots\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Port).To(Equal("9091"))
+	})
+
+	It("skips unparsable configs without affecting other files", func() {
+		writeScrapeConfig(configDir, "job-1", "port: [")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\nsource_id: db\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].SourceID).To(Equal("db"))
+	})
+
+	It("skips configs with a source id of a previous file", func() {
+		writeScrapeConfig(configDir, "job-1", "port: 9090\nsource_id: db\n")
+		writeScrapeConfig(configDir, "job-2", "port: 9091\nsource_id: db\n")
+
+		configs, err := provider.Configs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Port).To(Equal("9090"))
+
+		status := provider.Status()
+		Expect(status[1].Status).To(Equal(scrapeconfig.StatusInvalid))
+		Expect(status[1].Error).To(ContainSubstring("source_id db is already used by"))
+	})
+
+	Describe("Status", func() {
+		var (
+			metricsSpy *metrichelpers.SpyMetricsRegistry
+			file       = func(job string) string {
+				return filepath.Join(configDir, job, "prom_scraper_config.yml")
+			}
+			statusValue = func(job, status string) float64 {
+				return metricsSpy.GetMetricValue("scrape_config_file_status", map[string]string{
+					"file":   file(job),
+					"status": status,
+				})
+			}
+		)
+
+		BeforeEach(func() {
+			metricsSpy = metrichelpers.NewMetricsRegistry()
+			provider = scrapeconfig.NewProvider(
+				[]string{filepath.Join(configDir, "*/prom_scraper_config.yml")},
+				time.Minute,
+				slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+				scrapeconfig.WithMetrics(metricsSpy),
+			)
+		})
+
+		It("reports the status of every file", func() {
+			writeScrapeConfig(configDir, "job-1", "port: 9090\nsource_id: db\n")
+			writeScrapeConfig(configDir, "job-2", "port: [")
+
+			_, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+
+			status := provider.Status()
+			Expect(status).To(HaveLen(2))
+			Expect(status[0].File).To(Equal(file("job-1")))
+			Expect(status[0].Status).To(Equal(scrapeconfig.StatusLoaded))
+			Expect(status[0].SourceID).To(Equal("db"))
+			Expect(status[0].LastLoaded).ToNot(BeZero())
+			Expect(status[1].File).To(Equal(file("job-2")))
+			Expect(status[1].Status).To(Equal(scrapeconfig.StatusInvalid))
+			Expect(status[1].Error).ToNot(BeEmpty())
+
+			Expect(statusValue("job-1", scrapeconfig.StatusLoaded)).To(Equal(1.0))
+			Expect(statusValue("job-1", scrapeconfig.StatusInvalid)).To(Equal(0.0))
+			Expect(statusValue("job-2", scrapeconfig.StatusInvalid)).To(Equal(1.0))
+			Expect(statusValue("job-2", scrapeconfig.StatusLoaded)).To(Equal(0.0))
+		})
+
+		It("keeps the last config of files that cannot be read anymore", func() {
+			writeScrapeConfig(configDir, "job-1", "port: 9090\nsource_id: db\n")
+			_, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.Remove(file("job-1"))).To(Succeed())
+			Expect(os.Mkdir(file("job-1"), 0755)).To(Succeed())
+
+			configs, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(HaveLen(1))
+			Expect(configs[0].SourceID).To(Equal("db"))
+
+			status := provider.Status()
+			Expect(status[0].Status).To(Equal(scrapeconfig.StatusStale))
+			Expect(status[0].Error).ToNot(BeEmpty())
+			Expect(statusValue("job-1", scrapeconfig.StatusStale)).To(Equal(1.0))
+			Expect(statusValue("job-1", scrapeconfig.StatusLoaded)).To(Equal(0.0))
+		})
+
+		It("skips files that were never readable", func() {
+			Expect(os.MkdirAll(file("job-1"), 0755)).To(Succeed())
+
+			configs, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(BeEmpty())
+			Expect(provider.Status()[0].Status).To(Equal(scrapeconfig.StatusUnreadable))
+		})
+
+		It("does not keep the last config of files that became invalid", func() {
+			writeScrapeConfig(configDir, "job-1", "port: 9090\nsource_id: db\n")
+			_, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+
+			writeScrapeConfig(configDir, "job-1", "port: [")
+			configs, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(BeEmpty())
+			Expect(provider.Status()[0].Status).To(Equal(scrapeconfig.StatusInvalid))
+		})
+
+		It("clears the status of removed files", func() {
+			writeScrapeConfig(configDir, "job-1", "port: 9090\nsource_id: db\n")
+			_, err := provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.RemoveAll(filepath.Join(configDir, "job-1"))).To(Succeed())
+			_, err = provider.Configs()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(provider.Status()).To(BeEmpty())
+			for _, status := range []string{scrapeconfig.StatusLoaded, scrapeconfig.StatusStale, scrapeconfig.StatusUnreadable, scrapeconfig.StatusInvalid} {
+				Expect(metricsSpy.HasMetric("scrape_config_file_status", map[string]string{
+					"file":   file("job-1"),
+					"status": status,
+				})).To(BeFalse())
+			}
+		})
+	})
+
+	It("defaults the envelope policy to drop", func() {
+		Expect(scrapeconfig.Config{}.GetEnvelopePolicy()).To(Equal(scrapeconfig.EnvelopePolicyDrop))
+	})
+})
+
+func writeScrapeConfig(dir, job, content string) {
+	jobDir := filepath.Join(dir, job)
+	Expect(os.MkdirAll(jobDir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(jobDir, "prom_scraper_config.yml"), []byte(content), 0600)).To(Succeed())
+}
@@ -0,0 +1,13 @@
+package scrapeconfig_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestScrapeConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Scrape Config Suite")
+}
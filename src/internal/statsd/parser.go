@@ -0,0 +1,100 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	counterType      = "c"
+	gaugeType        = "g"
+	timerType        = "ms"
+	histogramType    = "h"
+	distributionType = "d"
+	setType          = "s"
+)
+
+// sample is a single parsed StatsD line.
+type sample struct {
+	name       string
+	metricType string
+	value      float64
+	setValue   string
+	relative   bool
+	sampleRate float64
+	tags       map[string]string
+}
+
+// parseLine parses a line in the StatsD format
+// <name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]. DogStatsD
+// extensions that are not tags are ignored.
+func parseLine(line string) (sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample{}, fmt.Errorf("missing metric name in %q", line)
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return sample{}, fmt.Errorf("missing metric type in %q", line)
+	}
+
+	s := sample{
+		name:       name,
+		metricType: fields[1],
+		sampleRate: 1,
+		tags:       map[string]string{},
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample{}, fmt.Errorf("invalid sample rate in %q", line)
+			}
+			s.sampleRate = rate
+		case strings.HasPrefix(field, "#"):
+			parseTags(field[1:], s.tags)
+		}
+	}
+
+	value := fields[0]
+	switch s.metricType {
+	case setType:
+		if value == "" {
+			return sample{}, fmt.Errorf("missing value in %q", line)
+		}
+		s.setValue = value
+		return s, nil
+	case gaugeType:
+		s.relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	case counterType, timerType, histogramType, distributionType:
+	default:
+		return sample{}, fmt.Errorf("unknown metric type %q in %q", s.metricType, line)
+	}
+
+	var err error
+	s.value, err = strconv.ParseFloat(value, 64)
+	if err != nil {
+		return sample{}, fmt.Errorf("invalid value in %q", line)
+	}
+
+	if s.metricType == counterType && s.value < 0 {
+		return sample{}, errors.New("counters can not be decremented")
+	}
+
+	return s, nil
+}
+
+func parseTags(tags string, into map[string]string) {
+	for _, tag := range strings.Split(tags, ",") {
+		if tag == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(tag, ":")
+		into[name] = value
+	}
+}
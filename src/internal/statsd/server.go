@@ -0,0 +1,380 @@
+package statsd
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrics "code.cloudfoundry.org/go-metric-registry"
+)
+
+// setResetInterval is how long the unique values of a set are counted
+// before the set starts over.
+const setResetInterval = time.Minute
+
+// gaugeExpirationInterval is the time between removals of gauges that were
+// not updated within the gauge TTL.
+const gaugeExpirationInterval = time.Minute
+
+const maxPacketSize = 65535
+
+// DataSetter receives the envelopes converted from StatsD metrics.
+type DataSetter interface {
+	Set(e *loggregator_v2.Envelope)
+}
+
+type metricsRegistry interface {
+	NewCounter(name, helpText string, opts ...metrics.MetricOption) metrics.Counter
+}
+
+// Server listens for StatsD metrics on UDP and TCP and converts them into
+// loggregator v2 envelopes. Counters become counter envelopes with a delta,
+// gauges become gauge envelopes and timers, histograms and distributions
+// become timer envelopes with values in milliseconds. Sets become gauges
+// counting their unique values.
+type Server struct {
+	udpAddr  string
+	tcpAddr  string
+	sourceID string
+	setter   DataSetter
+	log      *slog.Logger
+
+	ingress metrics.Counter
+	invalid metrics.Counter
+
+	maxConnections int
+	maxLineLength  int
+	connections    chan struct{}
+
+	mu           sync.Mutex
+	gauges       map[string]*gauge
+	gaugeTTL     time.Duration
+	gaugesExpire time.Time
+	sets         map[string]map[string]struct{}
+	setsExpire   time.Time
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+}
+
+// gauge is the last value of a gauge, which relative changes apply to.
+type gauge struct {
+	value      float64
+	lastUpdate time.Time
+}
+
+type ServerOption func(*Server)
+
+// WithGaugeTTL sets how long the last value of a gauge is kept for relative
+// changes after its last update. It defaults to an hour.
+func WithGaugeTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.gaugeTTL = ttl
+	}
+}
+
+// WithMaxConnections limits the number of open TCP connections. Further
+// connections are closed right away. It defaults to 100.
+func WithMaxConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConnections = n
+	}
+}
+
+// WithMaxLineLength limits the length of a line received over TCP. A
+// connection sending a longer line is closed. It defaults to 65535 bytes.
+func WithMaxLineLength(n int) ServerOption {
+	return func(s *Server) {
+		s.maxLineLength = n
+	}
+}
+
+// NewServer returns a Server that listens on the given UDP and TCP
+// addresses. An empty address disables the protocol.
+func NewServer(
+	udpAddr string,
+	tcpAddr string,
+	sourceID string,
+	setter DataSetter,
+	m metricsRegistry,
+	log *slog.Logger,
+	opts ...ServerOption,
+) *Server {
+	s := &Server{
+		udpAddr:  udpAddr,
+		tcpAddr:  tcpAddr,
+		sourceID: sourceID,
+		setter:   setter,
+		log:      log,
+		ingress: m.NewCounter(
+			"statsd_ingress",
+			"Total number of StatsD metrics ingressed by the agent.",
+		),
+		invalid: m.NewCounter(
+			"statsd_invalid_metrics",
+			"Total number of StatsD metrics that could not be parsed.",
+		),
+		maxConnections: 100,
+		maxLineLength:  maxPacketSize,
+		gauges:         map[string]*gauge{},
+		gaugeTTL:       time.Hour,
+		sets:           map[string]map[string]struct{}{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.connections = make(chan struct{}, s.maxConnections)
+
+	return s
+}
+
+// SetGaugeTTL changes how long the last value of a gauge is kept after its
+// last update.
+func (s *Server) SetGaugeTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gaugeTTL = ttl
+}
+
+// Start binds the listeners and handles metrics in the background.
+func (s *Server) Start() error {
+	if s.udpAddr != "" {
+		conn, err := net.ListenPacket("udp", s.udpAddr)
+		if err != nil {
+			return err
+		}
+		s.udpConn = conn
+		go s.serveUDP()
+	}
+
+	if s.tcpAddr != "" {
+		listener, err := net.Listen("tcp", s.tcpAddr)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		s.tcpListener = listener
+		go s.serveTCP()
+	}
+
+	return nil
+}
+
+// UDPAddr returns the address of the UDP listener once started.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udpConn == nil {
+		return nil
+	}
+	return s.udpConn.LocalAddr()
+}
+
+// TCPAddr returns the address of the TCP listener once started.
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcpListener == nil {
+		return nil
+	}
+	return s.tcpListener.Addr()
+}
+
+// Stop closes the listeners.
+func (s *Server) Stop() {
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+}
+
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Warn("failed to read StatsD packet", "error", err)
+			continue
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line)
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Warn("failed to accept StatsD connection", "error", err)
+			continue
+		}
+
+		select {
+		case s.connections <- struct{}{}:
+			go s.handleConn(conn)
+		default:
+			s.log.Warn("closing StatsD connection, too many connections are open", "max_connections", s.maxConnections)
+			conn.Close()
+		}
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer func() { <-s.connections }()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, min(4096, s.maxLineLength)), s.maxLineLength)
+	for scanner.Scan() {
+		s.handleLine(scanner.Text())
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		s.invalid.Add(1)
+		s.log.Warn("closing StatsD connection, line is too long", "max_line_length", s.maxLineLength)
+	}
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	smp, err := parseLine(line)
+	if err != nil {
+		s.invalid.Add(1)
+		return
+	}
+
+	s.ingress.Add(1)
+	s.setter.Set(s.envelope(smp))
+}
+
+func (s *Server) envelope(smp sample) *loggregator_v2.Envelope {
+	env := &loggregator_v2.Envelope{
+		Timestamp: time.Now().UnixNano(),
+		SourceId:  s.sourceID,
+		Tags:      smp.tags,
+	}
+
+	switch smp.metricType {
+	case counterType:
+		env.Message = &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{
+				Name:  smp.name,
+				Delta: uint64(math.Round(smp.value / smp.sampleRate)),
+			},
+		}
+	case gaugeType:
+		env.Message = gaugeMessage(smp.name, s.gaugeValue(smp))
+	case setType:
+		env.Message = gaugeMessage(smp.name, s.setSize(smp))
+	default:
+		env.Message = &loggregator_v2.Envelope_Timer{
+			Timer: &loggregator_v2.Timer{
+				Name:  smp.name,
+				Start: 0,
+				Stop:  int64(smp.value * float64(time.Millisecond)),
+			},
+		}
+	}
+
+	return env
+}
+
+func gaugeMessage(name string, value float64) *loggregator_v2.Envelope_Gauge {
+	return &loggregator_v2.Envelope_Gauge{
+		Gauge: &loggregator_v2.Gauge{
+			Metrics: map[string]*loggregator_v2.GaugeValue{
+				name: {Value: value},
+			},
+		},
+	}
+}
+
+// gaugeValue applies relative gauge changes to the last value of the gauge.
+// Gauges not updated within the gauge TTL start over from zero.
+func (s *Server) gaugeValue(smp sample) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.gaugesExpire) {
+		s.expireGauges(now)
+		s.gaugesExpire = now.Add(gaugeExpirationInterval)
+	}
+
+	key := seriesKey(smp)
+	g, ok := s.gauges[key]
+	if !ok || now.Sub(g.lastUpdate) > s.gaugeTTL {
+		g = &gauge{}
+		s.gauges[key] = g
+	}
+	if smp.relative {
+		g.value += smp.value
+	} else {
+		g.value = smp.value
+	}
+	g.lastUpdate = now
+
+	return g.value
+}
+
+// expireGauges removes the gauges not updated within the gauge TTL. It must
+// be called with mu held.
+func (s *Server) expireGauges(now time.Time) {
+	for key, g := range s.gauges {
+		if now.Sub(g.lastUpdate) > s.gaugeTTL {
+			delete(s.gauges, key)
+		}
+	}
+}
+
+// setSize returns the number of unique values of the set since it was last
+// reset.
+func (s *Server) setSize(smp sample) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.setsExpire) {
+		s.sets = map[string]map[string]struct{}{}
+		s.setsExpire = now.Add(setResetInterval)
+	}
+
+	key := seriesKey(smp)
+	values, ok := s.sets[key]
+	if !ok {
+		values = map[string]struct{}{}
+		s.sets[key] = values
+	}
+	values[smp.setValue] = struct{}{}
+
+	return float64(len(values))
+}
+
+func seriesKey(smp sample) string {
+	tags := make([]string, 0, len(smp.tags))
+	for name, value := range smp.tags {
+		tags = append(tags, name+"="+value)
+	}
+	sort.Strings(tags)
+
+	return smp.name + "\xff" + strings.Join(tags, "\xff")
+}
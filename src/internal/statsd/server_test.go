@@ -0,0 +1,217 @@
+package statsd_test
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/statsd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		setter  *spySetter
+		metrics *metrichelpers.SpyMetricsRegistry
+		server  *statsd.Server
+	)
+
+	BeforeEach(func() {
+		setter = newSpySetter()
+		metrics = metrichelpers.NewMetricsRegistry()
+		server = statsd.NewServer("127.0.0.1:0", "127.0.0.1:0", "statsd", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+		Expect(server.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	var sendUDP = func(payload string) {
+		conn, err := net.Dial("udp", server.UDPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = fmt.Fprint(conn, payload)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("converts counters to counter envelopes", func() {
+		sendUDP("requests:2|c|@0.5|#app:some-app,canary")
+
+		var env *loggregator_v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&env))
+		Expect(env.GetSourceId()).To(Equal("statsd"))
+		Expect(env.GetTags()).To(Equal(map[string]string{"app": "some-app", "canary": ""}))
+		Expect(env.GetCounter().GetName()).To(Equal("requests"))
+		Expect(env.GetCounter().GetDelta()).To(Equal(uint64(4)))
+		Expect(metrics.GetMetricValue("statsd_ingress", nil)).To(Equal(1.0))
+	})
+
+	It("converts gauges and applies relative changes", func() {
+		sendUDP("memory:10|g\nmemory:-3|g\nmemory:+1.5|g")
+
+		var values []float64
+		for range 3 {
+			var env *loggregator_v2.Envelope
+			Eventually(setter.envelopes).Should(Receive(&env))
+			values = append(values, env.GetGauge().GetMetrics()["memory"].GetValue())
+		}
+		Expect(values).To(Equal([]float64{10, 7, 8.5}))
+	})
+
+	DescribeTable("converts timers, histograms and distributions to timer envelopes",
+		func(metricType string) {
+			sendUDP("latency:250|" + metricType)
+
+			var env *loggregator_v2.Envelope
+			Eventually(setter.envelopes).Should(Receive(&env))
+			Expect(env.GetTimer().GetName()).To(Equal("latency"))
+			Expect(time.Duration(env.GetTimer().GetStop() - env.GetTimer().GetStart())).To(Equal(250 * time.Millisecond))
+		},
+		Entry("timer", "ms"),
+		Entry("histogram", "h"),
+		Entry("distribution", "d"),
+	)
+
+	It("converts sets to gauges of their unique values", func() {
+		sendUDP("users:alice|s\nusers:bob|s\nusers:alice|s")
+
+		var values []float64
+		for range 3 {
+			var env *loggregator_v2.Envelope
+			Eventually(setter.envelopes).Should(Receive(&env))
+			values = append(values, env.GetGauge().GetMetrics()["users"].GetValue())
+		}
+		Expect(values).To(Equal([]float64{1, 2, 2}))
+	})
+
+	It("accepts metrics over tcp", func() {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = fmt.Fprint(conn, "requests:1|c\nmemory:5|g\n")
+		Expect(err).ToNot(HaveOccurred())
+
+		var env *loggregator_v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&env))
+		Expect(env.GetCounter().GetName()).To(Equal("requests"))
+		Eventually(setter.envelopes).Should(Receive(&env))
+		Expect(env.GetGauge().GetMetrics()).To(HaveKey("memory"))
+	})
+
+	It("starts relative gauge changes over once the gauge expired", func() {
+		server := statsd.NewServer("127.0.0.1:0", "", "statsd", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+			statsd.WithGaugeTTL(50*time.Millisecond),
+		)
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+		send := func(payload string) float64 {
+			conn, err := net.Dial("udp", server.UDPAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = fmt.Fprint(conn, payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			var env *loggregator_v2.Envelope
+			Eventually(setter.envelopes).Should(Receive(&env))
+			return env.GetGauge().GetMetrics()["memory"].GetValue()
+		}
+
+		Expect(send("memory:10|g")).To(Equal(10.0))
+		Expect(send("memory:+1|g")).To(Equal(11.0))
+		time.Sleep(100 * time.Millisecond)
+		Expect(send("memory:+1|g")).To(Equal(1.0))
+	})
+
+	It("closes tcp connections beyond the maximum", func() {
+		server := statsd.NewServer("", "127.0.0.1:0", "statsd", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+			statsd.WithMaxConnections(1),
+		)
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		first, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer first.Close()
+		_, err = fmt.Fprint(first, "requests:1|c\n")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(setter.envelopes).Should(Receive())
+
+		second, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer second.Close()
+		Expect(second.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+		_, err = second.Read(make([]byte, 1))
+		Expect(err).To(HaveOccurred())
+		Expect(err).ToNot(MatchError(os.ErrDeadlineExceeded))
+	})
+
+	It("closes tcp connections sending lines longer than the maximum", func() {
+		server := statsd.NewServer("", "127.0.0.1:0", "statsd", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)),
+			statsd.WithMaxLineLength(16),
+		)
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "requests:1|c\n"+strings.Repeat("a", 32)+":1|c\n")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(setter.envelopes).Should(Receive())
+		Expect(conn.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).To(HaveOccurred())
+		Expect(err).ToNot(MatchError(os.ErrDeadlineExceeded))
+		Expect(metrics.GetMetricValue("statsd_invalid_metrics", nil)).To(Equal(1.0))
+	})
+
+	DescribeTable("counts invalid metrics",
+		func(line string) {
+			sendUDP(line)
+
+			Eventually(func() float64 {
+				return metrics.GetMetricValue("statsd_invalid_metrics", nil)
+			}).Should(Equal(1.0))
+			Consistently(setter.envelopes).ShouldNot(Receive())
+		},
+		Entry("missing name", ":1|c"),
+		Entry("missing type", "requests:1"),
+		Entry("unknown type", "requests:1|x"),
+		Entry("invalid value", "requests:one|c"),
+		Entry("invalid sample rate", "requests:1|c|@2"),
+		Entry("negative counter", "requests:-1|c"),
+	)
+
+	It("can disable a protocol", func() {
+		server := statsd.NewServer("", "127.0.0.1:0", "statsd", setter, metrics, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		Expect(server.UDPAddr()).To(BeNil())
+		Expect(server.TCPAddr()).ToNot(BeNil())
+	})
+})
+
+type spySetter struct {
+	envelopes chan *loggregator_v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{
+		envelopes: make(chan *loggregator_v2.Envelope, 100),
+	}
+}
+
+func (s *spySetter) Set(e *loggregator_v2.Envelope) {
+	s.envelopes <- e
+}
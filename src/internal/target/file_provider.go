@@ -1,7 +1,7 @@
 package target
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -16,10 +16,10 @@ type fileProvider struct {
 	refreshInterval time.Duration
 	targets         []*Target
 
-	logger *log.Logger
+	logger *slog.Logger
 }
 
-func NewFileProvider(glob string, i time.Duration, logger *log.Logger) *fileProvider {
+func NewFileProvider(glob string, i time.Duration, logger *slog.Logger) *fileProvider {
 	return &fileProvider{
 		configGlob:      glob,
 		refreshInterval: i,
@@ -43,27 +43,27 @@ func (fp *fileProvider) populateTargets() {
 	fp.targets = make([]*Target, 0)
 	files, err := filepath.Glob(fp.configGlob)
 	if err != nil {
-		fp.logger.Println("Unable to read downstream port location")
+		fp.logger.Error("unable to list target files", "glob", fp.configGlob, "error", err)
 		return
 	}
 
 	for _, f := range files {
 		yamlFile, err := os.ReadFile(f)
 		if err != nil {
-			fp.logger.Printf("cannot read file: %s", err)
+			fp.logger.Warn("cannot read target file", "file", f, "error", err)
 			continue
 		}
 
 		var targets []*Target
 		err = yaml.Unmarshal(yamlFile, &targets)
 		if err != nil {
-			fp.logger.Printf("Unmarshal: %v", err)
+			fp.logger.Warn("cannot parse target file", "file", f, "error", err)
 			continue
 		}
 
 		for _, t := range targets {
 			if t.Source == "" {
-				fp.logger.Printf("Target from %s is missing source", f)
+				fp.logger.Warn("target is missing source", "file", f)
 				continue
 			}
 
@@ -3,6 +3,7 @@ package target_test
 import (
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"time"
 
@@ -12,7 +13,7 @@ import (
 )
 
 var _ = Describe("FileProvider", func() {
-	var logger = log.New(GinkgoWriter, "", 0)
+	var logger = slog.New(slog.NewTextHandler(GinkgoWriter, nil))
 
 	It("parses a file and provides scrape targets", func() {
 		f := targetConfigFile("targets.yml")
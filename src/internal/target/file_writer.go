@@ -2,10 +2,11 @@ package target
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
 	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
+	"code.cloudfoundry.org/metrics-discovery/internal/logging"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,7 +18,7 @@ type WriterConfig struct {
 	ScrapeConfigs []scraper.PromScraperConfig
 }
 
-func WriteFile(cfg WriterConfig, logger *log.Logger) {
+func WriteFile(cfg WriterConfig, logger *slog.Logger) {
 	metricsExporterTarget := []string{cfg.MetricsHost}
 
 	labels := copyMap(cfg.DefaultLabels)
@@ -42,16 +43,16 @@ func WriteFile(cfg WriterConfig, logger *log.Logger) {
 	writeTargets(cfg, logger, targets)
 }
 
-func writeTargets(cfg WriterConfig, logger *log.Logger, targets []Target) {
+func writeTargets(cfg WriterConfig, logger *slog.Logger, targets []Target) {
 	f, err := os.Create(cfg.File)
 	if err != nil {
-		logger.Fatalf("unable to create metrics target file at %s: %s", cfg.File, err)
+		logging.Fatal(logger, "unable to create metrics target file", "file", cfg.File, "error", err)
 	}
 	defer f.Close()
 
 	err = yaml.NewEncoder(f).Encode(targets)
 	if err != nil {
-		logger.Fatalf("unable to marshal metrics target file: %s", err)
+		logging.Fatal(logger, "unable to marshal metrics target file", "file", cfg.File, "error", err)
 	}
 }
 
@@ -1,7 +1,7 @@
 package target_test
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"code.cloudfoundry.org/loggregator-agent-release/src/pkg/scraper"
@@ -35,7 +35,7 @@ var _ = Describe("File Writer", func() {
 			File:          tmpDir + "/metrics_targets.yml",
 			ScrapeConfigs: scrapeCfgs,
 		}
-		target.WriteFile(cfg, log.New(GinkgoWriter, "", 0))
+		target.WriteFile(cfg, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
 	})
 
 	var readTargetsFromFile = func(tmpDir string) []target.Target {
@@ -0,0 +1,103 @@
+package testhelpers
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DropsondeEnvelope builds a marshalled dropsonde events.Envelope.
+type DropsondeEnvelope struct {
+	Origin     string
+	Deployment string
+	Job        string
+	Tags       map[string]string
+
+	eventType uint64
+	field     protowire.Number
+	event     []byte
+}
+
+// ValueMetric sets a ValueMetric event on the envelope.
+func (e DropsondeEnvelope) ValueMetric(name string, value float64, unit string) DropsondeEnvelope {
+	var b []byte
+	b = appendString(b, 1, name)
+	b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = appendString(b, 3, unit)
+
+	e.eventType, e.field, e.event = 6, 9, b
+	return e
+}
+
+// CounterEvent sets a CounterEvent event on the envelope.
+func (e DropsondeEnvelope) CounterEvent(name string, delta, total uint64) DropsondeEnvelope {
+	var b []byte
+	b = appendString(b, 1, name)
+	b = appendVarint(b, 2, delta)
+	b = appendVarint(b, 3, total)
+
+	e.eventType, e.field, e.event = 7, 10, b
+	return e
+}
+
+// ContainerMetric sets a ContainerMetric event on the envelope.
+func (e DropsondeEnvelope) ContainerMetric(appID string, index int32, cpu float64, memory, disk uint64) DropsondeEnvelope {
+	var b []byte
+	b = appendString(b, 1, appID)
+	b = appendVarint(b, 2, uint64(index))
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(cpu))
+	b = appendVarint(b, 4, memory)
+	b = appendVarint(b, 5, disk)
+
+	e.eventType, e.field, e.event = 9, 12, b
+	return e
+}
+
+// LogMessage sets a LogMessage event on the envelope.
+func (e DropsondeEnvelope) LogMessage(message string) DropsondeEnvelope {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte(message))
+	b = appendVarint(b, 2, 1)
+
+	e.eventType, e.field, e.event = 5, 8, b
+	return e
+}
+
+// Marshal returns the protobuf encoding of the envelope.
+func (e DropsondeEnvelope) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, e.Origin)
+	b = appendVarint(b, 2, e.eventType)
+	b = appendVarint(b, 6, 1000)
+	b = protowire.AppendTag(b, e.field, protowire.BytesType)
+	b = protowire.AppendBytes(b, e.event)
+	if e.Deployment != "" {
+		b = appendString(b, 13, e.Deployment)
+	}
+	if e.Job != "" {
+		b = appendString(b, 14, e.Job)
+	}
+
+	for k, v := range e.Tags {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		b = protowire.AppendTag(b, 17, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
@@ -0,0 +1,92 @@
+package testhelpers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Metric types of the remote write MetricMetadata message.
+const (
+	RemoteWriteCounter = 1
+	RemoteWriteGauge   = 2
+)
+
+// RemoteWriteRequest builds a remote write 1.0 WriteRequest.
+type RemoteWriteRequest struct {
+	Series []RemoteWriteSeries
+	// Types maps metric family names to the metric type of the metadata.
+	Types map[string]uint64
+}
+
+// RemoteWriteSeries is a time series of a RemoteWriteRequest. Histograms is
+// the number of native histogram samples.
+type RemoteWriteSeries struct {
+	Labels     map[string]string
+	Samples    []RemoteWriteSample
+	Histograms int
+}
+
+// RemoteWriteSample is a float sample with a timestamp in milliseconds.
+type RemoteWriteSample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Marshal returns the protobuf encoding of the request.
+func (r RemoteWriteRequest) Marshal() []byte {
+	var b []byte
+	for _, s := range r.Series {
+		var ts []byte
+
+		names := make([]string, 0, len(s.Labels))
+		for name := range s.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var l []byte
+			l = appendString(l, 1, name)
+			l = appendString(l, 2, s.Labels[name])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+
+		for _, smp := range s.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(smp.Value))
+			sb = appendVarint(sb, 2, uint64(smp.Timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+
+		for i := 0; i < s.Histograms; i++ {
+			var h []byte
+			h = appendVarint(h, 1, 1)
+			ts = protowire.AppendTag(ts, 4, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, h)
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+
+	for name, metricType := range r.Types {
+		var m []byte
+		m = appendVarint(m, 1, metricType)
+		m = appendString(m, 2, name)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+
+	return b
+}
+
+// Compressed returns the snappy compressed protobuf encoding of the
+// request as sent by Prometheus.
+func (r RemoteWriteRequest) Compressed() []byte {
+	return snappy.Encode(nil, r.Marshal())
+}
@@ -0,0 +1,102 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// ServerConfig returns a copy of base that presents the current certificate.
+// When a CA is configured, clients must present a certificate signed by one
+// of the trusted CAs.
+func (r *Reloader) ServerConfig(base *tls.Config) *tls.Config {
+	c := base.Clone()
+	c.Certificates = nil
+	c.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.currentCertificate()
+	}
+
+	if r.caFile != "" {
+		// The client certificate is verified by VerifyPeerCertificate
+		// against the current pool instead of the static ClientCAs.
+		c.ClientAuth = tls.RequireAnyClientCert
+		c.ClientCAs = nil
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs, err := parseRawCerts(rawCerts)
+			if err != nil {
+				return err
+			}
+			return r.verify(certs, x509.ExtKeyUsageClientAuth, "")
+		}
+	}
+
+	return c
+}
+
+// ClientConfig returns a copy of base that presents the current certificate
+// if requested by the server. When a CA is configured, the server
+// certificate is verified against the trusted CAs and connections without a
+// server name fail like they do with the default verification.
+func (r *Reloader) ClientConfig(base *tls.Config) *tls.Config {
+	c := base.Clone()
+	c.Certificates = nil
+	if r.hasCert() {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.currentCertificate()
+		}
+	}
+
+	if r.caFile != "" {
+		// The default verification is replaced by VerifyConnection, which
+		// verifies the chain and the server name against the current pool.
+		c.RootCAs = nil
+		c.InsecureSkipVerify = true //nolint:gosec
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				return errors.New("server name is required to verify the server certificate")
+			}
+			return r.verify(cs.PeerCertificates, x509.ExtKeyUsageServerAuth, cs.ServerName)
+		}
+	}
+
+	return c
+}
+
+func (r *Reloader) currentCertificate() (*tls.Certificate, error) {
+	cert := r.Certificate()
+	if cert == nil {
+		return nil, errors.New("no certificate configured")
+	}
+	return cert, nil
+}
+
+func (r *Reloader) verify(certs []*x509.Certificate, usage x509.ExtKeyUsage, dnsName string) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         r.CAPool(),
+		Intermediates: intermediates,
+		DNSName:       dnsName,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+func parseRawCerts(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
@@ -0,0 +1,156 @@
+package tlsreload_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS configs", func() {
+	var (
+		serverCerts *tlsreload.Reloader
+		clientCerts *tlsreload.Reloader
+
+		serverDir string
+		clientDir string
+	)
+
+	var newReloader = func(dir string) *tlsreload.Reloader {
+		r, err := tlsreload.New(
+			filepath.Join(dir, "cert.crt"),
+			filepath.Join(dir, "cert.key"),
+			filepath.Join(dir, "ca.crt"),
+			tlsreload.WithCheckInterval(0),
+			tlsreload.WithLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	var writeCerts = func(dir string, certs *testhelpers.TestCerts, commonName string) {
+		copyFile(certs.Cert(commonName), filepath.Join(dir, "cert.crt"))
+		copyFile(certs.Key(commonName), filepath.Join(dir, "cert.key"))
+	}
+
+	BeforeEach(func() {
+		serverDir = GinkgoT().TempDir()
+		writeCerts(serverDir, oldCerts, "server")
+		copyFile(oldCerts.CA(), filepath.Join(serverDir, "ca.crt"))
+
+		clientDir = GinkgoT().TempDir()
+		writeCerts(clientDir, oldCerts, "client")
+		copyFile(oldCerts.CA(), filepath.Join(clientDir, "ca.crt"))
+
+		serverCerts = newReloader(serverDir)
+		clientCerts = newReloader(clientDir)
+	})
+
+	var handshakeWithServerName = func(serverName string) error {
+		serverConfig := serverCerts.ServerConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+		clientConfig := clientCerts.ClientConfig(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: serverName,
+		})
+
+		lis, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer conn.Close()
+			serverErr <- conn.(*tls.Conn).Handshake()
+		}()
+
+		rawConn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		conn := tls.Client(rawConn, clientConfig)
+		defer conn.Close()
+		if err := conn.Handshake(); err != nil {
+			return err
+		}
+		// The server verifies the client certificate after the client
+		// finished its handshake, so the error is only seen by the server.
+		return <-serverErr
+	}
+
+	var handshake = func() error {
+		return handshakeWithServerName("server")
+	}
+
+	It("verifies the certificates of both sides", func() {
+		Expect(handshake()).To(Succeed())
+	})
+
+	It("rejects clients signed by an untrusted CA", func() {
+		writeCerts(clientDir, newCerts, "client")
+
+		Expect(handshake()).ToNot(Succeed())
+	})
+
+	It("rejects servers signed by an untrusted CA", func() {
+		writeCerts(serverDir, newCerts, "server")
+
+		Expect(handshake()).ToNot(Succeed())
+	})
+
+	It("rejects servers with a different name", func() {
+		writeCerts(serverDir, oldCerts, "other")
+
+		Expect(handshake()).ToNot(Succeed())
+	})
+
+	It("rejects servers when no server name is set", func() {
+		Expect(handshakeWithServerName("")).To(MatchError(ContainSubstring("server name is required")))
+	})
+
+	It("accepts certificates of the new CA after rotation", func() {
+		copyFile(newCerts.CA(), filepath.Join(serverDir, "ca.crt"))
+		copyFile(newCerts.CA(), filepath.Join(clientDir, "ca.crt"))
+		Expect(handshake()).To(Succeed())
+
+		writeCerts(serverDir, newCerts, "server")
+		Expect(handshake()).To(Succeed())
+
+		writeCerts(clientDir, newCerts, "client")
+		Expect(handshake()).To(Succeed())
+	})
+})
+
+func copyFile(src, dst string) {
+	data, err := os.ReadFile(src)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.WriteFile(dst, data, 0600)).To(Succeed())
+}
+
+func loadCert(file string) *x509.Certificate {
+	data, err := os.ReadFile(file)
+	Expect(err).ToNot(HaveOccurred())
+	block, _ := pem.Decode(data)
+	Expect(block).ToNot(BeNil())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	Expect(err).ToNot(HaveOccurred())
+	return cert
+}
+
+func verify(r *tlsreload.Reloader, cert *x509.Certificate) error {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:     r.CAPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
@@ -0,0 +1,324 @@
+package tlsreload
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	metrics "code.cloudfoundry.org/go-metric-registry"
+)
+
+const (
+	// DefaultCheckInterval is the minimum time between two checks of the
+	// files for changes.
+	DefaultCheckInterval = time.Second
+
+	// DefaultCATransition is how long the previous CAs are still trusted
+	// after the CA file changed.
+	DefaultCATransition = time.Hour
+)
+
+type metricsRegistry interface {
+	NewGauge(name, helpText string, opts ...metrics.MetricOption) metrics.Gauge
+}
+
+// Reloader holds a certificate and a CA pool loaded from files. The files
+// are checked for changes when a TLS handshake needs them and reloaded when
+// they changed. Files that fail to load keep the previous certificate or CA
+// in use.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	checkInterval time.Duration
+	caTransition  time.Duration
+	log           *slog.Logger
+	now           func() time.Time
+
+	metrics    metricsRegistry
+	name       string
+	certExpiry metrics.Gauge
+	caExpiry   metrics.Gauge
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	certStat  fileStat
+	keyStat   fileStat
+	caStat    fileStat
+
+	cert            *tls.Certificate
+	caPEM           []byte
+	previousCAPEM   []byte
+	previousCAUntil time.Time
+	pool            *x509.CertPool
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+type Option func(*Reloader)
+
+// WithMetrics exposes the expiry times of the certificate and the CAs as
+// gauges labeled with the given name.
+func WithMetrics(m metricsRegistry, name string) Option {
+	return func(r *Reloader) {
+		r.metrics = m
+		r.name = name
+	}
+}
+
+// WithCheckInterval sets the minimum time between two checks of the files.
+func WithCheckInterval(d time.Duration) Option {
+	return func(r *Reloader) {
+		r.checkInterval = d
+	}
+}
+
+// WithCATransition sets how long the previous CAs are trusted in addition
+// to the new ones after the CA file changed.
+func WithCATransition(d time.Duration) Option {
+	return func(r *Reloader) {
+		r.caTransition = d
+	}
+}
+
+// WithLogger sets the logger for reload failures.
+func WithLogger(l *slog.Logger) Option {
+	return func(r *Reloader) {
+		r.log = l
+	}
+}
+
+// New returns a Reloader for the given files. The certificate and key or the
+// CA may be empty. An error is returned if the files cannot be loaded
+// initially.
+func New(certFile, keyFile, caFile string, opts ...Option) (*Reloader, error) {
+	r := &Reloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		caFile:        caFile,
+		checkInterval: DefaultCheckInterval,
+		caTransition:  DefaultCATransition,
+		log:           slog.Default(),
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.newExpiryMetrics()
+
+	if r.hasCert() {
+		if err := r.loadCert(); err != nil {
+			return nil, err
+		}
+	}
+	if caFile != "" {
+		if err := r.loadCA(); err != nil {
+			return nil, err
+		}
+	}
+	r.lastCheck = r.now()
+
+	return r, nil
+}
+
+// newExpiryMetrics creates the gauges of the configured files.
+func (r *Reloader) newExpiryMetrics() {
+	if r.metrics == nil {
+		return
+	}
+
+	labels := metrics.WithMetricLabels(map[string]string{"name": r.name})
+	if r.hasCert() {
+		r.certExpiry = r.metrics.NewGauge(
+			"tls_certificate_expiry_timestamp_seconds",
+			"Unix time at which the certificate expires.",
+			labels,
+		)
+	}
+	if r.caFile != "" {
+		r.caExpiry = r.metrics.NewGauge(
+			"tls_ca_expiry_timestamp_seconds",
+			"Unix time at which the first of the trusted CAs expires.",
+			labels,
+		)
+	}
+}
+
+func (r *Reloader) hasCert() bool {
+	return r.certFile != "" && r.keyFile != ""
+}
+
+// Certificate returns the current certificate.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloadIfChanged()
+	return r.cert
+}
+
+// CAPool returns the trusted CAs. During the transition period after a CA
+// change the pool contains the previous and the new CAs.
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloadIfChanged()
+	return r.pool
+}
+
+func (r *Reloader) reloadIfChanged() {
+	now := r.now()
+	if now.Sub(r.lastCheck) < r.checkInterval {
+		return
+	}
+	r.lastCheck = now
+
+	if r.hasCert() && (changed(r.certFile, r.certStat) || changed(r.keyFile, r.keyStat)) {
+		if err := r.loadCert(); err != nil {
+			r.log.Error("failed to reload certificate", "file", r.certFile, "error", err)
+		}
+	}
+
+	if r.caFile != "" && changed(r.caFile, r.caStat) {
+		if err := r.loadCA(); err != nil {
+			r.log.Error("failed to reload CA", "file", r.caFile, "error", err)
+		}
+	}
+
+	if r.previousCAPEM != nil && !now.Before(r.previousCAUntil) {
+		r.previousCAPEM = nil
+		r.buildPool()
+	}
+}
+
+// loadCert loads the certificate and key. The file stats are only recorded
+// on success so that a key written after its certificate is picked up by
+// the next check.
+func (r *Reloader) loadCert() error {
+	certStat, err := stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	r.cert = &cert
+	r.certStat = certStat
+	r.keyStat = keyStat
+	if r.certExpiry != nil {
+		r.certExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+
+	return nil
+}
+
+func (r *Reloader) loadCA() error {
+	caStat, err := stat(r.caFile)
+	if err != nil {
+		return err
+	}
+
+	caPEM, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return err
+	}
+	if _, err := parseCerts(caPEM); err != nil {
+		return fmt.Errorf("%s: %w", r.caFile, err)
+	}
+
+	if r.caPEM != nil && !bytes.Equal(r.caPEM, caPEM) {
+		r.previousCAPEM = r.caPEM
+		r.previousCAUntil = r.now().Add(r.caTransition)
+	}
+	r.caPEM = caPEM
+	r.caStat = caStat
+	r.buildPool()
+
+	return nil
+}
+
+func (r *Reloader) buildPool() {
+	pool := x509.NewCertPool()
+	var expiry time.Time
+	for _, p := range [][]byte{r.caPEM, r.previousCAPEM} {
+		certs, _ := parseCerts(p)
+		for _, c := range certs {
+			pool.AddCert(c)
+			if expiry.IsZero() || c.NotAfter.Before(expiry) {
+				expiry = c.NotAfter
+			}
+		}
+	}
+	r.pool = pool
+
+	if r.caExpiry != nil && !expiry.IsZero() {
+		r.caExpiry.Set(float64(expiry.Unix()))
+	}
+}
+
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+func stat(path string) (fileStat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func changed(path string, last fileStat) bool {
+	s, err := stat(path)
+	if err != nil {
+		return false
+	}
+	return s != last
+}
@@ -0,0 +1,123 @@
+package tlsreload_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	metrichelpers "code.cloudfoundry.org/go-metric-registry/testhelpers"
+	"code.cloudfoundry.org/metrics-discovery/internal/tlsreload"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		dir      string
+		certFile string
+		keyFile  string
+		caFile   string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		certFile = filepath.Join(dir, "cert.crt")
+		keyFile = filepath.Join(dir, "cert.key")
+		caFile = filepath.Join(dir, "ca.crt")
+
+		copyFile(oldCerts.Cert("server"), certFile)
+		copyFile(oldCerts.Key("server"), keyFile)
+		copyFile(oldCerts.CA(), caFile)
+	})
+
+	var newReloader = func(opts ...tlsreload.Option) *tlsreload.Reloader {
+		opts = append([]tlsreload.Option{
+			tlsreload.WithCheckInterval(0),
+			tlsreload.WithLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))),
+		}, opts...)
+		r, err := tlsreload.New(certFile, keyFile, caFile, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	It("returns an error if the files cannot be loaded", func() {
+		_, err := tlsreload.New(certFile, filepath.Join(dir, "missing.key"), caFile)
+		Expect(err).To(HaveOccurred())
+
+		Expect(os.WriteFile(caFile, []byte("invalid"), 0600)).To(Succeed())
+		_, err = tlsreload.New(certFile, keyFile, caFile)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("allows the certificate and CA to be empty", func() {
+		r, err := tlsreload.New("", "", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Certificate()).To(BeNil())
+		Expect(r.CAPool()).To(BeNil())
+	})
+
+	It("reloads the certificate when the files change", func() {
+		r := newReloader()
+		oldLeaf := r.Certificate().Leaf
+		Expect(oldLeaf.Issuer.CommonName).To(Equal("oldCA"))
+
+		copyFile(newCerts.Cert("server"), certFile)
+		copyFile(newCerts.Key("server"), keyFile)
+
+		Expect(r.Certificate().Leaf.Issuer.CommonName).To(Equal("newCA"))
+	})
+
+	It("keeps the previous certificate if the new one cannot be loaded", func() {
+		r := newReloader()
+
+		copyFile(newCerts.Cert("server"), certFile)
+		Expect(r.Certificate().Leaf.Issuer.CommonName).To(Equal("oldCA"))
+
+		copyFile(newCerts.Key("server"), keyFile)
+		Expect(r.Certificate().Leaf.Issuer.CommonName).To(Equal("newCA"))
+	})
+
+	It("does not check the files more often than the check interval", func() {
+		r := newReloader(tlsreload.WithCheckInterval(time.Hour))
+
+		copyFile(newCerts.Cert("server"), certFile)
+		copyFile(newCerts.Key("server"), keyFile)
+
+		Expect(r.Certificate().Leaf.Issuer.CommonName).To(Equal("oldCA"))
+	})
+
+	It("trusts the previous and the new CA during the transition period", func() {
+		r := newReloader(tlsreload.WithCATransition(200 * time.Millisecond))
+		oldCert := loadCert(oldCerts.Cert("client"))
+		newCert := loadCert(newCerts.Cert("client"))
+
+		copyFile(newCerts.CA(), caFile)
+		Expect(verify(r, newCert)).To(Succeed())
+		Expect(verify(r, oldCert)).To(Succeed())
+
+		Eventually(func() error {
+			return verify(r, oldCert)
+		}).Should(HaveOccurred())
+		Expect(verify(r, newCert)).To(Succeed())
+	})
+
+	It("keeps the previous CA if the new one cannot be loaded", func() {
+		r := newReloader()
+		oldCert := loadCert(oldCerts.Cert("client"))
+
+		Expect(os.WriteFile(caFile, []byte("invalid"), 0600)).To(Succeed())
+		Expect(verify(r, oldCert)).To(Succeed())
+	})
+
+	It("exposes the expiry times as metrics", func() {
+		m := metrichelpers.NewMetricsRegistry()
+		r := newReloader(tlsreload.WithMetrics(m, "test"))
+		labels := map[string]string{"name": "test"}
+
+		Expect(m.GetMetricValue("tls_certificate_expiry_timestamp_seconds", labels)).
+			To(Equal(float64(r.Certificate().Leaf.NotAfter.Unix())))
+		Expect(m.GetMetricValue("tls_ca_expiry_timestamp_seconds", labels)).
+			To(BeNumerically(">", time.Now().Unix()))
+	})
+})
@@ -0,0 +1,24 @@
+package tlsreload_test
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/metrics-discovery/internal/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTLSReload(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TLS Reload Suite")
+}
+
+var (
+	oldCerts *testhelpers.TestCerts
+	newCerts *testhelpers.TestCerts
+)
+
+var _ = BeforeSuite(func() {
+	oldCerts = testhelpers.GenerateCerts("oldCA")
+	newCerts = testhelpers.GenerateCerts("newCA")
+})